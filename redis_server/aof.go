@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"ccwc/redis_server/resp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const BGREWRITEAOF = "BGREWRITEAOF"
+
+// The append-only file (AOF) logs the commands that modified the dataset in
+// the RESP format, it is replayed at startup. BGREWRITEAOF compacts it: a
+// snapshot of the dataset in the RDB format, the preamble, replaces the
+// commands, followed by the commands executed while it was written.
+
+// appendfsync policies
+const (
+	fsyncAlways   = "always"
+	fsyncEverySec = "everysec"
+	fsyncNo       = "no"
+)
+
+// propagate logs a command that modified the dataset, the server lock must be held
+func (s *Server) propagate(args []string) {
+	rewriting := s.cow != nil && s.cow.aof
+	if s.aofFile == nil && !rewriting {
+		return
+	}
+	if s.propagateMulti {
+		s.propagateMulti = false
+		s.propagate([]string{MULTI})
+	}
+	var buf bytes.Buffer
+	// the command is replayed in the database it was executed in
+	if s.db.id != s.aofSelectedDB {
+		resp.NewBulkStringArray([]string{SELECT, strconv.Itoa(s.db.id)}).Encode(&buf)
+		s.aofSelectedDB = s.db.id
+	}
+	resp.NewBulkStringArray(args).Encode(&buf)
+	if rewriting {
+		s.cow.aofTail.Write(buf.Bytes())
+	}
+	if s.aofFile != nil {
+		s.aofBuf = append(s.aofBuf, buf.Bytes()...)
+		s.flushAOF()
+	}
+}
+
+// flushAOF writes the commands not written yet. After a write error, the
+// write commands are refused until the commands can be written again.
+func (s *Server) flushAOF() {
+	if s.aofFile == nil || len(s.aofBuf) == 0 {
+		return
+	}
+	n, err := s.aofFile.Write(s.aofBuf)
+	s.aofSize += int64(n)
+	// a partial write is completed by the next attempt
+	s.aofBuf = s.aofBuf[n:]
+	if err == nil && s.config.AppendFsync == fsyncAlways {
+		err = s.aofFile.Sync()
+	}
+	if err != nil {
+		if s.aofWriteErr == nil {
+			s.logf(logWarning, "Error writing to the AOF file: %s", err)
+		}
+		s.aofWriteErr = err
+		return
+	}
+	if s.aofWriteErr != nil {
+		s.logf(logWarning, "AOF write error looks solved, Redis can write again.")
+		s.aofWriteErr = nil
+	}
+	s.aofBuf = s.aofBuf[:0]
+	s.aofUnsynced = true
+}
+
+// aofFsync syncs the AOF once per second with the everysec policy,
+// the server lock must not be held since the file is synced outside of it
+func (s *Server) aofFsync() {
+	s.mu.Lock()
+	file := s.aofFile
+	due := file != nil && s.aofUnsynced && s.config.AppendFsync == fsyncEverySec &&
+		time.Since(s.aofLastFsync) >= time.Second
+	if due {
+		s.aofUnsynced = false
+		s.aofLastFsync = time.Now()
+	}
+	s.mu.Unlock()
+
+	// the file may be closed meanwhile by a rewrite
+	if due {
+		if err := file.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			s.logf(logWarning, "Error syncing the AOF file: %s", err)
+		}
+	}
+}
+
+// BGREWRITEAOF rewrites the AOF in the background, the rewrite is scheduled
+// when a background save is in progress
+func (s *Server) handleBgrewriteaof(c *client, args []string) resp.Value {
+	switch {
+	case s.cow != nil && s.cow.aof:
+		return resp.NewError("ERR Background append only file rewriting already in progress")
+	case s.cow != nil:
+		s.aofRewriteScheduled = true
+		return resp.NewSimpleString("Background append only file rewriting scheduled")
+	}
+	if err := s.startAOFRewrite(); err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	return resp.NewSimpleString("Background append only file rewriting started")
+}
+
+// startAOFRewrite starts a background rewrite of the AOF,
+// the server lock must be held
+func (s *Server) startAOFRewrite() error {
+	path := s.config.aofPath()
+	file, err := os.CreateTemp(filepath.Dir(path), "temp-rewriteaof-*.aof")
+	if err != nil {
+		s.aofLastRewriteOK = false
+		return err
+	}
+
+	s.aofRewriteScheduled = false
+	// the commands executed meanwhile are appended after the snapshot,
+	// starting with a SELECT
+	s.aofSelectedDB = -1
+	s.startSnapshot(file, true, func(cow *cowSnapshot, err error) {
+		if err == nil {
+			_, err = cow.aofTail.WriteTo(file)
+		}
+		if err == nil {
+			err = file.Sync()
+		}
+		if err == nil {
+			err = os.Rename(file.Name(), path)
+		}
+		s.aofLastRewriteTime = time.Since(cow.start)
+		if err == nil && s.config.AppendOnly {
+			// the commands not written yet are in the rewritten file
+			s.aofBuf = s.aofBuf[:0]
+			err = s.openAOF()
+		}
+		if err != nil {
+			s.aofLastRewriteOK = false
+			s.logf(logWarning, "Background AOF rewrite error: %s", err)
+			return
+		}
+		s.aofLastRewriteOK = true
+		s.aofRewrites++
+		s.logf(logNotice, "Background AOF rewrite finished successfully")
+	})
+	s.logf(logNotice, "Background append only file rewriting started")
+	return nil
+}
+
+// switchAOF turns the AOF on or off at runtime. When it is turned on, the
+// file is created by a rewrite and the commands are logged once it is over.
+func (s *Server) switchAOF(on bool) error {
+	if !on {
+		s.closeAOF()
+		return nil
+	}
+	if s.cow != nil {
+		s.aofRewriteScheduled = true
+		return nil
+	}
+	return s.startAOFRewrite()
+}
+
+// openAOF opens the AOF to append the commands, a new file starts with a
+// snapshot of the dataset. The file opened before is closed.
+func (s *Server) openAOF() error {
+	path := s.config.aofPath()
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if err := writeAOFPreamble(path, s.dicts()); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.closeAOF()
+	s.aofFile = file
+	s.aofSelectedDB = -1
+	s.aofSize, s.aofBaseSize = info.Size(), info.Size()
+	s.aofWriteErr = nil
+	return nil
+}
+
+// closeAOF writes the commands not written yet, syncs and closes the AOF
+func (s *Server) closeAOF() {
+	if s.aofFile == nil {
+		return
+	}
+	s.flushAOF()
+	if err := s.aofFile.Sync(); err != nil {
+		s.logf(logWarning, "Error syncing the AOF file: %s", err)
+	}
+	s.aofFile.Close()
+	s.aofFile = nil
+	s.aofBuf = nil
+	s.aofWriteErr = nil
+}
+
+func writeAOFPreamble(path string, dicts []map[string]RedisValue) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "temp-rewriteaof-*.aof")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := writeRDB(file, dicts); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// loadAOF replays the AOF at startup and opens it. Without a file, the
+// snapshot file is loaded and the AOF is created from it.
+func (s *Server) loadAOF() error {
+	path := s.config.aofPath()
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := s.loadSnapshot(); err != nil {
+			return err
+		}
+		return s.openAOF()
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	valid, err := s.replayAOF(file)
+	switch {
+	case err == resp.IncompleteErr && s.config.AOFLoadTruncated:
+		s.logf(logWarning, "!!! Warning: short read while loading the AOF file %s!!!", path)
+		s.logf(logWarning, "AOF %s loaded anyway because aof-load-truncated is enabled", path)
+		// the incomplete command is removed so that the next ones are appended to a valid file
+		if err := os.Truncate(path, valid); err != nil {
+			return err
+		}
+	case err == resp.IncompleteErr:
+		return fmt.Errorf("unexpected end of file reading the append only file %s, "+
+			"set aof-load-truncated to yes to load it anyway", path)
+	case err != nil:
+		return err
+	}
+	s.dirty = 0
+	s.logf(logNotice, "DB loaded from append only file: %d keys", countKeys(s.dicts()))
+	return s.openAOF()
+}
+
+// replayAOF executes the commands of the AOF and returns the size of the
+// commands read entirely. The file may start with an RDB preamble.
+func (s *Server) replayAOF(file io.Reader) (valid int64, err error) {
+	counter := &countingReader{r: file}
+	rd := bufio.NewReader(counter)
+	if magic, _ := rd.Peek(5); string(magic) == "REDIS" {
+		// readRDB reads from rd itself, see bufio.NewReader
+		dicts, err := readRDB(rd, len(s.dbs))
+		if err != nil {
+			return 0, err
+		}
+		s.setDicts(dicts)
+	}
+
+	reader := resp.NewReader(rd)
+	// the commands are executed in the database of the last SELECT
+	c := &client{db: s.dbs[0], writer: resp.NewWriter(io.Discard)}
+	for {
+		// a transaction is valid once its EXEC was read, the commands
+		// queued are discarded when it is truncated
+		if c.multi == nil {
+			valid = counter.n - int64(rd.Buffered())
+		}
+		args, err := reader.ReadCommand()
+		if err == io.EOF && c.multi != nil {
+			return valid, resp.IncompleteErr
+		}
+		if err == io.EOF {
+			return valid, nil
+		}
+		if err == resp.IncompleteErr {
+			return valid, err
+		}
+		if err != nil {
+			return valid, fmt.Errorf("bad file format reading the append only file: %s", err)
+		}
+
+		cmd, err := lookupCommand(args)
+		if err != nil {
+			return valid, fmt.Errorf("%s reading the append only file", err)
+		}
+		s.call(c, cmd, args)
+	}
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadAOF_Truncated(t *testing.T) {
+	complete := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n*3\r\n$5\r\nRPUSH\r\n$1\r\nl\r\n$1\r\nx\r\n"
+	tests := []struct {
+		name      string
+		content   string
+		truncated bool // aof-load-truncated
+		wantErr   string
+		wantKeys  int
+	}{
+		{"complete", complete, false, "", 2},
+		{"truncated tail", complete + "*2\r\n$4\r\nINCR\r\n$1", true, "", 2},
+		{"truncated tail refused", complete + "*2\r\n$4\r\nINCR", false, "unexpected end of file", 0},
+		// the commands of a transaction without EXEC aren't executed
+		{"truncated transaction", complete + "*1\r\n$5\r\nMULTI\r\n*2\r\n$4\r\nINCR\r\n$1\r\na\r\n", true, "", 2},
+		{"unknown command", "*1\r\n$4\r\nNOPE\r\n", false, "unknown command 'NOPE'", 0},
+		{"bad format", "+OK\r\n", false, "bad file format", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Dir = t.TempDir()
+			config.AppendOnly = true
+			config.AOFLoadTruncated = tt.truncated
+			if err := os.WriteFile(config.aofPath(), []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			s := NewServerWithConfig(config)
+			defer s.closeAOF()
+
+			err := s.loadAOF()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s.db.dict) != tt.wantKeys {
+				t.Errorf("got %d keys, want %d", len(s.db.dict), tt.wantKeys)
+			}
+			// the incomplete command is removed before new ones are appended,
+			// starting with the database they are executed in
+			s.propagate([]string{INCR, "a"})
+			got, _ := os.ReadFile(config.aofPath())
+			if want := complete + "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*2\r\n$4\r\nINCR\r\n$1\r\na\r\n"; string(got) != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestAOFRewrite(t *testing.T) {
+	config := DefaultConfig()
+	config.Dir = t.TempDir()
+	config.AppendOnly = true
+	s := NewServerWithConfig(config)
+	if err := s.loadAOF(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.closeAOF()
+
+	c := &client{db: s.dbs[0]}
+	s.mu.Lock()
+	for i := 0; i < 100; i++ {
+		s.call(c, commandTable[INCR], []string{INCR, "counter"})
+	}
+	before := s.aofSize
+	if err := s.startAOFRewrite(); err != nil {
+		t.Fatal(err)
+	}
+	s.call(c, commandTable[INCR], []string{INCR, "counter"})
+	// the commands are replayed in the database they were executed in
+	other := &client{db: s.dbs[3]}
+	s.call(other, commandTable[INCR], []string{INCR, "counter"})
+	s.mu.Unlock()
+	s.background.Wait()
+
+	if !s.aofLastRewriteOK || s.aofRewrites != 1 {
+		t.Fatalf("got status %v and %d rewrites", s.aofLastRewriteOK, s.aofRewrites)
+	}
+	if s.aofSize >= before {
+		t.Errorf("got %d bytes after the rewrite, want less than %d", s.aofSize, before)
+	}
+	s.call(c, commandTable[INCR], []string{INCR, "counter"})
+	s.call(other, commandTable[INCR], []string{INCR, "counter"})
+
+	loaded := NewServerWithConfig(config)
+	if err := loaded.loadAOF(); err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.closeAOF()
+	if got := loaded.dbs[0].dict["counter"].value; got != "102" {
+		t.Errorf("got counter %v, want 102", got)
+	}
+	if got := loaded.dbs[3].dict["counter"].value; got != "2" {
+		t.Errorf("got counter %v in the database 3, want 2", got)
+	}
+}
+
+func TestAOF_Transaction(t *testing.T) {
+	s := NewServerWithConfig(DefaultConfig())
+	file, err := os.CreateTemp(t.TempDir(), "appendonly-*.aof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.aofFile = file
+	defer s.closeAOF()
+	c := &client{db: s.dbs[0]}
+
+	tests := []struct {
+		commands [][]string
+		want     [][]string // the commands logged to the AOF
+	}{
+		// the writes of a transaction are logged between MULTI and EXEC
+		{
+			[][]string{{MULTI}, {SET, "a", "1"}, {GET, "a"}, {INCR, "a"}, {EXEC}},
+			[][]string{{SELECT, "0"}, {MULTI}, {SET, "a", "1"}, {INCR, "a"}, {EXEC}},
+		},
+		{[][]string{{MULTI}, {GET, "a"}, {EXEC}}, nil},
+		{[][]string{{MULTI}, {SET, "b", "1"}, {DISCARD}}, nil},
+		// the database is selected before MULTI
+		{
+			[][]string{{MULTI}, {SELECT, "2"}, {SET, "a", "2"}, {EXEC}},
+			[][]string{{SELECT, "2"}, {MULTI}, {SET, "a", "2"}, {EXEC}},
+		},
+	}
+
+	reader := resp.NewReader(file)
+	for _, tt := range tests {
+		start, _ := file.Seek(0, io.SeekCurrent)
+		for _, args := range tt.commands {
+			s.call(c, commandTable[args[0]], args)
+		}
+
+		file.Seek(start, io.SeekStart)
+		var logged [][]string
+		for {
+			args, err := reader.ReadCommand()
+			if err != nil {
+				break
+			}
+			logged = append(logged, args)
+		}
+		if !reflect.DeepEqual(logged, tt.want) {
+			t.Errorf("for %v, got %q logged, want %q", tt.commands, logged, tt.want)
+		}
+	}
+}
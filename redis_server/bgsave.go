@@ -0,0 +1,312 @@
+package server
+
+import (
+	"bytes"
+	"ccwc/redis_server/resp"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	BGSAVE   = "BGSAVE"
+	LASTSAVE = "LASTSAVE"
+)
+
+// BGSAVE writes a point-in-time snapshot while the commands keep running.
+// A goroutine walks the live dictionary, holding the server lock only while
+// it encodes a batch of keys. Before a command modifies a key the saver hasn't
+// reached yet, the value the key had when the save started is preserved, so
+// only the modified keys are copied (copy-on-write).
+
+// number of keys encoded at a time by the saver
+const bgsaveBatchSize = 1000
+
+// an automatic save that failed is retried after this delay
+const bgsaveRetryDelay = 5 * time.Second
+
+var bgsaveAbortedErr = errors.New("background save aborted")
+
+// cowSnapshot is the state of a background save
+type cowSnapshot struct {
+	dbs []*cowDB // the databases that weren't empty when the save started
+
+	start     time.Time
+	dirty     int64 // changes when the save started
+	total     int   // number of keys when the save started
+	processed int   // number of keys saved
+
+	// aof is set for an AOF rewrite, aofTail holds the commands executed since it started
+	aof     bool
+	aofTail bytes.Buffer
+}
+
+// cowDB is the state of the save of a database. The keyspace being saved
+// is followed when SWAPDB moves it to another database, and isn't modified
+// anymore once FLUSHDB replaced it.
+type cowDB struct {
+	id   int // the number of the database when the save started
+	keys *keyspace
+	// originals holds the values at the start of the save of the keys
+	// modified since then and not saved yet
+	originals map[string]cowOriginal
+	written   map[string]struct{} // the keys already saved
+}
+
+type cowOriginal struct {
+	val RedisValue
+	// existed is false for a key created during the save
+	existed bool
+}
+
+// preserve keeps the value of key for the background save in progress,
+// it must be called before key is modified.
+func (s *Server) preserve(key string) {
+	if s.cow == nil {
+		return
+	}
+	var cow *cowDB
+	for _, saved := range s.cow.dbs {
+		if saved.keys == s.db.keyspace {
+			cow = saved
+		}
+	}
+	if cow == nil {
+		return
+	}
+	if _, ok := cow.written[key]; ok {
+		return
+	}
+	if _, ok := cow.originals[key]; ok {
+		return
+	}
+	val, ok := cow.keys.dict[key]
+	val.value = copyValue(val.value)
+	cow.originals[key] = cowOriginal{val: val, existed: ok}
+}
+
+// BGSAVE [SCHEDULE] saves the dataset in the background. With SCHEDULE, the
+// save starts once the one in progress is over.
+func (s *Server) handleBgsave(c *client, args []string) resp.Value {
+	schedule := len(args) == 2 && strings.EqualFold(args[1], "SCHEDULE")
+	if len(args) > 1 && !schedule {
+		return resp.NewError("ERR syntax error")
+	}
+	if s.cow != nil {
+		switch {
+		case schedule:
+			s.bgsaveScheduled = true
+			return resp.NewSimpleString("Background saving scheduled")
+		case s.cow.aof:
+			return resp.NewError("ERR Another child process is active (AOF?): can't BGSAVE right now. " +
+				"Use BGSAVE SCHEDULE in order to schedule a BGSAVE whenever possible")
+		default:
+			return resp.NewError("ERR Background save already in progress")
+		}
+	}
+	if err := s.startBgsave(); err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	return resp.NewSimpleString("Background saving started")
+}
+
+// LASTSAVE returns the Unix time of the last successful save
+func (s *Server) handleLastSave(c *client, args []string) resp.Value {
+	return resp.NewInteger(s.lastSave.Unix())
+}
+
+// startBgsave starts a background save, the server lock must be held
+func (s *Server) startBgsave() error {
+	path := s.config.snapshotPath()
+	file, err := os.CreateTemp(filepath.Dir(path), "temp-*.rdb")
+	if err != nil {
+		s.lastBgsaveOK, s.lastBgsaveTry = false, time.Now()
+		return err
+	}
+
+	s.bgsaveScheduled = false
+	s.startSnapshot(file, false, func(cow *cowSnapshot, err error) {
+		if err == nil {
+			// a SAVE can't replace the file meanwhile
+			err = os.Rename(file.Name(), path)
+		}
+		s.lastBgsaveTime = time.Since(cow.start)
+		if err != nil {
+			s.lastBgsaveOK, s.lastBgsaveTry = false, time.Now()
+			s.logf(logWarning, "Background saving error: %s", err)
+			return
+		}
+		s.lastBgsaveOK = true
+		s.lastSave = time.Now()
+		s.dirty -= cow.dirty
+		s.rdbSaves++
+		s.logf(logNotice, "Background saving terminated with success")
+	})
+	s.logf(logNotice, "Background saving started")
+	return nil
+}
+
+// startSnapshot writes a snapshot of the dataset to file in the background.
+// Once it is written and synced, finish is called with the server lock held,
+// then the file is closed and removed unless finish renamed it.
+func (s *Server) startSnapshot(file *os.File, aof bool, finish func(cow *cowSnapshot, err error)) {
+	cow := &cowSnapshot{
+		start: time.Now(),
+		dirty: s.dirty,
+		aof:   aof,
+	}
+	for _, db := range s.dbs {
+		if len(db.dict) == 0 {
+			continue
+		}
+		cow.dbs = append(cow.dbs, &cowDB{
+			id:        db.id,
+			keys:      db.keyspace,
+			originals: make(map[string]cowOriginal),
+			written:   make(map[string]struct{}),
+		})
+		cow.total += len(db.dict)
+	}
+	s.cow = cow
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		err := s.writeSnapshot(cow, file)
+		if err == nil {
+			err = file.Sync()
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.cow != cow {
+			return
+		}
+		s.cow = nil
+		finish(cow, err)
+	}()
+}
+
+// abortSnapshot stops the background save or AOF rewrite in progress,
+// the server lock must be held
+func (s *Server) abortSnapshot() {
+	if s.cow == nil {
+		return
+	}
+	if s.cow.aof {
+		s.logf(logWarning, "Background AOF rewrite aborted")
+	} else {
+		s.logf(logWarning, "Background saving aborted")
+	}
+	s.cow = nil
+}
+
+// writeSnapshot encodes the snapshot into file. The keys are encoded while
+// the server lock is held, and written to the file once it is released.
+func (s *Server) writeSnapshot(cow *cowSnapshot, file *os.File) error {
+	var buf bytes.Buffer
+	e := newRDBEncoder(&buf)
+
+	s.mu.Lock()
+	if s.cow != cow {
+		s.mu.Unlock()
+		return bgsaveAbortedErr
+	}
+	e.writeHeader()
+
+	var err error
+	n := 0
+	for _, db := range cow.dbs {
+		// the number of keys with an expiration is a hint that isn't worth a scan
+		e.writeSelectDB(db.id, len(db.keys.dict), 0)
+		for key, val := range db.keys.dict {
+			if err = s.saveKey(db, e, key, val); err != nil {
+				break
+			}
+			if n++; n%bgsaveBatchSize == 0 {
+				// the buffer only holds complete entries after a flush
+				e.w.Flush()
+				s.mu.Unlock()
+				_, err = buf.WriteTo(file)
+				s.mu.Lock()
+				if err == nil && s.cow != cow {
+					err = bgsaveAbortedErr
+				}
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			// the keys deleted before the saver reached them
+			for key, original := range db.originals {
+				if err = s.saveKey(db, e, key, original.val); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = e.writeEnd()
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(file)
+	return err
+}
+
+// saveKey encodes the value that key had when the save started,
+// unless it was already saved or didn't exist then
+func (s *Server) saveKey(cow *cowDB, e *rdbEncoder, key string, val RedisValue) error {
+	if _, ok := cow.written[key]; ok {
+		return nil
+	}
+	cow.written[key] = struct{}{}
+	if original, ok := cow.originals[key]; ok {
+		if !original.existed {
+			return nil
+		}
+		val = original.val
+		delete(cow.originals, key)
+	}
+	s.cow.processed++
+	return e.writeEntry(key, val)
+}
+
+// autoSave starts the scheduled background saves, or a background save when
+// a point of the save policy is reached, the server lock must be held
+func (s *Server) autoSave() {
+	if s.cow != nil || s.shuttingDown {
+		return
+	}
+	if s.aofRewriteScheduled {
+		s.startAOFRewrite()
+		return
+	}
+	if s.bgsaveScheduled {
+		s.startBgsave()
+		return
+	}
+
+	now := time.Now()
+	// don't retry a failed save in a loop
+	if !s.lastBgsaveOK && now.Sub(s.lastBgsaveTry) < bgsaveRetryDelay {
+		return
+	}
+	for _, point := range s.config.Save {
+		if s.dirty >= int64(point.Changes) && now.Sub(s.lastSave) >= time.Duration(point.Seconds)*time.Second {
+			s.logf(logNotice, "%d changes in %d seconds. Saving...", point.Changes, point.Seconds)
+			s.startBgsave()
+			return
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBgsave_PointInTime(t *testing.T) {
+	config := DefaultConfig()
+	config.Dir = t.TempDir()
+	s := NewServerWithConfig(config)
+
+	want := make(map[string]RedisValue)
+	for i := 0; i < 5*bgsaveBatchSize; i++ {
+		key := "key:" + strconv.Itoa(i)
+		want[key] = RedisValue{value: strconv.Itoa(i)}
+		if i%10 == 0 {
+			want[key] = RedisValue{value: newList("a", strconv.Itoa(i))}
+		}
+		s.db.dict[key] = RedisValue{value: copyValue(want[key].value)}
+	}
+
+	s.mu.Lock()
+	if err := s.startBgsave(); err != nil {
+		t.Fatal(err)
+	}
+	// the dataset changes before and while the keys are saved
+	s.mu.Unlock()
+	for i := 0; i < len(want); i++ {
+		s.mu.Lock()
+		key := "key:" + strconv.Itoa(i)
+		switch i % 3 {
+		case 0:
+			s.setKey(key, RedisValue{value: "changed"})
+		case 1:
+			s.deleteKey(key)
+		default:
+			s.setKey("new:"+key, RedisValue{value: "new"})
+		}
+		if i%10 == 0 {
+			s.handleRPush(nil, []string{RPUSH, key, "pushed"})
+		}
+		s.mu.Unlock()
+	}
+	s.background.Wait()
+
+	if !s.lastBgsaveOK || s.rdbSaves != 1 {
+		t.Fatalf("got status %v and %d saves", s.lastBgsaveOK, s.rdbSaves)
+	}
+	file, err := os.Open(config.snapshotPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	dicts, err := readRDB(file, config.Databases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dicts[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d keys, want the %d keys at the start of the save", len(dicts[0]), len(want))
+	}
+}
+
+func TestBgsave_Databases(t *testing.T) {
+	config := DefaultConfig()
+	config.Dir = t.TempDir()
+	s := NewServerWithConfig(config)
+	want := []map[string]RedisValue{{}, {}, {}}
+	for _, db := range []int{0, 2} {
+		for i := 0; i < 3*bgsaveBatchSize; i++ {
+			key := "key:" + strconv.Itoa(i)
+			want[db][key] = RedisValue{value: strconv.Itoa(db)}
+			s.dbs[db].dict[key] = RedisValue{value: newList(strconv.Itoa(db))}
+		}
+	}
+
+	s.mu.Lock()
+	if err := s.startBgsave(); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Unlock()
+	c := &client{db: s.dbs[0]}
+	for i := 0; i < 3*bgsaveBatchSize; i++ {
+		s.mu.Lock()
+		switch i {
+		case 10:
+			// the keys of the database 2 are modified from the database 0
+			s.call(c, commandTable[SWAPDB], []string{SWAPDB, "0", "2"})
+		case 100:
+			s.call(c, commandTable[FLUSHDB], []string{FLUSHDB})
+		}
+		s.call(c, commandTable[RPUSH], []string{RPUSH, "key:" + strconv.Itoa(i), "pushed"})
+		s.mu.Unlock()
+	}
+	s.background.Wait()
+
+	file, err := os.Open(config.snapshotPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	dicts, err := readRDB(file, config.Databases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for db := range want {
+		got := make(map[string]RedisValue)
+		for key, val := range dicts[db] {
+			got[key] = RedisValue{value: strings.Join(val.value.(*list).values(), ",")}
+		}
+		if !reflect.DeepEqual(got, want[db]) {
+			t.Errorf("got %d keys in the database %d, want the %d keys at the start of the save", len(got), db, len(want[db]))
+		}
+	}
+}
+
+func TestBgsave_Abort(t *testing.T) {
+	config := DefaultConfig()
+	config.Dir = t.TempDir()
+	s := NewServerWithConfig(config)
+	s.db.dict["key"] = RedisValue{value: "value"}
+
+	s.mu.Lock()
+	s.startBgsave()
+	s.abortSnapshot()
+	s.mu.Unlock()
+	s.background.Wait()
+
+	if _, err := os.Stat(config.snapshotPath()); !os.IsNotExist(err) {
+		t.Errorf("got %v, want no snapshot", err)
+	}
+	entries, _ := os.ReadDir(config.Dir)
+	if len(entries) != 0 {
+		t.Errorf("the temporary file %s is left", entries[0].Name())
+	}
+}
+
+func TestAutoSave(t *testing.T) {
+	config := DefaultConfig()
+	config.Dir = t.TempDir()
+	config.Save = []SavePoint{{Seconds: 10, Changes: 2}}
+	s := NewServerWithConfig(config)
+
+	tests := []struct {
+		since   time.Duration // since the last save
+		changes int64
+		want    bool
+	}{
+		{time.Second, 5, false},
+		{time.Minute, 1, false},
+		{time.Minute, 2, true},
+	}
+
+	for _, tt := range tests {
+		s.mu.Lock()
+		s.lastSave = time.Now().Add(-tt.since)
+		s.dirty = tt.changes
+		s.autoSave()
+		started := s.cow != nil
+		s.mu.Unlock()
+		s.background.Wait()
+
+		if started != tt.want {
+			t.Errorf("after %s with %d changes, got save %v, want %v", tt.since, tt.changes, started, tt.want)
+		}
+	}
+	// the changes made during the save are left
+	if s.dirty != 0 {
+		t.Errorf("got %d changes since the last save, want 0", s.dirty)
+	}
+}
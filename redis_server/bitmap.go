@@ -0,0 +1,571 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+const (
+	SETBIT   = "SETBIT"
+	GETBIT   = "GETBIT"
+	BITCOUNT = "BITCOUNT"
+	BITPOS   = "BITPOS"
+	BITOP    = "BITOP"
+	BITFIELD = "BITFIELD"
+)
+
+// Bitmaps are strings: the bit at offset n is the bit n%8 of the byte n/8,
+// counting from the most significant bit. Writing past the end of a string
+// pads it with zero bytes.
+
+var bitOffsetErr = errors.New("bit offset is not an integer or out of range")
+
+// parseBitOffset parses a bit offset, that must address a bit of a string of
+// at most 512MB
+func parseBitOffset(arg string) (int64, error) {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 || offset>>3 >= maxStringLen {
+		return 0, bitOffsetErr
+	}
+	return offset, nil
+}
+
+// getBit returns the bit at offset, 0 past the end of str
+func getBit(str string, offset int64) byte {
+	if offset>>3 >= int64(len(str)) {
+		return 0
+	}
+	return str[offset>>3] >> (7 - offset&7) & 1
+}
+
+// growBytes returns a copy of str padded with zero bytes to at least n bytes
+func growBytes(str string, n int64) []byte {
+	if n < int64(len(str)) {
+		n = int64(len(str))
+	}
+	b := make([]byte, n)
+	copy(b, str)
+	return b
+}
+
+// SETBIT key offset value sets or clears the bit at offset, returns its
+// previous value
+func (s *Server) handleSetBit(c *client, args []string) resp.Value {
+	offset, err := parseBitOffset(args[2])
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	if args[3] != "0" && args[3] != "1" {
+		return resp.NewError("ERR bit is not an integer or out of range")
+	}
+	val, exists := s.lookupKey(args[1])
+	str, ok := val.value.(string)
+	if exists && !ok {
+		return wrongTypeReply
+	}
+
+	old := getBit(str, offset)
+	b := growBytes(str, offset>>3+1)
+	mask := byte(1) << (7 - offset&7)
+	if args[3] == "1" {
+		b[offset>>3] |= mask
+	} else {
+		b[offset>>3] &^= mask
+	}
+	val.value = string(b)
+	// the expiration is kept
+	s.setKey(args[1], val)
+	return resp.NewInteger(int64(old))
+}
+
+// GETBIT key offset returns the bit at offset
+func (s *Server) handleGetBit(c *client, args []string) resp.Value {
+	offset, err := parseBitOffset(args[2])
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	str, _, ok := s.lookupString(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	return resp.NewInteger(int64(getBit(str, offset)))
+}
+
+// bitRange is a range of bits of a string, first and last included
+type bitRange struct {
+	first, last int64
+}
+
+// parseBitRange parses the start and end [BYTE|BIT] arguments of BITCOUNT and
+// BITPOS, negative offsets count from the end of str. ok is false if the
+// range is empty.
+func parseBitRange(str string, args []string) (r bitRange, ok bool, err error) {
+	start, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return r, false, notIntegerErr
+	}
+	end := int64(-1)
+	if len(args) > 1 {
+		if end, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return r, false, notIntegerErr
+		}
+	}
+	bitUnit := false
+	if len(args) > 2 {
+		switch strings.ToUpper(args[2]) {
+		case "BIT":
+			bitUnit = true
+		case "BYTE":
+		default:
+			return r, false, syntaxErr
+		}
+	}
+
+	n := int64(len(str))
+	if bitUnit {
+		n *= 8
+	}
+	if start < 0 && end < 0 && start > end {
+		return r, false, nil
+	}
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if start > end {
+		return r, false, nil
+	}
+	if bitUnit {
+		return bitRange{start, end}, true, nil
+	}
+	return bitRange{start * 8, end*8 + 7}, true, nil
+}
+
+// BITCOUNT key [start end [BYTE|BIT]] counts the bits set to 1 in the string,
+// or in the range of bytes or bits
+func (s *Server) handleBitCount(c *client, args []string) resp.Value {
+	if len(args) == 3 || len(args) > 5 {
+		return resp.NewError("ERR syntax error")
+	}
+	str, _, ok := s.lookupString(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	r := bitRange{0, int64(len(str))*8 - 1}
+	if len(args) > 2 {
+		var err error
+		if r, ok, err = parseBitRange(str, args[2:]); err != nil {
+			return resp.NewError("ERR " + err.Error())
+		} else if !ok {
+			return resp.NewInteger(0)
+		}
+	}
+
+	count := 0
+	for i := r.first; i <= r.last; {
+		if i&7 == 0 && i+7 <= r.last {
+			count += bits.OnesCount8(str[i>>3])
+			i += 8
+			continue
+		}
+		count += int(getBit(str, i))
+		i++
+	}
+	return resp.NewInteger(int64(count))
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]] returns the position of the first
+// bit set to bit in the string, or in the range of bytes or bits. Returns -1
+// if there is none, but the string is considered padded with zeros on the
+// right when looking for a 0 without an end.
+func (s *Server) handleBitPos(c *client, args []string) resp.Value {
+	if len(args) > 6 {
+		return resp.NewError("ERR syntax error")
+	}
+	if args[2] != "0" && args[2] != "1" {
+		return resp.NewError("ERR The bit argument must be 1 or 0.")
+	}
+	bit := args[2][0] - '0'
+	val, exists := s.lookupKey(args[1])
+	if !exists {
+		if bit == 1 {
+			return resp.NewInteger(-1)
+		}
+		return resp.NewInteger(0)
+	}
+	str, ok := val.value.(string)
+	if !ok {
+		return wrongTypeReply
+	}
+	r := bitRange{0, int64(len(str))*8 - 1}
+	if len(args) > 3 {
+		var err error
+		if r, ok, err = parseBitRange(str, args[3:]); err != nil {
+			return resp.NewError("ERR " + err.Error())
+		} else if !ok {
+			return resp.NewInteger(-1)
+		}
+	}
+
+	if r.first > r.last {
+		// empty string
+		return resp.NewInteger(-1)
+	}
+	// the bytes that only hold the other bit are skipped
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for i := r.first; i <= r.last; {
+		if i&7 == 0 && i+7 <= r.last && str[i>>3] == skip {
+			i += 8
+			continue
+		}
+		if getBit(str, i) == bit {
+			return resp.NewInteger(i)
+		}
+		i++
+	}
+	if bit == 1 || len(args) > 4 {
+		return resp.NewInteger(-1)
+	}
+	return resp.NewInteger(r.last + 1)
+}
+
+// BITOP AND|OR|XOR|NOT destkey key [key ...] stores the bitwise operation
+// between the strings at the keys in destkey, the shorter strings are padded
+// with zeros. Returns the length of the result, destkey is deleted if it is
+// empty.
+func (s *Server) handleBitOp(c *client, args []string) resp.Value {
+	op := strings.ToUpper(args[1])
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 4 {
+			return resp.NewError("ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return resp.NewError("ERR syntax error")
+	}
+
+	dest := args[2]
+	sources := make([]string, 0, len(args)-3)
+	n := 0
+	for _, key := range args[3:] {
+		str, _, ok := s.lookupString(key)
+		if !ok {
+			return wrongTypeReply
+		}
+		sources = append(sources, str)
+		if len(str) > n {
+			n = len(str)
+		}
+	}
+	if n == 0 {
+		s.deleteKey(dest)
+		return resp.NewInteger(0)
+	}
+
+	result := make([]byte, n)
+	for i := range result {
+		var b byte
+		for j, str := range sources {
+			var sb byte
+			if i < len(str) {
+				sb = str[i]
+			}
+			switch {
+			case op == "NOT":
+				b = ^sb
+			case j == 0:
+				b = sb
+			case op == "AND":
+				b &= sb
+			case op == "OR":
+				b |= sb
+			case op == "XOR":
+				b ^= sb
+			}
+		}
+		result[i] = b
+	}
+	s.setKey(dest, RedisValue{value: string(result)})
+	return resp.NewInteger(int64(n))
+}
+
+// overflow modes of BITFIELD
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// bitfieldOp is a subcommand of BITFIELD
+type bitfieldOp struct {
+	op       string // GET, SET or INCRBY
+	signed   bool
+	width    int
+	offset   int64
+	value    int64 // of SET and INCRBY
+	overflow int
+}
+
+// parseBitfieldType parses a type such as i8 or u16, u64 isn't supported as
+// the replies are signed
+func parseBitfieldType(arg string) (signed bool, width int, err error) {
+	invalid := errors.New("Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	if len(arg) < 2 {
+		return false, 0, invalid
+	}
+	switch arg[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+	default:
+		return false, 0, invalid
+	}
+	width, err = strconv.Atoi(arg[1:])
+	if err != nil || width < 1 || (signed && width > 64) || (!signed && width > 63) {
+		return false, 0, invalid
+	}
+	return signed, width, nil
+}
+
+// parseBitfieldOffset parses an offset in bits, or in multiples of the width
+// with the # prefix
+func parseBitfieldOffset(arg string, width int) (int64, error) {
+	multiple := strings.HasPrefix(arg, "#")
+	if multiple {
+		arg = arg[1:]
+	}
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, bitOffsetErr
+	}
+	if multiple {
+		if offset > 0 && offset > (maxStringLen*8)/int64(width) {
+			return 0, bitOffsetErr
+		}
+		offset *= int64(width)
+	}
+	if offset < 0 || offset>>3 >= maxStringLen {
+		return 0, bitOffsetErr
+	}
+	return offset, nil
+}
+
+// parseBitfieldOps parses the subcommands of BITFIELD
+func parseBitfieldOps(args []string) ([]bitfieldOp, error) {
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	for i := 0; i < len(args); i++ {
+		op := strings.ToUpper(args[i])
+		switch {
+		case op == "OVERFLOW" && i+1 < len(args):
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return nil, errors.New("Invalid OVERFLOW type specified")
+			}
+			i++
+		case op == "GET" && i+2 < len(args), (op == "SET" || op == "INCRBY") && i+3 < len(args):
+			o := bitfieldOp{op: op, overflow: overflow}
+			var err error
+			if o.signed, o.width, err = parseBitfieldType(args[i+1]); err != nil {
+				return nil, err
+			}
+			if o.offset, err = parseBitfieldOffset(args[i+2], o.width); err != nil {
+				return nil, err
+			}
+			if op != "GET" {
+				if o.value, err = strconv.ParseInt(args[i+3], 10, 64); err != nil {
+					return nil, notIntegerErr
+				}
+				i++
+			}
+			ops = append(ops, o)
+			i += 2
+		default:
+			return nil, syntaxErr
+		}
+	}
+	return ops, nil
+}
+
+// getBits returns the width bits at offset as an unsigned integer
+func getBits(b []byte, offset int64, width int) uint64 {
+	var v uint64
+	for i := int64(0); i < int64(width); i++ {
+		pos := offset + i
+		var bit uint64
+		if pos>>3 < int64(len(b)) {
+			bit = uint64(b[pos>>3]>>(7-pos&7)) & 1
+		}
+		v = v<<1 | bit
+	}
+	return v
+}
+
+// setBits writes the width low bits of v at offset, b must be large enough
+func setBits(b []byte, offset int64, width int, v uint64) {
+	for i := int64(0); i < int64(width); i++ {
+		pos := offset + i
+		mask := byte(1) << (7 - pos&7)
+		if v>>(int64(width)-1-i)&1 == 1 {
+			b[pos>>3] |= mask
+		} else {
+			b[pos>>3] &^= mask
+		}
+	}
+}
+
+// signedField reads a signed integer of the given width
+func signedField(v uint64, width int) int64 {
+	if width < 64 && v>>(width-1)&1 == 1 {
+		v |= ^uint64(0) << width
+	}
+	return int64(v)
+}
+
+// addSigned returns value+incr in a signed field of the given width, and
+// whether it overflowed
+func addSigned(value, incr int64, width, overflow int) (int64, bool) {
+	max := int64(^uint64(0) >> (65 - width))
+	min := -max - 1
+	maxIncr, minIncr := max-value, min-value
+	var sat int64
+	switch {
+	case value > max || (width != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr):
+		sat = max
+	case value < min || (width != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr):
+		sat = min
+	default:
+		return value + incr, false
+	}
+	if overflow == overflowSat {
+		return sat, true
+	}
+	return signedField(uint64(value+incr)&(^uint64(0)>>(64-width)), width), true
+}
+
+// addUnsigned returns value+incr in an unsigned field of the given width,
+// and whether it overflowed
+func addUnsigned(value uint64, incr int64, width, overflow int) (uint64, bool) {
+	max := ^uint64(0) >> (64 - width)
+	var sat uint64
+	switch {
+	case value > max || (incr > 0 && uint64(incr) > max-value):
+		sat = max
+	case incr < 0 && uint64(-incr) > value:
+		sat = 0
+	default:
+		return value + uint64(incr), false
+	}
+	if overflow == overflowSat {
+		return sat, true
+	}
+	return (value + uint64(incr)) & max, true
+}
+
+// BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]
+// reads and writes integers of arbitrary width at any bit offset of the
+// string. SET returns the previous value and INCRBY the new one. OVERFLOW
+// sets how the following SET and INCRBY overflow: WRAP wraps around, SAT
+// saturates to the minimum or maximum value and FAIL replies nil without
+// writing.
+func (s *Server) handleBitField(c *client, args []string) resp.Value {
+	ops, err := parseBitfieldOps(args[2:])
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	val, exists := s.lookupKey(args[1])
+	str, ok := val.value.(string)
+	if exists && !ok {
+		return wrongTypeReply
+	}
+
+	// the string is padded to the highest field written, even if the
+	// writes fail
+	var size int64
+	for _, o := range ops {
+		if end := (o.offset+int64(o.width)-1)>>3 + 1; o.op != "GET" && end > size {
+			size = end
+		}
+	}
+	written := size > 0
+	var b []byte
+	if written {
+		b = growBytes(str, size)
+	} else {
+		b = []byte(str)
+	}
+
+	values := make([]resp.Value, 0, len(ops))
+	for _, o := range ops {
+		old := getBits(b, o.offset, o.width)
+		if o.op == "GET" {
+			if o.signed {
+				values = append(values, resp.NewInteger(signedField(old, o.width)))
+			} else {
+				values = append(values, resp.NewInteger(int64(old)))
+			}
+			continue
+		}
+
+		var field uint64
+		var reply int64
+		var overflowed bool
+		if o.signed {
+			oldValue := signedField(old, o.width)
+			var v int64
+			if o.op == "INCRBY" {
+				v, overflowed = addSigned(oldValue, o.value, o.width, o.overflow)
+				reply = v
+			} else {
+				v, overflowed = addSigned(o.value, 0, o.width, o.overflow)
+				reply = oldValue
+			}
+			field = uint64(v)
+		} else {
+			if o.op == "INCRBY" {
+				field, overflowed = addUnsigned(old, o.value, o.width, o.overflow)
+				reply = int64(field)
+			} else {
+				field, overflowed = addUnsigned(uint64(o.value), 0, o.width, o.overflow)
+				reply = int64(old)
+			}
+		}
+		if overflowed && o.overflow == overflowFail {
+			values = append(values, resp.NewNull())
+			continue
+		}
+		setBits(b, o.offset, o.width, field)
+		values = append(values, resp.NewInteger(reply))
+	}
+
+	if written {
+		val.value = string(b)
+		// the expiration is kept
+		s.setKey(args[1], val)
+	}
+	return resp.NewArray(values...)
+}
@@ -0,0 +1,378 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	BLPOP      = "BLPOP"
+	BRPOP      = "BRPOP"
+	BLMOVE     = "BLMOVE"
+	BRPOPLPUSH = "BRPOPLPUSH"
+)
+
+// A blocking pop on empty lists, or a blocking read of streams without new
+// entries, blocks the client: it is queued on each of the keys, and the
+// connection waits for the reply without holding the server lock. Once a
+// command pushed to a key or added an entry to it, the clients blocked on it
+// are served in the order they blocked, as long as the key can serve them. A
+// blocked client is unblocked when its timeout expires or when it
+// disconnects.
+
+// blockedClient is a client blocked by a pop
+type blockedClient struct {
+	c    *client
+	db   *database // the database of the keys
+	keys []string
+	// front pops at the head of the lists, at the tail otherwise
+	front bool
+	// move pushes the element popped to destination, at the head if toFront
+	move        bool
+	destination string
+	toFront     bool
+	// read is set for the stream reads, the fields above are unused
+	read *streamRead
+
+	timeout time.Duration // 0 blocks forever
+	served  bool
+	reply   chan resp.Value // receives the reply once served
+}
+
+// streamRead is a blocked XREAD or XREADGROUP
+type streamRead struct {
+	ids   map[string]streamID // XREAD returns the entries after them
+	count int
+	// XREADGROUP returns the new entries of the group
+	group    string
+	consumer string
+	noAck    bool
+}
+
+// BLPOP key [key ...] timeout pops the head of the first non-empty list, or
+// blocks until an element is pushed to one of them. Returns the key and the
+// element, nil if the timeout expires.
+func (s *Server) handleBLPop(c *client, args []string) resp.Value {
+	return s.blockingPop(c, args, true)
+}
+
+// BRPOP key [key ...] timeout pops the tail of the first non-empty list, or
+// blocks until an element is pushed to one of them
+func (s *Server) handleBRPop(c *client, args []string) resp.Value {
+	return s.blockingPop(c, args, false)
+}
+
+func (s *Server) blockingPop(c *client, args []string, front bool) resp.Value {
+	keys := args[1 : len(args)-1]
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+
+	for _, key := range keys {
+		l, ok := s.lookupList(key)
+		if !ok {
+			return wrongTypeReply
+		}
+		if l != nil {
+			c.propagate = []string{popCommand(front), key}
+			return s.popBlocked(key, l, front)
+		}
+	}
+
+	return s.block(c, &blockedClient{keys: keys, front: front, timeout: timeout})
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout is LMOVE, it blocks
+// until an element is pushed to source if it is empty
+func (s *Server) handleBLMove(c *client, args []string) resp.Value {
+	from, okFrom := parseListSide(args[3])
+	to, okTo := parseListSide(args[4])
+	if !okFrom || !okTo {
+		return resp.NewError("ERR syntax error")
+	}
+	return s.blockingMove(c, args[1], args[2], from, to, args[5])
+}
+
+// BRPOPLPUSH source destination timeout is BLMOVE source destination RIGHT LEFT timeout
+func (s *Server) handleBRPopLPush(c *client, args []string) resp.Value {
+	return s.blockingMove(c, args[1], args[2], false, true, args[3])
+}
+
+func (s *Server) blockingMove(c *client, source, destination string, from, to bool, timeoutArg string) resp.Value {
+	timeout, err := parseTimeout(timeoutArg)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	l, ok := s.lookupList(source)
+	if !ok {
+		return wrongTypeReply
+	}
+	if l != nil {
+		c.propagate = moveCommand(source, destination, from, to)
+		return s.move(source, destination, from, to)
+	}
+
+	return s.block(c, &blockedClient{
+		keys:        []string{source},
+		front:       from,
+		move:        true,
+		destination: destination,
+		toFront:     to,
+		timeout:     timeout,
+	})
+}
+
+// parseTimeout parses a timeout in seconds, 0 means forever
+func parseTimeout(arg string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, errors.New("timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, errors.New("timeout is negative")
+	}
+	if seconds > float64(math.MaxInt64/time.Second) {
+		return 0, errors.New("timeout is out of range")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func popCommand(front bool) string {
+	if front {
+		return LPOP
+	}
+	return RPOP
+}
+
+// moveCommand returns the LMOVE command equivalent to a blocking move
+func moveCommand(source, destination string, from, to bool) []string {
+	side := func(front bool) string {
+		if front {
+			return "LEFT"
+		}
+		return "RIGHT"
+	}
+	return []string{LMOVE, source, destination, side(from), side(to)}
+}
+
+// popBlocked pops an element of the list at key for BLPOP or BRPOP
+func (s *Server) popBlocked(key string, l *list, front bool) resp.Value {
+	s.touchKey(key)
+	value := l.pop(front)
+	if l.len() == 0 {
+		s.deleteKey(key)
+	}
+	return resp.NewArray(resp.NewBulkString(key), resp.NewBulkString(value))
+}
+
+// block queues the client on the keys, the connection waits for the reply
+// once the command returns, see waitUnblocked. The commands of a transaction
+// can't block, they reply as if the timeout expired.
+func (s *Server) block(c *client, bc *blockedClient) resp.Value {
+	if c.inExec {
+		return bc.timeoutReply()
+	}
+	bc.c = c
+	bc.db = s.db
+	bc.reply = make(chan resp.Value, 1)
+	for _, key := range bc.keys {
+		s.db.blocked[key] = append(s.db.blocked[key], bc)
+	}
+	s.blockedClients++
+	c.blocked = bc
+	return resp.Value{}
+}
+
+// timeoutReply is the reply of a blocked client once its timeout expired
+func (bc *blockedClient) timeoutReply() resp.Value {
+	if bc.move {
+		return resp.NewNull()
+	}
+	return resp.NewNullArray()
+}
+
+// unblock removes the client from the queues of its keys
+func (s *Server) unblock(bc *blockedClient) {
+	for _, key := range bc.keys {
+		queue := bc.db.blocked[key]
+		for i, other := range queue {
+			if other == bc {
+				queue = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(bc.db.blocked, key)
+		} else {
+			bc.db.blocked[key] = queue
+		}
+	}
+	s.blockedClients--
+}
+
+// readyKey is a key of a database that may serve blocked clients
+type readyKey struct {
+	db  *database
+	key string
+}
+
+// signalReady records that elements were pushed to key,
+// the clients blocked on it are served once the command returns
+func (s *Server) signalReady(key string) {
+	if _, ok := s.db.blocked[key]; !ok {
+		return
+	}
+	ready := readyKey{s.db, key}
+	for _, other := range s.readyKeys {
+		if other == ready {
+			return
+		}
+	}
+	s.readyKeys = append(s.readyKeys, ready)
+}
+
+// serveBlocked serves the clients blocked on the keys that received
+// elements, the server lock must be held
+func (s *Server) serveBlocked() {
+	for len(s.readyKeys) > 0 {
+		key := s.readyKeys[0].key
+		s.db = s.readyKeys[0].db
+		s.readyKeys = s.readyKeys[1:]
+
+		// the clients that the key can't serve are skipped, the moves to a
+		// destination of another type or the reads of other entries
+		for i := 0; i < len(s.db.blocked[key]); {
+			bc := s.db.blocked[key][i]
+			if !s.canServe(bc, key) {
+				i++
+				continue
+			}
+			s.unblock(bc)
+			reply, command := s.serve(bc, key)
+			if command != nil {
+				s.propagate(command)
+			}
+			bc.served = true
+			bc.reply <- reply
+		}
+	}
+}
+
+// canServe reports whether the blocked client can be served by key
+func (s *Server) canServe(bc *blockedClient, key string) bool {
+	if bc.read != nil {
+		if bc.read.group != "" {
+			st, g, _ := s.lookupGroup(key, bc.read.group)
+			return g != nil && len(st.after(g.lastID, 1)) > 0
+		}
+		st, ok := s.lookupStream(key)
+		return ok && st != nil && len(st.after(bc.read.ids[key], 1)) > 0
+	}
+	l, ok := s.lookupList(key)
+	if !ok || l == nil {
+		return false
+	}
+	if bc.move {
+		_, ok := s.lookupList(bc.destination)
+		return ok
+	}
+	return true
+}
+
+// serve returns the reply of the blocked client served by key, and the
+// command that has the same effect
+func (s *Server) serve(bc *blockedClient, key string) (reply resp.Value, command []string) {
+	c := bc.c
+	switch {
+	case bc.read != nil && bc.read.group != "":
+		opts := streamReadOptions{count: bc.read.count, group: bc.read.group, consumer: bc.read.consumer, noAck: bc.read.noAck}
+		entries := s.readGroup(key, opts, true, streamID{})
+		return streamsReply(c, []resp.Value{resp.NewBulkString(key), entries}),
+			readGroupCommand(opts, []string{key}, []string{">"})
+	case bc.read != nil:
+		st, _ := s.lookupStream(key)
+		entries := entriesReply(st.after(bc.read.ids[key], bc.read.count))
+		// reading doesn't change the dataset
+		return streamsReply(c, []resp.Value{resp.NewBulkString(key), entries}), nil
+	case bc.move:
+		command = moveCommand(key, bc.destination, bc.front, bc.toFront)
+		// may signal destination
+		return s.move(key, bc.destination, bc.front, bc.toFront), command
+	default:
+		l, _ := s.lookupList(key)
+		return s.popBlocked(key, l, bc.front), []string{popCommand(bc.front), key}
+	}
+}
+
+// waitUnblocked waits until the blocked client is served, its timeout
+// expires or it disconnects, and returns the reply of its command
+func (s *Server) waitUnblocked(c *client) resp.Value {
+	bc := c.blocked
+	c.blocked = nil
+
+	var expired <-chan time.Time
+	if bc.timeout > 0 {
+		timer := time.NewTimer(bc.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	// reading the connection notices that the client disconnected
+	// without consuming the commands it may send meanwhile
+	peeked := make(chan error, 1)
+	go func() {
+		_, err := c.reader.Peek(1)
+		peeked <- err
+	}()
+	peeking, disconnected := true, false
+	var reply resp.Value
+	served := false
+
+wait:
+	for {
+		select {
+		case reply = <-bc.reply:
+			served = true
+			break wait
+		case <-expired:
+			break wait
+		case err := <-peeked:
+			peeking = false
+			if err != nil {
+				disconnected = true
+				break wait
+			}
+		}
+	}
+
+	s.mu.Lock()
+	switch {
+	case served:
+	case bc.served:
+		// served meanwhile
+		reply = <-bc.reply
+	case disconnected || s.shuttingDown:
+		s.unblock(bc)
+		c.closing = true
+	default:
+		s.unblock(bc)
+		reply = bc.timeoutReply()
+	}
+	s.mu.Unlock()
+
+	if peeking {
+		// interrupt the read, the deadline is restored unless the server is
+		// stopping meanwhile, see shutdown
+		c.conn.SetReadDeadline(time.Now())
+		<-peeked
+		s.mu.Lock()
+		if !s.shuttingDown {
+			c.conn.SetReadDeadline(time.Time{})
+		}
+		s.mu.Unlock()
+	}
+	return reply
+}
@@ -0,0 +1,277 @@
+// Package client is a client for the server, with a pool of connections.
+//
+// Do sends any command and Pipeline sends several at once. The common
+// commands also have typed methods: Get, Set, SetArgs, Del, Exists, Incr,
+// Decr, Expire, TTL, LPush, RPush, HSet, HGet and Save.
+package client
+
+import (
+	"ccwc/redis_server/resp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Nil is returned by the typed methods when the key does not exist
+var Nil = errors.New("redis: nil")
+
+var ClosedErr = errors.New("redis: client is closed")
+
+type Options struct {
+	// Addr is the host:port address of the server
+	Addr string
+	// PoolSize is the maximum number of open connections, 10 by default
+	PoolSize int
+	// DialTimeout bounds the connection to the server, 5 seconds by default
+	DialTimeout time.Duration
+	// Protocol is the RESP version negotiated with HELLO, 2 by default
+	Protocol int
+	// DB is the database selected by the connections, 0 by default
+	DB int
+}
+
+// Client is a connection pool to a server, safe for concurrent use.
+// A context deadline or cancellation bounds every call.
+type Client struct {
+	opts Options
+
+	mu     sync.Mutex
+	idle   []*conn
+	tokens chan struct{} // one token per connection in use
+	closed bool
+}
+
+type conn struct {
+	netConn net.Conn
+	reader  *resp.Reader
+	writer  *resp.Writer
+}
+
+func New(opts Options) *Client {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 10
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.Protocol == 0 {
+		opts.Protocol = resp.RESP2
+	}
+	return &Client{
+		opts:   opts,
+		tokens: make(chan struct{}, opts.PoolSize),
+	}
+}
+
+// Close closes the idle connections, the connections in use are closed once released
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	var err error
+	for _, cn := range c.idle {
+		if closeErr := cn.netConn.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	c.idle = nil
+	return err
+}
+
+// Do sends a command and returns its reply decoded by resp.Reader.
+// An error reply is returned as a resp.Error.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.exec(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	return replyOrError(replies[0])
+}
+
+// exec sends the commands in a single write and reads their replies
+func (c *Client) exec(ctx context.Context, cmds [][]string) ([]any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := cn.roundTrip(ctx, cmds)
+	// the state of the stream is unknown after a network error
+	c.put(cn, err != nil)
+	return replies, err
+}
+
+func (cn *conn) roundTrip(ctx context.Context, cmds [][]string) ([]any, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.netConn.SetDeadline(deadline)
+	} else {
+		cn.netConn.SetDeadline(time.Time{})
+	}
+	// unblock the connection when the context is cancelled
+	if ctx.Done() != nil {
+		stop, done := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(done)
+			select {
+			case <-ctx.Done():
+				cn.netConn.SetDeadline(time.Now())
+			case <-stop:
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+	for _, args := range cmds {
+		cn.writer.WriteValue(resp.NewBulkStringArray(args))
+	}
+	if err := cn.writer.Flush(); err != nil {
+		return nil, contextErr(ctx, err)
+	}
+
+	replies := make([]any, len(cmds))
+	for i := range cmds {
+		reply, err := cn.reader.Read()
+		if err != nil {
+			return nil, contextErr(ctx, err)
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// get returns an idle connection or opens a new one if the pool isn't full
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case c.tokens <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.tokens
+		return nil, ClosedErr
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	cn, err := c.dial(ctx)
+	if err != nil {
+		<-c.tokens
+		return nil, err
+	}
+	return cn, nil
+}
+
+// put releases a connection, a broken one is closed
+func (c *Client) put(cn *conn, broken bool) {
+	c.mu.Lock()
+	if broken || c.closed {
+		cn.netConn.Close()
+	} else {
+		c.idle = append(c.idle, cn)
+	}
+	c.mu.Unlock()
+	<-c.tokens
+}
+
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{
+		netConn: netConn,
+		reader:  resp.NewReader(netConn),
+		writer:  resp.NewWriter(netConn),
+	}
+
+	var setup [][]string
+	if c.opts.Protocol != resp.RESP2 {
+		setup = append(setup, []string{"HELLO", strconv.Itoa(c.opts.Protocol)})
+	}
+	if c.opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.opts.DB)})
+	}
+	if len(setup) > 0 {
+		replies, err := cn.roundTrip(ctx, setup)
+		for i := 0; err == nil && i < len(replies); i++ {
+			_, err = replyOrError(replies[i])
+		}
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func replyOrError(reply any) (any, error) {
+	if err, ok := reply.(resp.Error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// contextErr reports the context error rather than the I/O timeout it caused
+func contextErr(ctx context.Context, err error) error {
+	// the connection deadline may expire just before the context one
+	if _, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) {
+		<-ctx.Done()
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// Pipeline queues commands and sends them in a single write with Exec
+type Pipeline struct {
+	client *Client
+	cmds   [][]string
+}
+
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{client: c}
+}
+
+// Do queues a command
+func (p *Pipeline) Do(args ...string) {
+	p.cmds = append(p.cmds, args)
+}
+
+// Len returns the number of queued commands
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Exec sends the queued commands and returns their replies in the same order,
+// error replies are returned as resp.Error values. The pipeline is emptied.
+func (p *Pipeline) Exec(ctx context.Context) ([]any, error) {
+	cmds := p.cmds
+	p.cmds = nil
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	return p.client.exec(ctx, cmds)
+}
+
+// replyError reports an unexpected reply type
+func replyError(reply any) error {
+	return fmt.Errorf("redis: unexpected reply type %T", reply)
+}
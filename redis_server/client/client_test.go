@@ -0,0 +1,269 @@
+package client_test
+
+import (
+	"ccwc/redis_server"
+	"ccwc/redis_server/client"
+	"ccwc/redis_server/resp"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAddr = "localhost:8889"
+
+func TestClient_Commands(t *testing.T) {
+	c := client.New(client.Options{Addr: testAddr})
+	defer c.Close()
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "client:name", "JOHN", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get(ctx, "client:name"); err != nil || got != "JOHN" {
+		t.Errorf("got %q, %v, want %q", got, err, "JOHN")
+	}
+	if _, err := c.Get(ctx, "client:missing"); err != client.Nil {
+		t.Errorf("got %v, want %v", err, client.Nil)
+	}
+	if got, err := c.Incr(ctx, "client:counter"); err != nil || got != 1 {
+		t.Errorf("got %d, %v, want %d", got, err, 1)
+	}
+	if got, err := c.Decr(ctx, "client:counter"); err != nil || got != 0 {
+		t.Errorf("got %d, %v, want %d", got, err, 0)
+	}
+	if got, err := c.RPush(ctx, "client:list", "a", "b"); err != nil || got != 2 {
+		t.Errorf("got %d, %v, want %d", got, err, 2)
+	}
+	if got, err := c.LPush(ctx, "client:list", "c"); err != nil || got != 3 {
+		t.Errorf("got %d, %v, want %d", got, err, 3)
+	}
+	if got, err := c.Exists(ctx, "client:name", "client:list", "client:missing"); err != nil || got != 2 {
+		t.Errorf("got %d, %v, want %d", got, err, 2)
+	}
+	if got, err := c.Del(ctx, "client:name", "client:missing"); err != nil || got != 1 {
+		t.Errorf("got %d, %v, want %d", got, err, 1)
+	}
+
+	// an error reply is returned as a resp.Error
+	_, err := c.Incr(ctx, "client:list")
+	var errReply resp.Error
+	if !errors.As(err, &errReply) {
+		t.Errorf("got %v, want a resp.Error", err)
+	}
+}
+
+func TestClient_SetExpiration(t *testing.T) {
+	c := client.New(client.Options{Addr: testAddr})
+	defer c.Close()
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "client:ttl", "v", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetArgs(ctx, "client:at", "v", client.SetArgs{ExpireAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	// a TTL under a millisecond is rounded up instead of being sent as 0
+	if err := c.Set(ctx, "client:short", "v", time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, "client:negative", "v", -time.Second); err != client.InvalidTTLErr {
+		t.Errorf("got %v, want %v", err, client.InvalidTTLErr)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := c.Get(ctx, "client:ttl"); err != client.Nil {
+		t.Errorf("got %v, want %v", err, client.Nil)
+	}
+	if _, err := c.Get(ctx, "client:short"); err != client.Nil {
+		t.Errorf("got %v, want %v", err, client.Nil)
+	}
+
+	if ok, err := c.Expire(ctx, "client:at", 100*time.Second); err != nil || !ok {
+		t.Errorf("got %v, %v, want true", ok, err)
+	}
+	if got, err := c.TTL(ctx, "client:at"); err != nil || got <= 99*time.Second || got > 100*time.Second {
+		t.Errorf("got %v, %v, want 100s", got, err)
+	}
+	if ok, err := c.Expire(ctx, "client:at", 1500*time.Millisecond); err != nil || !ok {
+		t.Errorf("got %v, %v, want true", ok, err)
+	}
+	if got, err := c.TTL(ctx, "client:at"); err != nil || got <= time.Second || got > 1500*time.Millisecond {
+		t.Errorf("got %v, %v, want 1.5s", got, err)
+	}
+	if ok, err := c.Expire(ctx, "client:missing", time.Second); err != nil || ok {
+		t.Errorf("got %v, %v, want false", ok, err)
+	}
+	if _, err := c.Expire(ctx, "client:at", 0); err != client.InvalidTTLErr {
+		t.Errorf("got %v, want %v", err, client.InvalidTTLErr)
+	}
+	if _, err := c.TTL(ctx, "client:missing"); err != client.Nil {
+		t.Errorf("got %v, want %v", err, client.Nil)
+	}
+	c.Set(ctx, "client:persistent", "v", 0)
+	if got, err := c.TTL(ctx, "client:persistent"); err != nil || got != -1 {
+		t.Errorf("got %v, %v, want -1", got, err)
+	}
+}
+
+func TestClient_Hash(t *testing.T) {
+	c := client.New(client.Options{Addr: testAddr})
+	defer c.Close()
+	ctx := context.Background()
+
+	if got, err := c.HSet(ctx, "client:hash", "a", "1", "b", "2"); err != nil || got != 2 {
+		t.Errorf("got %d, %v, want %d", got, err, 2)
+	}
+	if got, err := c.HGet(ctx, "client:hash", "b"); err != nil || got != "2" {
+		t.Errorf("got %q, %v, want %q", got, err, "2")
+	}
+	if _, err := c.HGet(ctx, "client:hash", "missing"); err != client.Nil {
+		t.Errorf("got %v, want %v", err, client.Nil)
+	}
+}
+
+func TestClient_Pool(t *testing.T) {
+	c := client.New(client.Options{Addr: testAddr, PoolSize: 3})
+	defer c.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Incr(ctx, "client:concurrent"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, err := c.Get(ctx, "client:concurrent"); err != nil || got != "50" {
+		t.Errorf("got %q, %v, want %q", got, err, "50")
+	}
+}
+
+func TestClient_Pipeline(t *testing.T) {
+	c := client.New(client.Options{Addr: testAddr})
+	defer c.Close()
+
+	pipe := c.Pipeline()
+	for i := 0; i < 3; i++ {
+		pipe.Do("SET", "client:pipe"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	pipe.Do("GET", "client:pipe2")
+	pipe.Do("INCR", "client:pipe1")
+	pipe.Do("NOPE")
+
+	got, err := pipe.Exec(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{"OK", "OK", "OK", "2", 2, resp.Error("ERR unknown command 'NOPE'")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if pipe.Len() != 0 {
+		t.Errorf("the pipeline wasn't emptied")
+	}
+}
+
+func TestClient_Resp3(t *testing.T) {
+	c := client.New(client.Options{Addr: testAddr, Protocol: resp.RESP3})
+	defer c.Close()
+
+	got, err := c.Do(context.Background(), "HELLO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.(resp.Map); !ok {
+		t.Errorf("got %T, want a resp.Map", got)
+	}
+}
+
+func TestClient_DB(t *testing.T) {
+	ctx := context.Background()
+	c := client.New(client.Options{Addr: testAddr, DB: 3})
+	defer c.Close()
+	if err := c.Set(ctx, "client:db", "3", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "client:db"); err != nil {
+		t.Errorf("got %v in the same database", err)
+	}
+
+	other := client.New(client.Options{Addr: testAddr})
+	defer other.Close()
+	if _, err := other.Get(ctx, "client:db"); err != client.Nil {
+		t.Errorf("got %v in the database 0, want client.Nil", err)
+	}
+
+	bad := client.New(client.Options{Addr: testAddr, DB: 100})
+	defer bad.Close()
+	if _, err := bad.Do(ctx, "PING"); err == nil {
+		t.Error("a database out of range was selected")
+	}
+}
+
+func TestClient_ContextTimeout(t *testing.T) {
+	// a server that never replies
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c := client.New(client.Options{Addr: l.Addr().String()})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = c.Get(ctx, "key"); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err = c.Get(ctx, "key"); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func init() {
+	config := server.DefaultConfig()
+	config.Port = 8889
+	// the snapshots are written out of the source tree
+	dir, err := os.MkdirTemp("", "redis_client")
+	if err != nil {
+		panic(err)
+	}
+	config.Dir = dir
+
+	s := server.NewServerWithConfig(config)
+	go s.Run(context.Background())
+
+	// wait for the server to accept connections
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", testAddr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
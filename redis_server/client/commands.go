@@ -0,0 +1,157 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// InvalidTTLErr is returned for a negative expiration, which the server refuses
+var InvalidTTLErr = errors.New("redis: negative expiration")
+
+// Get returns the value of key, or Nil if the key does not exist
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	return stringReply(c.Do(ctx, "GET", key))
+}
+
+// Set sets key to value, an expiration of 0 means that the key doesn't expire
+func (c *Client) Set(ctx context.Context, key, value string, expiration time.Duration) error {
+	return c.SetArgs(ctx, key, value, SetArgs{TTL: expiration})
+}
+
+// SetArgs are the options of SET, at most one of TTL and ExpireAt is set
+type SetArgs struct {
+	// TTL expires the key after a duration, sent with millisecond precision.
+	// A duration under a millisecond is rounded up to one.
+	TTL time.Duration
+	// ExpireAt expires the key at a point in time
+	ExpireAt time.Time
+}
+
+func (c *Client) SetArgs(ctx context.Context, key, value string, a SetArgs) error {
+	args := []string{"SET", key, value}
+	switch {
+	case a.TTL < 0:
+		return InvalidTTLErr
+	case a.TTL > 0 && a.TTL%time.Second == 0:
+		args = append(args, "EX", strconv.FormatInt(int64(a.TTL/time.Second), 10))
+	case a.TTL > 0:
+		args = append(args, "PX", strconv.FormatInt(milliseconds(a.TTL), 10))
+	case !a.ExpireAt.IsZero():
+		args = append(args, "PXAT", strconv.FormatInt(a.ExpireAt.UnixMilli(), 10))
+	}
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+// Del removes the keys and returns the number of keys that were removed
+func (c *Client) Del(ctx context.Context, keys ...string) (int64, error) {
+	return intReply(c.Do(ctx, append([]string{"DEL"}, keys...)...))
+}
+
+// Exists returns the number of keys that exist, a key given twice is counted twice
+func (c *Client) Exists(ctx context.Context, keys ...string) (int64, error) {
+	return intReply(c.Do(ctx, append([]string{"EXISTS"}, keys...)...))
+}
+
+func (c *Client) Incr(ctx context.Context, key string) (int64, error) {
+	return intReply(c.Do(ctx, "INCR", key))
+}
+
+func (c *Client) Decr(ctx context.Context, key string) (int64, error) {
+	return intReply(c.Do(ctx, "DECR", key))
+}
+
+// LPush inserts the values at the head of the list and returns its length
+func (c *Client) LPush(ctx context.Context, key string, values ...string) (int64, error) {
+	return intReply(c.Do(ctx, append([]string{"LPUSH", key}, values...)...))
+}
+
+// RPush inserts the values at the tail of the list and returns its length
+func (c *Client) RPush(ctx context.Context, key string, values ...string) (int64, error) {
+	return intReply(c.Do(ctx, append([]string{"RPUSH", key}, values...)...))
+}
+
+// Expire sets the time to live of key, with millisecond precision. It returns
+// false if the key doesn't exist.
+func (c *Client) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	if expiration <= 0 {
+		return false, InvalidTTLErr
+	}
+	var n int64
+	var err error
+	if expiration%time.Second == 0 {
+		n, err = intReply(c.Do(ctx, "EXPIRE", key, strconv.FormatInt(int64(expiration/time.Second), 10)))
+	} else {
+		n, err = intReply(c.Do(ctx, "PEXPIRE", key, strconv.FormatInt(milliseconds(expiration), 10)))
+	}
+	return n == 1, err
+}
+
+// TTL returns the time to live of key with millisecond precision, -1 if the
+// key doesn't expire, or Nil if the key does not exist
+func (c *Client) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ms, err := intReply(c.Do(ctx, "PTTL", key))
+	switch {
+	case err != nil:
+		return 0, err
+	case ms == -2:
+		return 0, Nil
+	case ms == -1:
+		return -1, nil
+	default:
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+}
+
+// HSet sets the fields of the hash, given as field, value pairs, and returns
+// the number of fields added
+func (c *Client) HSet(ctx context.Context, key string, fieldValues ...string) (int64, error) {
+	return intReply(c.Do(ctx, append([]string{"HSET", key}, fieldValues...)...))
+}
+
+// HGet returns the value of the field of the hash, or Nil if it does not exist
+func (c *Client) HGet(ctx context.Context, key, field string) (string, error) {
+	return stringReply(c.Do(ctx, "HGET", key, field))
+}
+
+// Save performs a synchronous snapshot of the dataset
+func (c *Client) Save(ctx context.Context) error {
+	_, err := c.Do(ctx, "SAVE")
+	return err
+}
+
+// milliseconds returns d in milliseconds, rounded up to 1 so that a positive
+// duration isn't sent as 0, which the server refuses
+func milliseconds(d time.Duration) int64 {
+	if d > 0 && d < time.Millisecond {
+		return 1
+	}
+	return d.Milliseconds()
+}
+
+func stringReply(reply any, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch r := reply.(type) {
+	case string:
+		return r, nil
+	case nil:
+		return "", Nil
+	default:
+		return "", replyError(reply)
+	}
+}
+
+func intReply(reply any, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int)
+	if !ok {
+		return 0, replyError(reply)
+	}
+	return int64(n), nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"ccwc/redis_server/resp"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var InvalidArgsErr = errors.New("Invalid argument(s)")
+
+// splitArgs splits a line typed by the user into arguments the way redis-cli
+// does: arguments are separated by spaces and may be quoted. Double quoted
+// arguments support the escapes \n \r \t \b \a \" \\ and \xhh, single quoted
+// arguments only support \'. A closing quote must be followed by a space.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		// skip blanks
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		inDoubleQuotes, inSingleQuotes := false, false
+		current := strings.Builder{}
+		for done := false; !done; i++ {
+			if i == len(line) {
+				// unterminated quotes
+				if inDoubleQuotes || inSingleQuotes {
+					return nil, InvalidArgsErr
+				}
+				break
+			}
+			ch := line[i]
+			switch {
+			case inDoubleQuotes:
+				switch {
+				case ch == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current.WriteByte(byte(b))
+					i += 3
+				case ch == '\\' && i+1 < len(line):
+					i++
+					current.WriteByte(unescape(line[i]))
+				case ch == '"':
+					// the closing quote must be followed by a space or nothing
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, InvalidArgsErr
+					}
+					done = true
+				default:
+					current.WriteByte(ch)
+				}
+			case inSingleQuotes:
+				switch {
+				case ch == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					current.WriteByte('\'')
+				case ch == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, InvalidArgsErr
+					}
+					done = true
+				default:
+					current.WriteByte(ch)
+				}
+			default:
+				switch {
+				case isSpace(ch):
+					done = true
+				case ch == '"':
+					inDoubleQuotes = true
+				case ch == '\'':
+					inSingleQuotes = true
+				default:
+					current.WriteByte(ch)
+				}
+			}
+		}
+		args = append(args, current.String())
+	}
+}
+
+func unescape(ch byte) byte {
+	switch ch {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return ch
+	}
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\n' || ch == '\r' || ch == '\t' || ch == '\v' || ch == '\f'
+}
+
+func isHex(ch byte) bool {
+	return (ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
+// formatTTY formats a reply for a terminal the way redis-cli does, e.g.
+// (integer) 3, (nil) or numbered array items. prefix is the indentation of
+// the nested aggregates. The result ends with a new line.
+func formatTTY(v resp.Value, prefix string) string {
+	if v.IsNull {
+		return "(nil)\n"
+	}
+
+	switch v.Type {
+	case resp.Errors:
+		return "(error) " + v.Str + "\n"
+	case resp.SimpleString:
+		return v.Str + "\n"
+	case resp.Integers:
+		return "(integer) " + strconv.FormatInt(v.Int, 10) + "\n"
+	case resp.Doubles:
+		return "(double) " + formatDouble(v.Float) + "\n"
+	case resp.BigNumbers:
+		return "(big number) " + v.Str + "\n"
+	case resp.BulkStrings:
+		return quote(v.Str) + "\n"
+	case resp.VerbatimString:
+		return v.Str + "\n"
+	case resp.Booleans:
+		if v.Int != 0 {
+			return "(true)\n"
+		}
+		return "(false)\n"
+	case resp.Arrays, resp.Maps, resp.Sets, resp.Pushes:
+		return formatAggregateTTY(v, prefix)
+	default: // Null
+		return "(nil)\n"
+	}
+}
+
+func formatAggregateTTY(v resp.Value, prefix string) string {
+	size := len(v.Elems)
+	separator := ")"
+	switch v.Type {
+	case resp.Maps:
+		size /= 2
+		separator = "#"
+	case resp.Sets:
+		separator = "~"
+	}
+	if size == 0 {
+		switch v.Type {
+		case resp.Maps:
+			return "(empty hash)\n"
+		case resp.Sets:
+			return "(empty set)\n"
+		default:
+			return "(empty array)\n"
+		}
+	}
+
+	// the nested elements are indented by the width of the largest index
+	idxLen := len(strconv.Itoa(size))
+	nestedPrefix := prefix + strings.Repeat(" ", idxLen+2)
+
+	sb := strings.Builder{}
+	for i := 0; i < len(v.Elems); i++ {
+		idx := i + 1
+		if v.Type == resp.Maps {
+			idx = i/2 + 1
+		}
+		// the caller already wrote the prefix of the first element
+		if i > 0 {
+			sb.WriteString(prefix)
+		}
+		sb.WriteString(strings.Repeat(" ", idxLen-len(strconv.Itoa(idx))))
+		sb.WriteString(strconv.Itoa(idx) + separator + " ")
+		sb.WriteString(strings.TrimSuffix(formatTTY(v.Elems[i], nestedPrefix), "\n"))
+		if v.Type == resp.Maps {
+			i++
+			sb.WriteString(" => ")
+			sb.WriteString(strings.TrimSuffix(formatTTY(v.Elems[i], nestedPrefix), "\n"))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// formatRaw formats a reply without type information, for scripts
+func formatRaw(v resp.Value) string {
+	if v.IsNull {
+		return ""
+	}
+
+	switch v.Type {
+	case resp.Integers:
+		return strconv.FormatInt(v.Int, 10)
+	case resp.Doubles:
+		return formatDouble(v.Float)
+	case resp.Booleans:
+		if v.Int != 0 {
+			return "1"
+		}
+		return "0"
+	case resp.Arrays, resp.Maps, resp.Sets, resp.Pushes:
+		elems := make([]string, len(v.Elems))
+		for i, elem := range v.Elems {
+			elems[i] = formatRaw(elem)
+		}
+		return strings.Join(elems, "\n")
+	case resp.Null:
+		return ""
+	default:
+		return v.Str
+	}
+}
+
+// quote returns s between double quotes with its special characters escaped
+func quote(s string) string {
+	sb := strings.Builder{}
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch ch {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(ch)
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		case '\a':
+			sb.WriteString("\\a")
+		case '\b':
+			sb.WriteString("\\b")
+		default:
+			if ch < 0x20 || ch >= 0x7f {
+				sb.WriteString("\\x")
+				sb.WriteString(strconv.FormatUint(uint64(ch)|0x100, 16)[1:])
+			} else {
+				sb.WriteByte(ch)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
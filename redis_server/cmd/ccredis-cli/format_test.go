@@ -0,0 +1,93 @@
+package main
+
+import (
+	"ccwc/redis_server/resp"
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		Description string
+		Line        string
+		Want        []string
+		WantErr     bool
+	}{
+		{"arguments are split on spaces", "SET  key\tvalue ", []string{"SET", "key", "value"}, false},
+		{"empty line has no arguments", "   ", nil, false},
+		{"double quotes keep spaces", `SET k "hello world"`, []string{"SET", "k", "hello world"}, false},
+		{"double quotes support escapes", `SET k "a\n\"b\"\x41"`, []string{"SET", "k", "a\n\"b\"A"}, false},
+		{"single quotes are literal", `SET k 'a\nb \'c\''`, []string{"SET", "k", `a\nb 'c'`}, false},
+		{"empty quoted argument", `SET k ""`, []string{"SET", "k", ""}, false},
+		{"unterminated quotes are an error", `SET k "abc`, nil, true},
+		{"closing quote must be followed by a space", `SET k "a"b`, nil, true},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Description, func(t *testing.T) {
+			got, err := splitArgs(test.Line)
+			if (err != nil) != test.WantErr {
+				t.Fatalf("got error %v, want error %v", err, test.WantErr)
+			}
+			if !reflect.DeepEqual(got, test.Want) {
+				t.Errorf("got %q, want %q", got, test.Want)
+			}
+		})
+	}
+}
+
+func TestFormatTTY(t *testing.T) {
+	cases := []struct {
+		Description string
+		Value       resp.Value
+		Want        string
+	}{
+		{"status is printed as is", resp.OK, "OK\n"},
+		{"bulk string is quoted", resp.NewBulkString("a \"b\"\n"), "\"a \\\"b\\\"\\n\"\n"},
+		{"integer", resp.NewInteger(3), "(integer) 3\n"},
+		{"null", resp.NewNull(), "(nil)\n"},
+		{"null array", resp.NewNullArray(), "(nil)\n"},
+		{"error", resp.NewError("ERR boom"), "(error) ERR boom\n"},
+		{"boolean", resp.NewBool(true), "(true)\n"},
+		{"double", resp.NewDouble(1.5), "(double) 1.5\n"},
+		{"empty array", resp.NewArray(), "(empty array)\n"},
+		{"array items are numbered", resp.NewArray(resp.NewBulkString("a"), resp.NewInteger(1)),
+			"1) \"a\"\n2) (integer) 1\n"},
+		{"nested arrays are indented", resp.NewArray(resp.NewArray(resp.NewBulkString("a"), resp.NewBulkString("b")), resp.NewBulkString("c")),
+			"1) 1) \"a\"\n   2) \"b\"\n2) \"c\"\n"},
+		{"indexes are aligned", resp.NewBulkStringArray([]string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}),
+			" 1) \"1\"\n 2) \"2\"\n 3) \"3\"\n 4) \"4\"\n 5) \"5\"\n 6) \"6\"\n 7) \"7\"\n 8) \"8\"\n 9) \"9\"\n10) \"10\"\n"},
+		{"map pairs are numbered", resp.NewMap(resp.NewBulkString("k"), resp.NewInteger(1), resp.NewBulkString("l"), resp.NewArray(resp.NewInteger(2))),
+			"1# \"k\" => (integer) 1\n2# \"l\" => 1) (integer) 2\n"},
+		{"set items are numbered", resp.NewSet(resp.NewBulkString("a")), "1~ \"a\"\n"},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Description, func(t *testing.T) {
+			if got := formatTTY(test.Value, ""); got != test.Want {
+				t.Errorf("got %q, want %q", got, test.Want)
+			}
+		})
+	}
+}
+
+func TestFormatRaw(t *testing.T) {
+	cases := []struct {
+		Description string
+		Value       resp.Value
+		Want        string
+	}{
+		{"string", resp.NewBulkString("a b"), "a b"},
+		{"integer", resp.NewInteger(-2), "-2"},
+		{"null", resp.NewNull(), ""},
+		{"array", resp.NewArray(resp.NewBulkString("a"), resp.NewInteger(1)), "a\n1"},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Description, func(t *testing.T) {
+			if got := formatRaw(test.Value); got != test.Want {
+				t.Errorf("got %q, want %q", got, test.Want)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// ccredis-cli is a command line interface to redis_server.
+//
+//	ccredis-cli [-h host] [-p port] [-3] [--raw | --no-raw] [cmd [arg...]]
+//
+// With a command it is sent and its reply printed, otherwise the commands are
+// read from the standard input: one per line for scripts, or interactively
+// with a prompt when the standard input is a terminal.
+package main
+
+import (
+	"bufio"
+	"ccwc/redis_server/resp"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type cli struct {
+	addr   string
+	proto  int
+	raw    bool
+	conn   net.Conn
+	reader *resp.Reader
+	writer *resp.Writer
+}
+
+func main() {
+	host := flag.String("h", "127.0.0.1", "Server hostname")
+	port := flag.Int("p", 6379, "Server port")
+	resp3 := flag.Bool("3", false, "Start session in RESP3 protocol mode")
+	raw := flag.Bool("raw", false, "Use raw formatting for replies (default when STDOUT is not a tty)")
+	noRaw := flag.Bool("no-raw", false, "Force formatted output even when STDOUT is not a tty")
+	flag.Parse()
+
+	c := &cli{
+		addr:  net.JoinHostPort(*host, strconv.Itoa(*port)),
+		proto: resp.RESP2,
+		raw:   *raw || (!*noRaw && !isTerminal(os.Stdout)),
+	}
+	if *resp3 {
+		c.proto = resp.RESP3
+	}
+
+	switch {
+	case flag.NArg() > 0:
+		// one-shot mode: ccredis-cli SET k v
+		if err := c.connect(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		reply, err := c.send(flag.Args())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		c.print(reply)
+		if reply.IsError() {
+			os.Exit(1)
+		}
+	case !isTerminal(os.Stdin):
+		if err := c.connect(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := c.runScript(os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	default:
+		c.repl()
+	}
+}
+
+// runScript sends the commands of r, one per line
+func (c *cli) runScript(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		args, err := splitArgs(scanner.Text())
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+		reply, err := c.send(args)
+		if err != nil {
+			return err
+		}
+		c.print(reply)
+	}
+	return scanner.Err()
+}
+
+// repl reads the commands typed by the user until quit, exit or end of input
+func (c *cli) repl() {
+	if err := c.connect(); err != nil {
+		fmt.Println(err)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if c.conn != nil {
+			fmt.Print(c.addr + "> ")
+		} else {
+			fmt.Print("not connected> ")
+		}
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+
+		args, err := splitArgs(scanner.Text())
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if cmd := strings.ToLower(args[0]); cmd == "quit" || cmd == "exit" {
+			return
+		}
+
+		// reconnect if the server went away
+		if c.conn == nil {
+			if err = c.connect(); err != nil {
+				fmt.Println(err)
+				continue
+			}
+		}
+		reply, err := c.send(args)
+		if err != nil {
+			fmt.Println(err)
+			c.close()
+			continue
+		}
+		c.print(reply)
+	}
+}
+
+func (c *cli) connect() error {
+	conn, err := net.Dial("tcp", c.addr)
+	if err != nil {
+		return fmt.Errorf("Could not connect to Redis at %s: %s", c.addr, err)
+	}
+	c.conn = conn
+	c.reader = resp.NewReader(conn)
+	c.writer = resp.NewWriter(conn)
+
+	if c.proto == resp.RESP3 {
+		reply, err := c.send([]string{"HELLO", "3"})
+		if err != nil {
+			c.close()
+			return err
+		}
+		if reply.IsError() {
+			c.close()
+			return fmt.Errorf("Unable to switch to RESP3: %s", reply.Str)
+		}
+	}
+	return nil
+}
+
+func (c *cli) close() {
+	c.conn.Close()
+	c.conn = nil
+}
+
+func (c *cli) send(args []string) (resp.Value, error) {
+	c.writer.WriteValue(resp.NewBulkStringArray(args))
+	if err := c.writer.Flush(); err != nil {
+		return resp.Value{}, err
+	}
+	reply, err := c.reader.ReadValue()
+	if err == io.EOF {
+		err = fmt.Errorf("Error: Server closed the connection")
+	}
+	return reply, err
+}
+
+func (c *cli) print(reply resp.Value) {
+	if c.raw {
+		fmt.Println(formatRaw(reply))
+	} else {
+		fmt.Print(formatTTY(reply, ""))
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,66 @@
+// redis-server runs a server configured by a redis.conf-style file and flags,
+// the flags override the parameters of the file:
+//
+//	redis-server [/path/to/redis.conf] [--port 6379] [--bind localhost] ...
+//
+// SIGINT and SIGTERM shut the server down gracefully.
+package main
+
+import (
+	"ccwc/redis_server"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+func main() {
+	args := os.Args[1:]
+	config := server.DefaultConfig()
+
+	// the configuration file is the first argument if it isn't a flag
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		var err error
+		config, err = server.LoadConfig(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		args = args[1:]
+	}
+
+	flags := flag.NewFlagSet("redis-server", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: redis-server [/path/to/redis.conf] [options]")
+		flags.PrintDefaults()
+	}
+	defaults := server.DefaultConfig()
+	for _, name := range server.ConfigParams() {
+		value, _ := defaults.Get(name)
+		flags.String(name, value, server.ConfigUsage(name))
+	}
+	flags.Parse(args)
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if err == nil {
+			if setErr := config.Set(f.Name, f.Value.String()); setErr != nil {
+				err = fmt.Errorf("invalid value %q for flag -%s: %s", f.Value, f.Name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := server.NewServerWithConfig(config).Run(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import "ccwc/redis_server/resp"
+
+type command struct {
+	handler func(s *Server, c *client, args []string) resp.Value
+	// arity is the number of arguments including the command name,
+	// -N means that the command takes at least N arguments
+	arity int
+	flags int
+}
+
+// command flags
+const (
+	// flagWrite marks the commands that may modify the dataset
+	flagWrite = 1 << iota
+	// flagDenyOOM marks the commands that may use more memory,
+	// they are refused when the memory used is over maxmemory
+	flagDenyOOM
+	// flagNoQueue marks the commands executed right away inside a
+	// transaction instead of being queued
+	flagNoQueue
+	// flagNoMulti marks the commands refused inside a transaction, e.g.
+	// the ones that close the connection instead of replying
+	flagNoMulti
+)
+
+var commandTable = map[string]command{
+	SET:      {(*Server).handleSet, -3, flagWrite | flagDenyOOM},
+	GET:      {(*Server).handleGet, 2, 0},
+	EXISTS:   {(*Server).handleExists, -2, 0},
+	DEL:      {(*Server).handleDelete, -2, flagWrite},
+	INCR:     {(*Server).handleIncr, 2, flagWrite | flagDenyOOM},
+	DECR:     {(*Server).handleDecr, 2, flagWrite | flagDenyOOM},
+	RPUSH:    {(*Server).handleRPush, -3, flagWrite | flagDenyOOM},
+	LPUSH:    {(*Server).handleLPush, -3, flagWrite | flagDenyOOM},
+	SAVE:     {(*Server).handleSave, 1, 0},
+	LOAD:     {(*Server).handleLoad, 1, flagWrite},
+	HELLO:    {(*Server).handleHello, -1, 0},
+	CONFIG:   {(*Server).handleConfig, -2, 0},
+	SHUTDOWN: {(*Server).handleShutdown, -1, flagNoMulti},
+	BGSAVE:   {(*Server).handleBgsave, -1, 0},
+	LASTSAVE: {(*Server).handleLastSave, 1, 0},
+	INFO:     {(*Server).handleInfo, -1, 0},
+
+	BGREWRITEAOF: {(*Server).handleBgrewriteaof, 1, 0},
+
+	MULTI:   {(*Server).handleMulti, 1, flagNoQueue},
+	EXEC:    {(*Server).handleExec, 1, flagNoQueue},
+	DISCARD: {(*Server).handleDiscard, 1, flagNoQueue},
+	WATCH:   {(*Server).handleWatch, -2, flagNoQueue},
+	UNWATCH: {(*Server).handleUnwatch, 1, 0},
+
+	KEYS:      {(*Server).handleKeys, 2, 0},
+	SCAN:      {(*Server).handleScan, -2, 0},
+	TYPE:      {(*Server).handleType, 2, 0},
+	RENAME:    {(*Server).handleRename, 3, flagWrite},
+	RENAMENX:  {(*Server).handleRenameNX, 3, flagWrite},
+	RANDOMKEY: {(*Server).handleRandomKey, 1, 0},
+	DBSIZE:    {(*Server).handleDBSize, 1, 0},
+	COPY:      {(*Server).handleCopy, -3, flagWrite | flagDenyOOM},
+	TOUCH:     {(*Server).handleTouch, -2, 0},
+	UNLINK:    {(*Server).handleUnlink, -2, flagWrite},
+	SELECT:    {(*Server).handleSelect, 2, 0},
+	MOVE:      {(*Server).handleMove, 3, flagWrite},
+	SWAPDB:    {(*Server).handleSwapDB, 3, flagWrite},
+	FLUSHDB:   {(*Server).handleFlushDB, -1, flagWrite},
+	FLUSHALL:  {(*Server).handleFlushAll, -1, flagWrite},
+
+	MGET:        {(*Server).handleMGet, -2, 0},
+	MSET:        {(*Server).handleMSet, -3, flagWrite | flagDenyOOM},
+	MSETNX:      {(*Server).handleMSetNX, -3, flagWrite | flagDenyOOM},
+	SETNX:       {(*Server).handleSetNX, 3, flagWrite | flagDenyOOM},
+	SETEX:       {(*Server).handleSetEx, 4, flagWrite | flagDenyOOM},
+	PSETEX:      {(*Server).handlePSetEx, 4, flagWrite | flagDenyOOM},
+	GETSET:      {(*Server).handleGetSet, 3, flagWrite | flagDenyOOM},
+	GETDEL:      {(*Server).handleGetDel, 2, flagWrite},
+	GETEX:       {(*Server).handleGetEx, -2, flagWrite},
+	APPEND:      {(*Server).handleAppend, 3, flagWrite | flagDenyOOM},
+	STRLEN:      {(*Server).handleStrLen, 2, 0},
+	GETRANGE:    {(*Server).handleGetRange, 4, 0},
+	SETRANGE:    {(*Server).handleSetRange, 4, flagWrite | flagDenyOOM},
+	INCRBY:      {(*Server).handleIncrBy, 3, flagWrite | flagDenyOOM},
+	DECRBY:      {(*Server).handleDecrBy, 3, flagWrite | flagDenyOOM},
+	INCRBYFLOAT: {(*Server).handleIncrByFloat, 3, flagWrite | flagDenyOOM},
+
+	SETBIT:   {(*Server).handleSetBit, 4, flagWrite | flagDenyOOM},
+	GETBIT:   {(*Server).handleGetBit, 3, 0},
+	BITCOUNT: {(*Server).handleBitCount, -2, 0},
+	BITPOS:   {(*Server).handleBitPos, -3, 0},
+	BITOP:    {(*Server).handleBitOp, -4, flagWrite | flagDenyOOM},
+	BITFIELD: {(*Server).handleBitField, -2, flagWrite | flagDenyOOM},
+
+	PFADD:   {(*Server).handlePFAdd, -2, flagWrite | flagDenyOOM},
+	PFCOUNT: {(*Server).handlePFCount, -2, 0},
+	PFMERGE: {(*Server).handlePFMerge, -2, flagWrite | flagDenyOOM},
+
+	EXPIRE:    {(*Server).handleExpire, -3, flagWrite},
+	PEXPIRE:   {(*Server).handlePExpire, -3, flagWrite},
+	EXPIREAT:  {(*Server).handleExpireAt, -3, flagWrite},
+	PEXPIREAT: {(*Server).handlePExpireAt, -3, flagWrite},
+	TTL:       {(*Server).handleTTL, 2, 0},
+	PTTL:      {(*Server).handlePTTL, 2, 0},
+	PERSIST:   {(*Server).handlePersist, 2, flagWrite},
+
+	LPOP:      {(*Server).handleLPop, -2, flagWrite},
+	RPOP:      {(*Server).handleRPop, -2, flagWrite},
+	LLEN:      {(*Server).handleLLen, 2, 0},
+	LRANGE:    {(*Server).handleLRange, 4, 0},
+	LINDEX:    {(*Server).handleLIndex, 3, 0},
+	LSET:      {(*Server).handleLSet, 4, flagWrite | flagDenyOOM},
+	LINSERT:   {(*Server).handleLInsert, 5, flagWrite | flagDenyOOM},
+	LREM:      {(*Server).handleLRem, 4, flagWrite},
+	LTRIM:     {(*Server).handleLTrim, 4, flagWrite},
+	LPOS:      {(*Server).handleLPos, -3, 0},
+	LMOVE:     {(*Server).handleLMove, 5, flagWrite | flagDenyOOM},
+	RPOPLPUSH: {(*Server).handleRPopLPush, 3, flagWrite | flagDenyOOM},
+
+	BLPOP:      {(*Server).handleBLPop, -3, flagWrite},
+	BRPOP:      {(*Server).handleBRPop, -3, flagWrite},
+	BLMOVE:     {(*Server).handleBLMove, 6, flagWrite | flagDenyOOM},
+	BRPOPLPUSH: {(*Server).handleBRPopLPush, 4, flagWrite | flagDenyOOM},
+
+	HSET:         {(*Server).handleHSet, -4, flagWrite | flagDenyOOM},
+	HSETNX:       {(*Server).handleHSetNX, 4, flagWrite | flagDenyOOM},
+	HGET:         {(*Server).handleHGet, 3, 0},
+	HMGET:        {(*Server).handleHMGet, -3, 0},
+	HDEL:         {(*Server).handleHDel, -3, flagWrite},
+	HEXISTS:      {(*Server).handleHExists, 3, 0},
+	HLEN:         {(*Server).handleHLen, 2, 0},
+	HKEYS:        {(*Server).handleHKeys, 2, 0},
+	HVALS:        {(*Server).handleHVals, 2, 0},
+	HGETALL:      {(*Server).handleHGetAll, 2, 0},
+	HINCRBY:      {(*Server).handleHIncrBy, 4, flagWrite | flagDenyOOM},
+	HINCRBYFLOAT: {(*Server).handleHIncrByFloat, 4, flagWrite | flagDenyOOM},
+	HSCAN:        {(*Server).handleHScan, -3, 0},
+
+	SADD:        {(*Server).handleSAdd, -3, flagWrite | flagDenyOOM},
+	SREM:        {(*Server).handleSRem, -3, flagWrite},
+	SISMEMBER:   {(*Server).handleSIsMember, 3, 0},
+	SMISMEMBER:  {(*Server).handleSMIsMember, -3, 0},
+	SMEMBERS:    {(*Server).handleSMembers, 2, 0},
+	SCARD:       {(*Server).handleSCard, 2, 0},
+	SPOP:        {(*Server).handleSPop, -2, flagWrite},
+	SRANDMEMBER: {(*Server).handleSRandMember, -2, 0},
+	SINTER:      {(*Server).handleSInter, -2, 0},
+	SINTERSTORE: {(*Server).handleSInterStore, -3, flagWrite | flagDenyOOM},
+	SUNION:      {(*Server).handleSUnion, -2, 0},
+	SUNIONSTORE: {(*Server).handleSUnionStore, -3, flagWrite | flagDenyOOM},
+	SDIFF:       {(*Server).handleSDiff, -2, 0},
+	SDIFFSTORE:  {(*Server).handleSDiffStore, -3, flagWrite | flagDenyOOM},
+	SSCAN:       {(*Server).handleSScan, -3, 0},
+
+	ZADD:          {(*Server).handleZAdd, -4, flagWrite | flagDenyOOM},
+	ZREM:          {(*Server).handleZRem, -3, flagWrite},
+	ZSCORE:        {(*Server).handleZScore, 3, 0},
+	ZINCRBY:       {(*Server).handleZIncrBy, 4, flagWrite | flagDenyOOM},
+	ZCARD:         {(*Server).handleZCard, 2, 0},
+	ZRANK:         {(*Server).handleZRank, -3, 0},
+	ZREVRANK:      {(*Server).handleZRevRank, -3, 0},
+	ZRANGE:        {(*Server).handleZRange, -4, 0},
+	ZRANGEBYSCORE: {(*Server).handleZRangeByScore, -4, 0},
+	ZCOUNT:        {(*Server).handleZCount, 4, 0},
+	ZPOPMIN:       {(*Server).handleZPopMin, -2, flagWrite},
+	ZPOPMAX:       {(*Server).handleZPopMax, -2, flagWrite},
+	ZUNIONSTORE:   {(*Server).handleZUnionStore, -4, flagWrite | flagDenyOOM},
+	ZINTERSTORE:   {(*Server).handleZInterStore, -4, flagWrite | flagDenyOOM},
+
+	GEOADD:         {(*Server).handleGeoAdd, -5, flagWrite | flagDenyOOM},
+	GEOPOS:         {(*Server).handleGeoPos, -2, 0},
+	GEODIST:        {(*Server).handleGeoDist, -4, 0},
+	GEOHASH:        {(*Server).handleGeoHash, -2, 0},
+	GEOSEARCH:      {(*Server).handleGeoSearch, -7, 0},
+	GEOSEARCHSTORE: {(*Server).handleGeoSearchStore, -8, flagWrite | flagDenyOOM},
+
+	XADD:       {(*Server).handleXAdd, -5, flagWrite | flagDenyOOM},
+	XLEN:       {(*Server).handleXLen, 2, 0},
+	XRANGE:     {(*Server).handleXRange, -4, 0},
+	XREVRANGE:  {(*Server).handleXRevRange, -4, 0},
+	XTRIM:      {(*Server).handleXTrim, -4, flagWrite},
+	XDEL:       {(*Server).handleXDel, -3, flagWrite},
+	XREAD:      {(*Server).handleXRead, -4, 0},
+	XGROUP:     {(*Server).handleXGroup, -2, flagWrite | flagDenyOOM},
+	XREADGROUP: {(*Server).handleXReadGroup, -7, flagWrite},
+	XACK:       {(*Server).handleXAck, -4, flagWrite},
+	XPENDING:   {(*Server).handleXPending, -3, 0},
+	XCLAIM:     {(*Server).handleXClaim, -6, flagWrite},
+	XAUTOCLAIM: {(*Server).handleXAutoClaim, -6, flagWrite},
+}
@@ -0,0 +1,483 @@
+package server
+
+import (
+	"bufio"
+	"ccwc/redis_server/resp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const CONFIG = "CONFIG"
+
+// Config holds the parameters of a server. It is read from a redis.conf-style
+// file and command line flags, some parameters can be changed at runtime
+// with CONFIG SET.
+type Config struct {
+	Bind       string
+	Port       int
+	Dir        string // working directory of the snapshots
+	DBFilename string
+	MaxClients int
+	MaxMemory  int64 // bytes, 0 means no limit
+	Databases  int   // number of logical databases
+	LogLevel   string
+	// Save is the snapshotting policy, a background save starts as soon as
+	// one of the points is reached
+	Save []SavePoint
+	// AppendOnly logs the write commands to the file AppendFilename,
+	// synced to disk according to AppendFsync: always, everysec or no
+	AppendOnly     bool
+	AppendFilename string
+	AppendFsync    string
+	// AOFLoadTruncated loads an AOF whose last command is incomplete
+	AOFLoadTruncated bool
+	// ShutdownTimeout bounds the wait for the connections to finish their
+	// current command on shutdown, in seconds in the configuration file
+	ShutdownTimeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Bind:       ConnHost,
+		Port:       6379,
+		Dir:        ".",
+		DBFilename: "snapshot.rdb",
+		MaxClients: 10000,
+		Databases:  16,
+		LogLevel:   "notice",
+
+		Save:             []SavePoint{{3600, 1}, {300, 100}, {60, 10000}},
+		AppendFilename:   "appendonly.aof",
+		AppendFsync:      fsyncEverySec,
+		AOFLoadTruncated: true,
+		ShutdownTimeout:  10 * time.Second,
+	}
+}
+
+// SavePoint triggers a snapshot when there were at least Changes write
+// operations and Seconds seconds have passed since the last snapshot
+type SavePoint struct {
+	Seconds int
+	Changes int
+}
+
+// configParam describes a parameter that can be read and written by name
+type configParam struct {
+	name string
+	// a mutable parameter can be changed at runtime with CONFIG SET
+	mutable bool
+	usage   string
+	get     func(c *Config) string
+	set     func(c *Config, value string) error
+}
+
+var configParams = []configParam{
+	{
+		name:  "bind",
+		usage: "address to listen on",
+		get:   func(c *Config) string { return c.Bind },
+		set: func(c *Config, value string) error {
+			if len(strings.Fields(value)) != 1 {
+				return errors.New("only one address is supported")
+			}
+			c.Bind = value
+			return nil
+		},
+	},
+	{
+		name:  "port",
+		usage: "TCP port to listen on",
+		get:   func(c *Config) string { return strconv.Itoa(c.Port) },
+		set: func(c *Config, value string) error {
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 0 || port > 65535 {
+				return errors.New("argument must be between 0 and 65535")
+			}
+			c.Port = port
+			return nil
+		},
+	},
+	{
+		name:  "dir",
+		usage: "working directory of the snapshots",
+		get:   func(c *Config) string { return c.Dir },
+		set: func(c *Config, value string) error {
+			info, err := os.Stat(value)
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return errors.New("not a directory")
+			}
+			c.Dir = value
+			return nil
+		},
+	},
+	{
+		name:  "dbfilename",
+		usage: "file name of the snapshots",
+		get:   func(c *Config) string { return c.DBFilename },
+		set: func(c *Config, value string) error {
+			if value == "" || filepath.Base(value) != value {
+				return errors.New("dbfilename can't be a path, just a filename")
+			}
+			c.DBFilename = value
+			return nil
+		},
+	},
+	{
+		name:    "maxclients",
+		mutable: true,
+		usage:   "maximum number of connected clients",
+		get:     func(c *Config) string { return strconv.Itoa(c.MaxClients) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return errors.New("argument must be a positive integer")
+			}
+			c.MaxClients = n
+			return nil
+		},
+	},
+	{
+		name:  "databases",
+		usage: "number of logical databases",
+		get:   func(c *Config) string { return strconv.Itoa(c.Databases) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return errors.New("argument must be a positive integer")
+			}
+			c.Databases = n
+			return nil
+		},
+	},
+	{
+		name:    "maxmemory",
+		mutable: true,
+		usage:   "memory limit of the dataset, e.g. 100mb (0 means no limit)",
+		get:     func(c *Config) string { return strconv.FormatInt(c.MaxMemory, 10) },
+		set: func(c *Config, value string) error {
+			n, err := parseMemory(value)
+			if err != nil {
+				return err
+			}
+			c.MaxMemory = n
+			return nil
+		},
+	},
+	{
+		name:    "loglevel",
+		mutable: true,
+		usage:   "verbosity: debug, verbose, notice or warning",
+		get:     func(c *Config) string { return c.LogLevel },
+		set: func(c *Config, value string) error {
+			value = strings.ToLower(value)
+			if _, ok := logLevels[value]; !ok {
+				return errors.New("argument(s) must be one of the following: debug, verbose, notice, warning, nothing")
+			}
+			c.LogLevel = value
+			return nil
+		},
+	},
+	{
+		name:    "save",
+		mutable: true,
+		usage:   `snapshotting policy "<seconds> <changes> [<seconds> <changes> ...]", "" disables it`,
+		get: func(c *Config) string {
+			points := make([]string, len(c.Save))
+			for i, point := range c.Save {
+				points[i] = strconv.Itoa(point.Seconds) + " " + strconv.Itoa(point.Changes)
+			}
+			return strings.Join(points, " ")
+		},
+		set: func(c *Config, value string) error {
+			fields := strings.Fields(value)
+			if len(fields)%2 != 0 {
+				return errors.New("Invalid save parameters")
+			}
+			var points []SavePoint
+			for i := 0; i < len(fields); i += 2 {
+				seconds, err1 := strconv.Atoi(fields[i])
+				changes, err2 := strconv.Atoi(fields[i+1])
+				if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+					return errors.New("Invalid save parameters")
+				}
+				points = append(points, SavePoint{seconds, changes})
+			}
+			c.Save = points
+			return nil
+		},
+	},
+	{
+		name:    "appendonly",
+		mutable: true,
+		usage:   "log the write commands to the append-only file: yes or no",
+		get:     func(c *Config) string { return formatYesNo(c.AppendOnly) },
+		set: func(c *Config, value string) (err error) {
+			c.AppendOnly, err = parseYesNo(value)
+			return err
+		},
+	},
+	{
+		name:  "appendfilename",
+		usage: "file name of the append-only file",
+		get:   func(c *Config) string { return c.AppendFilename },
+		set: func(c *Config, value string) error {
+			if value == "" || filepath.Base(value) != value {
+				return errors.New("appendfilename can't be a path, just a filename")
+			}
+			c.AppendFilename = value
+			return nil
+		},
+	},
+	{
+		name:    "appendfsync",
+		mutable: true,
+		usage:   "when the append-only file is synced to disk: always, everysec or no",
+		get:     func(c *Config) string { return c.AppendFsync },
+		set: func(c *Config, value string) error {
+			value = strings.ToLower(value)
+			if value != fsyncAlways && value != fsyncEverySec && value != fsyncNo {
+				return errors.New("argument(s) must be one of the following: always, everysec, no")
+			}
+			c.AppendFsync = value
+			return nil
+		},
+	},
+	{
+		name:    "aof-load-truncated",
+		mutable: true,
+		usage:   "load an append-only file whose last command is incomplete: yes or no",
+		get:     func(c *Config) string { return formatYesNo(c.AOFLoadTruncated) },
+		set: func(c *Config, value string) (err error) {
+			c.AOFLoadTruncated, err = parseYesNo(value)
+			return err
+		},
+	},
+	{
+		name:    "shutdown-timeout",
+		mutable: true,
+		usage:   "seconds to wait for the connections to finish on shutdown",
+		get:     func(c *Config) string { return strconv.Itoa(int(c.ShutdownTimeout / time.Second)) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return errors.New("argument must be a non-negative integer")
+			}
+			c.ShutdownTimeout = time.Duration(n) * time.Second
+			return nil
+		},
+	},
+}
+
+func lookupConfigParam(name string) (configParam, bool) {
+	name = strings.ToLower(name)
+	for _, param := range configParams {
+		if param.name == name {
+			return param, true
+		}
+	}
+	return configParam{}, false
+}
+
+// ConfigParams returns the names of the parameters
+func ConfigParams() []string {
+	names := make([]string, len(configParams))
+	for i, param := range configParams {
+		names[i] = param.name
+	}
+	return names
+}
+
+// ConfigUsage returns the description of the parameter name
+func ConfigUsage(name string) string {
+	param, _ := lookupConfigParam(name)
+	return param.usage
+}
+
+// Get returns the value of the parameter name
+func (c *Config) Get(name string) (string, bool) {
+	param, ok := lookupConfigParam(name)
+	if !ok {
+		return "", false
+	}
+	return param.get(c), true
+}
+
+// Set sets the parameter name to value
+func (c *Config) Set(name, value string) error {
+	param, ok := lookupConfigParam(name)
+	if !ok {
+		return fmt.Errorf("unknown parameter '%s'", name)
+	}
+	return param.set(c, value)
+}
+
+// ParseConfig reads a redis.conf-style file: one directive per line made of
+// a parameter name followed by its value, lines starting with # are comments.
+// The save points of several save lines add up.
+func (c *Config) ParseConfig(r io.Reader) error {
+	var savePoints []SavePoint
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		name, value := fields[0], strings.Join(fields[1:], " ")
+		// values may be quoted
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if err := c.Set(name, value); err != nil {
+			return fmt.Errorf("config file error at line %d: '%s': %s", lineNum, line, err)
+		}
+		if strings.ToLower(name) == "save" {
+			if len(c.Save) == 0 {
+				// save "" removes the points of the previous lines
+				savePoints = nil
+			}
+			savePoints = append(savePoints, c.Save...)
+			c.Save = savePoints
+		}
+	}
+	return scanner.Err()
+}
+
+// LoadConfig returns the default configuration overridden by the file at path
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	file, err := os.Open(path)
+	if err != nil {
+		return config, err
+	}
+	defer file.Close()
+	err = config.ParseConfig(file)
+	return config, err
+}
+
+func parseYesNo(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	default:
+		return false, errors.New("argument must be 'yes' or 'no'")
+	}
+}
+
+func formatYesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// parseMemory parses a number of bytes with an optional unit the way
+// redis.conf does: 1k = 1000 bytes, 1kb = 1024 bytes, and so on for m and g.
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	value = strings.ToLower(value)
+	mul := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			mul = unit.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// snapshotPath returns the path of the snapshot file
+func (c *Config) snapshotPath() string {
+	return filepath.Join(c.Dir, c.DBFilename)
+}
+
+// aofPath returns the path of the append-only file
+func (c *Config) aofPath() string {
+	return filepath.Join(c.Dir, c.AppendFilename)
+}
+
+// CONFIG GET parameter [parameter ...] returns the parameters matching the
+// glob-style patterns, CONFIG SET parameter value [parameter value ...]
+// changes parameters at runtime: either all of them are set or none.
+func (s *Server) handleConfig(c *client, args []string) resp.Value {
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "GET" && len(args) >= 3:
+		return s.configGet(args[2:])
+	case sub == "SET" && len(args) >= 4 && len(args)%2 == 0:
+		return s.configSet(args[2:])
+	default:
+		return resp.NewError("ERR unknown subcommand or wrong number of arguments for '" + args[1] + "'. Try CONFIG HELP.")
+	}
+}
+
+func (s *Server) configGet(patterns []string) resp.Value {
+	var names []string
+	for _, param := range configParams {
+		for _, pattern := range patterns {
+			if stringMatch(pattern, param.name, true) {
+				names = append(names, param.name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+
+	pairs := make([]resp.Value, 0, 2*len(names))
+	for _, name := range names {
+		param, _ := lookupConfigParam(name)
+		pairs = append(pairs, resp.NewBulkString(name), resp.NewBulkString(param.get(&s.config)))
+	}
+	return resp.NewMap(pairs...)
+}
+
+func (s *Server) configSet(pairs []string) resp.Value {
+	// validate everything on a copy so that a failure leaves the config untouched
+	config := s.config
+	for i := 0; i < len(pairs); i += 2 {
+		name, value := pairs[i], pairs[i+1]
+		param, ok := lookupConfigParam(name)
+		if !ok {
+			return resp.NewError("ERR Unknown option or number of arguments for CONFIG SET - '" + name + "'")
+		}
+		if !param.mutable {
+			return resp.NewError("ERR CONFIG SET failed (possibly related to argument '" + name + "') - can't set immutable config")
+		}
+		if err := param.set(&config, value); err != nil {
+			return resp.NewError("ERR CONFIG SET failed (possibly related to argument '" + name + "') - " + err.Error())
+		}
+	}
+	old := s.config
+	s.config = config
+	s.setLogLevel(config.LogLevel)
+	if config.AppendOnly != old.AppendOnly {
+		if err := s.switchAOF(config.AppendOnly); err != nil {
+			s.config.AppendOnly = old.AppendOnly
+			return resp.NewError("ERR CONFIG SET failed (possibly related to argument 'appendonly') - " + err.Error())
+		}
+	}
+	return resp.OK
+}
@@ -0,0 +1,163 @@
+package server_test
+
+import (
+	"ccwc/redis_server"
+	"ccwc/redis_server/resp"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_ParseConfig(t *testing.T) {
+	file := `# a comment
+bind 0.0.0.0
+port 7000
+
+maxmemory 2mb
+loglevel "warning"
+dbfilename dump.rdb
+save ""
+save 900 1
+save 300 10 60 10000
+`
+	config := server.DefaultConfig()
+	if err := config.ParseConfig(strings.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := server.DefaultConfig()
+	want.Bind = "0.0.0.0"
+	want.Port = 7000
+	want.MaxMemory = 2 * 1024 * 1024
+	want.LogLevel = "warning"
+	want.DBFilename = "dump.rdb"
+	want.Save = []server.SavePoint{{900, 1}, {300, 10}, {60, 10000}}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("got %+v, want %+v", config, want)
+	}
+}
+
+func TestConfig_ParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"port 70000", "config file error at line 1: 'port 70000': argument must be between 0 and 65535"},
+		{"# comment\nnope 1", "config file error at line 2: 'nope 1': unknown parameter 'nope'"},
+		{"maxmemory lots", "config file error at line 1: 'maxmemory lots': argument must be a memory value"},
+		{"save 900", "config file error at line 1: 'save 900': Invalid save parameters"},
+	}
+
+	for _, tt := range tests {
+		config := server.DefaultConfig()
+		err := config.ParseConfig(strings.NewReader(tt.file))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("for %q, got %v, want %q", tt.file, err, tt.want)
+		}
+	}
+}
+
+func TestServer_ConfigGetSet(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"CONFIG GET maxclients", []any{"maxclients", "10000"}},
+		{"CONFIG GET max* PORT", []any{"maxclients", "10000", "maxmemory", "0", "port", "8888"}},
+		{"CONFIG GET nope", []any{}},
+		{"CONFIG SET maxclients 20 loglevel verbose", "OK"},
+		{"CONFIG GET maxclients loglevel", []any{"loglevel", "verbose", "maxclients", "20"}},
+		{"CONFIG SET maxclients 30 loglevel loud", resp.Error("ERR CONFIG SET failed (possibly related to argument 'loglevel') - argument(s) must be one of the following: debug, verbose, notice, warning, nothing")},
+		// a failed CONFIG SET doesn't change anything
+		{"CONFIG GET maxclients", []any{"maxclients", "20"}},
+		{"CONFIG SET port 7000", resp.Error("ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config")},
+		// the snapshot path can't be pointed at another file at runtime
+		{"CONFIG SET dir /tmp", resp.Error("ERR CONFIG SET failed (possibly related to argument 'dir') - can't set immutable config")},
+		{"CONFIG SET dbfilename authorized_keys", resp.Error("ERR CONFIG SET failed (possibly related to argument 'dbfilename') - can't set immutable config")},
+		{"CONFIG SET nope 1", resp.Error("ERR Unknown option or number of arguments for CONFIG SET - 'nope'")},
+		{"CONFIG SET maxclients 10000 loglevel notice", "OK"},
+		{"CONFIG SET save 10", resp.Error("ERR CONFIG SET failed (possibly related to argument 'save') - Invalid save parameters")},
+		{"CONFIG GET save", []any{"save", "3600 1 300 100 60 10000"}},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_MaxMemory(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"SET maxmemory:key 1", "OK"},
+		{"CONFIG SET maxmemory 1kb", "OK"},
+		{"SET maxmemory:key 2", resp.Error("OOM command not allowed when used memory > 'maxmemory'.")},
+		// commands that don't use more memory are still allowed
+		{"GET maxmemory:key", "1"},
+		{"DEL maxmemory:key", 1},
+		{"CONFIG SET maxmemory 0", "OK"},
+		{"SET maxmemory:key 2", "OK"},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_MaxClients(t *testing.T) {
+	config := server.DefaultConfig()
+	config.Port = 8890
+	config.Dir = t.TempDir()
+	config.MaxClients = 1
+	go server.NewServerWithConfig(config).Run(context.Background())
+	waitForServer("localhost:8890")
+
+	// the first connection must be registered before the second one is accepted,
+	// and the connection of waitForServer may not be unregistered yet
+	maxClientsErr := resp.Error("ERR max number of clients reached")
+	var first net.Conn
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", "localhost:8890")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("*1\r\n$8\r\nLASTSAVE\r\n"))
+		if reply, _ := resp.NewReader(conn).Read(); reply != maxClientsErr {
+			first = conn
+			break
+		}
+		conn.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if first == nil {
+		t.Fatal("no connection accepted")
+	}
+	defer first.Close()
+
+	second, err := net.Dial("tcp", "localhost:8890")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	got, err := resp.NewReader(second).Read()
+	if got != maxClientsErr {
+		t.Errorf("got %q, %v, want %q", got, err, maxClientsErr)
+	}
+}
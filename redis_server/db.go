@@ -0,0 +1,182 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The commands read the dataset through lookupKey, which deletes the keys
+// that expired, and modify it through setKey, touchKey and deleteKey, which
+// count the changes for the save policy and preserve the keys of a background
+// save. They all work on s.db, the database selected by the client of the
+// command being executed.
+
+var wrongTypeReply = resp.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+var (
+	notIntegerErr = errors.New("value is not an integer or out of range")
+	notFloatErr   = errors.New("value is not a valid float")
+	syntaxErr     = errors.New("syntax error")
+)
+
+// database is a logical database, selected by the clients with SELECT.
+// Its keyspace is replaced by FLUSHDB and exchanged with another one by
+// SWAPDB, the clients blocked on its keys stay.
+type database struct {
+	id int
+	*keyspace
+	// the clients blocked on the keys, see blocking.go
+	blocked map[string][]*blockedClient // by key, in the order they blocked
+	// the keys watched by clients, see transaction.go
+	watched map[string]*watchedKey
+}
+
+// keyspace holds the keys of a database
+type keyspace struct {
+	dict map[string]RedisValue
+	// volatile holds the keys with an expiration, see expire.go
+	volatile map[string]struct{}
+	// scan orders the keys for SCAN, nil until its first call, see scan.go
+	scan *scanIndex
+}
+
+func newDatabase(id int) *database {
+	return &database{
+		id:       id,
+		keyspace: newKeyspace(make(map[string]RedisValue)),
+		blocked:  make(map[string][]*blockedClient),
+		watched:  make(map[string]*watchedKey),
+	}
+}
+
+func newKeyspace(dict map[string]RedisValue) *keyspace {
+	ks := &keyspace{dict: dict, volatile: make(map[string]struct{})}
+	for key, val := range dict {
+		if !val.exp.IsZero() {
+			ks.volatile[key] = struct{}{}
+		}
+	}
+	return ks
+}
+
+// lookupKey returns the value of key, unless it doesn't exist or expired
+func (s *Server) lookupKey(key string) (RedisValue, bool) {
+	val, ok := s.db.dict[key]
+	if ok && val.expired(time.Now()) {
+		s.expireKey(key)
+		return RedisValue{}, false
+	}
+	return val, ok
+}
+
+// setKey stores val at key
+func (s *Server) setKey(key string, val RedisValue) {
+	s.preserve(key)
+	s.touchWatched(s.db, key)
+	if _, exists := s.db.dict[key]; !exists {
+		s.db.scan.add(key)
+	}
+	s.db.dict[key] = val
+	if val.exp.IsZero() {
+		delete(s.db.volatile, key)
+	} else {
+		s.db.volatile[key] = struct{}{}
+	}
+	s.dirty++
+}
+
+// touchKey must be called before the value of key is modified in place
+func (s *Server) touchKey(key string) {
+	s.preserve(key)
+	s.touchWatched(s.db, key)
+	s.dirty++
+}
+
+// deleteKey removes key and reports whether it existed
+func (s *Server) deleteKey(key string) bool {
+	if _, ok := s.db.dict[key]; !ok {
+		return false
+	}
+	s.preserve(key)
+	s.touchWatched(s.db, key)
+	delete(s.db.dict, key)
+	delete(s.db.volatile, key)
+	s.db.scan.remove(key)
+	s.dirty++
+	return true
+}
+
+// setDicts replaces the dataset with dicts, the dictionaries of the
+// databases by number
+func (s *Server) setDicts(dicts []map[string]RedisValue) {
+	for i, db := range s.dbs {
+		old := db.keyspace
+		db.keyspace = newKeyspace(dicts[i])
+		s.touchAllWatched(db, old, db.keyspace)
+	}
+}
+
+// dicts returns the dictionaries of the databases by number
+func (s *Server) dicts() []map[string]RedisValue {
+	dicts := make([]map[string]RedisValue, len(s.dbs))
+	for i, db := range s.dbs {
+		dicts[i] = db.dict
+	}
+	return dicts
+}
+
+func countKeys(dicts []map[string]RedisValue) int {
+	n := 0
+	for _, dict := range dicts {
+		n += len(dict)
+	}
+	return n
+}
+
+// copyValue returns a copy of value that doesn't share any memory that the
+// commands modify in place
+func copyValue(value any) any {
+	switch v := value.(type) {
+	case *list:
+		return v.clone()
+	case *hash:
+		return v.clone()
+	case *set:
+		return v.clone()
+	case *zset:
+		return v.clone()
+	case *stream:
+		return v.clone()
+	default:
+		// strings are immutable
+		return value
+	}
+}
+
+// parseFloat parses a float the way Redis does: NaN and spaces are refused
+func parseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || strings.TrimSpace(s) != s {
+		return 0, notFloatErr
+	}
+	return f, nil
+}
+
+// parseInteger parses an integer the way string2ll does in Redis: a leading
+// '+', leading zeros and "-0" are refused, so the value formats back to s
+func parseInteger(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, notIntegerErr
+	}
+	return n, nil
+}
+
+// formatFloat formats a float without exponent, as the INCRBYFLOAT commands reply
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EXPIRE    = "EXPIRE"
+	PEXPIRE   = "PEXPIRE"
+	EXPIREAT  = "EXPIREAT"
+	PEXPIREAT = "PEXPIREAT"
+	TTL       = "TTL"
+	PTTL      = "PTTL"
+	PERSIST   = "PERSIST"
+)
+
+// A key expires at an absolute deadline. The commands delete the expired keys
+// they look up, and the cron samples the keys with an expiration to delete
+// the expired keys that are never looked up again, as Redis's active expire
+// cycle does. The deletions are propagated to the AOF as DEL commands.
+
+const (
+	// number of keys with an expiration checked at a time
+	activeExpireSample = 20
+	// the sampling goes on while more than a quarter of the sample expired,
+	// for at most a quarter of the cron period
+	activeExpireBudget = 25 * time.Millisecond
+)
+
+// expired reports whether the value expired at now
+func (v RedisValue) expired(now time.Time) bool {
+	return !v.exp.IsZero() && now.After(v.exp)
+}
+
+// expireKey deletes key once it expired and propagates the deletion
+func (s *Server) expireKey(key string) {
+	s.deleteKey(key)
+	s.expiredKeys++
+	s.propagate([]string{DEL, key})
+}
+
+// activeExpireCycle deletes a part of the expired keys, the server lock must be held
+func (s *Server) activeExpireCycle() {
+	start := time.Now()
+	selected := s.db
+	defer func() { s.db = selected }()
+	for _, db := range s.dbs {
+		// the deletions are made and propagated in db
+		s.db = db
+		for {
+			now := time.Now()
+			sampled, expired := 0, 0
+			// the iteration order of a map is random
+			for key := range db.volatile {
+				if sampled == activeExpireSample {
+					break
+				}
+				sampled++
+				if db.dict[key].expired(now) {
+					s.expireKey(key)
+					expired++
+				}
+			}
+			if time.Since(start) > activeExpireBudget {
+				return
+			}
+			if expired <= activeExpireSample/4 {
+				break
+			}
+		}
+	}
+}
+
+// expireDeadline returns the deadline given by the argument arg of the
+// expiration option EX, PX, EXAT or PXAT, EX and PX are relative to now
+func expireDeadline(option, arg, cmdName string, now time.Time) (time.Time, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return time.Time{}, notIntegerErr
+	}
+	invalid := fmt.Errorf("invalid expire time in '%s' command", cmdName)
+	ms := n
+	if option == EX || option == EXAT {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return time.Time{}, invalid
+		}
+		ms = n * 1000
+	}
+	if option == EX || option == PX {
+		base := now.UnixMilli()
+		if ms > math.MaxInt64-base {
+			return time.Time{}, invalid
+		}
+		ms += base
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// EXPIRE key seconds [NX|XX|GT|LT] sets the time to live of key in seconds
+func (s *Server) handleExpire(c *client, args []string) resp.Value {
+	return s.expire(c, args, EX)
+}
+
+// PEXPIRE key milliseconds [NX|XX|GT|LT] sets the time to live of key in milliseconds
+func (s *Server) handlePExpire(c *client, args []string) resp.Value {
+	return s.expire(c, args, PX)
+}
+
+// EXPIREAT key unix-time-seconds [NX|XX|GT|LT] sets the deadline of key in seconds
+func (s *Server) handleExpireAt(c *client, args []string) resp.Value {
+	return s.expire(c, args, EXAT)
+}
+
+// PEXPIREAT key unix-time-milliseconds [NX|XX|GT|LT] sets the deadline of key in milliseconds
+func (s *Server) handlePExpireAt(c *client, args []string) resp.Value {
+	return s.expire(c, args, PXAT)
+}
+
+// expire sets the expiration of a key, only if it has none with NX, if it has
+// one with XX, if it's later than the current one with GT and earlier with LT.
+// A deadline in the past deletes the key. Returns 1 if the expiration was set.
+func (s *Server) expire(c *client, args []string, option string) resp.Value {
+	key := args[1]
+	now := time.Now()
+	deadline, err := expireDeadline(option, args[2], strings.ToLower(args[0]), now)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	var nx, xx, gt, lt bool
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(arg) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return resp.NewError("ERR Unsupported option " + arg)
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return resp.NewError("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return resp.NewError("ERR GT and LT options at the same time are not compatible")
+	}
+
+	val, ok := s.lookupKey(key)
+	if !ok {
+		return resp.NewInteger(0)
+	}
+	// a key without expiration has an infinite time to live
+	persistent := val.exp.IsZero()
+	if (nx && !persistent) || (xx && persistent) ||
+		(gt && (persistent || !deadline.After(val.exp))) ||
+		(lt && !persistent && !deadline.Before(val.exp)) {
+		return resp.NewInteger(0)
+	}
+
+	if !deadline.After(now) {
+		s.deleteKey(key)
+		c.propagate = []string{DEL, key}
+		return resp.NewInteger(1)
+	}
+	val.exp = deadline
+	s.setKey(key, val)
+	c.propagate = []string{PEXPIREAT, key, strconv.FormatInt(deadline.UnixMilli(), 10)}
+	return resp.NewInteger(1)
+}
+
+// TTL key returns the remaining time to live of key in seconds,
+// -1 if it has no expiration and -2 if it doesn't exist
+func (s *Server) handleTTL(c *client, args []string) resp.Value {
+	return s.ttl(args[1], time.Second)
+}
+
+// PTTL key returns the remaining time to live of key in milliseconds,
+// -1 if it has no expiration and -2 if it doesn't exist
+func (s *Server) handlePTTL(c *client, args []string) resp.Value {
+	return s.ttl(args[1], time.Millisecond)
+}
+
+func (s *Server) ttl(key string, unit time.Duration) resp.Value {
+	val, ok := s.lookupKey(key)
+	if !ok {
+		return resp.NewInteger(-2)
+	}
+	if val.exp.IsZero() {
+		return resp.NewInteger(-1)
+	}
+	ttl := time.Until(val.exp).Truncate(time.Millisecond)
+	if ttl < 0 {
+		ttl = 0
+	}
+	// rounded to the nearest unit
+	return resp.NewInteger(int64((ttl + unit/2) / unit))
+}
+
+// PERSIST key removes the expiration of key, returns 1 if it had one
+func (s *Server) handlePersist(c *client, args []string) resp.Value {
+	val, ok := s.lookupKey(args[1])
+	if !ok || val.exp.IsZero() {
+		return resp.NewInteger(0)
+	}
+	val.exp = time.Time{}
+	s.setKey(args[1], val)
+	return resp.NewInteger(1)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestActiveExpireCycle(t *testing.T) {
+	s := NewServerWithConfig(DefaultConfig())
+	past := time.Now().Add(-time.Second)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		s.setKey("expired:"+key, RedisValue{value: key, exp: past})
+		s.setKey("persistent:"+key, RedisValue{value: key})
+	}
+
+	// the samples are expired until there is no key with an expiration left
+	s.activeExpireCycle()
+
+	if s.expiredKeys != 1000 || len(s.db.dict) != 1000 || len(s.db.volatile) != 0 {
+		t.Errorf("got %d keys expired, %d left and %d with an expiration", s.expiredKeys, len(s.db.dict), len(s.db.volatile))
+	}
+}
+
+func TestExpire_Propagate(t *testing.T) {
+	s := NewServerWithConfig(DefaultConfig())
+	file, err := os.CreateTemp(t.TempDir(), "appendonly-*.aof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.aofFile = file
+	defer s.closeAOF()
+	c := &client{db: s.dbs[0]}
+	ms := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+
+	tests := []struct {
+		args []string
+		want [][]string // the commands logged to the AOF
+	}{
+		// the first command logged selects the database
+		{[]string{SET, "a", "1", PXAT, ms}, [][]string{{SELECT, "0"}, {SET, "a", "1", PXAT, ms}}},
+		{[]string{PEXPIREAT, "a", ms, "XX"}, [][]string{{PEXPIREAT, "a", ms}}},
+		{[]string{EXPIRE, "a", "0"}, [][]string{{DEL, "a"}}},
+		{[]string{EXPIRE, "a", "10"}, nil},
+		{[]string{SET, "b", "1", PX, "1"}, nil},
+		// the key expires before INCR runs
+		{[]string{INCR, "b"}, [][]string{{DEL, "b"}, {INCR, "b"}}},
+	}
+
+	reader := resp.NewReader(file)
+	for _, tt := range tests {
+		if tt.args[0] == INCR {
+			time.Sleep(2 * time.Millisecond)
+		}
+		start, _ := file.Seek(0, io.SeekCurrent)
+		s.call(c, commandTable[tt.args[0]], tt.args)
+		if tt.args[0] == SET && tt.want == nil {
+			continue
+		}
+
+		file.Seek(start, io.SeekStart)
+		var logged [][]string
+		for {
+			args, err := reader.ReadCommand()
+			if err != nil {
+				break
+			}
+			logged = append(logged, args)
+		}
+		if !reflect.DeepEqual(logged, tt.want) {
+			t.Errorf("for %v, got %q logged, want %q", tt.args, logged, tt.want)
+		}
+	}
+}
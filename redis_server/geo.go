@@ -0,0 +1,735 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	GEOADD         = "GEOADD"
+	GEOPOS         = "GEOPOS"
+	GEODIST        = "GEODIST"
+	GEOHASH        = "GEOHASH"
+	GEOSEARCH      = "GEOSEARCH"
+	GEOSEARCHSTORE = "GEOSEARCHSTORE"
+)
+
+// The positions are stored in sorted sets as Redis does: the score of a
+// member is the geohash of its position on 52 bits, the bits of the latitude
+// and of the longitude interleaved, latitude first. The latitudes are limited
+// to the range of the Web Mercator projection. A search looks up the members
+// in the box of the center and its 8 neighbors, with a precision chosen so
+// that they cover the shape, then checks their distance.
+
+const (
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	geoLongMin = -180
+	geoLongMax = 180
+	geoStepMax = 26 // 52 bits
+
+	earthRadius = 6372797.560856 // meters
+	mercatorMax = 20037726.37
+)
+
+type geoRange struct {
+	min, max float64
+}
+
+var (
+	geoLongRange = geoRange{geoLongMin, geoLongMax}
+	geoLatRange  = geoRange{geoLatMin, geoLatMax}
+)
+
+// geoHash is a geohash of 2*step bits
+type geoHash struct {
+	bits uint64
+	step uint
+}
+
+func (h geoHash) isZero() bool {
+	return h.bits == 0 && h.step == 0
+}
+
+// score returns the geohash aligned on 52 bits, the score of a member
+func (h geoHash) score() uint64 {
+	return h.bits << (2*geoStepMax - 2*h.step)
+}
+
+// move returns the geohash of the neighbor box, dx to the east and dy to the
+// north
+func (h geoHash) move(dx, dy int) geoHash {
+	x := h.bits & 0xaaaaaaaaaaaaaaaa // longitude
+	y := h.bits & 0x5555555555555555 // latitude
+	shift := 64 - 2*h.step
+	if dx != 0 {
+		zz := uint64(0x5555555555555555) >> shift
+		if dx > 0 {
+			x += zz + 1
+		} else {
+			x |= zz
+			x -= zz + 1
+		}
+		x &= 0xaaaaaaaaaaaaaaaa >> shift
+	}
+	if dy != 0 {
+		zz := uint64(0xaaaaaaaaaaaaaaaa) >> shift
+		if dy > 0 {
+			y += zz + 1
+		} else {
+			y |= zz
+			y -= zz + 1
+		}
+		y &= 0x5555555555555555 >> shift
+	}
+	h.bits = x | y
+	return h
+}
+
+// spreadBits inserts a zero bit before each bit of v
+func spreadBits(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// compactBits returns the even bits of v
+func compactBits(v uint64) uint32 {
+	x := v & 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return uint32(x)
+}
+
+// geohashEncode returns the geohash of a position in the ranges, ok is
+// false if it is out of them or out of the supported positions
+func geohashEncode(longRange, latRange geoRange, long, lat float64, step uint) (h geoHash, ok bool) {
+	if long > geoLongMax || long < geoLongMin || lat > geoLatMax || lat < geoLatMin ||
+		lat < latRange.min || lat > latRange.max || long < longRange.min || long > longRange.max {
+		return geoHash{}, false
+	}
+	latOffset := (lat - latRange.min) / (latRange.max - latRange.min) * float64(uint64(1)<<step)
+	longOffset := (long - longRange.min) / (longRange.max - longRange.min) * float64(uint64(1)<<step)
+	return geoHash{spreadBits(uint32(latOffset)) | spreadBits(uint32(longOffset))<<1, step}, true
+}
+
+// geoArea is the box of a geohash
+type geoArea struct {
+	long, lat geoRange
+}
+
+func geohashDecode(longRange, latRange geoRange, h geoHash) geoArea {
+	latIndex, longIndex := float64(compactBits(h.bits)), float64(compactBits(h.bits>>1))
+	cells := float64(uint64(1) << h.step)
+	latScale, longScale := latRange.max-latRange.min, longRange.max-longRange.min
+	return geoArea{
+		long: geoRange{longRange.min + longIndex/cells*longScale, longRange.min + (longIndex+1)/cells*longScale},
+		lat:  geoRange{latRange.min + latIndex/cells*latScale, latRange.min + (latIndex+1)/cells*latScale},
+	}
+}
+
+// center returns the position in the middle of the area
+func (a geoArea) center() (long, lat float64) {
+	long = math.Max(math.Min((a.long.min+a.long.max)/2, geoLongMax), geoLongMin)
+	lat = math.Max(math.Min((a.lat.min+a.lat.max)/2, geoLatMax), geoLatMin)
+	return long, lat
+}
+
+// geoScore returns the score of a position
+func geoScore(long, lat float64) float64 {
+	h, _ := geohashEncode(geoLongRange, geoLatRange, long, lat, geoStepMax)
+	return float64(h.score())
+}
+
+// geoDecodeScore returns the position of a score, the center of its box
+func geoDecodeScore(score float64) (long, lat float64) {
+	return geohashDecode(geoLongRange, geoLatRange, geoHash{uint64(score), geoStepMax}).center()
+}
+
+func degToRad(deg float64) float64 {
+	return deg * (math.Pi / 180)
+}
+
+func radToDeg(rad float64) float64 {
+	return rad / (math.Pi / 180)
+}
+
+// geoDistance returns the distance in meters between two positions with the
+// haversine formula
+func geoDistance(long1, lat1, long2, lat2 float64) float64 {
+	v := math.Sin((degToRad(long2) - degToRad(long1)) / 2)
+	if v == 0 {
+		return geoLatDistance(lat1, lat2)
+	}
+	lat1r, lat2r := degToRad(lat1), degToRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// geoLatDistance returns the distance in meters between two latitudes
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return earthRadius * math.Abs(degToRad(lat2)-degToRad(lat1))
+}
+
+// geoShape is the area of a search, a circle or a box centered on a
+// position. The sizes are in the unit of the command, conversion converts
+// them to meters.
+type geoShape struct {
+	long, lat     float64
+	box           bool
+	radius        float64
+	width, height float64
+	conversion    float64
+}
+
+// contains returns the distance in meters from the center to the position,
+// ok is false if the position is out of the shape
+func (sh *geoShape) contains(long, lat float64) (dist float64, ok bool) {
+	if !sh.box {
+		dist = geoDistance(sh.long, sh.lat, long, lat)
+		return dist, dist <= sh.radius*sh.conversion
+	}
+	// the latitude distance is cheaper to check first
+	if geoLatDistance(lat, sh.lat) > sh.height*sh.conversion/2 ||
+		geoDistance(long, lat, sh.long, lat) > sh.width*sh.conversion/2 {
+		return 0, false
+	}
+	return geoDistance(sh.long, sh.lat, long, lat), true
+}
+
+// boundingBox returns the minimum and maximum longitudes and latitudes of the
+// shape
+func (sh *geoShape) boundingBox() (minLong, minLat, maxLong, maxLat float64) {
+	height, width := sh.radius*sh.conversion, sh.radius*sh.conversion
+	if sh.box {
+		height, width = sh.height*sh.conversion/2, sh.width*sh.conversion/2
+	}
+	latDelta := radToDeg(height / earthRadius)
+	longDeltaTop := radToDeg(width / earthRadius / math.Cos(degToRad(sh.lat+latDelta)))
+	longDeltaBottom := radToDeg(width / earthRadius / math.Cos(degToRad(sh.lat-latDelta)))
+	// the widest side is toward the equator
+	longDelta := longDeltaTop
+	if sh.lat < 0 {
+		longDelta = longDeltaBottom
+	}
+	return sh.long - longDelta, sh.lat - latDelta, sh.long + longDelta, sh.lat + latDelta
+}
+
+// geoEstimateSteps returns the precision of the boxes that cover a radius
+// at a latitude
+func geoEstimateSteps(meters, lat float64) uint {
+	if meters == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for ; meters < mercatorMax; meters *= 2 {
+		step++
+	}
+	step -= 2
+	// the boxes are narrower toward the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > geoStepMax {
+		step = geoStepMax
+	}
+	return uint(step)
+}
+
+// searchBoxes returns the boxes to search for the shape: the box of the
+// center, then its north, south, east, west, north-east, north-west,
+// south-east and south-west neighbors. The neighbors that don't intersect
+// the shape are zero.
+func (sh *geoShape) searchBoxes() [9]geoHash {
+	minLong, minLat, maxLong, maxLat := sh.boundingBox()
+	meters := sh.radius
+	if sh.box {
+		meters = math.Sqrt((sh.width/2)*(sh.width/2) + (sh.height/2)*(sh.height/2))
+	}
+	steps := geoEstimateSteps(meters*sh.conversion, sh.lat)
+
+	var h geoHash
+	var boxes [9]geoHash
+	neighbors := func() {
+		h, _ = geohashEncode(geoLongRange, geoLatRange, sh.long, sh.lat, steps)
+		boxes = [9]geoHash{h, h.move(0, 1), h.move(0, -1), h.move(1, 0), h.move(-1, 0),
+			h.move(1, 1), h.move(-1, 1), h.move(1, -1), h.move(-1, -1)}
+	}
+	neighbors()
+	// near the edges of the box of the center, the neighbors may not be
+	// large enough to cover the shape
+	north := geohashDecode(geoLongRange, geoLatRange, boxes[1])
+	south := geohashDecode(geoLongRange, geoLatRange, boxes[2])
+	east := geohashDecode(geoLongRange, geoLatRange, boxes[3])
+	west := geohashDecode(geoLongRange, geoLatRange, boxes[4])
+	if steps > 1 && (north.lat.max < maxLat || south.lat.min > minLat ||
+		east.long.max < maxLong || west.long.min > minLong) {
+		steps--
+		neighbors()
+	}
+
+	if steps >= 2 {
+		area := geohashDecode(geoLongRange, geoLatRange, h)
+		exclude := func(indexes ...int) {
+			for _, i := range indexes {
+				boxes[i] = geoHash{}
+			}
+		}
+		if area.lat.min < minLat {
+			exclude(2, 7, 8)
+		}
+		if area.lat.max > maxLat {
+			exclude(1, 5, 6)
+		}
+		if area.long.min < minLong {
+			exclude(4, 8, 6)
+		}
+		if area.long.max > maxLong {
+			exclude(3, 7, 5)
+		}
+	}
+	return boxes
+}
+
+// geoPoint is a member found by a search
+type geoPoint struct {
+	member    string
+	score     float64
+	long, lat float64
+	dist      float64 // meters
+}
+
+// geoSearch returns the members of the sorted set in the shape, in the order
+// of the boxes and of the scores. The search stops once limit members were
+// found if it isn't 0.
+func geoSearch(z *zset, sh *geoShape, limit int) []geoPoint {
+	var points []geoPoint
+	boxes := sh.searchBoxes()
+	last := 0
+	for i, h := range boxes {
+		if h.isZero() {
+			continue
+		}
+		// the neighbors of a large box may be the same
+		if last != 0 && h == boxes[last] {
+			continue
+		}
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+		next := h
+		next.bits++
+		r := scoreRange{min: float64(h.score()), max: float64(next.score()), maxex: true}
+		for x := z.zsl.firstInRange(r); x != nil && r.belowMax(x); x = x.level[0].forward {
+			long, lat := geoDecodeScore(x.score)
+			dist, ok := sh.contains(long, lat)
+			if !ok {
+				continue
+			}
+			points = append(points, geoPoint{x.member, x.score, long, lat, dist})
+			if limit > 0 && len(points) >= limit {
+				break
+			}
+		}
+		last = i
+	}
+	return points
+}
+
+// parseLongLat parses a position given as a longitude and a latitude
+func parseLongLat(longArg, latArg string) (long, lat float64, err error) {
+	if long, err = parseFloat(longArg); err != nil {
+		return 0, 0, err
+	}
+	if lat, err = parseFloat(latArg); err != nil {
+		return 0, 0, err
+	}
+	if long < geoLongMin || long > geoLongMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, 0, fmt.Errorf("invalid longitude,latitude pair %f,%f", long, lat)
+	}
+	return long, lat, nil
+}
+
+// parseGeoUnit returns the number of meters of a unit
+func parseGeoUnit(unit string) (float64, bool) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	return 0, false
+}
+
+var unitReply = resp.NewError("ERR unsupported unit provided. please use M, KM, FT, MI")
+
+// geoCoordReply replies a coordinate, with 17 decimals under RESP2 as Redis
+// does
+func geoCoordReply(c *client, f float64) resp.Value {
+	if c.writer.Proto == resp.RESP3 {
+		return resp.NewDouble(f)
+	}
+	str := strings.TrimRight(strconv.FormatFloat(f, 'f', 17, 64), "0")
+	return resp.NewBulkString(strings.TrimSuffix(str, "."))
+}
+
+// geoDistReply replies a distance with 4 decimals
+func geoDistReply(dist float64) resp.Value {
+	return resp.NewBulkString(strconv.FormatFloat(dist, 'f', 4, 64))
+}
+
+// GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+// adds the members at the positions, see ZADD
+func (s *Server) handleGeoAdd(c *client, args []string) resp.Value {
+	i := 2
+	var nx, xx bool
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+		default:
+			break options
+		}
+	}
+	if (len(args)-i)%3 != 0 || (nx && xx) {
+		return resp.NewError("ERR syntax error")
+	}
+
+	zaddArgs := append([]string{ZADD}, args[1:i]...)
+	for ; i < len(args); i += 3 {
+		long, lat, err := parseLongLat(args[i], args[i+1])
+		if err != nil {
+			return resp.NewError("ERR " + err.Error())
+		}
+		score := strconv.FormatUint(uint64(geoScore(long, lat)), 10)
+		zaddArgs = append(zaddArgs, score, args[i+2])
+	}
+	c.propagate = zaddArgs
+	return s.handleZAdd(c, zaddArgs)
+}
+
+// GEOPOS key [member ...] returns the positions of the members, nil for the
+// members that don't exist
+func (s *Server) handleGeoPos(c *client, args []string) resp.Value {
+	z, ok := s.lookupZset(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	values := make([]resp.Value, 0, len(args)-2)
+	for _, member := range args[2:] {
+		score, exists := 0.0, false
+		if z != nil {
+			score, exists = z.dict[member]
+		}
+		if !exists {
+			values = append(values, resp.NewNullArray())
+			continue
+		}
+		long, lat := geoDecodeScore(score)
+		values = append(values, resp.NewArray(geoCoordReply(c, long), geoCoordReply(c, lat)))
+	}
+	return resp.NewArray(values...)
+}
+
+// GEODIST key member1 member2 [M|KM|FT|MI] returns the distance between two
+// members, in meters by default, nil if one of them doesn't exist
+func (s *Server) handleGeoDist(c *client, args []string) resp.Value {
+	conversion := 1.0
+	if len(args) == 5 {
+		var ok bool
+		if conversion, ok = parseGeoUnit(args[4]); !ok {
+			return unitReply
+		}
+	} else if len(args) > 5 {
+		return resp.NewError("ERR syntax error")
+	}
+	z, ok := s.lookupZset(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if z == nil {
+		return resp.NewNull()
+	}
+	score1, ok1 := z.dict[args[2]]
+	score2, ok2 := z.dict[args[3]]
+	if !ok1 || !ok2 {
+		return resp.NewNull()
+	}
+	long1, lat1 := geoDecodeScore(score1)
+	long2, lat2 := geoDecodeScore(score2)
+	return geoDistReply(geoDistance(long1, lat1, long2, lat2) / conversion)
+}
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// GEOHASH key [member ...] returns the standard 11 characters geohashes of
+// the members, nil for the members that don't exist
+func (s *Server) handleGeoHash(c *client, args []string) resp.Value {
+	z, ok := s.lookupZset(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	values := make([]resp.Value, 0, len(args)-2)
+	for _, member := range args[2:] {
+		score, exists := 0.0, false
+		if z != nil {
+			score, exists = z.dict[member]
+		}
+		if !exists {
+			values = append(values, resp.NewNull())
+			continue
+		}
+		// the standard geohashes cover the latitudes from -90 to 90
+		long, lat := geoDecodeScore(score)
+		h, _ := geohashEncode(geoLongRange, geoRange{-90, 90}, long, lat, geoStepMax)
+		var b [11]byte
+		for i := range b {
+			// the 11th character has no bits left
+			idx := 0
+			if i < 10 {
+				idx = int(h.bits>>(52-(i+1)*5)) & 0x1f
+			}
+			b[i] = geoAlphabet[idx]
+		}
+		values = append(values, resp.NewBulkString(string(b[:])))
+	}
+	return resp.NewArray(values...)
+}
+
+// geoSearchOptions holds the options of GEOSEARCH and GEOSEARCHSTORE
+type geoSearchOptions struct {
+	shape                         geoShape
+	fromMember                    string
+	fromMemberSet, fromLongLat    bool
+	byRadius, byBox               bool
+	sort                          int // 0, 1 for ASC or -1 for DESC
+	count                         int
+	any                           bool
+	withDist, withHash, withCoord bool
+	storeDist                     bool
+}
+
+// parseGeoSearchOptions parses the options of GEOSEARCH from args[i:]
+func parseGeoSearchOptions(args []string, i int, store bool) (opts geoSearchOptions, err error) {
+	for ; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch arg := strings.ToUpper(args[i]); {
+		case arg == "WITHDIST":
+			opts.withDist = true
+		case arg == "WITHHASH":
+			opts.withHash = true
+		case arg == "WITHCOORD":
+			opts.withCoord = true
+		case arg == "STOREDIST" && store:
+			opts.storeDist = true
+		case arg == "ANY":
+			opts.any = true
+		case arg == "ASC":
+			opts.sort = 1
+		case arg == "DESC":
+			opts.sort = -1
+		case arg == "COUNT" && remaining >= 1:
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, notIntegerErr
+			}
+			if n <= 0 {
+				return opts, fmt.Errorf("COUNT must be > 0")
+			}
+			opts.count = int(n)
+			i++
+		case arg == "FROMMEMBER" && remaining >= 1 && !opts.fromLongLat:
+			opts.fromMember, opts.fromMemberSet = args[i+1], true
+			i++
+		case arg == "FROMLONLAT" && remaining >= 2 && !opts.fromMemberSet:
+			if opts.shape.long, opts.shape.lat, err = parseLongLat(args[i+1], args[i+2]); err != nil {
+				return opts, err
+			}
+			opts.fromLongLat = true
+			i += 2
+		case arg == "BYRADIUS" && remaining >= 2 && !opts.byBox:
+			radius, err := parseFloat(args[i+1])
+			if err != nil {
+				return opts, fmt.Errorf("need numeric radius")
+			}
+			if radius < 0 {
+				return opts, fmt.Errorf("radius cannot be negative")
+			}
+			conversion, ok := parseGeoUnit(args[i+2])
+			if !ok {
+				return opts, fmt.Errorf("unsupported unit provided. please use M, KM, FT, MI")
+			}
+			opts.shape.radius, opts.shape.conversion = radius, conversion
+			opts.byRadius = true
+			i += 2
+		case arg == "BYBOX" && remaining >= 3 && !opts.byRadius:
+			width, err := parseFloat(args[i+1])
+			if err != nil {
+				return opts, fmt.Errorf("need numeric width")
+			}
+			height, err := parseFloat(args[i+2])
+			if err != nil {
+				return opts, fmt.Errorf("need numeric height")
+			}
+			if width < 0 || height < 0 {
+				return opts, fmt.Errorf("height or width cannot be negative")
+			}
+			conversion, ok := parseGeoUnit(args[i+3])
+			if !ok {
+				return opts, fmt.Errorf("unsupported unit provided. please use M, KM, FT, MI")
+			}
+			opts.shape.width, opts.shape.height, opts.shape.conversion = width, height, conversion
+			opts.shape.box, opts.byBox = true, true
+			i += 3
+		default:
+			return opts, syntaxErr
+		}
+	}
+
+	if store && (opts.withDist || opts.withHash || opts.withCoord) {
+		return opts, fmt.Errorf("%s is not compatible with WITHDIST, WITHHASH and WITHCOORD options", args[0])
+	}
+	if !opts.fromMemberSet && !opts.fromLongLat {
+		return opts, fmt.Errorf("exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", args[0])
+	}
+	if !opts.byRadius && !opts.byBox {
+		return opts, fmt.Errorf("exactly one of BYRADIUS and BYBOX can be specified for %s", args[0])
+	}
+	if opts.any && opts.count == 0 {
+		return opts, fmt.Errorf("the ANY argument requires COUNT argument")
+	}
+	// the closest members are returned with COUNT
+	if opts.count > 0 && opts.sort == 0 && !opts.any {
+		opts.sort = 1
+	}
+	return opts, nil
+}
+
+// geoSearchKey returns the members of the sorted set at key in the shape of
+// the options, sorted and limited to COUNT
+func (s *Server) geoSearchKey(key string, opts *geoSearchOptions) ([]geoPoint, resp.Value, bool) {
+	z, ok := s.lookupZset(key)
+	if !ok {
+		return nil, wrongTypeReply, false
+	}
+	if z == nil {
+		return nil, resp.Value{}, true
+	}
+	if opts.fromMemberSet {
+		score, exists := z.dict[opts.fromMember]
+		if !exists {
+			return nil, resp.NewError("ERR could not decode requested zset member"), false
+		}
+		opts.shape.long, opts.shape.lat = geoDecodeScore(score)
+	}
+
+	limit := 0
+	if opts.any {
+		limit = opts.count
+	}
+	points := geoSearch(z, &opts.shape, limit)
+	if opts.sort != 0 {
+		sort.SliceStable(points, func(i, j int) bool {
+			if opts.sort < 0 {
+				return points[i].dist > points[j].dist
+			}
+			return points[i].dist < points[j].dist
+		})
+	}
+	if opts.count > 0 && len(points) > opts.count {
+		points = points[:opts.count]
+	}
+	return points, resp.Value{}, true
+}
+
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude
+// BYRADIUS radius M|KM|FT|MI|BYBOX width height M|KM|FT|MI [ASC|DESC]
+// [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+// returns the members in the circle or the box centered on a member or a
+// position. COUNT returns the closest members, or the first ones found with
+// ANY.
+func (s *Server) handleGeoSearch(c *client, args []string) resp.Value {
+	opts, err := parseGeoSearchOptions(args, 2, false)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	points, errReply, ok := s.geoSearchKey(args[1], &opts)
+	if !ok {
+		return errReply
+	}
+
+	values := make([]resp.Value, 0, len(points))
+	for _, p := range points {
+		if !opts.withDist && !opts.withHash && !opts.withCoord {
+			values = append(values, resp.NewBulkString(p.member))
+			continue
+		}
+		item := []resp.Value{resp.NewBulkString(p.member)}
+		if opts.withDist {
+			item = append(item, geoDistReply(p.dist/opts.shape.conversion))
+		}
+		if opts.withHash {
+			item = append(item, resp.NewInteger(int64(p.score)))
+		}
+		if opts.withCoord {
+			item = append(item, resp.NewArray(geoCoordReply(c, p.long), geoCoordReply(c, p.lat)))
+		}
+		values = append(values, resp.NewArray(item...))
+	}
+	return resp.NewArray(values...)
+}
+
+// GEOSEARCHSTORE destination source ... [STOREDIST] stores the members found
+// by GEOSEARCH in a sorted set, with their distance as score with STOREDIST.
+// Returns the number of members, destination is deleted if there are none.
+func (s *Server) handleGeoSearchStore(c *client, args []string) resp.Value {
+	opts, err := parseGeoSearchOptions(args, 3, true)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	points, errReply, ok := s.geoSearchKey(args[2], &opts)
+	if !ok {
+		return errReply
+	}
+
+	if len(points) == 0 {
+		s.deleteKey(args[1])
+		return resp.NewInteger(0)
+	}
+	z := newZset()
+	for _, p := range points {
+		score := p.score
+		if opts.storeDist {
+			score = p.dist / opts.shape.conversion
+		}
+		z.add(p.member, score)
+	}
+	s.setKey(args[1], RedisValue{value: z})
+	return resp.NewInteger(int64(len(points)))
+}
@@ -0,0 +1,39 @@
+package server
+
+import "testing"
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		nocase  bool
+		want    bool
+	}{
+		{"*", "anything", false, true},
+		{"*", "", false, true},
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hllo", false, false},
+		{"h*llo", "heeeello", false, true},
+		{"h*llo", "hello world", false, false},
+		{"h[ae]llo", "hallo", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h[b-a]llo", "hallo", false, true},
+		{"h[a-b]llo", "hcllo", false, false},
+		{"h\\*llo", "h*llo", false, true},
+		{"h\\*llo", "hello", false, false},
+		{"user:*:name", "user:1000:name", false, true},
+		{"MAX*", "maxclients", true, true},
+		{"MAX*", "maxclients", false, false},
+		{"a[bc", "ab", false, true},
+		{"**a", "ba", false, true},
+	}
+
+	for _, tt := range tests {
+		if got := stringMatch(tt.pattern, tt.str, tt.nocase); got != tt.want {
+			t.Errorf("stringMatch(%q, %q) = %v, want %v", tt.pattern, tt.str, got, tt.want)
+		}
+	}
+}
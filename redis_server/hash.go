@@ -0,0 +1,351 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	HSET         = "HSET"
+	HSETNX       = "HSETNX"
+	HGET         = "HGET"
+	HMGET        = "HMGET"
+	HDEL         = "HDEL"
+	HEXISTS      = "HEXISTS"
+	HLEN         = "HLEN"
+	HKEYS        = "HKEYS"
+	HVALS        = "HVALS"
+	HGETALL      = "HGETALL"
+	HINCRBY      = "HINCRBY"
+	HINCRBYFLOAT = "HINCRBYFLOAT"
+	HSCAN        = "HSCAN"
+)
+
+// hash maps fields to values, the commands modify it in place, see touchKey
+type hash struct {
+	dict map[string]string
+	// scan orders the fields for HSCAN, nil until its first call
+	scan *scanIndex
+}
+
+func newHash() *hash {
+	return &hash{dict: make(map[string]string)}
+}
+
+// len returns the number of fields, 0 for a nil hash
+func (h *hash) len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.dict)
+}
+
+// get returns the value of field, exists is false for a nil hash
+func (h *hash) get(field string) (value string, exists bool) {
+	if h == nil {
+		return "", false
+	}
+	value, exists = h.dict[field]
+	return value, exists
+}
+
+// set sets the value of field and reports whether it was added
+func (h *hash) set(field, value string) bool {
+	_, exists := h.dict[field]
+	if !exists {
+		h.scan.add(field)
+	}
+	h.dict[field] = value
+	return !exists
+}
+
+// remove removes field and reports whether it existed
+func (h *hash) remove(field string) bool {
+	if _, exists := h.dict[field]; !exists {
+		return false
+	}
+	delete(h.dict, field)
+	h.scan.remove(field)
+	return true
+}
+
+func (h *hash) clone() *hash {
+	clone := &hash{dict: make(map[string]string, len(h.dict))}
+	for field, value := range h.dict {
+		clone.dict[field] = value
+	}
+	return clone
+}
+
+// lookupHash returns the hash at key, nil if the key doesn't exist.
+// ok is false if the key holds another type.
+func (s *Server) lookupHash(key string) (h *hash, ok bool) {
+	val, exists := s.lookupKey(key)
+	if !exists {
+		return nil, true
+	}
+	h, ok = val.value.(*hash)
+	return h, ok
+}
+
+// hashForWrite returns the hash at key to be modified, created if the key
+// doesn't exist. ok is false if the key holds another type.
+func (s *Server) hashForWrite(key string) (h *hash, ok bool) {
+	h, ok = s.lookupHash(key)
+	if !ok {
+		return nil, false
+	}
+	if h == nil {
+		h = newHash()
+		s.setKey(key, RedisValue{value: h})
+	} else {
+		s.touchKey(key)
+	}
+	return h, true
+}
+
+// HSET key field value [field value ...] sets the fields,
+// returns the number of fields added
+func (s *Server) handleHSet(c *client, args []string) resp.Value {
+	if len(args)%2 != 0 {
+		return resp.NewError("ERR wrong number of arguments for '" + strings.ToLower(args[0]) + "' command")
+	}
+	h, ok := s.hashForWrite(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	added := 0
+	for i := 2; i < len(args); i += 2 {
+		if h.set(args[i], args[i+1]) {
+			added++
+		}
+	}
+	return resp.NewInteger(int64(added))
+}
+
+// HSETNX key field value sets the field unless it exists, returns 1 if it was set
+func (s *Server) handleHSetNX(c *client, args []string) resp.Value {
+	h, ok := s.lookupHash(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if _, exists := h.get(args[2]); exists {
+		return resp.NewInteger(0)
+	}
+	h, _ = s.hashForWrite(args[1])
+	h.set(args[2], args[3])
+	return resp.NewInteger(1)
+}
+
+// HGET key field returns the value of the field, nil if it doesn't exist
+func (s *Server) handleHGet(c *client, args []string) resp.Value {
+	h, ok := s.lookupHash(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	value, exists := h.get(args[2])
+	if !exists {
+		return resp.NewNull()
+	}
+	return resp.NewBulkString(value)
+}
+
+// HMGET key field [field ...] returns the values of the fields,
+// nil for the fields that don't exist
+func (s *Server) handleHMGet(c *client, args []string) resp.Value {
+	h, ok := s.lookupHash(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	values := make([]resp.Value, 0, len(args)-2)
+	for _, field := range args[2:] {
+		if value, exists := h.get(field); exists {
+			values = append(values, resp.NewBulkString(value))
+		} else {
+			values = append(values, resp.NewNull())
+		}
+	}
+	return resp.NewArray(values...)
+}
+
+// HDEL key field [field ...] removes the fields, the key is deleted with the
+// last one. Returns the number of fields removed.
+func (s *Server) handleHDel(c *client, args []string) resp.Value {
+	key := args[1]
+	h, ok := s.lookupHash(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	removed := 0
+	for _, field := range args[2:] {
+		if _, exists := h.get(field); !exists {
+			continue
+		}
+		if removed == 0 {
+			s.touchKey(key)
+		}
+		h.remove(field)
+		removed++
+	}
+	if removed > 0 && h.len() == 0 {
+		s.deleteKey(key)
+	}
+	return resp.NewInteger(int64(removed))
+}
+
+// HEXISTS key field returns 1 if the field exists
+func (s *Server) handleHExists(c *client, args []string) resp.Value {
+	h, ok := s.lookupHash(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if _, exists := h.get(args[2]); exists {
+		return resp.NewInteger(1)
+	}
+	return resp.NewInteger(0)
+}
+
+// HLEN key returns the number of fields
+func (s *Server) handleHLen(c *client, args []string) resp.Value {
+	h, ok := s.lookupHash(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	return resp.NewInteger(int64(h.len()))
+}
+
+// HKEYS key returns the fields
+func (s *Server) handleHKeys(c *client, args []string) resp.Value {
+	h, ok := s.lookupHash(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	fields := make([]string, 0, h.len())
+	if h != nil {
+		for field := range h.dict {
+			fields = append(fields, field)
+		}
+	}
+	return resp.NewBulkStringArray(fields)
+}
+
+// HVALS key returns the values
+func (s *Server) handleHVals(c *client, args []string) resp.Value {
+	h, ok := s.lookupHash(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	values := make([]string, 0, h.len())
+	if h != nil {
+		for _, value := range h.dict {
+			values = append(values, value)
+		}
+	}
+	return resp.NewBulkStringArray(values)
+}
+
+// HGETALL key returns the fields and their values, as a map under RESP3
+func (s *Server) handleHGetAll(c *client, args []string) resp.Value {
+	h, ok := s.lookupHash(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	pairs := make([]resp.Value, 0, 2*h.len())
+	if h != nil {
+		for field, value := range h.dict {
+			pairs = append(pairs, resp.NewBulkString(field), resp.NewBulkString(value))
+		}
+	}
+	return resp.NewMap(pairs...)
+}
+
+// HINCRBY key field increment adds increment to the integer value of the
+// field, a field that doesn't exist is set to 0 first. Returns the new value.
+func (s *Server) handleHIncrBy(c *client, args []string) resp.Value {
+	increment, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return resp.NewError("ERR " + notIntegerErr.Error())
+	}
+	h, ok := s.lookupHash(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	var n int64
+	if value, exists := h.get(args[2]); exists {
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return resp.NewError("ERR hash value is not an integer")
+		}
+	}
+	if (increment > 0 && n > math.MaxInt64-increment) || (increment < 0 && n < math.MinInt64-increment) {
+		return resp.NewError("ERR increment or decrement would overflow")
+	}
+	n += increment
+
+	h, _ = s.hashForWrite(args[1])
+	h.set(args[2], strconv.FormatInt(n, 10))
+	return resp.NewInteger(n)
+}
+
+// HINCRBYFLOAT key field increment adds increment to the float value of the
+// field, a field that doesn't exist is set to 0 first. Returns the new value.
+func (s *Server) handleHIncrByFloat(c *client, args []string) resp.Value {
+	increment, err := parseFloat(args[3])
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	h, ok := s.lookupHash(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	var f float64
+	if value, exists := h.get(args[2]); exists {
+		if f, err = parseFloat(value); err != nil {
+			return resp.NewError("ERR hash value is not a float")
+		}
+	}
+	f += increment
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return resp.NewError("ERR increment would produce NaN or Infinity")
+	}
+
+	value := formatFloat(f)
+	h, _ = s.hashForWrite(args[1])
+	h.set(args[2], value)
+	// the result may differ if the increment is replayed
+	c.propagate = []string{HSET, args[1], args[2], value}
+	return resp.NewBulkString(value)
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count] iterates the fields and
+// their values, see scanIndex
+func (s *Server) handleHScan(c *client, args []string) resp.Value {
+	opts, err := parseScanOptions(args[2:])
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	h, ok := s.lookupHash(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if h == nil {
+		return resp.NewArray(resp.NewBulkString("0"), resp.NewArray())
+	}
+	if h.scan == nil {
+		fields := make([]string, 0, len(h.dict))
+		for field := range h.dict {
+			fields = append(fields, field)
+		}
+		h.scan = newScanIndex(fields)
+	}
+	batch, cursor := h.scan.scan(opts)
+	pairs := make([]string, 0, 2*len(batch))
+	for _, field := range batch {
+		pairs = append(pairs, field, h.dict[field])
+	}
+	return resp.NewArray(
+		resp.NewBulkString(strconv.FormatUint(cursor, 10)),
+		resp.NewBulkStringArray(pairs),
+	)
+}
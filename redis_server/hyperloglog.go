@@ -0,0 +1,559 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	PFADD   = "PFADD"
+	PFCOUNT = "PFCOUNT"
+	PFMERGE = "PFMERGE"
+)
+
+// A HyperLogLog is a string with the layout of Redis, so that the values can
+// be exchanged with it: a 16 bytes header, "HYLL", the encoding, 3 unused
+// bytes and the cached cardinality (little endian, invalid if the most
+// significant bit is set), followed by the 16384 registers of 6 bits.
+// The dense encoding stores the registers one after another, starting from
+// the least significant bits of each byte. The sparse encoding stores runs of
+// registers with opcodes:
+//   - ZERO 00xxxxxx: xxxxxx+1 registers set to 0
+//   - XZERO 01xxxxxx yyyyyyyy: xxxxxxyyyyyyyy+1 registers set to 0
+//   - VAL 1vvvvvxx: xx+1 registers set to vvvvv+1
+// A sparse HyperLogLog is converted to dense when a register exceeds 32 or
+// the string would exceed hllSparseMaxBytes.
+
+const (
+	hllP           = 14 // the index of the register is made of 14 bits of the hash
+	hllQ           = 64 - hllP
+	hllRegisters   = 1 << hllP
+	hllBits        = 6
+	hllRegisterMax = 1<<hllBits - 1
+	hllHeaderSize  = 16
+	hllDenseSize   = hllHeaderSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0
+	hllSparse = 1
+
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+	hllSparseZeroMaxLen  = 64
+	// the default hll-sparse-max-bytes of Redis
+	hllSparseMaxBytes = 3000
+
+	// 0.5/ln(2)
+	hllAlphaInf = 0.721347520444481703680
+)
+
+var (
+	notHLLReply     = resp.NewError("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	corruptHLLReply = resp.NewError("INVALIDOBJ Corrupted HLL object detected")
+	corruptHLLErr   = errors.New("corrupted HyperLogLog")
+)
+
+// newHLL returns an empty sparse HyperLogLog
+func newHLL() []byte {
+	hll := make([]byte, hllHeaderSize, hllHeaderSize+2)
+	copy(hll, "HYLL")
+	hll[4] = hllSparse
+	return appendXZero(hll, hllRegisters)
+}
+
+// isHLL reports whether str has the header of a HyperLogLog
+func isHLL(str string) bool {
+	if len(str) < hllHeaderSize || str[:4] != "HYLL" || str[4] > hllSparse {
+		return false
+	}
+	return str[4] == hllSparse || len(str) == hllDenseSize
+}
+
+// lookupHLL returns the value at key and a copy of its HyperLogLog, nil if
+// the key doesn't exist. ok is false if the key holds another value, see
+// hllTypeReply.
+func (s *Server) lookupHLL(key string) (val RedisValue, hll []byte, ok bool) {
+	val, exists := s.lookupKey(key)
+	if !exists {
+		return val, nil, true
+	}
+	str, ok := val.value.(string)
+	if !ok || !isHLL(str) {
+		return val, nil, false
+	}
+	return val, []byte(str), true
+}
+
+// hllTypeReply is the error reply for a value that isn't a HyperLogLog
+func hllTypeReply(val RedisValue) resp.Value {
+	if _, ok := val.value.(string); ok {
+		return notHLLReply
+	}
+	return wrongTypeReply
+}
+
+func invalidateHLLCache(hll []byte) {
+	hll[15] |= 1 << 7
+}
+
+// hllPatternLen returns the register of element and the length of the
+// pattern 000..1 of the rest of its hash, the value the register is set to
+func hllPatternLen(element string) (index int, count uint8) {
+	hash := murmurHash64A([]byte(element), 0xadc83b19)
+	index = int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	// the loop terminates
+	hash |= 1 << hllQ
+	count = 1
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// murmurHash64A is the 64-bit MurmurHash2 used by Redis, reading the blocks
+// in little endian
+func murmurHash64A(key []byte, seed uint32) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := uint64(seed) ^ uint64(len(key))*m
+	for ; len(key) >= 8; key = key[8:] {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllAdd adds element to the HyperLogLog, that may be reallocated. Reports
+// whether a register changed.
+func hllAdd(hll []byte, element string) ([]byte, bool, error) {
+	index, count := hllPatternLen(element)
+	if hll[4] == hllDense {
+		return hll, hllDenseSet(hll[hllHeaderSize:], index, count), nil
+	}
+	return hllSparseSet(hll, index, count)
+}
+
+func hllDenseGet(registers []byte, index int) uint8 {
+	byteIndex := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+	v := uint(registers[byteIndex]) >> fb
+	if byteIndex+1 < len(registers) {
+		v |= uint(registers[byteIndex+1]) << (8 - fb)
+	}
+	return uint8(v & hllRegisterMax)
+}
+
+// hllDenseSet sets the register to count if it is greater, reports whether
+// it changed
+func hllDenseSet(registers []byte, index int, count uint8) bool {
+	if count <= hllDenseGet(registers, index) {
+		return false
+	}
+	byteIndex := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+	registers[byteIndex] &^= hllRegisterMax << fb
+	registers[byteIndex] |= count << fb
+	if byteIndex+1 < len(registers) {
+		registers[byteIndex+1] &^= hllRegisterMax >> (8 - fb)
+		registers[byteIndex+1] |= count >> (8 - fb)
+	}
+	return true
+}
+
+func isSparseZero(op byte) bool  { return op&0xc0 == 0 }
+func isSparseXZero(op byte) bool { return op&0xc0 == 0x40 }
+func isSparseVal(op byte) bool   { return op&0x80 != 0 }
+
+func sparseValValue(op byte) uint8 { return (op>>2)&0x1f + 1 }
+func sparseValLen(op byte) int     { return int(op&0x3) + 1 }
+
+func sparseVal(value uint8, n int) byte {
+	return (value-1)<<2 | byte(n-1) | 0x80
+}
+
+func appendZero(b []byte, n int) []byte {
+	return append(b, byte(n-1))
+}
+
+func appendXZero(b []byte, n int) []byte {
+	return append(b, byte((n-1)>>8)|0x40, byte(n-1))
+}
+
+// appendZeros appends a ZERO or XZERO opcode for n registers
+func appendZeros(b []byte, n int) []byte {
+	if n > hllSparseZeroMaxLen {
+		return appendXZero(b, n)
+	}
+	return appendZero(b, n)
+}
+
+// sparseOp returns the number of registers and the length of the opcode at
+// hll[p]
+func sparseOp(hll []byte, p int) (span, oplen int, err error) {
+	switch op := hll[p]; {
+	case isSparseZero(op):
+		return int(op&0x3f) + 1, 1, nil
+	case isSparseXZero(op):
+		if p+1 >= len(hll) {
+			return 0, 0, corruptHLLErr
+		}
+		return (int(op&0x3f)<<8 | int(hll[p+1])) + 1, 2, nil
+	default:
+		return sparseValLen(op), 1, nil
+	}
+}
+
+// hllSparseSet sets the register to count if it is greater, as Redis does:
+// the opcode holding the register is split in up to 3 opcodes, then the
+// VAL opcodes around it are merged if they have the same value. The
+// HyperLogLog is converted to dense if needed.
+func hllSparseSet(hll []byte, index int, count uint8) ([]byte, bool, error) {
+	if count > hllSparseValMaxValue {
+		return hllPromote(hll, index, count)
+	}
+
+	// find the opcode holding the register
+	p, prev := hllHeaderSize, -1
+	first, span, oplen := 0, 0, 0
+	for p < len(hll) {
+		var err error
+		if span, oplen, err = sparseOp(hll, p); err != nil {
+			return hll, false, err
+		}
+		if index <= first+span-1 {
+			break
+		}
+		prev = p
+		p += oplen
+		first += span
+	}
+	if span == 0 || p >= len(hll) {
+		return hll, false, corruptHLLErr
+	}
+
+	op := hll[p]
+	switch {
+	case isSparseVal(op) && sparseValValue(op) >= count:
+		return hll, false, nil
+	case (isSparseVal(op) || isSparseZero(op)) && span == 1:
+		// updated in place
+		hll[p] = sparseVal(count, 1)
+	default:
+		// the opcode is replaced with the registers before index, the
+		// register and the registers after index
+		var seq []byte
+		last := first + span - 1
+		if isSparseVal(op) {
+			value := sparseValValue(op)
+			if index != first {
+				seq = append(seq, sparseVal(value, index-first))
+			}
+			seq = append(seq, sparseVal(count, 1))
+			if index != last {
+				seq = append(seq, sparseVal(value, last-index))
+			}
+		} else {
+			if index != first {
+				seq = appendZeros(seq, index-first)
+			}
+			seq = append(seq, sparseVal(count, 1))
+			if index != last {
+				seq = appendZeros(seq, last-index)
+			}
+		}
+		if len(seq) > oplen && len(hll)+len(seq)-oplen > hllSparseMaxBytes {
+			return hllPromote(hll, index, count)
+		}
+		updated := make([]byte, 0, len(hll)+len(seq)-oplen)
+		updated = append(updated, hll[:p]...)
+		updated = append(updated, seq...)
+		hll = append(updated, hll[p+oplen:]...)
+	}
+
+	// merge the adjacent VAL opcodes with the same value, scanning up to 5
+	// opcodes from the one before the register
+	p = hllHeaderSize
+	if prev >= 0 {
+		p = prev
+	}
+	for scan := 5; p < len(hll) && scan > 0; scan-- {
+		switch op := hll[p]; {
+		case isSparseXZero(op):
+			p += 2
+			continue
+		case isSparseZero(op):
+			p++
+			continue
+		}
+		if p+1 < len(hll) && isSparseVal(hll[p+1]) {
+			value := sparseValValue(hll[p])
+			n := sparseValLen(hll[p]) + sparseValLen(hll[p+1])
+			if value == sparseValValue(hll[p+1]) && n <= hllSparseValMaxLen {
+				hll[p+1] = sparseVal(value, n)
+				hll = append(hll[:p], hll[p+1:]...)
+				// the merged opcode may be merged with the next one
+				continue
+			}
+		}
+		p++
+	}
+	invalidateHLLCache(hll)
+	return hll, true, nil
+}
+
+// hllPromote converts the HyperLogLog to dense and sets the register
+func hllPromote(hll []byte, index int, count uint8) ([]byte, bool, error) {
+	hll, err := hllSparseToDense(hll)
+	if err != nil {
+		return hll, false, err
+	}
+	return hll, hllDenseSet(hll[hllHeaderSize:], index, count), nil
+}
+
+// hllSparseToDense returns the HyperLogLog with the dense encoding, the
+// cached cardinality is kept
+func hllSparseToDense(hll []byte) ([]byte, error) {
+	if hll[4] == hllDense {
+		return hll, nil
+	}
+	dense := make([]byte, hllDenseSize)
+	copy(dense, hll[:hllHeaderSize])
+	dense[4] = hllDense
+	registers := dense[hllHeaderSize:]
+	err := walkSparse(hll, func(index, n int, value uint8) {
+		for i := index; i < index+n; i++ {
+			hllDenseSet(registers, i, value)
+		}
+	})
+	if err != nil {
+		return hll, err
+	}
+	return dense, nil
+}
+
+// walkSparse calls fn for the runs of registers set by the VAL opcodes of
+// a sparse HyperLogLog, checking that they cover all the registers
+func walkSparse(hll []byte, fn func(index, n int, value uint8)) error {
+	index := 0
+	for p := hllHeaderSize; p < len(hll); {
+		span, oplen, err := sparseOp(hll, p)
+		if err != nil {
+			return err
+		}
+		if index+span > hllRegisters {
+			return corruptHLLErr
+		}
+		if isSparseVal(hll[p]) {
+			fn(index, span, sparseValValue(hll[p]))
+		}
+		index += span
+		p += oplen
+	}
+	if index != hllRegisters {
+		return corruptHLLErr
+	}
+	return nil
+}
+
+// hllMerge sets each register of max to the maximum of its value and the
+// register of the HyperLogLog
+func hllMerge(max []uint8, hll []byte) error {
+	if hll[4] == hllDense {
+		registers := hll[hllHeaderSize:]
+		for i := range max {
+			if v := hllDenseGet(registers, i); v > max[i] {
+				max[i] = v
+			}
+		}
+		return nil
+	}
+	return walkSparse(hll, func(index, n int, value uint8) {
+		for i := index; i < index+n; i++ {
+			if value > max[i] {
+				max[i] = value
+			}
+		}
+	})
+}
+
+// hllCount estimates the cardinality from the registers with the estimator
+// of Redis, from "New cardinality estimation algorithms for HyperLogLog
+// sketches" by Otmar Ertl
+func hllCount(registers []uint8) uint64 {
+	var histogram [64]int
+	for _, v := range registers {
+		histogram[v]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+// PFADD key [element ...] adds the elements to the HyperLogLog, created if
+// the key doesn't exist. Returns 1 if a register changed or the key was
+// created.
+func (s *Server) handlePFAdd(c *client, args []string) resp.Value {
+	val, hll, ok := s.lookupHLL(args[1])
+	if !ok {
+		return hllTypeReply(val)
+	}
+	updated := false
+	if hll == nil {
+		hll = newHLL()
+		updated = true
+	}
+	for _, element := range args[2:] {
+		var changed bool
+		var err error
+		if hll, changed, err = hllAdd(hll, element); err != nil {
+			return corruptHLLReply
+		}
+		updated = updated || changed
+	}
+	if !updated {
+		return resp.NewInteger(0)
+	}
+	invalidateHLLCache(hll)
+	val.value = string(hll)
+	// the expiration is kept
+	s.setKey(args[1], val)
+	return resp.NewInteger(1)
+}
+
+// PFCOUNT key [key ...] returns the estimated cardinality of the union of
+// the HyperLogLogs. The cardinality of a single key is cached in its header.
+func (s *Server) handlePFCount(c *client, args []string) resp.Value {
+	if len(args) == 2 {
+		val, hll, ok := s.lookupHLL(args[1])
+		if !ok {
+			return hllTypeReply(val)
+		}
+		if hll == nil {
+			return resp.NewInteger(0)
+		}
+		if hll[15]&(1<<7) == 0 {
+			return resp.NewInteger(int64(binary.LittleEndian.Uint64(hll[8:hllHeaderSize])))
+		}
+		registers := make([]uint8, hllRegisters)
+		if err := hllMerge(registers, hll); err != nil {
+			return corruptHLLReply
+		}
+		card := hllCount(registers)
+		binary.LittleEndian.PutUint64(hll[8:hllHeaderSize], card)
+		val.value = string(hll)
+		s.setKey(args[1], val)
+		return resp.NewInteger(int64(card))
+	}
+
+	registers := make([]uint8, hllRegisters)
+	for _, key := range args[1:] {
+		val, hll, ok := s.lookupHLL(key)
+		if !ok {
+			return hllTypeReply(val)
+		}
+		if hll == nil {
+			continue
+		}
+		if err := hllMerge(registers, hll); err != nil {
+			return corruptHLLReply
+		}
+	}
+	return resp.NewInteger(int64(hllCount(registers)))
+}
+
+// PFMERGE destkey [sourcekey ...] stores the union of the HyperLogLogs,
+// destkey included, in destkey. The result is dense if one of them is.
+func (s *Server) handlePFMerge(c *client, args []string) resp.Value {
+	registers := make([]uint8, hllRegisters)
+	dense := false
+	for _, key := range args[1:] {
+		val, hll, ok := s.lookupHLL(key)
+		if !ok {
+			return hllTypeReply(val)
+		}
+		if hll == nil {
+			continue
+		}
+		dense = dense || hll[4] == hllDense
+		if err := hllMerge(registers, hll); err != nil {
+			return corruptHLLReply
+		}
+	}
+
+	val, hll, _ := s.lookupHLL(args[1])
+	if hll == nil {
+		hll = newHLL()
+	}
+	var err error
+	if dense {
+		if hll, err = hllSparseToDense(hll); err != nil {
+			return corruptHLLReply
+		}
+	}
+	for i, count := range registers {
+		if count == 0 {
+			continue
+		}
+		if hll[4] == hllDense {
+			hllDenseSet(hll[hllHeaderSize:], i, count)
+		} else if hll, _, err = hllSparseSet(hll, i, count); err != nil {
+			return corruptHLLReply
+		}
+	}
+	invalidateHLLCache(hll)
+	val.value = string(hll)
+	s.setKey(args[1], val)
+	return resp.OK
+}
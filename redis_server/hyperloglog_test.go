@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestMurmurHash64A(t *testing.T) {
+	// the verification value of SMHasher: the hashes of the keys 0..i with
+	// the seeds 256-i, hashed again
+	key := make([]byte, 256)
+	hashes := make([]byte, 8*256)
+	for i := 0; i < 256; i++ {
+		key[i] = byte(i)
+		binary.LittleEndian.PutUint64(hashes[8*i:], murmurHash64A(key[:i], uint32(256-i)))
+	}
+	if got := uint32(murmurHash64A(hashes, 0)); got != 0x1f0d3804 {
+		t.Errorf("got verification value %#x, want 0x1f0d3804", got)
+	}
+}
+
+func TestHLL(t *testing.T) {
+	// the registers are checked against a plain array of registers
+	want := make([]uint8, hllRegisters)
+	sparse, dense := newHLL(), make([]byte, hllDenseSize)
+	copy(dense, sparse[:hllHeaderSize])
+	dense[4] = hllDense
+	promoted := false
+	for i := 0; i < 20000; i++ {
+		element := strconv.Itoa(i)
+		index, count := hllPatternLen(element)
+		changed := count > want[index]
+		if changed {
+			want[index] = count
+		}
+
+		var got bool
+		var err error
+		wasSparse := sparse[4] == hllSparse
+		if sparse, got, err = hllAdd(sparse, element); err != nil {
+			t.Fatal(err)
+		}
+		if got != changed {
+			t.Fatalf("adding %s reported %v, want %v", element, got, changed)
+		}
+		if wasSparse && sparse[4] == hllDense {
+			promoted = true
+			if len(sparse) != hllDenseSize {
+				t.Fatalf("got %d bytes after the conversion, want %d", len(sparse), hllDenseSize)
+			}
+		}
+		if dense, got, _ = hllAdd(dense, element); got != changed {
+			t.Fatalf("adding %s to the dense HyperLogLog reported %v, want %v", element, got, changed)
+		}
+
+		if i%1000 == 0 || i < 100 {
+			for _, hll := range [][]byte{sparse, dense} {
+				registers := make([]uint8, hllRegisters)
+				if err := hllMerge(registers, hll); err != nil {
+					t.Fatal(err)
+				}
+				for j := range registers {
+					if registers[j] != want[j] {
+						t.Fatalf("after %d elements, got %d in register %d, want %d", i+1, registers[j], j, want[j])
+					}
+				}
+			}
+		}
+	}
+	if !promoted {
+		t.Error("the sparse HyperLogLog wasn't converted to dense")
+	}
+
+	if got := hllCount(make([]uint8, hllRegisters)); got != 0 {
+		t.Errorf("got %d for the empty HyperLogLog", got)
+	}
+	if got := hllCount(want); math.Abs(float64(got)-20000) > 20000*0.02 {
+		t.Errorf("got an estimate of %d for 20000 elements", got)
+	}
+}
+
+func TestHLL_SparseOpcodes(t *testing.T) {
+	hll := newHLL()
+	if string(hll[hllHeaderSize:]) != "\x7f\xff" {
+		t.Fatalf("got %q for the empty HyperLogLog, want a single XZERO", hll[hllHeaderSize:])
+	}
+
+	set := func(index int, count uint8) {
+		var err error
+		if hll, _, err = hllSparseSet(hll, index, count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set(1000, 3)
+	// XZERO 1000, VAL 3, XZERO 15383
+	if got, want := string(hll[hllHeaderSize:]), "\x43\xe7\x88\x7c\x16"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	set(1001, 3)
+	set(999, 3)
+	// the adjacent VAL opcodes with the same value are merged
+	if got, want := string(hll[hllHeaderSize:]), "\x43\xe6\x8a\x7c\x15"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	set(10, 2)
+	// ZERO 10, VAL 2, XZERO 988, VAL 3 x3, XZERO 15382
+	if got, want := string(hll[hllHeaderSize:]), "\x09\x84\x43\xdb\x8a\x7c\x15"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	set(1000, 33)
+	if hll[4] != hllDense || hllDenseGet(hll[hllHeaderSize:], 1000) != 33 || hllDenseGet(hll[hllHeaderSize:], 10) != 2 {
+		t.Error("a register over 32 doesn't convert to dense")
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const INFO = "INFO"
+
+// infoSection is a section of the INFO reply, made of name:value fields
+type infoSection struct {
+	name   string
+	title  string
+	fields func(s *Server) []string
+}
+
+var infoSections = []infoSection{
+	{"server", "Server", (*Server).infoServer},
+	{"clients", "Clients", (*Server).infoClients},
+	{"memory", "Memory", (*Server).infoMemory},
+	{"persistence", "Persistence", (*Server).infoPersistence},
+	{"stats", "Stats", (*Server).infoStats},
+	{"keyspace", "Keyspace", (*Server).infoKeyspace},
+}
+
+// INFO [section [section ...]] returns information about the server, the
+// sections default, all and everything select every section
+func (s *Server) handleInfo(c *client, args []string) resp.Value {
+	all := len(args) == 1
+	wanted := make(map[string]bool)
+	for _, arg := range args[1:] {
+		arg = strings.ToLower(arg)
+		all = all || arg == "default" || arg == "all" || arg == "everything"
+		wanted[arg] = true
+	}
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + section.title + "\r\n")
+		for _, field := range section.fields(s) {
+			b.WriteString(field + "\r\n")
+		}
+	}
+	return resp.NewVerbatim("txt", b.String())
+}
+
+func (s *Server) infoServer() []string {
+	uptime := time.Since(s.startTime)
+	return []string{
+		"redis_version:" + redisVersion,
+		"redis_mode:standalone",
+		"arch_bits:" + strconv.Itoa(strconv.IntSize),
+		"process_id:" + strconv.Itoa(os.Getpid()),
+		"tcp_port:" + strconv.Itoa(s.config.Port),
+		"uptime_in_seconds:" + strconv.Itoa(int(uptime/time.Second)),
+		"uptime_in_days:" + strconv.Itoa(int(uptime/(24*time.Hour))),
+	}
+}
+
+func (s *Server) infoClients() []string {
+	return []string{
+		"connected_clients:" + strconv.Itoa(len(s.clients)),
+		"maxclients:" + strconv.Itoa(s.config.MaxClients),
+		"blocked_clients:" + strconv.Itoa(s.blockedClients),
+	}
+}
+
+func (s *Server) infoMemory() []string {
+	return []string{
+		"used_memory:" + strconv.FormatInt(usedMemory(), 10),
+		"maxmemory:" + strconv.FormatInt(s.config.MaxMemory, 10),
+	}
+}
+
+func (s *Server) infoPersistence() []string {
+	bgsave, rewrite, processed, total, current, currentRewrite := 0, 0, 0, 0, -1, -1
+	if s.cow != nil {
+		processed, total = s.cow.processed, s.cow.total
+		elapsed := int(time.Since(s.cow.start) / time.Second)
+		if s.cow.aof {
+			rewrite, currentRewrite = 1, elapsed
+		} else {
+			bgsave, current = 1, elapsed
+		}
+	}
+	aofWrite := "ok"
+	if s.aofWriteErr != nil {
+		aofWrite = "err"
+	}
+	fields := []string{
+		"loading:0",
+		"current_save_keys_processed:" + strconv.Itoa(processed),
+		"current_save_keys_total:" + strconv.Itoa(total),
+		"rdb_changes_since_last_save:" + strconv.FormatInt(s.dirty, 10),
+		"rdb_bgsave_in_progress:" + strconv.Itoa(bgsave),
+		"rdb_last_save_time:" + strconv.FormatInt(s.lastSave.Unix(), 10),
+		"rdb_last_bgsave_status:" + formatStatus(s.lastBgsaveOK),
+		"rdb_last_bgsave_time_sec:" + formatSeconds(s.lastBgsaveTime),
+		"rdb_current_bgsave_time_sec:" + strconv.Itoa(current),
+		"rdb_saves:" + strconv.FormatInt(s.rdbSaves, 10),
+		"aof_enabled:" + strconv.Itoa(boolToInt(s.aofFile != nil)),
+		"aof_rewrite_in_progress:" + strconv.Itoa(rewrite),
+		"aof_rewrite_scheduled:" + strconv.Itoa(boolToInt(s.aofRewriteScheduled)),
+		"aof_last_rewrite_time_sec:" + formatSeconds(s.aofLastRewriteTime),
+		"aof_current_rewrite_time_sec:" + strconv.Itoa(currentRewrite),
+		"aof_last_bgrewrite_status:" + formatStatus(s.aofLastRewriteOK),
+		"aof_rewrites:" + strconv.FormatInt(s.aofRewrites, 10),
+		"aof_last_write_status:" + aofWrite,
+	}
+	if s.aofFile != nil {
+		fields = append(fields,
+			"aof_current_size:"+strconv.FormatInt(s.aofSize, 10),
+			"aof_base_size:"+strconv.FormatInt(s.aofBaseSize, 10),
+		)
+	}
+	return fields
+}
+
+func (s *Server) infoStats() []string {
+	return []string{
+		"expired_keys:" + strconv.FormatInt(s.expiredKeys, 10),
+	}
+}
+
+// infoKeyspace lists the databases that have keys
+func (s *Server) infoKeyspace() []string {
+	var fields []string
+	for _, db := range s.dbs {
+		if len(db.dict) == 0 {
+			continue
+		}
+		fields = append(fields, "db"+strconv.Itoa(db.id)+":keys="+strconv.Itoa(len(db.dict))+
+			",expires="+strconv.Itoa(len(db.volatile)))
+	}
+	return fields
+}
+
+func formatStatus(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "err"
+}
+
+// formatSeconds formats a duration in whole seconds, -1 stays -1
+func formatSeconds(d time.Duration) string {
+	if d < 0 {
+		return "-1"
+	}
+	return strconv.Itoa(int(d / time.Second))
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,383 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	KEYS      = "KEYS"
+	SCAN      = "SCAN"
+	TYPE      = "TYPE"
+	RENAME    = "RENAME"
+	RENAMENX  = "RENAMENX"
+	RANDOMKEY = "RANDOMKEY"
+	DBSIZE    = "DBSIZE"
+	COPY      = "COPY"
+	TOUCH     = "TOUCH"
+	UNLINK    = "UNLINK"
+	SELECT    = "SELECT"
+	MOVE      = "MOVE"
+	SWAPDB    = "SWAPDB"
+	FLUSHDB   = "FLUSHDB"
+	FLUSHALL  = "FLUSHALL"
+)
+
+var (
+	noSuchKeyErr   = errors.New("no such key")
+	dbRangeErr     = errors.New("DB index is out of range")
+	sameObjectsErr = errors.New("source and destination objects are the same")
+)
+
+// typeName returns the name of the type of value, as TYPE replies
+func typeName(value any) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case *list:
+		return "list"
+	case *hash:
+		return "hash"
+	case *set:
+		return "set"
+	case *zset:
+		return "zset"
+	case *stream:
+		return "stream"
+	default:
+		return "none"
+	}
+}
+
+// KEYS pattern returns the keys matching the glob-style pattern
+func (s *Server) handleKeys(c *client, args []string) resp.Value {
+	pattern := args[1]
+	all := pattern == "*"
+	now := time.Now()
+	keys := []string{}
+	for key, val := range s.db.dict {
+		if val.expired(now) {
+			continue
+		}
+		if all || stringMatch(pattern, key, false) {
+			keys = append(keys, key)
+		}
+	}
+	return resp.NewBulkStringArray(keys)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type] iterates the keys,
+// see scanIndex. The cursor doesn't depend on the layout of the dict, so the
+// keys present during the whole iteration are returned whatever the keys
+// added or deleted in between.
+func (s *Server) handleScan(c *client, args []string) resp.Value {
+	// TYPE is only accepted by SCAN, the keys are filtered once examined
+	var typ string
+	scanArgs := []string{args[1]}
+	for i := 2; i < len(args); i += 2 {
+		if strings.EqualFold(args[i], "TYPE") && i+1 < len(args) {
+			typ = strings.ToLower(args[i+1])
+			continue
+		}
+		if i+1 < len(args) {
+			scanArgs = append(scanArgs, args[i], args[i+1])
+		} else {
+			scanArgs = append(scanArgs, args[i])
+		}
+	}
+	opts, err := parseScanOptions(scanArgs)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+
+	if s.db.scan == nil {
+		keys := make([]string, 0, len(s.db.dict))
+		for key := range s.db.dict {
+			keys = append(keys, key)
+		}
+		s.db.scan = newScanIndex(keys)
+	}
+	batch, cursor := s.db.scan.scan(opts)
+	found := batch[:0]
+	for _, key := range batch {
+		val, ok := s.lookupKey(key)
+		if ok && (typ == "" || typeName(val.value) == typ) {
+			found = append(found, key)
+		}
+	}
+	return resp.NewArray(
+		resp.NewBulkString(strconv.FormatUint(cursor, 10)),
+		resp.NewBulkStringArray(found),
+	)
+}
+
+// TYPE key returns the type of the value at key, none if it doesn't exist
+func (s *Server) handleType(c *client, args []string) resp.Value {
+	val, ok := s.lookupKey(args[1])
+	if !ok {
+		return resp.NewSimpleString("none")
+	}
+	return resp.NewSimpleString(typeName(val.value))
+}
+
+// RENAME key newkey moves the value and the expiration of key to newkey,
+// replacing its value
+func (s *Server) handleRename(c *client, args []string) resp.Value {
+	if _, err := s.rename(args[1], args[2], false); err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	return resp.OK
+}
+
+// RENAMENX key newkey renames key unless newkey exists, returns 1 if it was
+// renamed and 0 otherwise
+func (s *Server) handleRenameNX(c *client, args []string) resp.Value {
+	renamed, err := s.rename(args[1], args[2], true)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	if !renamed {
+		return resp.NewInteger(0)
+	}
+	return resp.NewInteger(1)
+}
+
+// rename moves key to newKey, unless newKey exists and nx is set
+func (s *Server) rename(key, newKey string, nx bool) (bool, error) {
+	val, ok := s.lookupKey(key)
+	if !ok {
+		return false, noSuchKeyErr
+	}
+	if key == newKey {
+		return !nx, nil
+	}
+	if _, exists := s.lookupKey(newKey); exists && nx {
+		return false, nil
+	}
+	s.deleteKey(key)
+	s.setKey(newKey, val)
+	s.signalReady(newKey)
+	return true, nil
+}
+
+// RANDOMKEY returns a random key, nil when the dataset is empty
+func (s *Server) handleRandomKey(c *client, args []string) resp.Value {
+	now := time.Now()
+	// the iteration order of a map is random
+	for key, val := range s.db.dict {
+		if val.expired(now) {
+			s.expireKey(key)
+			continue
+		}
+		return resp.NewBulkString(key)
+	}
+	return resp.NewNull()
+}
+
+// DBSIZE returns the number of keys, including the ones that expired but
+// weren't deleted yet
+func (s *Server) handleDBSize(c *client, args []string) resp.Value {
+	return resp.NewInteger(int64(len(s.db.dict)))
+}
+
+// COPY source destination [DB destination-db] [REPLACE] copies the value and
+// the expiration of source to destination, in the current database unless DB
+// is given. Returns 1 if it was copied and 0 if destination exists without
+// REPLACE.
+func (s *Server) handleCopy(c *client, args []string) resp.Value {
+	source, destination := args[1], args[2]
+	db, replace := s.db, false
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "REPLACE":
+			replace = true
+		case opt == "DB" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return resp.NewError("ERR " + notIntegerErr.Error())
+			}
+			if db = s.database(n); db == nil {
+				return resp.NewError("ERR " + dbRangeErr.Error())
+			}
+			i++
+		default:
+			return resp.NewError("ERR syntax error")
+		}
+	}
+	if source == destination && db == s.db {
+		return resp.NewError("ERR " + sameObjectsErr.Error())
+	}
+
+	val, ok := s.lookupKey(source)
+	if !ok {
+		return resp.NewInteger(0)
+	}
+	copied := false
+	s.inDB(db, func() {
+		if _, exists := s.lookupKey(destination); exists && !replace {
+			return
+		}
+		val.value = copyValue(val.value)
+		s.setKey(destination, val)
+		s.signalReady(destination)
+		copied = true
+	})
+	if !copied {
+		return resp.NewInteger(0)
+	}
+	return resp.NewInteger(1)
+}
+
+// TOUCH key [key ...] returns the number of keys that exist
+func (s *Server) handleTouch(c *client, args []string) resp.Value {
+	return s.handleExists(c, args)
+}
+
+// UNLINK key [key ...] deletes the keys as DEL does, returns the number of
+// keys deleted
+func (s *Server) handleUnlink(c *client, args []string) resp.Value {
+	return s.handleDelete(c, args)
+}
+
+// database returns the database number n, nil if it is out of range
+func (s *Server) database(n int) *database {
+	if n < 0 || n >= len(s.dbs) {
+		return nil
+	}
+	return s.dbs[n]
+}
+
+// inDB calls f with db selected instead of the database of the command
+func (s *Server) inDB(db *database, f func()) {
+	selected := s.db
+	s.db = db
+	defer func() { s.db = selected }()
+	f()
+}
+
+// SELECT index selects the database of the connection
+func (s *Server) handleSelect(c *client, args []string) resp.Value {
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return resp.NewError("ERR invalid DB index")
+	}
+	db := s.database(n)
+	if db == nil {
+		return resp.NewError("ERR " + dbRangeErr.Error())
+	}
+	c.db = db
+	return resp.OK
+}
+
+// MOVE key db moves key and its expiration to the database db, returns 1 if
+// it was moved and 0 if key doesn't exist or db already has it
+func (s *Server) handleMove(c *client, args []string) resp.Value {
+	key := args[1]
+	n, err := strconv.Atoi(args[2])
+	if err != nil {
+		return resp.NewError("ERR " + notIntegerErr.Error())
+	}
+	db := s.database(n)
+	if db == nil {
+		return resp.NewError("ERR " + dbRangeErr.Error())
+	}
+	if db == s.db {
+		return resp.NewError("ERR " + sameObjectsErr.Error())
+	}
+
+	val, ok := s.lookupKey(key)
+	if !ok {
+		return resp.NewInteger(0)
+	}
+	moved := false
+	s.inDB(db, func() {
+		if _, exists := s.lookupKey(key); exists {
+			return
+		}
+		s.setKey(key, val)
+		s.signalReady(key)
+		moved = true
+	})
+	if !moved {
+		return resp.NewInteger(0)
+	}
+	s.deleteKey(key)
+	return resp.NewInteger(1)
+}
+
+// SWAPDB index1 index2 exchanges the keys of two databases, the clients
+// connected to one of them see the keys of the other one
+func (s *Server) handleSwapDB(c *client, args []string) resp.Value {
+	n1, err := strconv.Atoi(args[1])
+	if err != nil {
+		return resp.NewError("ERR invalid first DB index")
+	}
+	n2, err := strconv.Atoi(args[2])
+	if err != nil {
+		return resp.NewError("ERR invalid second DB index")
+	}
+	db1, db2 := s.database(n1), s.database(n2)
+	if db1 == nil || db2 == nil {
+		return resp.NewError("ERR " + dbRangeErr.Error())
+	}
+	if db1 == db2 {
+		return resp.OK
+	}
+
+	db1.keyspace, db2.keyspace = db2.keyspace, db1.keyspace
+	s.touchAllWatched(db1, db1.keyspace, db2.keyspace)
+	s.touchAllWatched(db2, db1.keyspace, db2.keyspace)
+	s.dirty++
+	// the clients blocked on a key stay in their database, the key may
+	// serve them now
+	for _, db := range []*database{db1, db2} {
+		s.inDB(db, func() {
+			for key := range db.blocked {
+				s.signalReady(key)
+			}
+		})
+	}
+	return resp.OK
+}
+
+// FLUSHDB [ASYNC|SYNC] deletes the keys of the current database
+func (s *Server) handleFlushDB(c *client, args []string) resp.Value {
+	if err := parseFlushMode(args); err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	s.flushDB(s.db)
+	return resp.OK
+}
+
+// FLUSHALL [ASYNC|SYNC] deletes the keys of every database
+func (s *Server) handleFlushAll(c *client, args []string) resp.Value {
+	if err := parseFlushMode(args); err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	for _, db := range s.dbs {
+		s.flushDB(db)
+	}
+	return resp.OK
+}
+
+// parseFlushMode checks the mode of FLUSHDB and FLUSHALL. The keys are
+// always freed in the background by the garbage collector, so both are
+// accepted.
+func parseFlushMode(args []string) error {
+	if len(args) > 2 || (len(args) == 2 && !strings.EqualFold(args[1], "ASYNC") && !strings.EqualFold(args[1], "SYNC")) {
+		return syntaxErr
+	}
+	return nil
+}
+
+// flushDB replaces the keyspace of db with an empty one, the keyspace of a
+// background save in progress is left untouched
+func (s *Server) flushDB(db *database) {
+	// the command is propagated even if the database was already empty
+	s.dirty += int64(len(db.dict)) + 1
+	s.touchAllWatched(db, db.keyspace)
+	db.keyspace = newKeyspace(make(map[string]RedisValue))
+}
@@ -0,0 +1,614 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"strconv"
+	"strings"
+)
+
+const (
+	LPOP      = "LPOP"
+	RPOP      = "RPOP"
+	LLEN      = "LLEN"
+	LRANGE    = "LRANGE"
+	LINDEX    = "LINDEX"
+	LSET      = "LSET"
+	LINSERT   = "LINSERT"
+	LREM      = "LREM"
+	LTRIM     = "LTRIM"
+	LPOS      = "LPOS"
+	LMOVE     = "LMOVE"
+	RPOPLPUSH = "RPOPLPUSH"
+)
+
+// list is a double-ended queue stored in a ring buffer: pushing and popping
+// at both ends is O(1) amortized, and so is the access by index.
+// The commands modify it in place, see touchKey.
+type list struct {
+	buf  []string
+	head int // index in buf of the first element
+	n    int
+}
+
+func newList(values ...string) *list {
+	return &list{buf: append([]string(nil), values...), n: len(values)}
+}
+
+func (l *list) len() int {
+	return l.n
+}
+
+// index returns the element at index i, 0 <= i < l.len()
+func (l *list) index(i int) string {
+	return l.buf[(l.head+i)%len(l.buf)]
+}
+
+func (l *list) set(i int, value string) {
+	l.buf[(l.head+i)%len(l.buf)] = value
+}
+
+func (l *list) pushFront(value string) {
+	l.grow()
+	l.head = (l.head + len(l.buf) - 1) % len(l.buf)
+	l.buf[l.head] = value
+	l.n++
+}
+
+func (l *list) pushBack(value string) {
+	l.grow()
+	l.buf[(l.head+l.n)%len(l.buf)] = value
+	l.n++
+}
+
+// popFront removes and returns the first element, the list must not be empty
+func (l *list) popFront() string {
+	value := l.buf[l.head]
+	l.buf[l.head] = ""
+	l.head = (l.head + 1) % len(l.buf)
+	l.n--
+	l.shrink()
+	return value
+}
+
+// popBack removes and returns the last element, the list must not be empty
+func (l *list) popBack() string {
+	i := (l.head + l.n - 1) % len(l.buf)
+	value := l.buf[i]
+	l.buf[i] = ""
+	l.n--
+	l.shrink()
+	return value
+}
+
+// pop removes and returns the first or the last element
+func (l *list) pop(front bool) string {
+	if front {
+		return l.popFront()
+	}
+	return l.popBack()
+}
+
+// push inserts value at the head or at the tail
+func (l *list) push(value string, front bool) {
+	if front {
+		l.pushFront(value)
+	} else {
+		l.pushBack(value)
+	}
+}
+
+// rangeValues returns the elements from start to stop included,
+// 0 <= start <= stop < l.len()
+func (l *list) rangeValues(start, stop int) []string {
+	values := make([]string, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		values = append(values, l.index(i))
+	}
+	return values
+}
+
+// values returns the elements in order
+func (l *list) values() []string {
+	if l.n == 0 {
+		return nil
+	}
+	return l.rangeValues(0, l.n-1)
+}
+
+// reset replaces the elements, it is used by the commands that modify the
+// middle of the list, which is O(n) anyway
+func (l *list) reset(values []string) {
+	*l = *newList(values...)
+}
+
+func (l *list) clone() *list {
+	return newList(l.values()...)
+}
+
+func (l *list) grow() {
+	if l.n < len(l.buf) {
+		return
+	}
+	l.resize(2*len(l.buf) + 4)
+}
+
+// shrink releases the memory of the lists that lost most of their elements
+func (l *list) shrink() {
+	if len(l.buf) > 64 && l.n < len(l.buf)/4 {
+		l.resize(len(l.buf) / 2)
+	}
+}
+
+func (l *list) resize(size int) {
+	buf := make([]string, size)
+	for i := 0; i < l.n; i++ {
+		buf[i] = l.index(i)
+	}
+	l.buf, l.head = buf, 0
+}
+
+// lookupList returns the list at key, nil if the key doesn't exist.
+// ok is false if the key holds another type.
+func (s *Server) lookupList(key string) (l *list, ok bool) {
+	val, exists := s.lookupKey(key)
+	if !exists {
+		return nil, true
+	}
+	l, ok = val.value.(*list)
+	return l, ok
+}
+
+// listIndex converts an index that may count from the end of the list
+func listIndex(arg string, n int) (int, error) {
+	i, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, notIntegerErr
+	}
+	if i < 0 {
+		i += n
+	}
+	return i, nil
+}
+
+// listRange converts the start and stop indexes of a range that may count
+// from the end of the list, ok is false if the range is empty
+func listRange(startArg, stopArg string, n int) (start, stop int, ok bool, err error) {
+	if start, err = listIndex(startArg, n); err != nil {
+		return 0, 0, false, err
+	}
+	if stop, err = listIndex(stopArg, n); err != nil {
+		return 0, 0, false, err
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	return start, stop, start <= stop, nil
+}
+
+// LPUSH key element [element ...] inserts the elements at the head of the list
+// one after the other. Returns Integer reply: the length of the list after the push operations.
+func (s *Server) handleLPush(c *client, args []string) resp.Value {
+	return s.push(args, true)
+}
+
+// RPUSH key element [element ...] inserts the elements at the tail of the list.
+// Returns Integer reply: the length of the list after the push operations.
+func (s *Server) handleRPush(c *client, args []string) resp.Value {
+	return s.push(args, false)
+}
+
+func (s *Server) push(args []string, front bool) resp.Value {
+	key := args[1]
+	l, ok := s.lookupList(key)
+	//When key holds a value that is not a list, an error is returned.
+	if !ok {
+		return wrongTypeReply
+	}
+	//If key does not exist, it is created as empty list
+	if l == nil {
+		l = newList()
+		s.setKey(key, RedisValue{value: l})
+	} else {
+		s.touchKey(key)
+	}
+
+	for _, value := range args[2:] {
+		l.push(value, front)
+	}
+	s.signalReady(key)
+	return resp.NewInteger(int64(l.len()))
+}
+
+// LPOP key [count] removes and returns the first elements of the list
+func (s *Server) handleLPop(c *client, args []string) resp.Value {
+	return s.pop(args, true)
+}
+
+// RPOP key [count] removes and returns the last elements of the list
+func (s *Server) handleRPop(c *client, args []string) resp.Value {
+	return s.pop(args, false)
+}
+
+// pop returns a bulk string without count, an array with count,
+// nil when the key doesn't exist
+func (s *Server) pop(args []string, front bool) resp.Value {
+	if len(args) > 3 {
+		return resp.NewError("ERR syntax error")
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return resp.NewError("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	key := args[1]
+	l, ok := s.lookupList(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	if l == nil {
+		if len(args) == 3 {
+			return resp.NewNullArray()
+		}
+		return resp.NewNull()
+	}
+
+	var values []resp.Value
+	if count > 0 {
+		s.touchKey(key)
+	}
+	for ; count > 0 && l.len() > 0; count-- {
+		values = append(values, resp.NewBulkString(l.pop(front)))
+	}
+	if l.len() == 0 {
+		s.deleteKey(key)
+	}
+
+	if len(args) == 3 {
+		return resp.NewArray(values...)
+	}
+	return values[0]
+}
+
+// LLEN key returns the length of the list, 0 if the key doesn't exist
+func (s *Server) handleLLen(c *client, args []string) resp.Value {
+	l, ok := s.lookupList(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if l == nil {
+		return resp.NewInteger(0)
+	}
+	return resp.NewInteger(int64(l.len()))
+}
+
+// LRANGE key start stop returns the elements from start to stop included,
+// negative indexes count from the end of the list
+func (s *Server) handleLRange(c *client, args []string) resp.Value {
+	l, ok := s.lookupList(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if l == nil {
+		l = newList()
+	}
+	start, stop, ok, err := listRange(args[2], args[3], l.len())
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	if !ok {
+		return resp.NewArray()
+	}
+	return resp.NewBulkStringArray(l.rangeValues(start, stop))
+}
+
+// LINDEX key index returns the element at index, nil if it is out of range
+func (s *Server) handleLIndex(c *client, args []string) resp.Value {
+	l, ok := s.lookupList(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if l == nil {
+		l = newList()
+	}
+	i, err := listIndex(args[2], l.len())
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	if i < 0 || i >= l.len() {
+		return resp.NewNull()
+	}
+	return resp.NewBulkString(l.index(i))
+}
+
+// LSET key index element replaces the element at index
+func (s *Server) handleLSet(c *client, args []string) resp.Value {
+	key := args[1]
+	l, ok := s.lookupList(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	if l == nil {
+		return resp.NewError("ERR no such key")
+	}
+	i, err := listIndex(args[2], l.len())
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	if i < 0 || i >= l.len() {
+		return resp.NewError("ERR index out of range")
+	}
+	s.touchKey(key)
+	l.set(i, args[3])
+	return resp.OK
+}
+
+// LINSERT key BEFORE|AFTER pivot element inserts element next to the first
+// pivot, returns the length of the list, -1 if there is no pivot
+// and 0 if the key doesn't exist
+func (s *Server) handleLInsert(c *client, args []string) resp.Value {
+	key := args[1]
+	var after bool
+	switch strings.ToUpper(args[2]) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return resp.NewError("ERR syntax error")
+	}
+	l, ok := s.lookupList(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	if l == nil {
+		return resp.NewInteger(0)
+	}
+
+	values := l.values()
+	for i, value := range values {
+		if value != args[3] {
+			continue
+		}
+		if after {
+			i++
+		}
+		s.touchKey(key)
+		values = append(values[:i], append([]string{args[4]}, values[i:]...)...)
+		l.reset(values)
+		return resp.NewInteger(int64(l.len()))
+	}
+	return resp.NewInteger(-1)
+}
+
+// LREM key count element removes the first count occurrences of element,
+// the last ones if count is negative and all of them if count is 0.
+// Returns the number of elements removed.
+func (s *Server) handleLRem(c *client, args []string) resp.Value {
+	key := args[1]
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return resp.NewError("ERR " + notIntegerErr.Error())
+	}
+	l, ok := s.lookupList(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	if l == nil {
+		return resp.NewInteger(0)
+	}
+
+	values := l.values()
+	remove := make([]bool, len(values))
+	removed := 0
+	for j := range values {
+		i := j
+		if count < 0 {
+			// from the tail
+			i = len(values) - 1 - j
+		}
+		if values[i] == args[3] {
+			remove[i] = true
+			removed++
+			if removed == count || removed == -count {
+				break
+			}
+		}
+	}
+	if removed == 0 {
+		return resp.NewInteger(0)
+	}
+
+	s.touchKey(key)
+	kept := values[:0]
+	for i, value := range values {
+		if !remove[i] {
+			kept = append(kept, value)
+		}
+	}
+	l.reset(kept)
+	if l.len() == 0 {
+		s.deleteKey(key)
+	}
+	return resp.NewInteger(int64(removed))
+}
+
+// LTRIM key start stop keeps only the elements from start to stop included
+func (s *Server) handleLTrim(c *client, args []string) resp.Value {
+	key := args[1]
+	l, ok := s.lookupList(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	if l == nil {
+		l = newList()
+	}
+	start, stop, ok, err := listRange(args[2], args[3], l.len())
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	if l.len() == 0 || (ok && start == 0 && stop == l.len()-1) {
+		return resp.OK
+	}
+
+	s.touchKey(key)
+	if !ok {
+		s.deleteKey(key)
+		return resp.OK
+	}
+	for i := l.len() - 1; i > stop; i-- {
+		l.popBack()
+	}
+	for i := 0; i < start; i++ {
+		l.popFront()
+	}
+	return resp.OK
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len] returns the
+// index of the rank-th match of element, counting from the tail if rank is
+// negative, or of num-matches matches, all of them if it is 0. At most len
+// elements are compared, all of them if it is 0.
+func (s *Server) handleLPos(c *client, args []string) resp.Value {
+	rank, count, maxLen := 1, -1, 0
+	for i := 3; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return resp.NewError("ERR syntax error")
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return resp.NewError("ERR " + notIntegerErr.Error())
+		}
+		switch strings.ToUpper(args[i]) {
+		case "RANK":
+			if n == 0 {
+				return resp.NewError("ERR RANK can't be zero: use 1 to start from the first match, " +
+					"2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return resp.NewError("ERR COUNT can't be negative")
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return resp.NewError("ERR MAXLEN can't be negative")
+			}
+			maxLen = n
+		default:
+			return resp.NewError("ERR syntax error")
+		}
+	}
+
+	l, ok := s.lookupList(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if l == nil {
+		l = newList()
+	}
+
+	var matches []resp.Value
+	wanted := count
+	if wanted < 0 {
+		wanted = 1
+	}
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+	for j := 0; j < l.len() && (maxLen == 0 || j < maxLen); j++ {
+		i := j
+		if rank < 0 {
+			i = l.len() - 1 - j
+		}
+		if l.index(i) != args[2] {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		matches = append(matches, resp.NewInteger(int64(i)))
+		if wanted > 0 && len(matches) == wanted {
+			break
+		}
+	}
+
+	if count >= 0 {
+		return resp.NewArray(matches...)
+	}
+	if len(matches) == 0 {
+		return resp.NewNull()
+	}
+	return matches[0]
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT pops an element from a side
+// of source and pushes it on a side of destination, returns the element or
+// nil if source doesn't exist
+func (s *Server) handleLMove(c *client, args []string) resp.Value {
+	from, okFrom := parseListSide(args[3])
+	to, okTo := parseListSide(args[4])
+	if !okFrom || !okTo {
+		return resp.NewError("ERR syntax error")
+	}
+	return s.move(args[1], args[2], from, to)
+}
+
+// RPOPLPUSH source destination is LMOVE source destination RIGHT LEFT
+func (s *Server) handleRPopLPush(c *client, args []string) resp.Value {
+	return s.move(args[1], args[2], false, true)
+}
+
+// parseListSide returns true for LEFT and false for RIGHT
+func parseListSide(arg string) (front, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+func (s *Server) move(source, destination string, fromFront, toFront bool) resp.Value {
+	src, ok := s.lookupList(source)
+	if !ok {
+		return wrongTypeReply
+	}
+	if src == nil {
+		return resp.NewNull()
+	}
+	// the destination is checked before anything is modified
+	dst, ok := s.lookupList(destination)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	s.touchKey(source)
+	value := src.pop(fromFront)
+	if src.len() == 0 {
+		s.deleteKey(source)
+		if source == destination {
+			dst = nil
+		}
+	}
+
+	if dst == nil {
+		dst = newList()
+		s.setKey(destination, RedisValue{value: dst})
+	} else {
+		s.touchKey(destination)
+	}
+	dst.push(value, toFront)
+	s.signalReady(destination)
+	return resp.NewBulkString(value)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	l := newList()
+	var want []string
+	// the elements wrap around the ring buffer as it grows and shrinks
+	for i := 0; i < 200; i++ {
+		value := strconv.Itoa(i)
+		if i%3 == 0 {
+			l.pushFront(value)
+			want = append([]string{value}, want...)
+		} else {
+			l.pushBack(value)
+			want = append(want, value)
+		}
+	}
+	for i := 0; i < 150; i++ {
+		if i%2 == 0 {
+			if got := l.popFront(); got != want[0] {
+				t.Fatalf("popFront got %s, want %s", got, want[0])
+			}
+			want = want[1:]
+		} else {
+			if got := l.popBack(); got != want[len(want)-1] {
+				t.Fatalf("popBack got %s, want %s", got, want[len(want)-1])
+			}
+			want = want[:len(want)-1]
+		}
+	}
+	l.pushFront("front")
+	want = append([]string{"front"}, want...)
+
+	if got := l.values(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if len(l.buf) > 4*len(want) {
+		t.Errorf("got a buffer of %d for %d elements", len(l.buf), len(want))
+	}
+	for i := range want {
+		if got := l.index(i); got != want[i] {
+			t.Errorf("at %d, got %s, want %s", i, got, want[i])
+		}
+	}
+	clone := l.clone()
+	l.set(0, "changed")
+	if clone.index(0) != "front" {
+		t.Errorf("the clone shares the elements of the list")
+	}
+}
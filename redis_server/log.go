@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// log levels, from the most verbose to the least
+const (
+	logDebug = iota
+	logVerbose
+	logNotice
+	logWarning
+	logNothing
+)
+
+var logLevels = map[string]int32{
+	"debug":   logDebug,
+	"verbose": logVerbose,
+	"notice":  logNotice,
+	"warning": logWarning,
+	"nothing": logNothing,
+}
+
+// the marker of each level in the log lines, as in the Redis logs
+var logMarkers = []string{".", "-", "*", "#"}
+
+var logger = log.New(os.Stdout, "", log.LstdFlags|log.Lmicroseconds)
+
+func (s *Server) setLogLevel(name string) {
+	atomic.StoreInt32(&s.logLevel, logLevels[name])
+}
+
+// logf logs a message if level is at least the configured log level
+func (s *Server) logf(level int32, format string, args ...any) {
+	if level < atomic.LoadInt32(&s.logLevel) {
+		return
+	}
+	logger.Printf("%s %s", logMarkers[level], fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,947 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The snapshots use the Redis RDB file format: a header with the format
+// version, then the keys of each database as a type byte, the key and the
+// value, and finally a CRC64 checksum of the file.
+// The format is documented in https://rdb.fnordig.de/file_format.html
+
+// rdbVersion is the version of the files written, RDB 9 files are read by Redis 5.0 and later.
+// Files up to the version written by Redis 7.4 are read.
+const (
+	rdbVersion        = 9
+	rdbMaxReadVersion = 12
+)
+
+// opcodes of the sections of a file
+const (
+	rdbOpSlotInfo     = 244
+	rdbOpFunction2    = 245
+	rdbOpFunction     = 246
+	rdbOpModuleAux    = 247
+	rdbOpIdle         = 248
+	rdbOpFreq         = 249
+	rdbOpAux          = 250
+	rdbOpResizeDB     = 251
+	rdbOpExpireTimeMs = 252
+	rdbOpExpireTime   = 253
+	rdbOpSelectDB     = 254
+	rdbOpEOF          = 255
+)
+
+// object types
+const (
+	rdbTypeString = 0
+	rdbTypeList   = 1
+	rdbTypeSet    = 2
+	rdbTypeZset   = 3
+	rdbTypeHash   = 4
+	// sorted set with binary scores
+	rdbTypeZset2         = 5
+	rdbTypeHashZiplist   = 13
+	rdbTypeHashListpack  = 16
+	rdbTypeListZiplist   = 10
+	rdbTypeSetIntset     = 11
+	rdbTypeZsetZiplist   = 12
+	rdbTypeListQuicklist = 14
+	rdbTypeZsetListpack  = 17
+	rdbTypeSetListpack   = 20
+	// quicklist of listpacks, see rdbQuicklistPlain
+	rdbTypeListQuicklist2 = 18
+)
+
+// a node of a quicklist2 is either a single element or a listpack of elements
+const (
+	rdbQuicklistPlain  = 1
+	rdbQuicklistPacked = 2
+)
+
+// the special encodings of a string, in the low bits of its length byte
+const (
+	rdbEncInt8 = iota
+	rdbEncInt16
+	rdbEncInt32
+	rdbEncLZF
+)
+
+// maximum length of a string read from a file: 512MB
+const rdbMaxStringLen = 512 * 1024 * 1024
+
+// Redis checksums the files with the Jones CRC64 variant, reflected like
+// hash/crc64 but without its initial and final inversion
+var crc64Table = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func crc64Jones(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crc64Table, p)
+}
+
+// writeRDB writes the dataset to w, dicts are the dictionaries of the
+// databases by number
+func writeRDB(w io.Writer, dicts []map[string]RedisValue) error {
+	e := newRDBEncoder(w)
+	e.writeHeader()
+	for db, dict := range dicts {
+		// the empty databases are left out
+		if len(dict) == 0 {
+			continue
+		}
+		expires := 0
+		for _, val := range dict {
+			if !val.exp.IsZero() {
+				expires++
+			}
+		}
+		e.writeSelectDB(db, len(dict), expires)
+		for key, val := range dict {
+			if err := e.writeEntry(key, val); err != nil {
+				return err
+			}
+		}
+	}
+	return e.writeEnd()
+}
+
+// rdbEncoder writes the values of a file and computes its checksum.
+// The write errors are reported by writeEnd.
+type rdbEncoder struct {
+	w   *bufio.Writer
+	crc uint64
+}
+
+func newRDBEncoder(w io.Writer) *rdbEncoder {
+	return &rdbEncoder{w: bufio.NewWriter(w)}
+}
+
+func (e *rdbEncoder) writeHeader() {
+	e.write([]byte(fmt.Sprintf("REDIS%04d", rdbVersion)))
+	e.writeAux("redis-ver", redisVersion)
+	e.writeAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	e.writeAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	e.writeAux("used-mem", strconv.FormatInt(usedMemory(), 10))
+}
+
+// writeSelectDB starts the keys of the database db,
+// its number of keys and of keys with an expiration are hints for the reader
+func (e *rdbEncoder) writeSelectDB(db, size, expires int) {
+	e.writeByte(rdbOpSelectDB)
+	e.writeLength(uint64(db))
+	e.writeByte(rdbOpResizeDB)
+	e.writeLength(uint64(size))
+	e.writeLength(uint64(expires))
+}
+
+// writeEntry writes a key, its value and its expiration
+func (e *rdbEncoder) writeEntry(key string, val RedisValue) error {
+	if !val.exp.IsZero() {
+		e.writeByte(rdbOpExpireTimeMs)
+		e.writeUint64(uint64(val.exp.UnixMilli()))
+	}
+	return e.writeObject(key, val.value)
+}
+
+// writeEnd writes the end of the file and its checksum, and flushes the encoder
+func (e *rdbEncoder) writeEnd() error {
+	e.writeByte(rdbOpEOF)
+	e.writeUint64(e.crc)
+	return e.w.Flush()
+}
+
+func (e *rdbEncoder) write(p []byte) {
+	e.crc = crc64Jones(e.crc, p)
+	e.w.Write(p)
+}
+
+func (e *rdbEncoder) writeByte(b byte) {
+	e.write([]byte{b})
+}
+
+func (e *rdbEncoder) writeUint64(n uint64) {
+	e.write(binary.LittleEndian.AppendUint64(nil, n))
+}
+
+// writeLength writes n on 1, 2, 5 or 9 bytes, the first two bits of the
+// first byte tell how many
+func (e *rdbEncoder) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.writeByte(byte(n))
+	case n < 1<<14:
+		e.write([]byte{0x40 | byte(n>>8), byte(n)})
+	case n <= math.MaxUint32:
+		e.write(binary.BigEndian.AppendUint32([]byte{0x80}, uint32(n)))
+	default:
+		e.write(binary.BigEndian.AppendUint64([]byte{0x81}, n))
+	}
+}
+
+// writeString writes a length-prefixed string, the strings holding a 32 bits
+// integer are written as integers the way Redis does
+func (e *rdbEncoder) writeString(s string) {
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		e.writeLength(uint64(len(s)))
+		e.write([]byte(s))
+		return
+	}
+	switch {
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		e.write([]byte{0xc0 | rdbEncInt8, byte(n)})
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		e.write(binary.LittleEndian.AppendUint16([]byte{0xc0 | rdbEncInt16}, uint16(n)))
+	default:
+		e.write(binary.LittleEndian.AppendUint32([]byte{0xc0 | rdbEncInt32}, uint32(n)))
+	}
+}
+
+func (e *rdbEncoder) writeAux(key, value string) {
+	e.writeByte(rdbOpAux)
+	e.writeString(key)
+	e.writeString(value)
+}
+
+func (e *rdbEncoder) writeObject(key string, value any) error {
+	switch v := value.(type) {
+	case string:
+		e.writeByte(rdbTypeString)
+		e.writeString(key)
+		e.writeString(v)
+	case *list:
+		e.writeByte(rdbTypeList)
+		e.writeString(key)
+		e.writeLength(uint64(v.len()))
+		for i := 0; i < v.len(); i++ {
+			e.writeString(v.index(i))
+		}
+	case *hash:
+		e.writeByte(rdbTypeHash)
+		e.writeString(key)
+		e.writeLength(uint64(v.len()))
+		for field, value := range v.dict {
+			e.writeString(field)
+			e.writeString(value)
+		}
+	case *set:
+		e.writeByte(rdbTypeSet)
+		e.writeString(key)
+		e.writeLength(uint64(v.len()))
+		for member := range v.dict {
+			e.writeString(member)
+		}
+	case *zset:
+		e.writeByte(rdbTypeZset2)
+		e.writeString(key)
+		e.writeLength(uint64(v.len()))
+		for x := v.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			e.writeString(x.member)
+			e.write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(x.score)))
+		}
+	case *stream:
+		e.writeByte(rdbTypeStreamListpacks)
+		e.writeString(key)
+		e.writeStream(v)
+	default:
+		return fmt.Errorf("can't save the value of type %T of key '%s'", value, key)
+	}
+	return nil
+}
+
+// readRDB reads a file written by writeRDB or by Redis, and returns the
+// dictionaries of the databases by number. The file can't have keys in more
+// databases than the server has, the keys that are already expired are
+// skipped.
+func readRDB(r io.Reader, databases int) ([]map[string]RedisValue, error) {
+	d := &rdbDecoder{r: bufio.NewReader(r)}
+	dicts, err := d.read(databases)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("invalid RDB file: %w", err)
+	}
+	return dicts, nil
+}
+
+// rdbDecoder reads the values of a file and computes its checksum
+type rdbDecoder struct {
+	r   *bufio.Reader
+	crc uint64
+}
+
+func (d *rdbDecoder) read(databases int) ([]map[string]RedisValue, error) {
+	header, err := d.readFull(9)
+	if err != nil {
+		return nil, err
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if string(header[:5]) != "REDIS" || err != nil {
+		return nil, errors.New("wrong signature")
+	}
+	if version < 1 || version > rdbMaxReadVersion {
+		return nil, fmt.Errorf("can't handle RDB format version %d", version)
+	}
+
+	dicts := make([]map[string]RedisValue, databases)
+	for i := range dicts {
+		dicts[i] = make(map[string]RedisValue)
+	}
+	// the keys before the first SELECTDB are in the database 0
+	dict := dicts[0]
+	now := time.Now()
+	var deadline time.Time
+	for {
+		op, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case rdbOpEOF:
+			// files older than version 5 and files written with rdbchecksum no have no checksum
+			if version < 5 {
+				return dicts, nil
+			}
+			crc := d.crc
+			sum, err := d.readFull(8)
+			if err != nil {
+				return nil, err
+			}
+			if stored := binary.LittleEndian.Uint64(sum); stored != 0 && stored != crc {
+				return nil, errors.New("wrong RDB checksum")
+			}
+			return dicts, nil
+		case rdbOpSelectDB:
+			db, _, err := d.readLength()
+			if err != nil {
+				return nil, err
+			}
+			if db >= uint64(databases) {
+				return nil, fmt.Errorf("the file has keys in DB %d, the server has %d databases", db, databases)
+			}
+			dict = dicts[db]
+		case rdbOpResizeDB, rdbOpSlotInfo:
+			// the sizes are only hints
+			n := 2
+			if op == rdbOpSlotInfo {
+				n = 3
+			}
+			for i := 0; i < n; i++ {
+				if _, _, err := d.readLength(); err != nil {
+					return nil, err
+				}
+			}
+		case rdbOpAux:
+			if _, err := d.readString(); err != nil {
+				return nil, err
+			}
+			if _, err := d.readString(); err != nil {
+				return nil, err
+			}
+		case rdbOpExpireTimeMs:
+			ms, err := d.readFull(8)
+			if err != nil {
+				return nil, err
+			}
+			deadline = time.UnixMilli(int64(binary.LittleEndian.Uint64(ms)))
+		case rdbOpExpireTime:
+			sec, err := d.readFull(4)
+			if err != nil {
+				return nil, err
+			}
+			deadline = time.Unix(int64(binary.LittleEndian.Uint32(sec)), 0)
+		case rdbOpIdle:
+			if _, _, err := d.readLength(); err != nil {
+				return nil, err
+			}
+		case rdbOpFreq:
+			if _, err := d.readByte(); err != nil {
+				return nil, err
+			}
+		case rdbOpFunction2:
+			// the code of a library of functions, functions aren't supported
+			if _, err := d.readString(); err != nil {
+				return nil, err
+			}
+		case rdbOpModuleAux, rdbOpFunction:
+			return nil, fmt.Errorf("unsupported opcode %d", op)
+		default:
+			key, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.readObject(op)
+			if err != nil {
+				return nil, err
+			}
+			if deadline.IsZero() {
+				dict[key] = RedisValue{value: value}
+			} else if deadline.After(now) {
+				dict[key] = RedisValue{value: value, exp: deadline}
+			}
+			deadline = time.Time{}
+		}
+	}
+}
+
+// readObject reads a value of type typ. The number of elements comes from
+// the file, which may be corrupted, so the containers grow as the elements
+// are read instead of being allocated from it.
+func (d *rdbDecoder) readObject(typ byte) (any, error) {
+	switch typ {
+	case rdbTypeString:
+		return d.readString()
+	case rdbTypeList:
+		n, _, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		var list []string
+		for i := uint64(0); i < n; i++ {
+			elem, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elem)
+		}
+		return newList(list...), nil
+	case rdbTypeListZiplist:
+		zl, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		list, err := ziplistEntries([]byte(zl))
+		if err != nil {
+			return nil, err
+		}
+		return newList(list...), nil
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		n, _, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		var list []string
+		for i := uint64(0); i < n; i++ {
+			container := uint64(rdbQuicklistPacked)
+			if typ == rdbTypeListQuicklist2 {
+				if container, _, err = d.readLength(); err != nil {
+					return nil, err
+				}
+			}
+			node, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+
+			var elems []string
+			switch {
+			case container == rdbQuicklistPlain:
+				elems = []string{node}
+			case typ == rdbTypeListQuicklist:
+				elems, err = ziplistEntries([]byte(node))
+			default:
+				elems, err = listpackEntries([]byte(node))
+			}
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elems...)
+		}
+		return newList(list...), nil
+	case rdbTypeHash:
+		n, _, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		h := newHash()
+		for i := uint64(0); i < n; i++ {
+			field, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			h.set(field, value)
+		}
+		return h, nil
+	case rdbTypeHashZiplist, rdbTypeHashListpack:
+		encoded, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var entries []string
+		if typ == rdbTypeHashZiplist {
+			entries, err = ziplistEntries([]byte(encoded))
+		} else {
+			entries, err = listpackEntries([]byte(encoded))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(entries)%2 != 0 {
+			return nil, fmt.Errorf("odd number of entries in a hash")
+		}
+		h := newHash()
+		for i := 0; i < len(entries); i += 2 {
+			h.set(entries[i], entries[i+1])
+		}
+		return h, nil
+	case rdbTypeSet:
+		n, _, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		st := newSet()
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			st.add(member)
+		}
+		return st, nil
+	case rdbTypeSetIntset, rdbTypeSetListpack:
+		encoded, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var members []string
+		if typ == rdbTypeSetIntset {
+			members, err = intsetEntries([]byte(encoded))
+		} else {
+			members, err = listpackEntries([]byte(encoded))
+		}
+		if err != nil {
+			return nil, err
+		}
+		st := newSet()
+		for _, member := range members {
+			st.add(member)
+		}
+		return st, nil
+	case rdbTypeZset, rdbTypeZset2:
+		n, _, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		z := newZset()
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			readScore := d.readBinaryScore
+			if typ == rdbTypeZset {
+				readScore = d.readStringScore
+			}
+			score, err := readScore()
+			if err != nil {
+				return nil, err
+			}
+			z.add(member, score)
+		}
+		return z, nil
+	case rdbTypeZsetZiplist, rdbTypeZsetListpack:
+		encoded, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var entries []string
+		if typ == rdbTypeZsetZiplist {
+			entries, err = ziplistEntries([]byte(encoded))
+		} else {
+			entries, err = listpackEntries([]byte(encoded))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(entries)%2 != 0 {
+			return nil, fmt.Errorf("odd number of entries in a sorted set")
+		}
+		z := newZset()
+		for i := 0; i < len(entries); i += 2 {
+			score, err := strconv.ParseFloat(entries[i+1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid score %q", entries[i+1])
+			}
+			z.add(entries[i], score)
+		}
+		return z, nil
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return d.readStream(typ)
+	default:
+		return nil, fmt.Errorf("unsupported object type %d", typ)
+	}
+}
+
+func (d *rdbDecoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	d.crc = crc64Jones(d.crc, []byte{b})
+	return b, nil
+}
+
+func (d *rdbDecoder) readFull(n uint64) ([]byte, error) {
+	if n > rdbMaxStringLen {
+		return nil, fmt.Errorf("string length %d exceeds the limit", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return nil, err
+	}
+	d.crc = crc64Jones(d.crc, buf)
+	return buf, nil
+}
+
+// readBinaryScore returns a score stored as a little endian float64
+func (d *rdbDecoder) readBinaryScore() (float64, error) {
+	b, err := d.readFull(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+// readStringScore returns a score stored as a string after its length byte,
+// the lengths 253, 254 and 255 stand for NaN, +inf and -inf
+func (d *rdbDecoder) readStringScore() (float64, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := d.readFull(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	score, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid score %q", b)
+	}
+	return score, nil
+}
+
+// readLength returns a length. When encoded is true, the length is instead
+// the special encoding of the string that follows, one of rdbEnc...
+func (d *rdbDecoder) readLength() (n uint64, encoded bool, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := d.readByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case 2:
+		switch b {
+		case 0x80:
+			buf, err := d.readFull(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := d.readFull(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		default:
+			return 0, false, fmt.Errorf("unknown length encoding %#x", b)
+		}
+	default:
+		return uint64(b & 0x3f), true, nil
+	}
+}
+
+func (d *rdbDecoder) readString() (string, error) {
+	n, encoded, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		buf, err := d.readFull(n)
+		return string(buf), err
+	}
+
+	switch n {
+	case rdbEncInt8, rdbEncInt16, rdbEncInt32:
+		buf, err := d.readFull(1 << n)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(littleEndianInt(buf), 10), nil
+	case rdbEncLZF:
+		clen, _, err := d.readLength()
+		if err != nil {
+			return "", err
+		}
+		ulen, _, err := d.readLength()
+		if err != nil {
+			return "", err
+		}
+		if ulen > rdbMaxStringLen {
+			return "", fmt.Errorf("string length %d exceeds the limit", ulen)
+		}
+		compressed, err := d.readFull(clen)
+		if err != nil {
+			return "", err
+		}
+		buf, err := lzfDecompress(compressed, int(ulen))
+		return string(buf), err
+	default:
+		return "", fmt.Errorf("unknown string encoding %d", n)
+	}
+}
+
+// lzfDecompress decompresses the LZF data in into n bytes. The data is a
+// sequence of literal runs and back references to the bytes already output.
+func lzfDecompress(in []byte, n int) ([]byte, error) {
+	corrupted := errors.New("invalid LZF compressed string")
+	out := make([]byte, 0, n)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			// a run of ctrl+1 literal bytes
+			if i+ctrl+1 > len(in) {
+				return nil, corrupted
+			}
+			out = append(out, in[i:i+ctrl+1]...)
+			i += ctrl + 1
+			continue
+		}
+
+		// a reference of length+2 bytes starting offset+1 bytes back
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, corrupted
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, corrupted
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, corrupted
+		}
+		// the reference may overlap the bytes it outputs
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != n {
+		return nil, corrupted
+	}
+	return out, nil
+}
+
+// ziplistEntries returns the entries of a ziplist, the encoding of the small
+// lists and hashes before Redis 7.0: a header of 10 bytes, the entries and
+// an end byte. An entry is the length of the previous entry, the encoding of
+// the entry and its content.
+func ziplistEntries(zl []byte) ([]string, error) {
+	corrupted := errors.New("invalid ziplist")
+	var entries []string
+	for pos := 10; ; {
+		if pos >= len(zl) {
+			return nil, corrupted
+		}
+		if zl[pos] == 0xff {
+			return entries, nil
+		}
+		if zl[pos] == 0xfe {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(zl) {
+			return nil, corrupted
+		}
+
+		enc := zl[pos]
+		var header, size int
+		isInt := true
+		switch {
+		case enc>>6 == 0:
+			header, size, isInt = 1, int(enc&0x3f), false
+		case enc>>6 == 1:
+			if pos+1 >= len(zl) {
+				return nil, corrupted
+			}
+			header, size, isInt = 2, int(enc&0x3f)<<8|int(zl[pos+1]), false
+		case enc>>6 == 2:
+			if pos+5 > len(zl) {
+				return nil, corrupted
+			}
+			header, size, isInt = 5, int(binary.BigEndian.Uint32(zl[pos+1:])), false
+		case enc == 0xc0:
+			header, size = 1, 2
+		case enc == 0xd0:
+			header, size = 1, 4
+		case enc == 0xe0:
+			header, size = 1, 8
+		case enc == 0xf0:
+			header, size = 1, 3
+		case enc == 0xfe:
+			header, size = 1, 1
+		case enc >= 0xf1 && enc <= 0xfd:
+			// a 4 bits immediate integer between 0 and 12
+			entries = append(entries, strconv.Itoa(int(enc&0x0f)-1))
+			pos++
+			continue
+		default:
+			return nil, corrupted
+		}
+
+		pos += header
+		if size < 0 || pos+size > len(zl) {
+			return nil, corrupted
+		}
+		if isInt {
+			entries = append(entries, strconv.FormatInt(littleEndianInt(zl[pos:pos+size]), 10))
+		} else {
+			entries = append(entries, string(zl[pos:pos+size]))
+		}
+		pos += size
+	}
+}
+
+// listpackEntries returns the entries of a listpack, the encoding of the
+// small aggregates since Redis 7.0: a header of 6 bytes, the entries and an
+// end byte. An entry is its encoding, its content and its length.
+func listpackEntries(lp []byte) ([]string, error) {
+	corrupted := errors.New("invalid listpack")
+	var entries []string
+	for pos := 6; ; {
+		if pos >= len(lp) {
+			return nil, corrupted
+		}
+		enc := lp[pos]
+		if enc == 0xff {
+			return entries, nil
+		}
+
+		var header, size int
+		isInt := true
+		switch {
+		case enc&0x80 == 0:
+			// a 7 bits unsigned integer
+			entries = append(entries, strconv.Itoa(int(enc)))
+			header = 1
+		case enc&0xc0 == 0x80:
+			header, size, isInt = 1, int(enc&0x3f), false
+		case enc&0xe0 == 0xc0:
+			// a 13 bits signed integer
+			if pos+1 >= len(lp) {
+				return nil, corrupted
+			}
+			n := int(enc&0x1f)<<8 | int(lp[pos+1])
+			if n >= 1<<12 {
+				n -= 1 << 13
+			}
+			entries = append(entries, strconv.Itoa(n))
+			header = 2
+		case enc&0xf0 == 0xe0:
+			if pos+1 >= len(lp) {
+				return nil, corrupted
+			}
+			header, size, isInt = 2, int(enc&0x0f)<<8|int(lp[pos+1]), false
+		case enc == 0xf0:
+			if pos+5 > len(lp) {
+				return nil, corrupted
+			}
+			header, size, isInt = 5, int(binary.LittleEndian.Uint32(lp[pos+1:])), false
+		case enc >= 0xf1 && enc <= 0xf4:
+			header, size = 1, []int{2, 3, 4, 8}[enc-0xf1]
+		default:
+			return nil, corrupted
+		}
+
+		if size < 0 || pos+header+size > len(lp) {
+			return nil, corrupted
+		}
+		if size > 0 || !isInt {
+			content := lp[pos+header : pos+header+size]
+			if isInt {
+				entries = append(entries, strconv.FormatInt(littleEndianInt(content), 10))
+			} else {
+				entries = append(entries, string(content))
+			}
+		}
+		pos += header + size + listpackBacklenSize(header+size)
+	}
+}
+
+// listpackBacklenSize returns the number of bytes of the length of an entry of n bytes
+func listpackBacklenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// littleEndianInt decodes a signed little-endian integer of 1 to 8 bytes
+func littleEndianInt(b []byte) int64 {
+	var n uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	// sign extension
+	shift := 64 - 8*len(b)
+	return int64(n<<shift) >> shift
+}
+
+// intsetEntries returns the members of an intset, the encoding of the small
+// sets of integers: the width of the integers, their number and the sorted
+// little endian integers.
+func intsetEntries(is []byte) ([]string, error) {
+	corrupted := errors.New("invalid intset")
+	if len(is) < 8 {
+		return nil, corrupted
+	}
+	width := int(binary.LittleEndian.Uint32(is))
+	n := int(binary.LittleEndian.Uint32(is[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(is)-8 != n*width {
+		return nil, corrupted
+	}
+	entries := make([]string, 0, n)
+	for pos := 8; pos < len(is); pos += width {
+		entries = append(entries, strconv.FormatInt(littleEndianInt(is[pos:pos+width]), 10))
+	}
+	return entries, nil
+}
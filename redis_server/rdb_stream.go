@@ -0,0 +1,457 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The streams are saved as Redis does since RDB 9: the entries are split in
+// nodes of at most streamNodeMaxEntries entries, each a listpack. A node
+// starts with a master entry, the number of entries, the number of deleted
+// entries and the fields of the first entry, followed by the entries:
+//
+//	flags ms-diff seq-diff [num-fields field ...] value ... lp-count
+//
+// The ID of an entry is relative to the ID of the node, and its fields are
+// omitted with the flag streamItemSameFields if they are the master fields.
+// The consumer groups follow the nodes.
+
+// stream object types: the second adds the first ID, the maximum deleted ID
+// and the number of entries added to the stream, and the read counters of
+// the groups; the third the active times of the consumers
+const (
+	rdbTypeStreamListpacks  = 15
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeStreamListpacks3 = 21
+)
+
+const streamNodeMaxEntries = 100
+
+// flags of the entries of a node
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+func (e *rdbEncoder) writeStream(st *stream) {
+	nodes := (len(st.entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	e.writeLength(uint64(nodes))
+	for i := 0; i < len(st.entries); i += streamNodeMaxEntries {
+		node := st.entries[i:]
+		if len(node) > streamNodeMaxEntries {
+			node = node[:streamNodeMaxEntries]
+		}
+		e.writeString(string(streamIDBytes(node[0].id)))
+		e.writeString(string(streamNodeListpack(node)))
+	}
+	e.writeLength(uint64(len(st.entries)))
+	e.writeLength(st.lastID.ms)
+	e.writeLength(st.lastID.seq)
+
+	e.writeLength(uint64(len(st.groups)))
+	names := make([]string, 0, len(st.groups))
+	for name := range st.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g := st.groups[name]
+		e.writeString(name)
+		e.writeLength(g.lastID.ms)
+		e.writeLength(g.lastID.seq)
+
+		e.writeLength(uint64(len(g.pending)))
+		for _, id := range sortedIDs(g.pending) {
+			pe := g.pending[id]
+			e.write(streamIDBytes(id))
+			e.writeUint64(uint64(pe.deliveryTime.UnixMilli()))
+			e.writeLength(pe.deliveries)
+		}
+
+		e.writeLength(uint64(len(g.consumers)))
+		for _, cons := range g.consumers {
+			e.writeString(cons.name)
+			e.writeUint64(uint64(cons.seenTime.UnixMilli()))
+			e.writeLength(uint64(len(cons.pending)))
+			for _, id := range sortedIDs(cons.pending) {
+				e.write(streamIDBytes(id))
+			}
+		}
+	}
+}
+
+// streamIDBytes returns the 128 bits big endian encoding of id
+func streamIDBytes(id streamID) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, id.ms), id.seq)
+}
+
+// streamNodeListpack returns the listpack of a node of entries
+func streamNodeListpack(entries []streamEntry) []byte {
+	master := entries[0]
+	var masterFields []string
+	for i := 0; i < len(master.fields); i += 2 {
+		masterFields = append(masterFields, master.fields[i])
+	}
+
+	lp := newListpackWriter()
+	lp.appendInt(int64(len(entries)))
+	lp.appendInt(0)
+	lp.appendInt(int64(len(masterFields)))
+	for _, field := range masterFields {
+		lp.appendString(field)
+	}
+	lp.appendInt(0)
+
+	for _, entry := range entries {
+		sameFields := len(entry.fields) == 2*len(masterFields)
+		for i := 0; sameFields && i < len(masterFields); i++ {
+			sameFields = entry.fields[2*i] == masterFields[i]
+		}
+		n := len(entry.fields) / 2
+		if sameFields {
+			lp.appendInt(streamItemSameFields)
+		} else {
+			lp.appendInt(0)
+		}
+		// the differences wrap around like the unsigned IDs
+		lp.appendInt(int64(entry.id.ms - master.id.ms))
+		lp.appendInt(int64(entry.id.seq - master.id.seq))
+		if sameFields {
+			for i := 1; i < len(entry.fields); i += 2 {
+				lp.appendString(entry.fields[i])
+			}
+			lp.appendInt(int64(n + 3))
+		} else {
+			lp.appendInt(int64(n))
+			for _, s := range entry.fields {
+				lp.appendString(s)
+			}
+			lp.appendInt(int64(2*n + 4))
+		}
+	}
+	return lp.bytes()
+}
+
+func (d *rdbDecoder) readStream(typ byte) (*stream, error) {
+	st := newStream()
+	nodes, _, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, errors.New("invalid stream node key")
+		}
+		lp, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		elems, err := listpackEntries([]byte(lp))
+		if err != nil {
+			return nil, err
+		}
+		entries, err := streamNodeEntries(readStreamID([]byte(key)), elems)
+		if err != nil {
+			return nil, err
+		}
+		st.entries = append(st.entries, entries...)
+	}
+
+	// the number of entries
+	if _, _, err := d.readLength(); err != nil {
+		return nil, err
+	}
+	if st.lastID, err = d.readLengthID(); err != nil {
+		return nil, err
+	}
+	if typ >= rdbTypeStreamListpacks2 {
+		// the first ID, the maximum deleted ID and the number of entries added
+		for i := 0; i < 5; i++ {
+			if _, _, err := d.readLength(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	groups, _, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		name, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		lastID, err := d.readLengthID()
+		if err != nil {
+			return nil, err
+		}
+		if typ >= rdbTypeStreamListpacks2 {
+			// the number of entries read
+			if _, _, err := d.readLength(); err != nil {
+				return nil, err
+			}
+		}
+		g := newConsumerGroup(lastID)
+		if err := d.readStreamPending(g); err != nil {
+			return nil, err
+		}
+		if err := d.readStreamConsumers(g, typ); err != nil {
+			return nil, err
+		}
+		st.groups[name] = g
+	}
+	return st, nil
+}
+
+func (d *rdbDecoder) readStreamPending(g *consumerGroup) error {
+	n, _, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		b, err := d.readFull(16 + 8)
+		if err != nil {
+			return err
+		}
+		deliveries, _, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		g.pending[readStreamID(b)] = &pendingEntry{
+			deliveryTime: time.UnixMilli(int64(binary.LittleEndian.Uint64(b[16:]))),
+			deliveries:   deliveries,
+		}
+	}
+	return nil
+}
+
+func (d *rdbDecoder) readStreamConsumers(g *consumerGroup, typ byte) error {
+	n, _, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		name, err := d.readString()
+		if err != nil {
+			return err
+		}
+		times := uint64(8)
+		if typ >= rdbTypeStreamListpacks3 {
+			// the active time follows the seen time
+			times = 16
+		}
+		b, err := d.readFull(times)
+		if err != nil {
+			return err
+		}
+		cons := newConsumer(name, time.UnixMilli(int64(binary.LittleEndian.Uint64(b))))
+		g.consumers[name] = cons
+
+		pending, _, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < pending; j++ {
+			b, err := d.readFull(16)
+			if err != nil {
+				return err
+			}
+			id := readStreamID(b)
+			pe, ok := g.pending[id]
+			if !ok || pe.consumer != nil {
+				return errors.New("invalid stream consumer pending entry")
+			}
+			g.assign(id, pe, cons)
+		}
+	}
+	for _, pe := range g.pending {
+		if pe.consumer == nil {
+			return errors.New("stream pending entry without consumer")
+		}
+	}
+	return nil
+}
+
+// readLengthID reads an ID stored as two lengths
+func (d *rdbDecoder) readLengthID() (streamID, error) {
+	ms, _, err := d.readLength()
+	if err != nil {
+		return streamID{}, err
+	}
+	seq, _, err := d.readLength()
+	return streamID{ms, seq}, err
+}
+
+func readStreamID(b []byte) streamID {
+	return streamID{binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:])}
+}
+
+// streamNodeEntries returns the entries of a node from the elements of its
+// listpack, the deleted entries are skipped
+func streamNodeEntries(master streamID, elems []string) ([]streamEntry, error) {
+	corrupted := errors.New("invalid stream node")
+	pos := 0
+	nextInt := func() (int64, error) {
+		if pos >= len(elems) {
+			return 0, corrupted
+		}
+		n, err := strconv.ParseInt(elems[pos], 10, 64)
+		pos++
+		if err != nil {
+			return 0, corrupted
+		}
+		return n, nil
+	}
+	nextStrings := func(n int64) ([]string, error) {
+		if n < 0 || n > int64(len(elems)-pos) {
+			return nil, corrupted
+		}
+		s := elems[pos : pos+int(n)]
+		pos += int(n)
+		return s, nil
+	}
+
+	// count and deleted
+	for i := 0; i < 2; i++ {
+		if _, err := nextInt(); err != nil {
+			return nil, err
+		}
+	}
+	n, err := nextInt()
+	if err != nil {
+		return nil, err
+	}
+	masterFields, err := nextStrings(n)
+	if err != nil {
+		return nil, err
+	}
+	if terminator, err := nextInt(); err != nil || terminator != 0 {
+		return nil, corrupted
+	}
+
+	var entries []streamEntry
+	for pos < len(elems) {
+		var header [3]int64
+		for i := range header {
+			if header[i], err = nextInt(); err != nil {
+				return nil, err
+			}
+		}
+		flags := header[0]
+		id := streamID{master.ms + uint64(header[1]), master.seq + uint64(header[2])}
+
+		var fields []string
+		if flags&streamItemSameFields != 0 {
+			values, err := nextStrings(int64(len(masterFields)))
+			if err != nil {
+				return nil, err
+			}
+			for i, value := range values {
+				fields = append(fields, masterFields[i], value)
+			}
+		} else {
+			n, err := nextInt()
+			if err != nil || n > math.MaxInt32 {
+				return nil, corrupted
+			}
+			if fields, err = nextStrings(2 * n); err != nil {
+				return nil, err
+			}
+			fields = append([]string(nil), fields...)
+		}
+		// lp-count
+		if _, err := nextInt(); err != nil {
+			return nil, err
+		}
+		if flags&streamItemDeleted == 0 {
+			entries = append(entries, streamEntry{id: id, fields: fields})
+		}
+	}
+	return entries, nil
+}
+
+// listpackWriter encodes a listpack, see listpackEntries
+type listpackWriter struct {
+	buf []byte
+	n   int
+}
+
+func newListpackWriter() *listpackWriter {
+	// the header is written by bytes
+	return &listpackWriter{buf: make([]byte, 6)}
+}
+
+// appendString appends s, as an integer if it is one
+func (lp *listpackWriter) appendString(s string) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+		lp.appendInt(n)
+		return
+	}
+	start := len(lp.buf)
+	switch n := len(s); {
+	case n < 1<<6:
+		lp.buf = append(lp.buf, 0x80|byte(n))
+	case n < 1<<12:
+		lp.buf = append(lp.buf, 0xe0|byte(n>>8), byte(n))
+	default:
+		lp.buf = binary.LittleEndian.AppendUint32(append(lp.buf, 0xf0), uint32(n))
+	}
+	lp.buf = append(lp.buf, s...)
+	lp.appendBacklen(len(lp.buf) - start)
+}
+
+func (lp *listpackWriter) appendInt(n int64) {
+	start := len(lp.buf)
+	switch {
+	case n >= 0 && n <= 127:
+		lp.buf = append(lp.buf, byte(n))
+	case n >= -4096 && n <= 4095:
+		u := uint16(n) & 0x1fff
+		lp.buf = append(lp.buf, 0xc0|byte(u>>8), byte(u))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		lp.buf = binary.LittleEndian.AppendUint16(append(lp.buf, 0xf1), uint16(n))
+	case n >= -1<<23 && n < 1<<23:
+		lp.buf = append(lp.buf, 0xf2, byte(n), byte(n>>8), byte(n>>16))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		lp.buf = binary.LittleEndian.AppendUint32(append(lp.buf, 0xf3), uint32(n))
+	default:
+		lp.buf = binary.LittleEndian.AppendUint64(append(lp.buf, 0xf4), uint64(n))
+	}
+	lp.appendBacklen(len(lp.buf) - start)
+}
+
+// appendBacklen appends the length of the entry, written backwards by
+// groups of 7 bits with the high bit set on all but the first byte
+func (lp *listpackWriter) appendBacklen(n int) {
+	size := listpackBacklenSize(n)
+	for i := size - 1; i >= 0; i-- {
+		b := byte(n>>(7*i)) & 0x7f
+		if i < size-1 {
+			b |= 0x80
+		}
+		lp.buf = append(lp.buf, b)
+	}
+	lp.n++
+}
+
+// bytes ends the listpack and returns it
+func (lp *listpackWriter) bytes() []byte {
+	lp.buf = append(lp.buf, 0xff)
+	binary.LittleEndian.PutUint32(lp.buf, uint32(len(lp.buf)))
+	n := lp.n
+	if n > math.MaxUint16 {
+		// unknown, the reader counts them
+		n = math.MaxUint16
+	}
+	binary.LittleEndian.PutUint16(lp.buf[4:], uint16(n))
+	return lp.buf
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCRC64Jones(t *testing.T) {
+	// check value of the Redis implementation
+	if got, want := crc64Jones(0, []byte("123456789")), uint64(0xe9c6d914c4b8d9ca); got != want {
+		t.Errorf("got %#x, want %#x", got, want)
+	}
+}
+
+func TestRDB_RoundTrip(t *testing.T) {
+	now := time.Now()
+	dict := map[string]RedisValue{
+		"string":  {value: "hello world"},
+		"empty":   {value: ""},
+		"special": {value: "a:b\nc\r\n"},
+		"int8":    {value: "-12"},
+		"int16":   {value: "1000"},
+		"int32":   {value: "-100000"},
+		"int64":   {value: "12345678901"},
+		"notint":  {value: "007"},
+		"long":    {value: strings.Repeat("x", 70000)},
+		"list":    {value: newList("a", "1", "", "b:c")},
+		"hash":    {value: &hash{dict: map[string]string{"field": "value", "n": "12", "": ""}}},
+		"set":     {value: &set{dict: map[string]struct{}{"a": {}, "1": {}, "": {}}}},
+		"zset":    {value: testZset(map[string]float64{"a": 1.5, "b": -2, "c": math.Inf(1), "": 0})},
+		"stream":  {value: testStream()},
+		"expires": {value: "soon", exp: now.Add(time.Hour).Truncate(time.Millisecond)},
+		"expired": {value: "gone", exp: now.Add(-time.Hour)},
+	}
+
+	// the database 1 is empty
+	other := map[string]RedisValue{"string": {value: "in db 2"}}
+	var buf bytes.Buffer
+	if err := writeRDB(&buf, []map[string]RedisValue{dict, {}, other}); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String()[:9]; got != "REDIS0009" {
+		t.Errorf("got header %q", got)
+	}
+	dicts, err := readRDB(&buf, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dicts) != 4 || len(dicts[1]) != 0 || len(dicts[3]) != 0 || !reflect.DeepEqual(dicts[2], other) {
+		t.Errorf("got the databases %v", dicts[1:])
+	}
+	got := dicts[0]
+
+	delete(dict, "expired")
+	if len(got) != len(dict) {
+		t.Errorf("got %d keys, want %d", len(got), len(dict))
+	}
+	for key, want := range dict {
+		if !reflect.DeepEqual(comparableValue(got[key].value), comparableValue(want.value)) {
+			t.Errorf("for key %q, got %q, want %q", key, got[key].value, want.value)
+		}
+	}
+	if deadline, want := got["expires"].exp, dict["expires"].exp; !deadline.Equal(want) {
+		t.Errorf("got deadline %v, want %v", deadline, want)
+	}
+}
+
+func testZset(scores map[string]float64) *zset {
+	z := newZset()
+	for member, score := range scores {
+		z.add(member, score)
+	}
+	return z
+}
+
+// testStream returns a stream of several nodes with a consumer group
+func testStream() *stream {
+	st := newStream()
+	for i := uint64(0); i < 250; i++ {
+		fields := []string{"n", strconv.FormatUint(i, 10), "name", "x"}
+		if i%7 == 0 {
+			fields = []string{"other", strings.Repeat("y", int(i)), "", "-5000"}
+		}
+		// the sequence numbers decrease when the timestamps increase
+		st.entries = append(st.entries, streamEntry{id: streamID{1000 + i/3, 2 - i%3}, fields: fields})
+	}
+	st.lastID = streamID{2000, 5}
+
+	delivered := time.UnixMilli(1700000000000)
+	g := newConsumerGroup(streamID{1010, 0})
+	alice := newConsumer("alice", delivered)
+	g.consumers["alice"] = alice
+	g.consumers["bob"] = newConsumer("bob", delivered.Add(time.Second))
+	for _, id := range []streamID{{1000, 2}, {1005, 1}} {
+		g.pending[id] = &pendingEntry{deliveryTime: delivered, deliveries: 3}
+		g.assign(id, g.pending[id], alice)
+	}
+	st.groups["group"] = g
+	st.groups["empty"] = newConsumerGroup(streamID{})
+	return st
+}
+
+// comparableValue returns the scores of a sorted set, since the levels of
+// its skiplist are random
+func comparableValue(value any) any {
+	if z, ok := value.(*zset); ok {
+		return z.dict
+	}
+	return value
+}
+
+// the ziplist [hello, -300, 1193046] with an int16 and an int24
+var testZiplist = []byte{
+	0x1c, 0, 0, 0, 0x17, 0, 0, 0, 3, 0,
+	0, 0x05, 'h', 'e', 'l', 'l', 'o',
+	7, 0xc0, 0xd4, 0xfe,
+	4, 0xf0, 0x56, 0x34, 0x12,
+	0xff,
+}
+
+// the listpack [100, ab, -2, 1000] with a 7 bits, a 13 bits and an int16 integer
+var testListpack = []byte{
+	0x14, 0, 0, 0, 4, 0,
+	0x64, 1,
+	0x82, 'a', 'b', 3,
+	0xdf, 0xfe, 2,
+	0xf1, 0xe8, 0x03, 3,
+	0xff,
+}
+
+func TestListpackWriter(t *testing.T) {
+	values := []string{"0", "127", "128", "-1", "-4096", "4095", "-32768", "32767", "8388607",
+		"-8388608", "2147483647", "-2147483648", "9223372036854775807", "007", "", "abc",
+		strings.Repeat("x", 63), strings.Repeat("y", 64), strings.Repeat("z", 5000)}
+	lp := newListpackWriter()
+	for _, value := range values {
+		lp.appendString(value)
+	}
+	got, err := listpackEntries(lp.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("got %q, want %q", got, values)
+	}
+}
+
+func TestReadRDB_Encodings(t *testing.T) {
+	future := binary.LittleEndian.AppendUint64(nil, uint64(time.Now().Add(time.Hour).UnixMilli()))
+	file := rdbFile(
+		[]byte{rdbOpAux, 9}, []byte("redis-ver"), []byte{5}, []byte("7.2.4"),
+		[]byte{rdbOpSelectDB, 0, rdbOpResizeDB, 9, 1},
+		// ziplist from the Redis documentation: [2, 5] with immediate integers
+		[]byte{rdbTypeListZiplist, 2}, []byte("zl"),
+		[]byte{15, 0x0f, 0, 0, 0, 0x0c, 0, 0, 0, 2, 0, 0, 0xf3, 2, 0xf6, 0xff},
+		[]byte{rdbTypeListQuicklist, 2}, []byte("ql"), []byte{1, byte(len(testZiplist))}, testZiplist,
+		[]byte{rdbTypeListQuicklist2, 3}, []byte("ql2"),
+		[]byte{2, rdbQuicklistPlain, 3}, []byte("big"),
+		[]byte{rdbQuicklistPacked, byte(len(testListpack))}, testListpack,
+		// intset [-2, 1000] of int16
+		[]byte{rdbTypeSetIntset, 2}, []byte("is"), []byte{12, 2, 0, 0, 0, 2, 0, 0, 0, 0xfe, 0xff, 0xe8, 0x03},
+		[]byte{rdbTypeSetListpack, 3}, []byte("lps"), []byte{byte(len(testListpack))}, testListpack,
+		[]byte{rdbTypeZset, 2}, []byte("zs"), []byte{2, 1, 'a', 3}, []byte("1.5"), []byte{1, 'b', 255},
+		// "aaaaaaaaaa": a literal and a back reference overlapping the output
+		[]byte{rdbTypeString, 3}, []byte("lzf"), []byte{0xc0 | rdbEncLZF, 5, 10, 0x00, 'a', 0xe0, 0x00, 0x00},
+		[]byte{rdbOpExpireTime, 1, 0, 0, 0, rdbTypeString, 3}, []byte("old"), []byte{1, 'x'},
+		[]byte{rdbOpExpireTimeMs}, future, []byte{rdbOpIdle, 5, rdbTypeString, 6}, []byte("future"), []byte{0xc1, 0xd2, 0x04},
+	)
+
+	dicts, err := readRDB(bytes.NewReader(file), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := dicts[0]
+	want := map[string]any{
+		"zs":     map[string]float64{"a": 1.5, "b": math.Inf(-1)},
+		"zl":     newList("2", "5"),
+		"ql":     newList("hello", "-300", "1193046"),
+		"ql2":    newList("big", "100", "ab", "-2", "1000"),
+		"is":     &set{dict: map[string]struct{}{"-2": {}, "1000": {}}},
+		"lps":    &set{dict: map[string]struct{}{"100": {}, "ab": {}, "-2": {}, "1000": {}}},
+		"lzf":    "aaaaaaaaaa",
+		"future": "1234",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d keys, want %d", len(got), len(want))
+	}
+	for key, value := range want {
+		if !reflect.DeepEqual(comparableValue(got[key].value), value) {
+			t.Errorf("for key %q, got %q, want %q", key, got[key].value, value)
+		}
+	}
+	if exp := got["future"].exp; exp.IsZero() || got["future"].expired(time.Now()) {
+		t.Errorf("got expiration %+v", got["future"].exp)
+	}
+}
+
+func TestReadRDB_Errors(t *testing.T) {
+	var valid bytes.Buffer
+	writeRDB(&valid, []map[string]RedisValue{{"key": {value: "value"}}})
+	corrupted := bytes.Clone(valid.Bytes())
+	corrupted[len(corrupted)-12] ^= 1
+	truncated := valid.Bytes()[:valid.Len()-4]
+
+	tests := []struct {
+		name string
+		file []byte
+		want string
+	}{
+		{"signature", []byte("RADIS0009\xff"), "invalid RDB file: wrong signature"},
+		{"version", []byte("REDIS0099\xff"), "invalid RDB file: can't handle RDB format version 99"},
+		{"checksum", corrupted, "invalid RDB file: wrong RDB checksum"},
+		{"truncated", truncated, "invalid RDB file: unexpected EOF"},
+		{"type", rdbFile([]byte{7, 1, 'k'}), "invalid RDB file: unsupported object type 7"},
+		{"ziplist", rdbFile([]byte{rdbTypeListZiplist, 1, 'k', 3, 0, 0, 0}), "invalid RDB file: invalid ziplist"},
+		{"intset", rdbFile([]byte{rdbTypeSetIntset, 1, 'k', 8, 2, 0, 0, 0, 1, 0, 0, 0}), "invalid RDB file: invalid intset"},
+		{"database", rdbFile([]byte{rdbOpSelectDB, 16}), "invalid RDB file: the file has keys in DB 16, the server has 16 databases"},
+		// the lengths aren't trusted to allocate anything before the elements are read
+		{"hash length", hugeLength(rdbTypeHash), "invalid RDB file: unexpected EOF"},
+		{"set length", hugeLength(rdbTypeSet), "invalid RDB file: unexpected EOF"},
+		{"list length", hugeLength(rdbTypeList), "invalid RDB file: unexpected EOF"},
+		{"zset length", hugeLength(rdbTypeZset2), "invalid RDB file: unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		_, err := readRDB(bytes.NewReader(tt.file), 16)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("for %s, got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// hugeLength returns a file with a key of type typ of 2^64-1 elements,
+// cut after its first element
+func hugeLength(typ byte) []byte {
+	file := append([]byte("REDIS0011"), typ, 1, 'k', 0x81)
+	file = append(file, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	return append(file, 1, 'a')
+}
+
+// rdbFile returns a file made of the sections without a checksum
+func rdbFile(sections ...[]byte) []byte {
+	file := []byte("REDIS0011")
+	for _, section := range sections {
+		file = append(file, section...)
+	}
+	file = append(file, rdbOpEOF)
+	return append(file, make([]byte, 8)...)
+}
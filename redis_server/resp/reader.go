@@ -0,0 +1,347 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maximum number of bytes of a bulk string: 512MB
+const maxBulkLen = 512 * 1024 * 1024
+
+// maximum number of elements of an aggregate type, as the multibulk limit of Redis
+const maxAggregateLen = 1024 * 1024
+
+// maximum number of bytes of a line, i.e. a header or a simple type, as the
+// inline limit of Redis: 64KB
+const maxLineLen = 64 * 1024
+
+// maximum number of values nested in aggregate types or attributes, the
+// decoding is recursive and must not overflow the stack
+const maxNestingDepth = 128
+
+var IncompleteErr = errors.New("incomplete value, need more data")
+var ArrayLenDecodeErr = errors.New("error decoding array length")
+var ArrayLenExceededErr = errors.New("error the array length cannot be larger than 1048576")
+var LineTooLongErr = errors.New("Protocol error: line longer than 65536 bytes")
+var NestingDepthExceededErr = errors.New("Protocol error: values nested deeper than 128 levels")
+var BooleanDecodeErr = errors.New("error decoding boolean")
+var BigNumberDecodeErr = errors.New("error decoding big number")
+var VerbatimDecodeErr = errors.New("error decoding verbatim string format")
+
+// Error is an error reply sent by the peer. It is returned as a value by
+// Reader.Read so that a connection stays usable after an error reply.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Reader decodes RESP values one at a time from a stream.
+// Bulk strings are read as binary-safe byte counts, so they may contain CRLF.
+type Reader struct {
+	rd    *bufio.Reader
+	depth int // the number of values being decoded, see readValue
+}
+
+func NewReader(r io.Reader) *Reader {
+	rd, ok := r.(*bufio.Reader)
+	if !ok {
+		rd = bufio.NewReader(r)
+	}
+	return &Reader{rd: rd}
+}
+
+// Buffered returns the number of bytes already read from the stream that
+// have not been decoded yet, e.g. pipelined commands.
+func (r *Reader) Buffered() int {
+	return r.rd.Buffered()
+}
+
+// Peek waits for the next n bytes of the stream and returns them without
+// consuming them, e.g. to notice that the peer closed the connection.
+func (r *Reader) Peek(n int) ([]byte, error) {
+	return r.rd.Peek(n)
+}
+
+// ReadValue decodes the next value of the stream into a typed Value.
+// RESP3 Attributes are attached to the value that follows them, in Attrs.
+// io.EOF is returned when the stream ends between two values,
+// IncompleteErr when it ends in the middle of one.
+func (r *Reader) ReadValue() (Value, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+	value, err := r.readValue(line)
+	if err == io.EOF {
+		return Value{}, IncompleteErr
+	}
+	return value, err
+}
+
+// Read decodes the next value of the stream into a Go value:
+//   - Simple Strings and Bulk Strings are returned as string
+//   - Integers are returned as int
+//   - Errors are returned as Error
+//   - Arrays are returned as []any
+//   - null Bulk Strings and null Arrays are returned as nil
+//
+// and the RESP3 types:
+//   - Null is returned as nil
+//   - Booleans are returned as bool
+//   - Doubles are returned as float64
+//   - Big Numbers are returned as *big.Int
+//   - Bulk Errors are returned as Error
+//   - Verbatim Strings are returned as Verbatim
+//   - Maps, Sets and Pushes are returned as Map, Set and Push
+//   - Attributes are returned as Attribute, wrapping the value that follows
+func (r *Reader) Read() (any, error) {
+	value, err := r.ReadValue()
+	if err != nil {
+		return nil, err
+	}
+	return value.Native(), nil
+}
+
+// ReadCommand reads the next command of the stream. A command is a RESP Array
+// consisting of only Bulk Strings, any other value is a ProtocolErr. As in
+// Redis, only the array header and the bulk strings are decoded, the other
+// types are refused from their first byte.
+func (r *Reader) ReadCommand() ([]string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || string(line[0]) != Arrays || string(line[1:]) == "-1" {
+		return nil, ProtocolErr
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 {
+		return nil, ArrayLenDecodeErr
+	}
+	if n > maxAggregateLen {
+		return nil, ArrayLenExceededErr
+	}
+
+	args := []string{}
+	for i := 0; i < n; i++ {
+		line, err := r.readLine()
+		if err == nil && (len(line) == 0 || string(line[0]) != BulkStrings) {
+			return nil, ProtocolErr
+		}
+		var arg Value
+		if err == nil {
+			arg, err = r.readBulkString(line[1:])
+		}
+		if err == io.EOF {
+			return nil, IncompleteErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		if arg.Type == Null {
+			return nil, ProtocolErr
+		}
+		args = append(args, arg.Str)
+	}
+	return args, nil
+}
+
+func (r *Reader) readValue(line []byte) (Value, error) {
+	if len(line) == 0 {
+		return Value{}, TokenErr
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+	if r.depth > maxNestingDepth {
+		return Value{}, NestingDepthExceededErr
+	}
+	dataType, data := string(line[0]), line[1:]
+	switch dataType {
+	case SimpleString:
+		return NewSimpleString(string(data)), nil
+	case Errors:
+		return NewError(string(data)), nil
+	case Integers:
+		n, err := strconv.ParseInt(string(data), 10, 64)
+		return NewInteger(n), err
+	case BulkStrings:
+		return r.readBulkString(data)
+	case Arrays:
+		// null array case
+		if string(data) == "-1" {
+			return NewNullArray(), nil
+		}
+		arr, err := r.readAggregate(data, 1)
+		return NewArray(arr...), err
+	case Null:
+		return NewNull(), nil
+	case Booleans:
+		return readBoolean(data)
+	case Doubles:
+		return readDouble(data)
+	case BigNumbers:
+		n, ok := new(big.Int).SetString(string(data), 10)
+		if !ok {
+			return Value{}, BigNumberDecodeErr
+		}
+		return NewBigNumber(n), nil
+	case BulkErrors:
+		msg, err := r.readBulkString(data)
+		return NewError(msg.Str), err
+	case VerbatimString:
+		return r.readVerbatim(data)
+	case Maps:
+		// a map of n pairs is sent as 2n values
+		m, err := r.readAggregate(data, 2)
+		return NewMap(m...), err
+	case Sets:
+		set, err := r.readAggregate(data, 1)
+		return NewSet(set...), err
+	case Pushes:
+		push, err := r.readAggregate(data, 1)
+		return NewPush(push...), err
+	case Attributes:
+		attrs, err := r.readAggregate(data, 2)
+		if err != nil {
+			return Value{}, err
+		}
+		line, err := r.readLine()
+		if err != nil {
+			return Value{}, err
+		}
+		value, err := r.readValue(line)
+		value.Attrs = attrs
+		return value, err
+	default:
+		return Value{}, errors.New("unknown data type symbol: " + dataType)
+	}
+}
+
+func (r *Reader) readBulkString(data []byte) (Value, error) {
+	bytesLen, err := strconv.Atoi(string(data))
+	if err != nil || bytesLen < -1 {
+		return Value{}, BytesLenDecodeErr
+	}
+	// null bulk string case
+	if bytesLen == -1 {
+		return NewNull(), nil
+	}
+	// cannot be larger than 512MB
+	if bytesLen > maxBulkLen {
+		return Value{}, BytesLenExceededErr
+	}
+
+	// the string is followed by its own CRLF
+	buf := make([]byte, bytesLen+2)
+	if _, err = io.ReadFull(r.rd, buf); err != nil {
+		return Value{}, io.EOF
+	}
+	if string(buf[bytesLen:]) != CRLF {
+		return Value{}, TermErr
+	}
+	return NewBulkString(string(buf[:bytesLen])), nil
+}
+
+// readAggregate reads the elements of an aggregate type of data elements,
+// each element being made of width values
+func (r *Reader) readAggregate(data []byte, width int) ([]Value, error) {
+	size, err := strconv.Atoi(string(data))
+	if err != nil || size < 0 {
+		return nil, ArrayLenDecodeErr
+	}
+	// checked before the multiplication, which could overflow
+	if size > maxAggregateLen {
+		return nil, ArrayLenExceededErr
+	}
+	size *= width
+
+	// the slice grows as the elements arrive, the length alone doesn't
+	// allocate anything
+	var arr []Value
+	for i := 0; i < size; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.readValue(line)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, value)
+	}
+	return arr, nil
+}
+
+func (r *Reader) readVerbatim(data []byte) (Value, error) {
+	bulk, err := r.readBulkString(data)
+	if err != nil || bulk.Type == Null {
+		return bulk, err
+	}
+	// the text is prefixed with a three bytes format and a colon: txt:text
+	format, text, found := strings.Cut(bulk.Str, ":")
+	if !found || len(format) != 3 {
+		return Value{}, VerbatimDecodeErr
+	}
+	return NewVerbatim(format, text), nil
+}
+
+func readBoolean(data []byte) (Value, error) {
+	switch string(data) {
+	case "t":
+		return NewBool(true), nil
+	case "f":
+		return NewBool(false), nil
+	default:
+		return Value{}, BooleanDecodeErr
+	}
+}
+
+func readDouble(data []byte) (Value, error) {
+	switch string(data) {
+	case "inf":
+		return NewDouble(math.Inf(1)), nil
+	case "-inf":
+		return NewDouble(math.Inf(-1)), nil
+	case "nan":
+		return NewDouble(math.NaN()), nil
+	default:
+		f, err := strconv.ParseFloat(string(data), 64)
+		return NewDouble(f), err
+	}
+}
+
+// readLine reads up to the next CRLF and returns the line without it
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.rd.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// the line is longer than the buffer, keep what was read so far
+		long := append([]byte(nil), line...)
+		for err == bufio.ErrBufferFull {
+			if len(long) > maxLineLen {
+				return nil, LineTooLongErr
+			}
+			line, err = r.rd.ReadSlice('\n')
+			long = append(long, line...)
+		}
+		if len(long) > maxLineLen+len(CRLF) {
+			return nil, LineTooLongErr
+		}
+		line = long
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			return nil, IncompleteErr
+		}
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, TermErr
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,159 @@
+package resp_test
+
+import (
+	"ccwc/redis_server/resp"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReader_Read(t *testing.T) {
+	stream := "+OK\r\n-ERR boom\r\n:42\r\n$5\r\na\r\nbc\r\n$-1\r\n*-1\r\n*2\r\n*1\r\n:1\r\n$0\r\n\r\n"
+	want := []any{"OK", resp.Error("ERR boom"), 42, "a\r\nbc", nil, nil, []any{[]any{1}, ""}}
+
+	// read one byte at a time to make sure values spanning reads are decoded
+	r := resp.NewReader(iotest.OneByteReader(strings.NewReader(stream)))
+	for _, w := range want {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("got %q, want %q", got, w)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("got %v, want %v", err, io.EOF)
+	}
+}
+
+func TestReader_Incomplete(t *testing.T) {
+	cases := []string{"+OK", "$5\r\nab", "*2\r\n:1\r\n", "*2\r\n$3\r\nGET\r\n$1\r"}
+
+	for _, c := range cases {
+		_, err := resp.NewReader(strings.NewReader(c)).Read()
+		if err != resp.IncompleteErr {
+			t.Errorf("for %q, got %v, want %v", c, err, resp.IncompleteErr)
+		}
+	}
+}
+
+func TestReader_AggregateLen(t *testing.T) {
+	tests := []struct {
+		input string
+		want  error
+	}{
+		{"*4611686018427387904\r\n", resp.ArrayLenExceededErr},
+		{"*9223372036854775807\r\n", resp.ArrayLenExceededErr},
+		{"*99999999999999999999\r\n", resp.ArrayLenDecodeErr},
+		{"%4611686018427387904\r\n", resp.ArrayLenExceededErr},
+		{"*1048577\r\n", resp.ArrayLenExceededErr},
+		// the elements are read before anything is allocated for them
+		{"*1048576\r\n:1\r\n", resp.IncompleteErr},
+	}
+
+	for _, tt := range tests {
+		if _, err := resp.NewReader(strings.NewReader(tt.input)).Read(); err != tt.want {
+			t.Errorf("for %q, got %v, want %v", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestReader_NestingDepth(t *testing.T) {
+	tests := []struct {
+		input string
+		want  error
+	}{
+		{strings.Repeat("*1\r\n", 1000000), resp.NestingDepthExceededErr},
+		{strings.Repeat("|0\r\n", 1000000), resp.NestingDepthExceededErr},
+		{strings.Repeat("*1\r\n", 127) + ":1\r\n", nil},
+	}
+
+	for _, tt := range tests {
+		if _, err := resp.NewReader(strings.NewReader(tt.input)).Read(); err != tt.want {
+			t.Errorf("for %.20q, got %v, want %v", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestReader_LineLen(t *testing.T) {
+	tests := []struct {
+		input string
+		want  error
+	}{
+		// the line is refused before its end arrives
+		{"+" + strings.Repeat("x", 10*maxLine), resp.LineTooLongErr},
+		{"*" + strings.Repeat("1", maxLine) + "\r\n", resp.LineTooLongErr},
+		{"+" + strings.Repeat("x", maxLine-1) + "\r\n", nil},
+	}
+
+	for _, tt := range tests {
+		if _, err := resp.NewReader(strings.NewReader(tt.input)).Read(); err != tt.want {
+			t.Errorf("for %.20q, got %v, want %v", tt.input, err, tt.want)
+		}
+	}
+}
+
+// maxLine is the maximum length of a line, see resp.LineTooLongErr
+const maxLine = 64 * 1024
+
+func TestReader_ReadCommand(t *testing.T) {
+	pipeline := "*2\r\n$3\r\nGET\r\n$1\r\na\r\n*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$4\r\nx\r\ny\r\n:1\r\n"
+	r := resp.NewReader(strings.NewReader(pipeline))
+
+	want := [][]string{{"GET", "a"}, {"SET", "b", "x\r\ny"}}
+	for _, w := range want {
+		got, err := r.ReadCommand()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("got %q, want %q", got, w)
+		}
+	}
+	if _, err := r.ReadCommand(); err != resp.ProtocolErr {
+		t.Errorf("got %v, want %v", err, resp.ProtocolErr)
+	}
+
+	// the other types are refused before they are decoded
+	tests := []struct {
+		input string
+		want  error
+	}{
+		{strings.Repeat("*1\r\n", 1000000), resp.ProtocolErr},
+		{"*2\r\n$3\r\nGET\r\n:1\r\n", resp.ProtocolErr},
+		{"*1\r\n$-1\r\n", resp.ProtocolErr},
+		{"*-1\r\n", resp.ProtocolErr},
+		{"*x\r\n", resp.ArrayLenDecodeErr},
+		{"*1048577\r\n", resp.ArrayLenExceededErr},
+		{"*2\r\n$3\r\nGET\r\n", resp.IncompleteErr},
+		{"*1\r\n$3\r\nGE", resp.IncompleteErr},
+	}
+	for _, tt := range tests {
+		if _, err := resp.NewReader(strings.NewReader(tt.input)).ReadCommand(); err != tt.want {
+			t.Errorf("for %.20q, got %v, want %v", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestReader_ReadValue(t *testing.T) {
+	stream := "+OK\r\n$2\r\nOK\r\n|1\r\n+ttl\r\n:1\r\n*1\r\n,0.5\r\n"
+	want := []resp.Value{
+		resp.NewSimpleString("OK"),
+		resp.NewBulkString("OK"),
+		{Type: resp.Arrays, Elems: []resp.Value{resp.NewDouble(0.5)}, Attrs: []resp.Value{resp.NewSimpleString("ttl"), resp.NewInteger(1)}},
+	}
+
+	r := resp.NewReader(strings.NewReader(stream))
+	for _, w := range want {
+		got, err := r.ReadValue()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("got %#v, want %#v", got, w)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package resp
+
+// Map is a RESP3 map, its keys and values are stored one after another
+// so the order of the pairs is kept: key1, value1, key2, value2...
+type Map []any
+
+// Set is a RESP3 set
+type Set []any
+
+// Push is a RESP3 out of band push message
+type Push []any
+
+// Verbatim is a RESP3 verbatim string, Format is a three bytes type such as txt or mkd
+type Verbatim struct {
+	Format string
+	Text   string
+}
+
+// Attribute is RESP3 auxiliary data sent before a reply
+type Attribute struct {
+	Attrs Map
+	Value any
+}
@@ -0,0 +1,49 @@
+package resp_test
+
+import (
+	"ccwc/redis_server/resp"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReader_ReadResp3(t *testing.T) {
+	cases := []struct {
+		Description string
+		Want        any
+		Command     string
+	}{
+		{"null is decoded", nil, "_\r\n"},
+		{"boolean true is decoded", true, "#t\r\n"},
+		{"boolean false is decoded", false, "#f\r\n"},
+		{"double is decoded", 1.5, ",1.5\r\n"},
+		{"double exponent is decoded", 1e10, ",1e10\r\n"},
+		{"double infinity is decoded", math.Inf(-1), ",-inf\r\n"},
+		{"big number is decoded", bigInt("3492890328409238509324850943850943825024385"), "(3492890328409238509324850943850943825024385\r\n"},
+		{"bulk error is decoded", resp.Error("SYNTAX invalid\r\nsyntax"), "!22\r\nSYNTAX invalid\r\nsyntax\r\n"},
+		{"verbatim string is decoded", resp.Verbatim{Format: "txt", Text: "Some string"}, "=15\r\ntxt:Some string\r\n"},
+		{"map is decoded", resp.Map{"first", 1, "second", 2}, "%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n"},
+		{"set is decoded", resp.Set{"orange", "apple", true}, "~3\r\n+orange\r\n$5\r\napple\r\n#t\r\n"},
+		{"push is decoded", resp.Push{"message", "news", "hello"}, ">3\r\n+message\r\n+news\r\n$5\r\nhello\r\n"},
+		{"attribute is decoded with its value", resp.Attribute{Attrs: resp.Map{"ttl", 3600}, Value: []any{2}}, "|1\r\n+ttl\r\n:3600\r\n*1\r\n:2\r\n"},
+		{"nested aggregates are decoded", []any{resp.Map{"a", resp.Set{}}, nil}, "*2\r\n%1\r\n+a\r\n~0\r\n_\r\n"},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Description, func(t *testing.T) {
+			got, err := resp.NewReader(strings.NewReader(test.Command)).Read()
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			} else if !reflect.DeepEqual(got, test.Want) {
+				t.Errorf("got %#v, want %#v", got, test.Want)
+			}
+		})
+	}
+}
+
+func bigInt(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 10)
+	return n
+}
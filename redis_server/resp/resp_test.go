@@ -25,6 +25,8 @@ func TestDecode(t *testing.T) {
 		{"null bulk string returns nil", nil, resp.NullBulkString},
 		{"array of int 1, 2, 3 is decoded", []any{1, 2, 3}, "*3\r\n:1\r\n:2\r\n:3\r\n"},
 		{"array of any values is decoded", []any{1, 2, 3, 4, "hello"}, "*5\r\n:1\r\n:2\r\n:3\r\n:4\r\n$5\r\nhello\r\n"},
+		{"bulk string containing a CRLF is decoded", "a\r\nb", "$4\r\na\r\nb\r\n"},
+		{"nested arrays are decoded", []any{[]any{1, "a\r\n"}, []any{}, "b"}, "*3\r\n*2\r\n:1\r\n$3\r\na\r\n\r\n*0\r\n$1\r\nb\r\n"},
 	}
 
 	for _, test := range cases {
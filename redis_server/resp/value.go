@@ -0,0 +1,300 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Value is a typed RESP value. Type is one of the data type symbols
+// (SimpleString, Errors, Integers, BulkStrings, Arrays, Maps...) and the
+// other fields hold its content depending on the type.
+// Values are built with the New... functions and encoded with Encode.
+type Value struct {
+	Type  string
+	Str   string  // Simple Strings, Errors, Bulk Strings, Big Numbers and Verbatim Strings
+	Int   int64   // Integers and Booleans
+	Float float64 // Doubles
+	// Arrays, Sets and Pushes elements; Maps keys and values one after another
+	Elems []Value
+	// Format of a Verbatim String, e.g. txt
+	Format string
+	// IsNull marks a null Array
+	IsNull bool
+	// Attrs are the RESP3 attributes sent before the value, keys and values one after another
+	Attrs []Value
+}
+
+var OK = NewSimpleString("OK")
+
+func NewSimpleString(s string) Value {
+	return Value{Type: SimpleString, Str: s}
+}
+
+func NewError(msg string) Value {
+	return Value{Type: Errors, Str: msg}
+}
+
+func NewInteger(n int64) Value {
+	return Value{Type: Integers, Int: n}
+}
+
+func NewBulkString(s string) Value {
+	return Value{Type: BulkStrings, Str: s}
+}
+
+// NewNull returns a missing value, a null Bulk String under RESP2
+func NewNull() Value {
+	return Value{Type: Null}
+}
+
+// NewNullArray returns a missing aggregate, a null Array under RESP2
+func NewNullArray() Value {
+	return Value{Type: Arrays, IsNull: true}
+}
+
+func NewArray(elems ...Value) Value {
+	return Value{Type: Arrays, Elems: elems}
+}
+
+// NewBulkStringArray returns an Array of the Bulk Strings elems
+func NewBulkStringArray(elems []string) Value {
+	arr := make([]Value, len(elems))
+	for i, elem := range elems {
+		arr[i] = NewBulkString(elem)
+	}
+	return NewArray(arr...)
+}
+
+// NewMap returns a Map of the keys and values stored one after another in pairs
+func NewMap(pairs ...Value) Value {
+	return Value{Type: Maps, Elems: pairs}
+}
+
+func NewSet(elems ...Value) Value {
+	return Value{Type: Sets, Elems: elems}
+}
+
+func NewPush(elems ...Value) Value {
+	return Value{Type: Pushes, Elems: elems}
+}
+
+func NewBool(b bool) Value {
+	if b {
+		return Value{Type: Booleans, Int: 1}
+	}
+	return Value{Type: Booleans}
+}
+
+func NewDouble(f float64) Value {
+	return Value{Type: Doubles, Float: f}
+}
+
+func NewBigNumber(n *big.Int) Value {
+	return Value{Type: BigNumbers, Str: n.String()}
+}
+
+func NewVerbatim(format, text string) Value {
+	return Value{Type: VerbatimString, Format: format, Str: text}
+}
+
+// Native converts v to the Go values returned by Reader.Read
+func (v Value) Native() any {
+	if v.Attrs != nil {
+		attrs := Value{Type: Maps, Elems: v.Attrs}.Native().(Map)
+		v.Attrs = nil
+		return Attribute{Attrs: attrs, Value: v.Native()}
+	}
+	if v.IsNull {
+		return nil
+	}
+
+	switch v.Type {
+	case SimpleString, BulkStrings:
+		return v.Str
+	case Errors:
+		return Error(v.Str)
+	case Integers:
+		return int(v.Int)
+	case Booleans:
+		return v.Int != 0
+	case Doubles:
+		return v.Float
+	case BigNumbers:
+		n, _ := new(big.Int).SetString(v.Str, 10)
+		return n
+	case VerbatimString:
+		return Verbatim{Format: v.Format, Text: v.Str}
+	case Arrays, Maps, Sets, Pushes:
+		elems := make([]any, len(v.Elems))
+		for i, elem := range v.Elems {
+			elems[i] = elem.Native()
+		}
+		switch v.Type {
+		case Maps:
+			return Map(elems)
+		case Sets:
+			return Set(elems)
+		case Pushes:
+			return Push(elems)
+		}
+		return elems
+	default: // Null
+		return nil
+	}
+}
+
+// IsError reports whether v is an error reply
+func (v Value) IsError() bool {
+	return v.Type == Errors
+}
+
+// Encode writes v to w with the RESP2 protocol,
+// a Writer encodes values for the protocol version negotiated by a client.
+func (v Value) Encode(w io.Writer) error {
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriter(w)
+	}
+	v.encode(bw, RESP2)
+	return bw.Flush()
+}
+
+// encode writes v with the protocol proto. Under RESP2 the RESP3 types are
+// sent as their closest RESP2 equivalent, the way Redis does it.
+func (v Value) encode(w *bufio.Writer, proto int) {
+	if proto == RESP3 && v.Attrs != nil {
+		writeHeader(w, Attributes, len(v.Attrs)/2)
+		for _, attr := range v.Attrs {
+			attr.encode(w, proto)
+		}
+	}
+	if v.IsNull || v.Type == Null {
+		switch {
+		case proto == RESP3:
+			w.WriteString(NullValue)
+		case v.Type == Arrays:
+			w.WriteString(NullArray)
+		default:
+			w.WriteString(NullBulkString)
+		}
+		return
+	}
+
+	switch v.Type {
+	case SimpleString, Errors:
+		// simple strings and errors can't contain a CR or a LF
+		w.WriteString(v.Type)
+		w.WriteString(lineBreaks.Replace(v.Str))
+		w.WriteString(CRLF)
+	case Integers:
+		w.WriteString(Integers)
+		w.WriteString(strconv.FormatInt(v.Int, 10))
+		w.WriteString(CRLF)
+	case BulkStrings:
+		writeBulkString(w, v.Str)
+	case Booleans:
+		switch {
+		case proto != RESP3:
+			NewInteger(v.Int).encode(w, proto)
+		case v.Int != 0:
+			w.WriteString(Booleans + "t" + CRLF)
+		default:
+			w.WriteString(Booleans + "f" + CRLF)
+		}
+	case Doubles:
+		if proto != RESP3 {
+			writeBulkString(w, formatDouble(v.Float))
+			return
+		}
+		w.WriteString(Doubles)
+		w.WriteString(formatDouble(v.Float))
+		w.WriteString(CRLF)
+	case BigNumbers:
+		if proto != RESP3 {
+			writeBulkString(w, v.Str)
+			return
+		}
+		w.WriteString(BigNumbers)
+		w.WriteString(v.Str)
+		w.WriteString(CRLF)
+	case VerbatimString:
+		if proto != RESP3 {
+			writeBulkString(w, v.Str)
+			return
+		}
+		writeHeader(w, VerbatimString, len(v.Format)+1+len(v.Str))
+		w.WriteString(v.Format)
+		w.WriteString(":")
+		w.WriteString(v.Str)
+		w.WriteString(CRLF)
+	default: // Arrays, Maps, Sets and Pushes
+		switch {
+		case proto != RESP3:
+			writeHeader(w, Arrays, len(v.Elems))
+		case v.Type == Maps:
+			writeHeader(w, Maps, len(v.Elems)/2)
+		default:
+			writeHeader(w, v.Type, len(v.Elems))
+		}
+		for _, elem := range v.Elems {
+			elem.encode(w, proto)
+		}
+	}
+}
+
+var lineBreaks = strings.NewReplacer("\r", " ", "\n", " ")
+
+func writeBulkString(w *bufio.Writer, s string) {
+	writeHeader(w, BulkStrings, len(s))
+	w.WriteString(s)
+	w.WriteString(CRLF)
+}
+
+func writeHeader(w *bufio.Writer, prefix string, n int) {
+	w.WriteString(prefix)
+	w.WriteString(strconv.Itoa(n))
+	w.WriteString(CRLF)
+}
+
+// formatDouble formats f with the shortest representation that reads back
+// as f, in plain decimal unless its exponent is below -4 or at least 17, as
+// %.17g does in Redis. Timestamp scores such as 1700000000123 stay integers.
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	// the exponent of the shortest representation, 0 for 0
+	e := strconv.FormatFloat(f, 'e', -1, 64)
+	if exp, _ := strconv.Atoi(e[strings.IndexByte(e, 'e')+1:]); exp < -4 || exp >= 17 {
+		return e
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Writer encodes replies for the protocol version negotiated by a client
+type Writer struct {
+	wr    *bufio.Writer
+	Proto int
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{wr: bufio.NewWriter(w), Proto: RESP2}
+}
+
+// WriteValue buffers the encoded value v, Flush sends it
+func (w *Writer) WriteValue(v Value) {
+	v.encode(w.wr, w.Proto)
+}
+
+func (w *Writer) Flush() error {
+	return w.wr.Flush()
+}
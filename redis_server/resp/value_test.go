@@ -0,0 +1,87 @@
+package resp_test
+
+import (
+	"bytes"
+	"ccwc/redis_server/resp"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestValue_Encode(t *testing.T) {
+	cases := []struct {
+		Description string
+		Want        string
+		Value       resp.Value
+	}{
+		{"simple string is encoded", "+OK\r\n", resp.OK},
+		{"simple string line breaks are removed", "+a b\r\n", resp.NewSimpleString("a\nb")},
+		{"error is encoded", "-ERR boom\r\n", resp.NewError("ERR boom")},
+		{"integer is encoded", ":-9223372036854775808\r\n", resp.NewInteger(math.MinInt64)},
+		{"bulk string is encoded", "$4\r\na\r\nb\r\n", resp.NewBulkString("a\r\nb")},
+		{"empty bulk string is encoded", "$0\r\n\r\n", resp.NewBulkString("")},
+		{"null is a null bulk string", "$-1\r\n", resp.NewNull()},
+		{"null array is encoded", "*-1\r\n", resp.NewNullArray()},
+		{"empty array is encoded", "*0\r\n", resp.NewArray()},
+		{"nested arrays are encoded", "*3\r\n:1\r\n*2\r\n$1\r\na\r\n$-1\r\n-ERR x\r\n",
+			resp.NewArray(resp.NewInteger(1), resp.NewArray(resp.NewBulkString("a"), resp.NewNull()), resp.NewError("ERR x"))},
+		{"map is a flat array", "*4\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n:2\r\n",
+			resp.NewMap(resp.NewBulkString("a"), resp.NewInteger(1), resp.NewBulkString("b"), resp.NewInteger(2))},
+		{"set is an array", "*1\r\n$1\r\na\r\n", resp.NewSet(resp.NewBulkString("a"))},
+		{"boolean is an integer", ":1\r\n", resp.NewBool(true)},
+		{"double is a bulk string", "$4\r\n1.25\r\n", resp.NewDouble(1.25)},
+		{"double under 1e17 is in decimal", "$13\r\n1700000000123\r\n", resp.NewDouble(1700000000123)},
+		{"double 1e6 is in decimal", "$7\r\n1000000\r\n", resp.NewDouble(1e6)},
+		{"double from 1e17 has an exponent", "$5\r\n1e+17\r\n", resp.NewDouble(1e17)},
+		{"small double is in decimal", "$6\r\n0.0001\r\n", resp.NewDouble(0.0001)},
+		{"double below 1e-4 has an exponent", "$7\r\n1.5e-05\r\n", resp.NewDouble(0.000015)},
+		{"big number is a bulk string", "$21\r\n123456789012345678901\r\n", resp.NewBigNumber(bigInt("123456789012345678901"))},
+		{"verbatim string is a bulk string", "$2\r\nhi\r\n", resp.NewVerbatim("txt", "hi")},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Description, func(t *testing.T) {
+			sb := strings.Builder{}
+			if err := test.Value.Encode(&sb); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if sb.String() != test.Want {
+				t.Errorf("got %q, want %q", sb.String(), test.Want)
+			}
+		})
+	}
+}
+
+func TestWriter_WriteValueResp3(t *testing.T) {
+	cases := []struct {
+		Description string
+		Want        string
+		Value       resp.Value
+	}{
+		{"null is encoded", "_\r\n", resp.NewNull()},
+		{"null array is encoded as null", "_\r\n", resp.NewNullArray()},
+		{"map is encoded", "%1\r\n$1\r\na\r\n_\r\n", resp.NewMap(resp.NewBulkString("a"), resp.NewNull())},
+		{"set is encoded", "~1\r\n:1\r\n", resp.NewSet(resp.NewInteger(1))},
+		{"push is encoded", ">1\r\n:1\r\n", resp.NewPush(resp.NewInteger(1))},
+		{"boolean is encoded", "#f\r\n", resp.NewBool(false)},
+		{"double is encoded", ",-inf\r\n", resp.NewDouble(math.Inf(-1))},
+		{"big number is encoded", "(-12\r\n", resp.NewBigNumber(big.NewInt(-12))},
+		{"verbatim string is encoded", "=6\r\ntxt:hi\r\n", resp.NewVerbatim("txt", "hi")},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Description, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			w := resp.NewWriter(&buf)
+			w.Proto = resp.RESP3
+			w.WriteValue(test.Value)
+			if err := w.Flush(); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if buf.String() != test.Want {
+				t.Errorf("got %q, want %q", buf.String(), test.Want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// The SCAN commands iterate with a cursor: the elements are ordered by the
+// hash of their name and the cursor is the hash of the next element to
+// return, 0 once the iteration is over. An element present during the whole
+// iteration is returned at least once, whatever the changes in between.
+//
+// The order is kept by a scanIndex, a skiplist whose scores are the hashes,
+// so that a call only examines about COUNT elements. It is built by the
+// first SCAN of a container, then kept up to date as elements are added and
+// removed, so that the containers never scanned don't pay for it.
+
+var invalidCursorErr = errors.New("invalid cursor")
+
+// scanOptions are the arguments of a SCAN command from the cursor
+type scanOptions struct {
+	cursor uint64
+	match  string // the glob-style pattern of the names returned, all of them if empty
+	count  int    // the number of elements examined
+}
+
+func parseScanOptions(args []string) (scanOptions, error) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return scanOptions{}, invalidCursorErr
+	}
+	opts := scanOptions{cursor: cursor, count: 10}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return scanOptions{}, syntaxErr
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			opts.match = args[i+1]
+		case "COUNT":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return scanOptions{}, notIntegerErr
+			}
+			if n < 1 {
+				return scanOptions{}, syntaxErr
+			}
+			opts.count = n
+		default:
+			return scanOptions{}, syntaxErr
+		}
+	}
+	return opts, nil
+}
+
+// scanIndex orders the names of a container by scanScore
+type scanIndex struct {
+	zsl *skiplist
+}
+
+func newScanIndex(names []string) *scanIndex {
+	idx := &scanIndex{zsl: newSkiplist()}
+	for _, name := range names {
+		idx.add(name)
+	}
+	return idx
+}
+
+// add indexes a name that isn't in the index, it does nothing on a nil index
+func (idx *scanIndex) add(name string) {
+	if idx != nil {
+		idx.zsl.insert(scanScore(name), name)
+	}
+}
+
+// remove removes a name from the index, it does nothing on a nil index
+func (idx *scanIndex) remove(name string) {
+	if idx != nil {
+		idx.zsl.delete(scanScore(name), name)
+	}
+}
+
+// scan returns the next names to examine from opts.cursor that match
+// opts.match, and the next cursor
+func (idx *scanIndex) scan(opts scanOptions) ([]string, uint64) {
+	var batch []string
+	var last float64
+	x := idx.zsl.firstInRange(scanFrom(opts.cursor))
+	// the names with the same hash are returned together
+	for i := 0; x != nil && (i < opts.count || x.score == last); i++ {
+		if opts.match == "" || stringMatch(opts.match, x.member, false) {
+			batch = append(batch, x.member)
+		}
+		last = x.score
+		x = x.level[0].forward
+	}
+	if x == nil {
+		return batch, 0
+	}
+	return batch, uint64(x.score)
+}
+
+// scanFrom is the range of the elements from a cursor
+type scanFrom uint64
+
+func (r scanFrom) aboveMin(n *skiplistNode) bool { return n.score >= float64(r) }
+func (r scanFrom) belowMax(n *skiplistNode) bool { return true }
+
+// scanScore is the position of name in a scan. The hash is truncated to the
+// 53 bits that a float64 score holds exactly.
+func scanScore(name string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return float64(h.Sum64() >> 11)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestScanIndex(t *testing.T) {
+	var names []string
+	for i := 0; i < 100; i++ {
+		names = append(names, "stable:"+strconv.Itoa(i))
+	}
+	idx := newScanIndex(names)
+
+	seen := make(map[string]bool)
+	opts := scanOptions{count: 7}
+	for i := 0; ; i++ {
+		// names come and go between the calls
+		idx.add("added:" + strconv.Itoa(i))
+		if i >= 3 {
+			idx.remove("added:" + strconv.Itoa(i-3))
+		}
+
+		var batch []string
+		batch, opts.cursor = idx.scan(opts)
+		for _, name := range batch {
+			seen[name] = true
+		}
+		if opts.cursor == 0 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("the iteration doesn't end")
+		}
+	}
+
+	for _, name := range names {
+		if !seen[name] {
+			t.Errorf("%s wasn't returned", name)
+		}
+	}
+
+	batch, cursor := newScanIndex([]string{"a:1", "b:1", "a:2"}).scan(scanOptions{count: 10, match: "a:*"})
+	if cursor != 0 || len(batch) != 2 {
+		t.Errorf("got %q and cursor %d, want the 2 names matching", batch, cursor)
+	}
+}
+
+func TestScanIndex_Keyspace(t *testing.T) {
+	s := NewServerWithConfig(DefaultConfig())
+	c := &client{db: s.dbs[0]}
+	for i := 0; i < 20; i++ {
+		s.call(c, commandTable[SET], []string{SET, "key:" + strconv.Itoa(i), "v"})
+	}
+	s.call(c, commandTable[SCAN], []string{SCAN, "0"})
+
+	// the index built by SCAN follows the keys added and deleted
+	s.call(c, commandTable[SET], []string{SET, "key:new", "v"})
+	s.call(c, commandTable[SET], []string{SET, "key:0", "overwritten"})
+	s.call(c, commandTable[DEL], []string{DEL, "key:1", "key:2"})
+	s.call(c, commandTable[RENAME], []string{RENAME, "key:3", "key:renamed"})
+
+	var want []string
+	for key := range s.db.dict {
+		want = append(want, key)
+	}
+	checkIndexed(t, s.db.scan, want)
+}
+
+func TestScanIndex_Hash(t *testing.T) {
+	s := NewServerWithConfig(DefaultConfig())
+	c := &client{db: s.dbs[0]}
+	for i := 0; i < 20; i++ {
+		s.call(c, commandTable[HSET], []string{HSET, "h", "field:" + strconv.Itoa(i), "v"})
+	}
+	s.call(c, commandTable[HSCAN], []string{HSCAN, "h", "0"})
+
+	// the index built by HSCAN follows the fields added and removed
+	s.call(c, commandTable[HSET], []string{HSET, "h", "field:new", "v", "field:0", "overwritten"})
+	s.call(c, commandTable[HSETNX], []string{HSETNX, "h", "field:nx", "v"})
+	s.call(c, commandTable[HINCRBY], []string{HINCRBY, "h", "field:n", "1"})
+	s.call(c, commandTable[HDEL], []string{HDEL, "h", "field:1", "field:2"})
+
+	h, _ := s.lookupHash("h")
+	var want []string
+	for field := range h.dict {
+		want = append(want, field)
+	}
+	checkIndexed(t, h.scan, want)
+}
+
+func TestScanIndex_Set(t *testing.T) {
+	s := NewServerWithConfig(DefaultConfig())
+	c := &client{db: s.dbs[0]}
+	for i := 0; i < 20; i++ {
+		s.call(c, commandTable[SADD], []string{SADD, "st", "member:" + strconv.Itoa(i)})
+	}
+	s.call(c, commandTable[SSCAN], []string{SSCAN, "st", "0"})
+
+	// the index built by SSCAN follows the members added and removed
+	s.call(c, commandTable[SADD], []string{SADD, "st", "member:new", "member:0"})
+	s.call(c, commandTable[SREM], []string{SREM, "st", "member:1", "member:2"})
+	s.call(c, commandTable[SPOP], []string{SPOP, "st", "3"})
+
+	st, _ := s.lookupSet("st")
+	checkIndexed(t, st.scan, st.members())
+}
+
+// checkIndexed checks that idx holds the names of want
+func checkIndexed(t *testing.T, idx *scanIndex, want []string) {
+	t.Helper()
+	var got []string
+	for x := idx.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		got = append(got, x.member)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q indexed, want %q", got, want)
+	}
+}
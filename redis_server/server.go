@@ -1,17 +1,19 @@
 package server
 
 import (
-	"bufio"
 	"ccwc/redis_server/resp"
-	"encoding/binary"
+	"context"
 	"errors"
-	"fmt"
+	"io"
+	"io/fs"
 	"net"
 	"os"
-	"reflect"
+	"path/filepath"
+	"runtime/metrics"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,17 +22,22 @@ const (
 	ConnType = "tcp"
 )
 
+// the Redis version the server is compatible with
+const redisVersion = "7.0.0"
+
 const (
-	SET    = "SET"
-	GET    = "GET"
-	EXISTS = "EXISTS"
-	DEL    = "DEL"
-	INCR   = "INCR"
-	DECR   = "DECR"
-	LPUSH  = "LPUSH"
-	RPUSH  = "RPUSH"
-	SAVE   = "SAVE"
-	LOAD   = "LOAD"
+	SET      = "SET"
+	GET      = "GET"
+	EXISTS   = "EXISTS"
+	DEL      = "DEL"
+	INCR     = "INCR"
+	DECR     = "DECR"
+	LPUSH    = "LPUSH"
+	RPUSH    = "RPUSH"
+	SAVE     = "SAVE"
+	LOAD     = "LOAD"
+	HELLO    = "HELLO"
+	SHUTDOWN = "SHUTDOWN"
 )
 
 const (
@@ -42,399 +49,699 @@ const (
 
 type RedisValue struct {
 	value any
-	exp   Expiration
+	// exp is the time at which the key expires, zero if it doesn't
+	exp time.Time
 }
 
-type Expiration struct {
-	time    string
-	option  string
-	timeout string
+type Server struct {
+	mu     sync.Mutex
+	config Config
+
+	// the logical databases by number, see db.go
+	dbs []*database
+	// db is the database of the command being executed
+	db *database
+
+	// the clients blocked by a pop, see blocking.go
+	readyKeys      []readyKey // keys pushed to with clients blocked on them
+	blockedClients int
+
+	clients      map[*client]struct{}
+	lastClientID int64
+	logLevel     int32
+
+	// persistence, see bgsave.go
+	dirty           int64        // changes since the last save
+	lastSave        time.Time    // last successful save
+	cow             *cowSnapshot // background save in progress
+	bgsaveScheduled bool
+	lastBgsaveOK    bool
+	lastBgsaveTry   time.Time     // last failed background save
+	lastBgsaveTime  time.Duration // duration of the last background save, -1 before the first one
+	rdbSaves        int64
+	expiredKeys     int64          // keys deleted because they expired
+	background      sync.WaitGroup // background saves and AOF rewrites
+
+	// append-only file, see aof.go
+	aofFile             *os.File // nil when the AOF is off
+	aofBuf              []byte   // commands not written yet
+	aofWriteErr         error    // the write commands are refused while set
+	aofSelectedDB       int      // the database of the last command logged, -1 if unknown
+	propagateMulti      bool     // MULTI is logged before the next command, see handleExec
+	aofUnsynced         bool     // commands written since the last fsync
+	aofLastFsync        time.Time
+	aofSize             int64
+	aofBaseSize         int64 // size after the last rewrite or startup
+	aofRewriteScheduled bool
+	aofLastRewriteOK    bool
+	aofLastRewriteTime  time.Duration // duration of the last rewrite, -1 before the first one
+	aofRewrites         int64
+
+	// lifecycle, see Run
+	startTime    time.Time
+	stop         context.CancelFunc // stops Run, set while it runs
+	done         chan struct{}      // closed when Run returns
+	shuttingDown bool               // commands are refused once set
+	conns        sync.WaitGroup     // connection handlers
 }
 
-type Server struct {
-	dict map[string]RedisValue
-	mu   sync.Mutex
-	port string
+// client holds the state of a connection
+type client struct {
+	conn   net.Conn
+	id     int64
+	name   string
+	db     *database // selected with SELECT
+	reader *resp.Reader
+	writer *resp.Writer // encodes replies with the protocol negotiated with HELLO
+	// closing closes the connection without replying to the last command
+	closing bool
+	// blocked is set by a command that blocks the client, see blocking.go
+	blocked *blockedClient
+	// propagate replaces the arguments of the command being executed in
+	// the AOF, e.g. with an absolute expiration instead of a relative one
+	propagate []string
+	// the transaction started by MULTI and the keys watched, see transaction.go
+	multi   *multiState
+	watched []watch
+	inExec  bool // executing the commands of a transaction
 }
 
+// NewServer returns a server listening on port with the default configuration
 func NewServer(port string) *Server {
-	return &Server{
-		port: port,
-		dict: make(map[string]RedisValue),
+	config := DefaultConfig()
+	config.Port, _ = strconv.Atoi(port)
+	return NewServerWithConfig(config)
+}
+
+func NewServerWithConfig(config Config) *Server {
+	s := &Server{
+		config:  config,
+		dbs:     make([]*database, config.Databases),
+		clients: make(map[*client]struct{}),
+
+		lastSave:       time.Now(),
+		lastBgsaveOK:   true,
+		lastBgsaveTime: -1,
+
+		aofSelectedDB:      -1,
+		aofLastRewriteOK:   true,
+		aofLastRewriteTime: -1,
+		startTime:          time.Now(),
+	}
+	for i := range s.dbs {
+		s.dbs[i] = newDatabase(i)
 	}
+	s.db = s.dbs[0]
+	s.setLogLevel(config.LogLevel)
+	return s
 }
 
-func (s *Server) Run() {
-	// Listen for incoming connections.
-	l, err := net.Listen(ConnType, ConnHost+":"+s.port)
+// Run serves connections until ctx is cancelled, Close is called or a client
+// sends SHUTDOWN. The listener is closed first, then the connections finish
+// their current command and are closed, forcibly after shutdown-timeout.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	s.mu.Lock()
+	if s.done != nil {
+		s.mu.Unlock()
+		return errors.New("server already started")
+	}
+	s.stop, s.done = stop, make(chan struct{})
+	addr := net.JoinHostPort(s.config.Bind, strconv.Itoa(s.config.Port))
+	s.mu.Unlock()
+	defer close(s.done)
+
+	// Listen for incoming connections. The address is bound before the data
+	// is loaded, so a server that can't start leaves the data files alone.
+	l, err := net.Listen(ConnType, addr)
+	if err != nil {
+		s.logf(logWarning, "Error listening: %s", err.Error())
+		return err
+	}
+	s.mu.Lock()
+	err = s.loadData()
+	s.mu.Unlock()
 	if err != nil {
-		fmt.Println("Error listening:", err.Error())
-		os.Exit(1)
+		l.Close()
+		return err
 	}
-	defer l.Close()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	go s.cron(ctx)
 
-	fmt.Println("Listening on " + ConnHost + ":" + s.port)
+	s.logf(logNotice, "Ready to accept connections on %s", addr)
 
+	var delay time.Duration
 	for {
 		// Listen for an incoming connection.
 		conn, err := l.Accept()
 		if err != nil {
-			fmt.Println("Error accepting: ", err.Error())
-			os.Exit(1)
+			if ctx.Err() != nil {
+				break
+			}
+			// e.g. too many open files: back off and try again
+			if delay *= 2; delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay > time.Second {
+				delay = time.Second
+			}
+			s.logf(logWarning, "Error accepting: %s, retrying in %s", err.Error(), delay)
+			time.Sleep(delay)
+			continue
 		}
+		delay = 0
 		// Handle connections concurrently
-		go s.handleRequest(conn)
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			s.handleConnection(conn)
+		}()
 	}
+
+	s.shutdown()
+	return nil
 }
 
-func (s *Server) Close() {
-	s.Close()
+// shutdown waits for the connections to finish their current command and
+// closes them. The connections still busy after shutdown-timeout are closed.
+func (s *Server) shutdown() {
+	s.mu.Lock()
+	if !s.shuttingDown {
+		// stopped by the context: save as SHUTDOWN does without argument
+		s.shuttingDown = true
+		if len(s.config.Save) > 0 {
+			s.abortSnapshot()
+			s.logf(logNotice, "Saving the final RDB snapshot before exiting.")
+			s.save()
+		}
+	}
+	s.abortSnapshot()
+	// wake up the connections waiting for a command
+	for c := range s.clients {
+		c.conn.SetReadDeadline(time.Now())
+	}
+	timeout := s.config.ShutdownTimeout
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		s.logf(logWarning, "Closing the connections still busy after %s", timeout)
+		s.mu.Lock()
+		for c := range s.clients {
+			c.conn.Close()
+		}
+		s.mu.Unlock()
+		<-drained
+	}
+	s.background.Wait()
+	s.mu.Lock()
+	s.closeAOF()
+	s.mu.Unlock()
+	s.logf(logWarning, "Redis is now ready to exit, bye bye...")
 }
 
-func (s *Server) handleRequest(conn net.Conn) {
-	buf := make([]byte, 1024)
-	// read the incoming connection into the buffer
-	_, err := conn.Read(buf)
-	if err != nil {
-		fmt.Println("Error reading:", err.Error())
+// cron runs the periodic tasks until ctx is done
+func (s *Server) cron(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			// retry the commands that failed to be written
+			s.flushAOF()
+			s.activeExpireCycle()
+			s.autoSave()
+			s.mu.Unlock()
+			s.aofFsync()
+		}
 	}
+}
 
-	// decode request
-	req, err := resp.Decode(buf)
-	if err != nil {
-		fmt.Println("Error decoding requests:", err.Error())
+// Close stops Run and waits for it to return, the dataset is not saved
+func (s *Server) Close() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.shuttingDown = stop != nil
+	s.mu.Unlock()
+	if stop != nil {
+		stop()
+		<-done
 	}
+}
 
-	reqArgs, err := anyToStringArray(req) // convert the request to an array of string
-	if err != nil {
-		fmt.Println("error converting request args")
+// handleConnection serves a client until it disconnects. Commands are decoded
+// one after another from a buffered stream, so a client may pipeline several
+// commands in a single write: the replies are written back in the same order
+// and flushed once every buffered command has been answered.
+func (s *Server) handleConnection(conn net.Conn) {
+	defer conn.Close() // Close the connection when the client is done
+
+	c := &client{
+		conn:   conn,
+		id:     atomic.AddInt64(&s.lastClientID, 1),
+		db:     s.dbs[0],
+		reader: resp.NewReader(conn),
+		writer: resp.NewWriter(conn),
 	}
+	if !s.addClient(c) {
+		c.writer.WriteValue(resp.NewError("ERR max number of clients reached"))
+		c.writer.Flush()
+		return
+	}
+	defer s.removeClient(c)
 
-	cmd := reqArgs[0]
-	var reply string
-	switch cmd {
-	case SET:
-		reply, err = s.handleSet(reqArgs)
+	for {
+		reqArgs, err := c.reader.ReadCommand()
 		if err != nil {
-			panic(err)
+			// a read deadline is only set to stop the connection on shutdown
+			if err != io.EOF && err != resp.IncompleteErr && !errors.Is(err, net.ErrClosed) &&
+				!errors.Is(err, os.ErrDeadlineExceeded) {
+				s.logf(logVerbose, "Error reading request: %s", err.Error())
+				// the stream can't be resynchronised after a malformed command
+				c.writer.WriteValue(resp.NewError("ERR " + err.Error()))
+				c.writer.Flush()
+			}
+			return
 		}
-	case GET:
-		reply, err = s.handleGet(reqArgs)
-		if err != nil {
-			panic(err)
+
+		reply := s.handleCommand(c, reqArgs)
+		if c.closing {
+			c.writer.Flush()
+			return
+		}
+		c.writer.WriteValue(reply)
+		if c.reader.Buffered() == 0 {
+			if err = c.writer.Flush(); err != nil {
+				s.logf(logVerbose, "Error writing responses: %s", err.Error())
+				return
+			}
 		}
-	case EXISTS:
-		reply = s.handleExists(reqArgs)
-	case DEL:
-		reply = s.handleDelete(reqArgs)
-	case INCR:
-		reply = s.handleIncrDecr(reqArgs, true)
-	case DECR:
-		reply = s.handleIncrDecr(reqArgs, false)
-	case RPUSH:
-		reply = s.handleRPush(reqArgs)
-	case LPUSH:
-		reply = s.handleLPush(reqArgs)
-	case SAVE:
-		reply = s.handleSave()
-	case LOAD:
-		reply = s.handleLoad()
-	}
-	_, err = conn.Write([]byte(reply)) // write back the response
-	if err != nil {
-		fmt.Println("error writing response: " + reply)
 	}
-	conn.Close() // Close the connection when done
 }
 
-// The SAVE commands performs a synchronous save of the dataset
-// producing a point in time snapshot of all the data inside the Redis instance,
-// in the form of an RDB file.
-func (s *Server) handleSave() string {
-	file, err := os.Create("snapshot.rdb")
+// handleCommand looks up and executes a single command, holding the
+// server lock so that commands never run concurrently. A client blocked by
+// the command waits for its reply once the lock is released.
+func (s *Server) handleCommand(c *client, reqArgs []string) resp.Value {
+	reply := s.execute(c, reqArgs)
+	if c.blocked != nil {
+		return s.waitUnblocked(c)
+	}
+	return reply
+}
+
+func (s *Server) execute(c *client, reqArgs []string) resp.Value {
+	cmd, err := lookupCommand(reqArgs)
 	if err != nil {
-		return resp.WriteRespError(err.Error())
+		c.flagTransaction()
+		return resp.NewError("ERR " + err.Error())
 	}
-	defer file.Close()
 
-	// encode and write the data
-	for k, v := range s.dict {
-		value := v.value
-		data := k + ":" + anyToString(value) + "\n"
-		err = binary.Write(file, binary.LittleEndian, []byte(data))
-		if err != nil {
-			return resp.WriteRespError("error binary encoding")
-		}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown {
+		c.closing = true
+		return resp.Value{}
 	}
-	return resp.OK
+	if cmd.flags&flagWrite != 0 && s.aofWriteErr != nil {
+		c.flagTransaction()
+		return resp.NewError("MISCONF Errors writing to the AOF file: " + s.aofWriteErr.Error())
+	}
+	if cmd.flags&flagDenyOOM != 0 && s.config.MaxMemory > 0 && usedMemory() > s.config.MaxMemory {
+		c.flagTransaction()
+		return resp.NewError("OOM command not allowed when used memory > 'maxmemory'.")
+	}
+	if cmd.flags&flagNoMulti != 0 && c.multi != nil {
+		c.flagTransaction()
+		return resp.NewError("ERR Command not allowed inside a transaction")
+	}
+	reply := s.call(c, cmd, reqArgs)
+	s.serveBlocked()
+	return reply
 }
 
-func (s *Server) handleLoad() string {
-	file, err := os.Open("snapshot.rdb")
-	if err != nil {
-		resp.WriteRespError(err.Error())
+// lookupCommand returns the command named by args[0] and checks its arity
+func lookupCommand(args []string) (command, error) {
+	if len(args) == 0 {
+		return command{}, errors.New("empty command")
 	}
-	defer file.Close()
+	cmd, ok := commandTable[strings.ToUpper(args[0])]
+	if !ok {
+		return command{}, errors.New("unknown command '" + args[0] + "'")
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		return command{}, errors.New("wrong number of arguments for '" + strings.ToLower(args[0]) + "' command")
+	}
+	return cmd, nil
+}
 
-	m := make(map[string]RedisValue)
-	reader := bufio.NewReader(file)
-	for {
-		// Read a chunk of data from the file
-		bytes, err := reader.ReadBytes('\n')
-		data := string(bytes)
-		if data == "" {
-			break
-		}
-		pair := strings.Split(data, ":")
-		key := pair[0]
-		value := pair[1]
-		m[key] = RedisValue{value: value}
-		if err != nil {
-			break // Break loop on end of file or error
+// call executes a command, the server lock must be held. The write commands
+// that modified the dataset are logged to the AOF. Inside a transaction, the
+// command is queued instead.
+func (s *Server) call(c *client, cmd command, args []string) resp.Value {
+	if c.multi != nil && cmd.flags&flagNoQueue == 0 {
+		c.multi.commands = append(c.multi.commands, queuedCommand{cmd, args})
+		return resp.NewSimpleString("QUEUED")
+	}
+	dirty, expired := s.dirty, s.expiredKeys
+	s.db = c.db
+	c.propagate = nil
+	reply := cmd.handler(s, c, args)
+	// the keys that expired meanwhile were already propagated
+	if cmd.flags&flagWrite != 0 && s.dirty-dirty > s.expiredKeys-expired {
+		if c.propagate != nil {
+			args = c.propagate
 		}
+		s.propagate(args)
 	}
-	s.dict = m
-	return resp.OK
+	return reply
 }
 
-// Returns Integer reply: the length of the list after the push operations.
-func (s *Server) handleLPush(args []string) string {
-	key := args[1]
-	redisVal, exists := s.dict[key]
-	//If key does not exist, it is created as empty list
-	if !exists {
-		redisVal.value = make([]string, 0)
+// addClient registers a new connection unless there are already maxclients
+func (s *Server) addClient(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) >= s.config.MaxClients {
+		return false
+	}
+	s.clients[c] = struct{}{}
+	if s.shuttingDown {
+		// the connection was accepted while the server was stopping
+		c.conn.SetReadDeadline(time.Now())
 	}
+	return true
+}
 
-	arr, err := anyToStringArray(redisVal.value)
-	//When key holds a value that is not a list, an error is returned.
-	if err != nil {
-		resp.WriteRespError(resp.NotAListErr.Error())
+func (s *Server) removeClient(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unwatchAll(c)
+	delete(s.clients, c)
+}
+
+// usedMemory returns the number of bytes allocated by the live objects of the heap
+func usedMemory() int64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return int64(sample[0].Value.Uint64())
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+// switches the connection to the protocol version protover and returns
+// a map of information about the server and the connection.
+func (s *Server) handleHello(c *client, args []string) resp.Value {
+	proto := c.writer.Proto
+	if len(args) > 1 {
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return resp.NewError("ERR Protocol version is not an integer or out of range")
+		}
+		if version != resp.RESP2 && version != resp.RESP3 {
+			return resp.NewError("NOPROTO unsupported protocol version")
+		}
+		proto = version
 	}
 
-	// Insert all the specified values at the head of the list stored at key.
+	name := c.name
 	for i := 2; i < len(args); i++ {
-		head := []string{args[i]}
-		arr = append(head, arr...)
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "AUTH" && i+2 < len(args):
+			// there is no password to check: only the default user exists
+			if args[i+1] != "default" {
+				return resp.NewError("WRONGPASS invalid username-password pair or user is disabled.")
+			}
+			i += 2
+		case opt == "SETNAME" && i+1 < len(args):
+			if strings.ContainsAny(args[i+1], " \n") {
+				return resp.NewError("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			name = args[i+1]
+			i++
+		default:
+			return resp.NewError("ERR Syntax error in HELLO option '" + args[i] + "'")
+		}
 	}
+	c.writer.Proto = proto
+	c.name = name
+
+	return resp.NewMap(
+		resp.NewBulkString("server"), resp.NewBulkString("redis"),
+		resp.NewBulkString("version"), resp.NewBulkString(redisVersion),
+		resp.NewBulkString("proto"), resp.NewInteger(int64(proto)),
+		resp.NewBulkString("id"), resp.NewInteger(c.id),
+		resp.NewBulkString("mode"), resp.NewBulkString("standalone"),
+		resp.NewBulkString("role"), resp.NewBulkString("master"),
+		resp.NewBulkString("modules"), resp.NewArray(),
+	)
+}
 
-	redisVal.value = arr
-	s.dict[key] = redisVal
-	return resp.WriteRespInt(len(arr))
+// The SAVE commands performs a synchronous save of the dataset
+// producing a point in time snapshot of all the data inside the Redis instance,
+// in the form of an RDB file.
+func (s *Server) handleSave(c *client, args []string) resp.Value {
+	if s.cow != nil {
+		return resp.NewError("ERR Background save already in progress")
+	}
+	if err := s.save(); err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	return resp.OK
 }
 
-// Insert all the specified values at the end of the list stored at key.
-// Returns Integer reply: the length of the list after the push operations.
-func (s *Server) handleRPush(args []string) string {
-	key := args[1]
-	redisVal, exists := s.dict[key]
-	//If key does not exist, it is created as empty list
-	if !exists {
-		redisVal.value = make([]string, 0)
+// save writes the dataset to the snapshot file in the RDB format
+func (s *Server) save() error {
+	path := s.config.snapshotPath()
+	// the previous snapshot is only replaced once the new one is complete
+	file, err := os.CreateTemp(filepath.Dir(path), "temp-*.rdb")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(file.Name())
 
-	arr, err := anyToStringArray(redisVal.value)
-	//When key holds a value that is not a list, an error is returned.
+	err = writeRDB(file, s.dicts())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
 	if err != nil {
-		resp.WriteRespError(resp.NotAListErr.Error())
+		s.logf(logWarning, "Failed saving the DB: %s", err)
+		return err
 	}
+	s.lastSave = time.Now()
+	s.dirty = 0
+	s.rdbSaves++
+	s.logf(logNotice, "DB saved on disk")
+	return nil
+}
 
-	for i := 2; i < len(args); i++ {
-		arr = append(arr, args[i])
+// SHUTDOWN [NOSAVE|SAVE] stops the server. A snapshot is written first with
+// SAVE, or when a save policy is configured unless NOSAVE is given, and the
+// server keeps running if it fails. On success the connection is closed
+// without a reply.
+func (s *Server) handleShutdown(c *client, args []string) resp.Value {
+	save, nosave := false, false
+	for _, arg := range args[1:] {
+		switch strings.ToUpper(arg) {
+		case "SAVE":
+			save = true
+		case "NOSAVE":
+			nosave = true
+		default:
+			return resp.NewError("ERR syntax error")
+		}
+	}
+	if save && nosave {
+		return resp.NewError("ERR syntax error")
 	}
 
-	redisVal.value = arr
-	s.dict[key] = redisVal
-	return resp.WriteRespInt(len(arr))
+	s.logf(logWarning, "User requested shutdown...")
+	if save || (!nosave && len(s.config.Save) > 0) {
+		s.abortSnapshot()
+		s.logf(logNotice, "Saving the final RDB snapshot before exiting.")
+		if err := s.save(); err != nil {
+			s.logf(logWarning, "Error trying to save the DB, can't exit: %s", err)
+			return resp.NewError("ERR Errors trying to SHUTDOWN. Check logs.")
+		}
+	}
+	s.shuttingDown = true
+	c.closing = true
+	if s.stop != nil {
+		s.stop()
+	}
+	return resp.Value{}
 }
 
-// Return Integer reply: the value of key after the increment or decrement
-func (s *Server) handleIncrDecr(args []string, increment bool) string {
-	key := args[1]
-	_, exists := s.dict[key]
+// LOAD replaces the dataset with the content of the snapshot file,
+// the dataset is left untouched if the file can't be read.
+func (s *Server) handleLoad(c *client, args []string) resp.Value {
+	dicts, err := s.load()
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	s.setDicts(dicts)
+	s.dirty = 0
+	if s.config.AppendOnly {
+		// the AOF holds the previous dataset: rewrite it from the new one
+		if s.cow != nil && s.cow.aof {
+			s.abortSnapshot()
+		}
+		if err := s.switchAOF(true); err != nil {
+			s.logf(logWarning, "Can't rewrite the append only file after LOAD: %s", err)
+		}
+	}
+	return resp.OK
+}
 
-	// If the key does not exist, it is set to 0 before performing the operation
-	if !exists {
-		redisValue := RedisValue{value: strconv.Itoa(0)}
-		s.dict[key] = redisValue
+// loadData loads the dataset at startup from the AOF when it is enabled,
+// from the snapshot file otherwise
+func (s *Server) loadData() error {
+	if s.config.AppendOnly {
+		return s.loadAOF()
 	}
+	return s.loadSnapshot()
+}
 
-	redisVal, _ := s.dict[key]
-	// An error is returned if the key contains a value of the wrong type or contains a string that can not be represented as integer
-	val, err := strconv.ParseInt(anyToString(redisVal.value), 10, 64)
+// loadSnapshot loads the snapshot file at startup if there is one
+func (s *Server) loadSnapshot() error {
+	dicts, err := s.load()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
-		return resp.WriteRespError(resp.IncrErr.Error())
+		return err
 	}
-	// Increment or decrements the number stored at key
-	if increment {
-		val++
-	} else {
-		val--
+	s.setDicts(dicts)
+	return nil
+}
+
+// load reads the snapshot file, it returns the dictionaries of the databases
+func (s *Server) load() ([]map[string]RedisValue, error) {
+	file, err := os.Open(s.config.snapshotPath())
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
 
-	redisVal.value = strconv.FormatInt(val, 10)
-	s.dict[key] = redisVal
-	return fmt.Sprintf("%s%d%s", resp.Integers, redisVal.value, resp.CRLF)
+	dicts, err := readRDB(file, len(s.dbs))
+	if err != nil {
+		s.logf(logWarning, "Failed loading the DB: %s", err)
+		return nil, err
+	}
+	s.logf(logNotice, "DB loaded from disk: %d keys", countKeys(dicts))
+	return dicts, nil
 }
 
 // returns the number of keys deleted as a resp integer
-func (s *Server) handleDelete(args []string) string {
+func (s *Server) handleDelete(c *client, args []string) resp.Value {
 	count := 0
 	for i := 1; i < len(args); i++ {
-		key := args[i]
-		if _, exists := s.dict[key]; exists {
-			delete(s.dict, key)
+		if _, exists := s.lookupKey(args[i]); exists && s.deleteKey(args[i]) {
 			count++
 		}
 	}
-	return resp.WriteRespInt(count)
+	return resp.NewInteger(int64(count))
 }
 
 // returns the count of existing keys as a resp integer
-func (s *Server) handleExists(args []string) string {
+func (s *Server) handleExists(c *client, args []string) resp.Value {
 	count := 0
 	for i := 1; i < len(args); i++ {
-		if _, exists := s.dict[args[i]]; exists {
+		if _, exists := s.lookupKey(args[i]); exists {
 			count++
 		}
 	}
-	return resp.WriteRespInt(count)
+	return resp.NewInteger(int64(count))
 }
 
-// returns simple string for OK
-// returns bulk string for old value
-func (s *Server) handleSet(args []string) (string, error) {
+// SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|KEEPTTL]
+// returns OK, or nil when the NX or XX condition isn't met. With GET, the
+// old value is returned instead.
+func (s *Server) handleSet(c *client, args []string) resp.Value {
 	key := args[1]
-	redisValue := RedisValue{value: args[2]}
-
-	err := setExpiration(args, &redisValue)
-	if err != nil {
-		return "", err
-	}
-
-	s.mu.Lock()
-	oldValue, ok := s.dict[key]
-	s.dict[key] = redisValue
-	s.mu.Unlock()
-	if ok {
-		sb := strings.Builder{}
-		resp.WriteBulkString(anyToString(oldValue.value), &sb)
-		return sb.String(), nil // if old value is present we return it
-	} else {
-		return resp.OK, nil
-	}
-}
-
-func setExpiration(args []string, redisValue *RedisValue) error {
-	if len(args) == 5 {
-		exp := Expiration{
-			option:  args[3],
-			timeout: args[4],
-			time:    strconv.FormatInt(time.Now().Unix(), 10),
-		}
-		if isValidExpiration(exp) {
-			redisValue.exp = exp
-		} else {
-			return errors.New("error, unexpected expiration: {opt:" + exp.option + ", timeout:" + exp.timeout + "}")
+	var nx, xx, get, keepTTL bool
+	var deadline time.Time
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "NX" && !xx:
+			nx = true
+		case opt == "XX" && !nx:
+			xx = true
+		case opt == "GET":
+			get = true
+		case opt == "KEEPTTL" && deadline.IsZero():
+			keepTTL = true
+		case (opt == EX || opt == PX || opt == EXAT || opt == PXAT) && !keepTTL && deadline.IsZero() && i+1 < len(args):
+			// a non-positive time is refused rather than deleting the key as EXPIRE does
+			if n, err := strconv.ParseInt(args[i+1], 10, 64); err == nil && n <= 0 {
+				return resp.NewError("ERR invalid expire time in 'set' command")
+			}
+			var err error
+			if deadline, err = expireDeadline(opt, args[i+1], "set", time.Now()); err != nil {
+				return resp.NewError("ERR " + err.Error())
+			}
+			i++
+		default:
+			return resp.NewError("ERR syntax error")
 		}
 	}
-	return nil
-}
 
-// bulk string reply
-func (s *Server) handleGet(args []string) (string, error) {
-	key := args[1]
-	s.mu.Lock()
-	val, ok := s.dict[key]
-	defer s.mu.Unlock()
-	if !ok {
-		return resp.NullBulkString, nil
+	old, exists := s.lookupKey(key)
+	oldValue, isString := old.value.(string)
+	if get && exists && !isString {
+		return wrongTypeReply
 	}
-	// check if expired
-	if val.exp.timeout != "" {
-		expired, err := isExpired(val)
-		if err != nil {
-			return "", err
-		}
-		if expired {
-			delete(s.dict, key)
-			return resp.NullBulkString, nil
+	reply := resp.OK
+	if get {
+		reply = resp.NewNull()
+		if exists {
+			reply = resp.NewBulkString(oldValue)
 		}
 	}
-
-	sb := strings.Builder{}
-	resp.WriteBulkString(anyToString(val.value), &sb)
-	return sb.String(), nil
-
-}
-
-// helper method to convert an any value to a string array
-func anyToStringArray(value any) ([]string, error) {
-	// Check if the value is an array or slice
-	val := reflect.ValueOf(value)
-	if val.Kind() != reflect.Array && val.Kind() != reflect.Slice {
-		return nil, fmt.Errorf("input value is not an array or slice")
-	}
-	// Convert each element of the array to a string and create a new array
-	var result []string
-	for i := 0; i < val.Len(); i++ {
-		elem := fmt.Sprint(val.Index(i).Interface())
-		result = append(result, elem)
+	if (nx && exists) || (xx && !exists) {
+		if get {
+			return reply
+		}
+		return resp.NewNull()
 	}
-	return result, nil
-}
 
-// helper method to convert an any value to a array
-func anyToString(value any) string {
-	switch v := value.(type) {
-	case string:
-		return v
-	default:
-		return ""
+	if keepTTL {
+		deadline = old.exp
 	}
-}
-
-func isValidExpiration(exp Expiration) bool {
-	// Check if the option is valid
-	switch exp.option {
-	case EX, PX, EXAT, PXAT:
-		// Option is valid, check if the timeout is an integer
-		_, err := strconv.Atoi(exp.timeout)
-		return err == nil
-	default:
-		// Invalid option
-		return false
+	s.setKey(key, RedisValue{value: args[2], exp: deadline})
+	if !deadline.IsZero() {
+		// the AOF is replayed later: the expiration must be absolute
+		c.propagate = []string{SET, key, args[2], PXAT, strconv.FormatInt(deadline.UnixMilli(), 10)}
 	}
+	return reply
 }
 
-func isExpired(val RedisValue) (bool, error) {
-	timestamp, err := strconv.ParseInt(val.exp.time, 10, 64)
-	if err != nil {
-		return false, fmt.Errorf("error parsing timestamp: %s", err)
+// bulk string reply
+func (s *Server) handleGet(c *client, args []string) resp.Value {
+	val, ok := s.lookupKey(args[1])
+	if !ok {
+		return resp.NewNull()
 	}
-
-	var expirationTime time.Time
-	switch val.exp.option {
-	case EX:
-		timeout, err := strconv.ParseInt(val.exp.timeout, 10, 64)
-		if err != nil {
-			return false, fmt.Errorf("error parsing timeout: %s", err)
-		}
-		expirationTime = time.Unix(timestamp, 0).Add(time.Duration(timeout) * time.Second)
-	case PX:
-		timeout, err := strconv.ParseInt(val.exp.timeout, 10, 64)
-		if err != nil {
-			return false, fmt.Errorf("error parsing timeout: %s", err)
-		}
-		expirationTime = time.Unix(timestamp, 0).Add(time.Duration(timeout) * time.Millisecond)
-	case EXAT:
-		expirationTime = time.Unix(timestamp, 0)
-	case PXAT:
-		expirationTime = time.Unix(0, timestamp*int64(time.Millisecond))
-	default:
-		return false, nil
+	str, ok := val.value.(string)
+	if !ok {
+		return wrongTypeReply
 	}
-
-	now := time.Now().Local()
-	return now.After(expirationTime), nil
+	return resp.NewBulkString(str)
 }
@@ -1,32 +1,58 @@
 package server_test
 
 import (
+	"bufio"
 	"ccwc/redis_server"
+	"ccwc/redis_server/client"
 	"ccwc/redis_server/resp"
+	"context"
+	"errors"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
 var testPort = ":8888"
 
+var testClient = client.New(client.Options{Addr: "localhost" + testPort})
+
 func TestServer_Set(t *testing.T) {
 	tests := []struct {
 		cmd  string
-		want string
+		want any
 	}{
 		{"SET name JOHN", "OK"},
-		{"SET name JANE", "JOHN"},
+		{"SET name JANE GET", "JOHN"},
+		{"SET name JOE NX", nil},
+		{"SET name JOE NX GET", "JANE"},
+		{"SET set:new JOE XX", nil},
+		{"SET set:new JOE XX GET", nil},
+		{"GET set:new", nil},
+		{"SET set:new JOE NX GET", nil},
+		{"GET set:new", "JOE"},
+		{"SET set:new JOE NX XX", resp.Error("ERR syntax error")},
+		{"SET set:new JOE EX 10 PX 10", resp.Error("ERR syntax error")},
+		{"SET set:new JOE EX 10 KEEPTTL", resp.Error("ERR syntax error")},
+		{"SET set:new JOE EX 0", resp.Error("ERR invalid expire time in 'set' command")},
+		{"SET set:new JOE PX ten", resp.Error("ERR value is not an integer or out of range")},
+		{"SET set:new JOE EX 9223372036854775807", resp.Error("ERR invalid expire time in 'set' command")},
+		{"RPUSH set:list a", 1},
+		{"SET set:list JOE GET", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
 	}
 
 	for _, tt := range tests {
 		got, err := send(tt.cmd)
 		if err != nil {
-			t.Errorf(err.Error())
+			got = err
 		}
-
-		if got != tt.want {
+		if !reflect.DeepEqual(got, tt.want) {
 			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
 		}
 	}
@@ -87,6 +113,65 @@ func TestServer_SetExpire(t *testing.T) {
 	}
 }
 
+func TestServer_ExpireTTL(t *testing.T) {
+	send("SET ttl:a 1")
+	send("SET ttl:b 1 EX 100")
+	send("SET ttl:gone 1 PX 1")
+	time.Sleep(2 * time.Millisecond)
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"TTL ttl:missing", -2},
+		{"TTL ttl:a", -1},
+		{"TTL ttl:b", 100},
+		{"EXISTS ttl:gone", 0},
+		{"DEL ttl:gone", 0},
+		{"EXPIRE ttl:missing 10", 0},
+		{"EXPIRE ttl:a 10 XX", 0},
+		{"EXPIRE ttl:a 10 NX", 1},
+		{"EXPIRE ttl:a 20 NX", 0},
+		{"EXPIRE ttl:a 5 GT", 0},
+		{"EXPIRE ttl:a 50 GT", 1},
+		{"TTL ttl:a", 50},
+		{"EXPIRE ttl:a 500 LT", 0},
+		{"PEXPIRE ttl:a 5000 LT", 1},
+		{"PTTL ttl:a", 5000},
+		{"EXPIRE ttl:a 10 NX XX", resp.Error("ERR NX and XX, GT or LT options at the same time are not compatible")},
+		{"EXPIRE ttl:a 10 GT LT", resp.Error("ERR GT and LT options at the same time are not compatible")},
+		{"EXPIRE ttl:a 10 SOON", resp.Error("ERR Unsupported option SOON")},
+		{"EXPIRE ttl:a ten", resp.Error("ERR value is not an integer or out of range")},
+		{"PERSIST ttl:a", 1},
+		{"PERSIST ttl:a", 0},
+		{"TTL ttl:a", -1},
+		{"EXPIREAT ttl:a 4102444800", 1},
+		{"PEXPIREAT ttl:b 4102444800000", 1},
+		{"SET ttl:a 2 KEEPTTL", "OK"},
+		{"SET ttl:b 2", "OK"},
+		{"PTTL ttl:b", -1},
+		// a deadline in the past deletes the key
+		{"EXPIREAT ttl:a 1", 1},
+		{"EXISTS ttl:a", 0},
+		{"SET ttl:c 1", "OK"},
+		{"EXPIRE ttl:c -1", 1},
+		{"GET ttl:c", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		// the time to live may have decreased since it was set
+		if n, ok := got.(int); ok && strings.HasPrefix(tt.cmd, "PTTL ") && n > 0 && tt.want.(int)-n < 100 {
+			got = tt.want
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %v, want %v", tt.cmd, got, tt.want)
+		}
+	}
+}
+
 func TestServer_Exists_Del(t *testing.T) {
 	_, err := send("SET name JOHN")
 	if err != nil {
@@ -116,6 +201,308 @@ func TestServer_Exists_Del(t *testing.T) {
 	}
 }
 
+func TestServer_Keyspace(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"SET keys:string x", "OK"},
+		{"RPUSH keys:list a b", 2},
+		{"HSET keys:hash f v", 1},
+		{"SADD keys:set a", 1},
+		{"ZADD keys:zset 1 a", 1},
+		{"XADD keys:stream 1-1 f v", "1-1"},
+		{"PFADD keys:hll a", 1},
+		{"TYPE keys:string", "string"},
+		{"TYPE keys:list", "list"},
+		{"TYPE keys:hash", "hash"},
+		{"TYPE keys:set", "set"},
+		{"TYPE keys:zset", "zset"},
+		{"TYPE keys:stream", "stream"},
+		{"TYPE keys:hll", "string"},
+		{"TYPE keys:missing", "none"},
+		{"KEYS keys:[lh]*", []any{"keys:hash", "keys:hll", "keys:list"}},
+		{"KEYS keys:?et", []any{"keys:set"}},
+		{"KEYS keys:missing*", []any{}},
+		{"SCAN 0 COUNT 0", resp.Error("ERR syntax error")},
+		{"SCAN x", resp.Error("ERR invalid cursor")},
+		{"SCAN 0 TYPE", resp.Error("ERR syntax error")},
+		{"RENAME keys:missing keys:other", resp.Error("ERR no such key")},
+		{"RENAME keys:string keys:string", "OK"},
+		{"SET keys:ttl v EX 100", "OK"},
+		{"RENAME keys:ttl keys:renamed", "OK"},
+		{"EXISTS keys:ttl", 0},
+		{"TTL keys:renamed", 100},
+		{"RENAME keys:renamed keys:list", "OK"},
+		{"GET keys:list", "v"},
+		{"RENAMENX keys:list keys:hash", 0},
+		{"RENAMENX keys:list keys:string", 0},
+		{"RENAMENX keys:list keys:moved", 1},
+		{"RENAMENX keys:moved keys:moved", 0},
+		{"GET keys:moved", "v"},
+		{"COPY keys:zset keys:zcopy", 1},
+		{"ZADD keys:zcopy 2 b", 1},
+		{"ZCARD keys:zset", 1},
+		{"COPY keys:zset keys:zcopy", 0},
+		{"COPY keys:zset keys:zcopy REPLACE", 1},
+		{"ZCARD keys:zcopy", 1},
+		{"COPY keys:missing keys:zcopy REPLACE", 0},
+		{"COPY keys:zset keys:zset", resp.Error("ERR source and destination objects are the same")},
+		{"COPY keys:zset keys:zcopy NX", resp.Error("ERR syntax error")},
+		{"TOUCH keys:zset keys:zcopy keys:missing", 2},
+		{"UNLINK keys:zset keys:zcopy keys:missing", 2},
+		{"EXISTS keys:zset keys:zcopy", 0},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		sortStrings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	if got, err := send("RANDOMKEY"); err != nil || got == nil {
+		t.Errorf("RANDOMKEY returned %q, %v", got, err)
+	}
+	if got, err := send("DBSIZE"); err != nil || got.(int) < 5 {
+		t.Errorf("DBSIZE returned %q, %v", got, err)
+	}
+}
+
+func TestServer_Scan(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		if _, err := send("SET scan:" + strconv.Itoa(i) + " x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := send("RPUSH scan:list x"); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]int)
+	cursor := "0"
+	for i := 0; ; i++ {
+		// keys are added between the calls
+		if _, err := send("SET scan:added:" + strconv.Itoa(i) + " x"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := send("SCAN " + cursor + " MATCH scan:* COUNT 5 TYPE string")
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := got.([]any)
+		for _, key := range reply[1].([]any) {
+			seen[key.(string)]++
+		}
+		if cursor = reply[0].(string); cursor == "0" {
+			break
+		}
+		if i > 1000 {
+			t.Fatal("the iteration doesn't end")
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if key := "scan:" + strconv.Itoa(i); seen[key] != 1 {
+			t.Errorf("%s was returned %d times", key, seen[key])
+		}
+	}
+	if seen["scan:list"] != 0 {
+		t.Error("SCAN TYPE string returned a list")
+	}
+}
+
+func TestServer_Databases(t *testing.T) {
+	// the commands are sent on a single connection since SELECT changes it
+	conn := dial(t)
+	defer conn.Close()
+	cn := &testConn{t: t, conn: conn, reader: resp.NewReader(conn)}
+
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"SET db:key zero", "OK"},
+		{"SELECT 1", "OK"},
+		{"GET db:key", nil},
+		{"SET db:key one", "OK"},
+		{"SELECT 16", resp.Error("ERR DB index is out of range")},
+		{"SELECT x", resp.Error("ERR invalid DB index")},
+		{"SELECT 0", "OK"},
+		{"GET db:key", "zero"},
+		{"MOVE db:key 1", 0},
+		{"MOVE db:key 0", resp.Error("ERR source and destination objects are the same")},
+		{"MOVE db:key 16", resp.Error("ERR DB index is out of range")},
+		{"SET db:moved v EX 100", "OK"},
+		{"MOVE db:moved 2", 1},
+		{"EXISTS db:moved", 0},
+		{"MOVE db:missing 2", 0},
+		{"COPY db:key db:key DB 3", 1},
+		{"COPY db:key db:key DB 16", resp.Error("ERR DB index is out of range")},
+		{"SWAPDB 2 3", "OK"},
+		{"SELECT 3", "OK"},
+		{"TTL db:moved", 100},
+		{"GET db:key", nil},
+		{"SELECT 2", "OK"},
+		{"GET db:key", "zero"},
+		{"DBSIZE", 1},
+		{"FLUSHDB", "OK"},
+		{"DBSIZE", 0},
+		{"FLUSHDB NOW", resp.Error("ERR syntax error")},
+		{"SWAPDB 2 x", resp.Error("ERR invalid second DB index")},
+		{"SWAPDB 2 16", resp.Error("ERR DB index is out of range")},
+		{"SELECT 3", "OK"},
+		{"FLUSHDB ASYNC", "OK"},
+		{"SELECT 0", "OK"},
+		{"GET db:key", "zero"},
+	}
+
+	for _, tt := range tests {
+		got := cn.do(strings.Split(tt.cmd, " ")...)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_SwapDBBlocked(t *testing.T) {
+	blocked := dial(t)
+	defer blocked.Close()
+	cn := &testConn{t: t, conn: blocked, reader: resp.NewReader(blocked)}
+	cn.do("SELECT", "4")
+	before := blockedClients(t)
+	cn.send("BLPOP", "swapdb:list", "0")
+	for blockedClients(t) == before {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the list pushed to another database is swapped into the database of
+	// the blocked client
+	other := dial(t)
+	defer other.Close()
+	pusher := &testConn{t: t, conn: other, reader: resp.NewReader(other)}
+	pusher.do("SELECT", "5")
+	pusher.do("RPUSH", "swapdb:list", "a")
+	pusher.do("SWAPDB", "4", "5")
+	if got, want := cn.read(), []any{"swapdb:list", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestServer_Transactions(t *testing.T) {
+	conn := dial(t)
+	defer conn.Close()
+	cn := &testConn{t: t, conn: conn, reader: resp.NewReader(conn)}
+	other := dial(t)
+	defer other.Close()
+	writer := &testConn{t: t, conn: other, reader: resp.NewReader(other)}
+
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"EXEC", resp.Error("ERR EXEC without MULTI")},
+		{"DISCARD", resp.Error("ERR DISCARD without MULTI")},
+		{"MULTI", "OK"},
+		{"MULTI", resp.Error("ERR MULTI calls can not be nested")},
+		{"SET multi:key 1", "QUEUED"},
+		{"INCR multi:key", "QUEUED"},
+		{"LPUSH multi:key x", "QUEUED"},
+		{"GET multi:key", "QUEUED"},
+		// the errors of the commands don't stop the transaction
+		{"EXEC", []any{"OK", 2, resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value"), "2"}},
+		{"MULTI", "OK"},
+		{"INCR multi:key", "QUEUED"},
+		{"DISCARD", "OK"},
+		{"GET multi:key", "2"},
+		// a command that can't be queued discards the transaction
+		{"MULTI", "OK"},
+		{"INCR multi:key", "QUEUED"},
+		{"NOPE", resp.Error("ERR unknown command 'NOPE'")},
+		{"GET", resp.Error("ERR wrong number of arguments for 'get' command")},
+		{"EXEC", resp.Error("EXECABORT Transaction discarded because of previous errors.")},
+		{"GET multi:key", "2"},
+		// SHUTDOWN closes the connection instead of replying
+		{"MULTI", "OK"},
+		{"SHUTDOWN NOSAVE", resp.Error("ERR Command not allowed inside a transaction")},
+		{"EXEC", resp.Error("EXECABORT Transaction discarded because of previous errors.")},
+		// blocking commands don't block inside a transaction
+		{"MULTI", "OK"},
+		{"BLPOP multi:list 0", "QUEUED"},
+		{"EXEC", []any{nil}},
+		{"WATCH multi:key", "OK"},
+		{"MULTI", "OK"},
+		{"WATCH multi:key", resp.Error("ERR WATCH inside MULTI is not allowed")},
+		{"INCR multi:key", "QUEUED"},
+		{"EXEC", []any{3}},
+	}
+
+	for _, tt := range tests {
+		got := cn.do(strings.Split(tt.cmd, " ")...)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	// a watched key modified by another client aborts the transaction
+	watchTests := []struct {
+		name   string
+		modify []string
+		want   any
+	}{
+		{"modified", []string{"SET", "multi:key", "10"}, nil},
+		{"deleted", []string{"DEL", "multi:key"}, nil},
+		{"created", []string{"SET", "multi:key", "1"}, nil},
+		{"other key", []string{"SET", "multi:other", "1"}, []any{2}},
+		{"flushed", []string{"FLUSHDB"}, nil},
+		{"unwatched", nil, []any{1}},
+	}
+	for _, tt := range watchTests {
+		cn.do("WATCH", "multi:key")
+		if tt.modify != nil {
+			writer.do(tt.modify...)
+		} else {
+			cn.do("UNWATCH")
+			writer.do("SET", "multi:key", "0")
+		}
+		cn.do("MULTI")
+		cn.do("INCR", "multi:key")
+		if got := cn.do("EXEC"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testConn sends the commands on a single connection
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *resp.Reader
+}
+
+func (cn *testConn) send(args ...string) {
+	if err := resp.NewBulkStringArray(args).Encode(cn.conn); err != nil {
+		cn.t.Fatal(err)
+	}
+}
+
+func (cn *testConn) read() any {
+	cn.conn.SetReadDeadline(time.Now().Add(time.Second))
+	reply, err := cn.reader.Read()
+	if err != nil {
+		cn.t.Fatal(err)
+	}
+	return reply
+}
+
+func (cn *testConn) do(args ...string) any {
+	cn.send(args...)
+	return cn.read()
+}
+
 func TestServer_Incr_Decr(t *testing.T) {
 	_, err := send("SET one 1")
 	_, err = send("SET two two")
@@ -133,12 +520,12 @@ func TestServer_Incr_Decr(t *testing.T) {
 		{"INCR one", 3},
 		{"INCR zero", 1},
 		{"INCR zero", 2},
-		{"INCR two", resp.IncrErr},
+		{"INCR two", resp.Error("ERR value is not an integer or out of range")},
 		{"DECR t1", 122},
 		{"DECR t0", -1},
 		{"DECR t0", -2},
 		{"INCR t0", -1},
-		{"DECR t2", resp.IncrErr},
+		{"DECR t2", resp.Error("ERR value is not an integer or out of range")},
 	}
 
 	for _, tt := range tests {
@@ -160,6 +547,273 @@ func TestServer_Incr_Decr(t *testing.T) {
 	}
 }
 
+func TestServer_Strings(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"MSET str:a 1 str:b 2", "OK"},
+		{"MSET str:a 1 str:b", resp.Error("ERR wrong number of arguments for 'mset' command")},
+		{"RPUSH str:list x", 1},
+		{"MGET str:a str:none str:list str:b", []any{"1", nil, nil, "2"}},
+		{"MSETNX str:c 3 str:a 4", 0},
+		{"EXISTS str:c", 0},
+		{"MSETNX str:c 3 str:d 4", 1},
+		{"SETNX str:c x", 0},
+		{"SETNX str:e x", 1},
+		{"SETEX str:ex 100 v", "OK"},
+		{"TTL str:ex", 100},
+		{"PSETEX str:ex 5000 v", "OK"},
+		{"TTL str:ex", 5},
+		{"SETEX str:ex 0 v", resp.Error("ERR invalid expire time in 'setex' command")},
+		{"PSETEX str:ex x v", resp.Error("ERR value is not an integer or out of range")},
+		{"GETSET str:ex w", "v"},
+		{"TTL str:ex", -1},
+		{"GETSET str:new w", nil},
+		{"GETSET str:list w", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"GETDEL str:new", "w"},
+		{"GETDEL str:new", nil},
+		{"GETEX str:ex EX 100", "w"},
+		{"TTL str:ex", 100},
+		{"GETEX str:ex PERSIST", "w"},
+		{"TTL str:ex", -1},
+		{"GETEX str:ex EX 0", resp.Error("ERR invalid expire time in 'getex' command")},
+		{"GETEX str:ex PERSIST EX 1", resp.Error("ERR syntax error")},
+		{"GETEX str:ex PXAT 1", "w"},
+		{"EXISTS str:ex", 0},
+		{"GETEX str:list", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"APPEND str:app Hello", 5},
+		{"APPEND str:app _World", 11},
+		{"STRLEN str:app", 11},
+		{"STRLEN str:none", 0},
+		{"GETRANGE str:app 0 4", "Hello"},
+		{"GETRANGE str:app -5 -1", "World"},
+		{"GETRANGE str:app 6 100", "World"},
+		{"GETRANGE str:app 5 2", ""},
+		{"GETRANGE str:app -1 -5", ""},
+		{"GETRANGE str:none 0 -1", ""},
+		{"SETRANGE str:app 6 Redis", 11},
+		{"GET str:app", "Hello_Redis"},
+		{"SETRANGE str:pad 3 x", 4},
+		{"GET str:pad", "\x00\x00\x00x"},
+		{"SETRANGE str:empty 0 ", 0},
+		{"EXISTS str:empty", 0},
+		{"SETRANGE str:pad -1 x", resp.Error("ERR offset is out of range")},
+		{"SETRANGE str:pad 536870911 xy", resp.Error("ERR string exceeds maximum allowed size (proto-max-bulk-len)")},
+		{"INCRBY str:n 10", 10},
+		{"DECRBY str:n 15", -5},
+		{"INCRBY str:n x", resp.Error("ERR value is not an integer or out of range")},
+		// a leading '+', leading zeros and -0 aren't integers
+		{"INCRBY str:n +1", resp.Error("ERR value is not an integer or out of range")},
+		{"DECRBY str:n 007", resp.Error("ERR value is not an integer or out of range")},
+		{"SET str:plus +5", "OK"},
+		{"INCR str:plus", resp.Error("ERR value is not an integer or out of range")},
+		{"SET str:zeros 007", "OK"},
+		{"INCR str:zeros", resp.Error("ERR value is not an integer or out of range")},
+		{"SET str:negzero -0", "OK"},
+		{"DECR str:negzero", resp.Error("ERR value is not an integer or out of range")},
+		{"SET str:zero 0", "OK"},
+		{"INCR str:zero", 1},
+		{"INCRBY str:app 1", resp.Error("ERR value is not an integer or out of range")},
+		{"INCRBY str:list 1", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"SET str:max 9223372036854775807", "OK"},
+		{"INCR str:max", resp.Error("ERR increment or decrement would overflow")},
+		{"DECRBY str:n -9223372036854775808", resp.Error("ERR decrement would overflow")},
+		{"DECRBY str:n 9223372036854775803", -9223372036854775808},
+		{"DECRBY str:n 2", resp.Error("ERR increment or decrement would overflow")},
+		{"INCRBYFLOAT str:f 10.5", "10.5"},
+		{"INCRBYFLOAT str:f 0.1", "10.6"},
+		{"INCRBYFLOAT str:f -5e3", "-4989.4"},
+		{"INCRBYFLOAT str:f x", resp.Error("ERR value is not a valid float")},
+		{"INCRBYFLOAT str:app 1", resp.Error("ERR value is not a valid float")},
+		{"SET str:inf 1e308", "OK"},
+		{"INCRBYFLOAT str:inf 1e308", resp.Error("ERR increment would produce NaN or Infinity")},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_Bitmaps(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"SETBIT bits:a 7 1", 0},
+		{"GET bits:a", "\x01"},
+		{"SETBIT bits:a 7 0", 1},
+		{"SETBIT bits:a 17 1", 0},
+		{"GET bits:a", "\x00\x00\x40"},
+		{"GETBIT bits:a 17", 1},
+		{"GETBIT bits:a 100", 0},
+		{"GETBIT bits:none 0", 0},
+		{"SETBIT bits:a 0 2", resp.Error("ERR bit is not an integer or out of range")},
+		{"SETBIT bits:a -1 1", resp.Error("ERR bit offset is not an integer or out of range")},
+		{"SETBIT bits:a 4294967296 1", resp.Error("ERR bit offset is not an integer or out of range")},
+		{"RPUSH bits:list x", 1},
+		{"SETBIT bits:list 0 1", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+
+		{"SET bits:count foobar", "OK"},
+		{"BITCOUNT bits:count", 26},
+		{"BITCOUNT bits:count 0 0", 4},
+		{"BITCOUNT bits:count 1 1 BYTE", 6},
+		{"BITCOUNT bits:count 5 30 BIT", 17},
+		{"BITCOUNT bits:count -2 -1", 7},
+		{"BITCOUNT bits:count -1 -2", 0},
+		{"BITCOUNT bits:count 0", resp.Error("ERR syntax error")},
+		{"BITCOUNT bits:count 0 1 BITS", resp.Error("ERR syntax error")},
+		{"BITCOUNT bits:count 0 x", resp.Error("ERR value is not an integer or out of range")},
+		{"BITCOUNT bits:none", 0},
+
+		{"SET bits:pos \xff\xf0\x00", "OK"},
+		{"BITPOS bits:pos 0", 12},
+		{"SET bits:pos \x00\xff\xf0", "OK"},
+		{"BITPOS bits:pos 1 0", 8},
+		{"BITPOS bits:pos 1 2", 16},
+		{"BITPOS bits:pos 1 2 -1 BYTE", 16},
+		{"BITPOS bits:pos 1 7 15 BIT", 8},
+		{"BITPOS bits:pos 0 8 14 BIT", -1},
+		{"SET bits:pos \xff\xff\xff", "OK"},
+		{"BITPOS bits:pos 0", 24},
+		{"BITPOS bits:pos 0 0 -1", -1},
+		{"BITPOS bits:pos 1 7 -3 BIT", 7},
+		{"BITPOS bits:none 0", 0},
+		{"BITPOS bits:none 1", -1},
+		{"BITPOS bits:pos 2", resp.Error("ERR The bit argument must be 1 or 0.")},
+
+		{"SET bits:op1 foobar", "OK"},
+		{"SET bits:op2 abcdef", "OK"},
+		{"BITOP AND bits:dest bits:op1 bits:op2", 6},
+		{"GET bits:dest", "`bc`ab"},
+		{"BITOP OR bits:dest bits:op1 bits:op2", 6},
+		{"GET bits:dest", "goofev"},
+		{"SET bits:short \xf0", "OK"},
+		{"BITOP XOR bits:dest bits:short bits:op1", 6},
+		{"GET bits:dest", "\x96oobar"},
+		{"BITOP NOT bits:dest bits:short", 1},
+		{"GET bits:dest", "\x0f"},
+		{"BITOP NOT bits:dest bits:op1 bits:op2", resp.Error("ERR BITOP NOT must be called with a single source key.")},
+		{"BITOP NAND bits:dest bits:op1", resp.Error("ERR syntax error")},
+		{"BITOP AND bits:dest bits:op1 bits:list", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"BITOP AND bits:dest bits:none", 0},
+		{"EXISTS bits:dest", 0},
+
+		{"BITFIELD bits:f INCRBY i5 100 1 GET u4 0", []any{1, 0}},
+		{"BITFIELD bits:u INCRBY u2 100 1 OVERFLOW SAT INCRBY u2 102 1", []any{1, 1}},
+		{"BITFIELD bits:u INCRBY u2 100 1 OVERFLOW SAT INCRBY u2 102 1", []any{2, 2}},
+		{"BITFIELD bits:u INCRBY u2 100 1 OVERFLOW SAT INCRBY u2 102 1", []any{3, 3}},
+		{"BITFIELD bits:u INCRBY u2 100 1 OVERFLOW SAT INCRBY u2 102 1", []any{0, 3}},
+		{"BITFIELD bits:u OVERFLOW FAIL INCRBY u2 102 1 GET u2 102", []any{nil, 3}},
+		{"BITFIELD bits:u OVERFLOW FAIL INCRBY u2 102 -4", []any{nil}},
+		{"BITFIELD bits:u OVERFLOW SAT INCRBY u2 102 -4", []any{0}},
+		{"BITFIELD bits:s SET i8 0 200 GET i8 0 GET u8 0", []any{0, -56, 200}},
+		{"BITFIELD bits:s OVERFLOW SAT SET i8 0 200 GET i8 0", []any{-56, 127}},
+		{"BITFIELD bits:s OVERFLOW SAT INCRBY i8 0 -300", []any{-128}},
+		{"BITFIELD bits:s OVERFLOW WRAP INCRBY i8 0 -1", []any{127}},
+		{"BITFIELD bits:s OVERFLOW SAT SET u8 0 -1 GET u8 0", []any{127, 255}},
+		{"BITFIELD bits:s SET u8 #1 255 GET u16 0 GET u8 8", []any{0, 65535, 255}},
+		{"BITFIELD bits:l SET i64 0 9223372036854775807 INCRBY i64 0 1", []any{0, -9223372036854775808}},
+		{"BITFIELD bits:l OVERFLOW SAT INCRBY i64 0 -1", []any{-9223372036854775808}},
+		{"BITFIELD bits:l OVERFLOW SAT SET u63 1 -1 GET u63 1", []any{0, 9223372036854775807}},
+		{"STRLEN bits:l", 8},
+		{"BITFIELD bits:l OVERFLOW FAIL SET i8 100 1000", []any{nil}},
+		{"STRLEN bits:l", 14},
+		{"BITFIELD bits:none GET u8 0", []any{0}},
+		{"EXISTS bits:none", 0},
+		{"BITFIELD bits:f GET u64 0", resp.Error("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")},
+		{"BITFIELD bits:f GET i65 0", resp.Error("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")},
+		{"BITFIELD bits:f GET u8 -1", resp.Error("ERR bit offset is not an integer or out of range")},
+		{"BITFIELD bits:f SET u8 0 x", resp.Error("ERR value is not an integer or out of range")},
+		{"BITFIELD bits:f OVERFLOW NOPE", resp.Error("ERR Invalid OVERFLOW type specified")},
+		{"BITFIELD bits:f GET u8", resp.Error("ERR syntax error")},
+		{"BITFIELD bits:list GET u8 0", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_HyperLogLog(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"PFADD hll:a a b c d e f g", 1},
+		{"PFADD hll:a a b c", 0},
+		{"PFCOUNT hll:a", 7},
+		// the cached cardinality is valid
+		{"GETRANGE hll:a 15 15", "\x00"},
+		{"PFADD hll:a h", 1},
+		{"GETRANGE hll:a 15 15", "\x80"},
+		{"PFCOUNT hll:a", 8},
+		{"PFADD hll:empty", 1},
+		{"PFADD hll:empty", 0},
+		{"PFCOUNT hll:empty", 0},
+		{"PFCOUNT hll:none", 0},
+		{"PFADD hll:b a x y", 1},
+		{"PFCOUNT hll:a hll:b hll:none", 10},
+		{"PFMERGE hll:m hll:a hll:b", "OK"},
+		{"PFCOUNT hll:m", 10},
+		{"PFMERGE hll:m hll:none", "OK"},
+		{"PFCOUNT hll:m", 10},
+		{"SET hll:string foo", "OK"},
+		{"PFADD hll:string a", resp.Error("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+		{"PFCOUNT hll:a hll:string", resp.Error("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+		{"PFMERGE hll:m hll:string", resp.Error("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+		{"RPUSH hll:list a", 1},
+		{"PFCOUNT hll:list", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"APPEND hll:b hello", 31},
+		{"PFCOUNT hll:b", resp.Error("INVALIDOBJ Corrupted HLL object detected")},
+		{"SETRANGE hll:a 4 \x00", 41},
+		{"PFCOUNT hll:a", resp.Error("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	// the HyperLogLogs converted to dense give the same estimates
+	args := []string{"PFADD", "hll:dense"}
+	for i := 0; i < 5000; i++ {
+		args = append(args, strconv.Itoa(i))
+	}
+	if _, err := testClient.Do(context.Background(), args...); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := send("STRLEN hll:dense"); got != 12304 {
+		t.Errorf("got a HyperLogLog of %v bytes, want a dense one", got)
+	}
+	send("PFADD hll:sparse 5000 5001")
+	send("PFMERGE hll:merged hll:sparse hll:dense")
+	count, _ := send("PFCOUNT hll:merged")
+	if n, ok := count.(int); !ok || n < 4900 || n > 5100 {
+		t.Errorf("got %v for about 5002 elements", count)
+	}
+	if got, _ := send("STRLEN hll:merged"); got != 12304 {
+		t.Errorf("got a merged HyperLogLog of %v bytes, want a dense one", got)
+	}
+}
+
 func TestServer_RPush_LPush(t *testing.T) {
 	send("SET one 1")
 	tests := []struct {
@@ -168,7 +822,7 @@ func TestServer_RPush_LPush(t *testing.T) {
 	}{
 		{"RPUSH mylist lol", 1},
 		{"RPUSH mylist 2", 2},
-		{"RPUSH one 3", resp.NotAListErr},
+		{"RPUSH one 3", errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")},
 		{"LPUSH mylist 3", 3},
 		{"LPUSH mylist 4", 4},
 	}
@@ -192,6 +846,679 @@ func TestServer_RPush_LPush(t *testing.T) {
 	}
 }
 
+func TestServer_Lists(t *testing.T) {
+	wrongType := resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	send("SET list:string x")
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"RPUSH list:a a b c d", 4},
+		{"LPUSH list:a z y", 6},
+		{"LRANGE list:a 0 -1", []any{"y", "z", "a", "b", "c", "d"}},
+		{"LRANGE list:a -2 100", []any{"c", "d"}},
+		{"LRANGE list:a 4 2", []any{}},
+		{"LRANGE list:missing 0 -1", []any{}},
+		{"LLEN list:a", 6},
+		{"LLEN list:missing", 0},
+		{"LINDEX list:a -1", "d"},
+		{"LINDEX list:a 6", nil},
+		{"LSET list:a 1 x", "OK"},
+		{"LSET list:a 6 x", resp.Error("ERR index out of range")},
+		{"LSET list:missing 0 x", resp.Error("ERR no such key")},
+		{"LPOP list:a", "y"},
+		{"RPOP list:a 2", []any{"d", "c"}},
+		{"LPOP list:a 0", []any{}},
+		{"LPOP list:missing", nil},
+		{"LPOP list:missing 2", nil},
+		{"LPOP list:a -1", resp.Error("ERR value is out of range, must be positive")},
+		{"LINSERT list:a BEFORE a w", 4},
+		{"LINSERT list:a AFTER b e", 5},
+		{"LINSERT list:a AFTER nope e", -1},
+		{"LINSERT list:missing AFTER a e", 0},
+		{"LINSERT list:a NEXT a e", resp.Error("ERR syntax error")},
+		{"LRANGE list:a 0 -1", []any{"x", "w", "a", "b", "e"}},
+		{"RPUSH list:a a x a", 8},
+		{"LPOS list:a a", 2},
+		{"LPOS list:a a RANK 2", 5},
+		{"LPOS list:a a RANK -1", 7},
+		{"LPOS list:a a COUNT 0", []any{2, 5, 7}},
+		{"LPOS list:a a COUNT 2 RANK -1", []any{7, 5}},
+		{"LPOS list:a a MAXLEN 2", nil},
+		{"LPOS list:a nope COUNT 1", []any{}},
+		{"LPOS list:a a RANK 0", resp.Error("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")},
+		{"LREM list:a -1 a", 1},
+		{"LREM list:a 0 x", 2},
+		{"LRANGE list:a 0 -1", []any{"w", "a", "b", "e", "a"}},
+		{"LTRIM list:a 1 -2", "OK"},
+		{"LRANGE list:a 0 -1", []any{"a", "b", "e"}},
+		{"LMOVE list:a list:b LEFT RIGHT", "a"},
+		{"LMOVE list:a list:b RIGHT LEFT", "e"},
+		{"RPOPLPUSH list:a list:a", "b"},
+		{"RPOPLPUSH list:a list:b", "b"},
+		{"EXISTS list:a", 0},
+		{"LRANGE list:b 0 -1", []any{"b", "e", "a"}},
+		{"LMOVE list:missing list:b LEFT LEFT", nil},
+		{"LMOVE list:b list:b UP LEFT", resp.Error("ERR syntax error")},
+		{"LTRIM list:b 5 10", "OK"},
+		{"EXISTS list:b", 0},
+		// every command checks the type of the key
+		{"LPUSH list:string a", wrongType},
+		{"LPOP list:string", wrongType},
+		{"LRANGE list:string 0 -1", wrongType},
+		{"LLEN list:string", wrongType},
+		{"LPOS list:string a", wrongType},
+		{"RPUSH list:c a", 1},
+		{"LMOVE list:c list:string LEFT LEFT", wrongType},
+		{"GET list:c", wrongType},
+		{"INCR list:c", wrongType},
+		{"LLEN list:c", 1},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_BlockingPops(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"RPUSH block:a x", 1},
+		{"BLPOP block:none block:a 0", []any{"block:a", "x"}},
+		{"BLPOP block:none 0.01", nil},
+		{"BLMOVE block:none block:b LEFT LEFT 0.01", nil},
+		{"BLPOP block:none -1", resp.Error("ERR timeout is negative")},
+		{"BLPOP block:none soon", resp.Error("ERR timeout is not a float or out of range")},
+		{"SET block:string x", "OK"},
+		{"BRPOP block:string 0", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+	}
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	// the clients blocked on a key are served in the order they blocked
+	var replies []chan any
+	for _, cmd := range []string{"BLPOP block:q 0", "BRPOPLPUSH block:q block:dst 0", "BRPOP block:other block:q 0"} {
+		waitBlocked := blockedClients(t) + 1
+		reply := make(chan any, 1)
+		replies = append(replies, reply)
+		go func(cmd string, replies chan any) {
+			c := client.New(client.Options{Addr: "localhost" + testPort})
+			defer c.Close()
+			reply, err := c.Do(context.Background(), strings.Split(cmd, " ")...)
+			if err != nil {
+				reply = err
+			}
+			replies <- reply
+		}(cmd, reply)
+		for blockedClients(t) < waitBlocked {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	send("RPUSH block:q 1 2")
+	for i, want := range []any{[]any{"block:q", "1"}, "2"} {
+		if got := <-replies[i]; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	send("LPUSH block:q 3")
+	if got, want := <-replies[2], []any{"block:q", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, _ := send("LRANGE block:dst 0 -1"); !reflect.DeepEqual(got, []any{"2"}) {
+		t.Errorf("got %q in the destination", got)
+	}
+
+	// a client that disconnects is unblocked
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := testClient.Do(ctx, "BLPOP", "block:gone", "0"); err == nil {
+		t.Fatal("BLPOP returned before the context deadline")
+	}
+	for i := 0; blockedClients(t) > 0; i++ {
+		if i == 100 {
+			t.Fatal("the client is still blocked after it disconnected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got, _ := send("RPUSH block:gone x"); got != 1 {
+		t.Errorf("got %v, the element was popped", got)
+	}
+}
+
+// blockedClients returns the number of clients blocked by the test server
+func blockedClients(t *testing.T) int {
+	info, err := send("INFO clients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(info.(string), "\r\n") {
+		if value, ok := strings.CutPrefix(line, "blocked_clients:"); ok {
+			n, _ := strconv.Atoi(value)
+			return n
+		}
+	}
+	t.Fatal("no blocked_clients field")
+	return 0
+}
+
+func TestServer_Hashes(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"HSET hash:a f1 v1 f2 v2", 2},
+		{"HSET hash:a f1 v3 f3 v3", 1},
+		{"HSET hash:a f1", resp.Error("ERR wrong number of arguments for 'hset' command")},
+		{"HSETNX hash:a f1 v4", 0},
+		{"HSETNX hash:a f4 v4", 1},
+		{"HGET hash:a f1", "v3"},
+		{"HGET hash:a nope", nil},
+		{"HGET hash:missing f1", nil},
+		{"HMGET hash:a f2 nope f4", []any{"v2", nil, "v4"}},
+		{"HEXISTS hash:a f2", 1},
+		{"HEXISTS hash:a nope", 0},
+		{"HLEN hash:a", 4},
+		{"HDEL hash:a f3 f4 nope", 2},
+		{"HGETALL hash:missing", []any{}},
+		{"HINCRBY hash:a n 5", 5},
+		{"HINCRBY hash:a n -7", -2},
+		{"HINCRBY hash:a f1 1", resp.Error("ERR hash value is not an integer")},
+		{"HINCRBY hash:a n x", resp.Error("ERR value is not an integer or out of range")},
+		{"HINCRBY hash:a n 9223372036854775807", 9223372036854775805},
+		{"HINCRBY hash:a n 3", resp.Error("ERR increment or decrement would overflow")},
+		{"HINCRBYFLOAT hash:a fl 10.5", "10.5"},
+		{"HINCRBYFLOAT hash:a fl 0.1", "10.6"},
+		{"HINCRBYFLOAT hash:a fl -5e3", "-4989.4"},
+		{"HINCRBYFLOAT hash:a f1 1", resp.Error("ERR hash value is not a float")},
+		{"HINCRBYFLOAT hash:a fl nan", resp.Error("ERR value is not a valid float")},
+		{"HINCRBYFLOAT hash:a fl inf", resp.Error("ERR increment would produce NaN or Infinity")},
+		{"HDEL hash:a f1 f2 n fl", 4},
+		{"EXISTS hash:a", 0},
+		{"HSET hash:b f v", 1},
+		{"HGETALL hash:b", []any{"f", "v"}},
+		{"HKEYS hash:b", []any{"f"}},
+		{"HVALS hash:b", []any{"v"}},
+		{"HSCAN hash:b 0", []any{"0", []any{"f", "v"}}},
+		{"HSCAN hash:b 0 MATCH x*", []any{"0", []any{}}},
+		{"HSCAN hash:b 0 COUNT 0", resp.Error("ERR syntax error")},
+		{"HSCAN hash:b x", resp.Error("ERR invalid cursor")},
+		{"RPUSH hash:list x", 1},
+		{"HSET hash:list f v", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"HGET hash:list f", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"LLEN hash:b", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"GET hash:b", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_Sets(t *testing.T) {
+	wrongType := resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"SADD set:a a b c d", 4},
+		{"SADD set:a a e", 1},
+		{"SADD set:b c d e f", 4},
+		{"SADD set:c d", 1},
+		{"SCARD set:a", 5},
+		{"SCARD set:missing", 0},
+		{"SISMEMBER set:a a", 1},
+		{"SISMEMBER set:a f", 0},
+		{"SMISMEMBER set:a a f e", []any{1, 0, 1}},
+		{"SMEMBERS set:b", []any{"c", "d", "e", "f"}},
+		{"SMEMBERS set:missing", []any{}},
+		{"SINTER set:a set:b", []any{"c", "d", "e"}},
+		{"SINTER set:a set:b set:c", []any{"d"}},
+		{"SINTER set:a set:missing", []any{}},
+		{"SUNION set:a set:b set:missing", []any{"a", "b", "c", "d", "e", "f"}},
+		{"SDIFF set:a set:b", []any{"a", "b"}},
+		{"SDIFF set:a set:b set:missing", []any{"a", "b"}},
+		{"SINTERSTORE set:dest set:a set:b", 3},
+		{"SMEMBERS set:dest", []any{"c", "d", "e"}},
+		{"SUNIONSTORE set:dest set:c set:missing", 1},
+		{"SMEMBERS set:dest", []any{"d"}},
+		{"SDIFFSTORE set:dest set:c set:a", 0},
+		{"EXISTS set:dest", 0},
+		{"SET set:string x", "OK"},
+		{"SDIFFSTORE set:string set:a set:b", 2},
+		{"SMEMBERS set:string", []any{"a", "b"}},
+		{"SREM set:a a b nope", 2},
+		{"SREM set:c d", 1},
+		{"EXISTS set:c", 0},
+		{"SPOP set:missing", nil},
+		{"SPOP set:missing 2", []any{}},
+		{"SPOP set:a -1", resp.Error("ERR value is out of range, must be positive")},
+		{"SPOP set:a 5", []any{"c", "d", "e"}},
+		{"EXISTS set:a", 0},
+		{"SADD set:one x", 1},
+		{"SRANDMEMBER set:one", "x"},
+		{"SRANDMEMBER set:one 3", []any{"x"}},
+		{"SRANDMEMBER set:one -3", []any{"x", "x", "x"}},
+		{"SRANDMEMBER set:missing", nil},
+		{"SRANDMEMBER set:missing 2", []any{}},
+		{"SPOP set:one", "x"},
+		{"EXISTS set:one", 0},
+		{"SSCAN set:b 0", []any{"0", []any{"c", "d", "e", "f"}}},
+		{"SSCAN set:b 0 MATCH [ef]", []any{"0", []any{"e", "f"}}},
+		{"SSCAN set:b 0 COUNT", resp.Error("ERR syntax error")},
+		{"RPUSH sets:list x", 1},
+		{"SADD sets:list x", wrongType},
+		{"SISMEMBER sets:list x", wrongType},
+		{"SINTER set:b sets:list", wrongType},
+		{"SUNIONSTORE set:dest set:b sets:list", wrongType},
+		{"LLEN set:b", wrongType},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		// the members are returned in any order
+		sortStrings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+// sortStrings sorts the arrays of strings nested in reply
+func sortStrings(reply any) {
+	elems, ok := reply.([]any)
+	if !ok {
+		return
+	}
+	for _, elem := range elems {
+		sortStrings(elem)
+	}
+	sort.SliceStable(elems, func(i, j int) bool {
+		a, okA := elems[i].(string)
+		b, okB := elems[j].(string)
+		return okA && okB && a < b
+	})
+}
+
+func TestServer_SortedSets(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"ZADD zset:a 1 a 2 b 3 c", 3},
+		{"ZADD zset:a 4 d 2.5 b", 1},
+		{"ZADD zset:a CH 5 d 5 e", 2},
+		{"ZADD zset:a NX 0 a 6 f", 1},
+		{"ZADD zset:a XX 0 a 0 g", 0},
+		{"ZADD zset:a GT CH 0 a 7 f", 1},
+		{"ZADD zset:a LT CH 1 a 1 c", 1},
+		{"ZADD zset:a INCR 10 a", "10"},
+		{"ZADD zset:a INCR NX 10 a", nil},
+		{"ZADD zset:a NX XX 1 a", resp.Error("ERR XX and NX options at the same time are not compatible")},
+		{"ZADD zset:a GT LT 1 a", resp.Error("ERR GT, LT, and/or NX options at the same time are not compatible")},
+		{"ZADD zset:a INCR 1 a 2 b", resp.Error("ERR INCR option supports a single increment-element pair")},
+		{"ZADD zset:a 1 a 2", resp.Error("ERR syntax error")},
+		{"ZADD zset:a x a", resp.Error("ERR value is not a valid float")},
+		{"ZADD zset:missing XX 1 a", 0},
+		{"EXISTS zset:missing", 0},
+		// c 1, b 2.5, d 5, e 5, f 7, a 10
+		{"ZCARD zset:a", 6},
+		{"ZSCORE zset:a b", "2.5"},
+		{"ZSCORE zset:a nope", nil},
+		{"ZINCRBY zset:a -1.5 b", "1"},
+		{"ZINCRBY zset:a 2 new", "2"},
+		{"ZINCRBY zset:a +inf a", "inf"},
+		{"ZINCRBY zset:a -inf a", resp.Error("ERR resulting score is not a number (NaN)")},
+		// the scores are formatted in decimal up to 1e17, e.g. timestamps
+		{"ZADD zset:ts 1700000000123 x 1000000 y", 2},
+		{"ZSCORE zset:ts x", "1700000000123"},
+		{"ZINCRBY zset:ts 1 y", "1000001"},
+		{"ZRANGE zset:ts 0 -1 WITHSCORES", []any{"y", "1000001", "x", "1700000000123"}},
+		{"ZINCRBY zset:ts 1e17 y", "1.00000000001e+17"},
+		{"ZREM zset:a new nope", 1},
+		{"ZREM zset:missing a", 0},
+		// b 1, c 1, d 5, e 5, f 7, a inf
+		{"ZRANK zset:a c", 1},
+		{"ZRANK zset:a a WITHSCORE", []any{5, "inf"}},
+		{"ZREVRANK zset:a b", 5},
+		{"ZRANK zset:a nope", nil},
+		{"ZRANGE zset:a 0 -1", []any{"b", "c", "d", "e", "f", "a"}},
+		{"ZRANGE zset:a 1 2 WITHSCORES", []any{"c", "1", "d", "5"}},
+		{"ZRANGE zset:a -2 100 REV", []any{"c", "b"}},
+		{"ZRANGE zset:a 4 2", []any{}},
+		{"ZRANGE zset:a 1 5 BYSCORE", []any{"b", "c", "d", "e"}},
+		{"ZRANGE zset:a (1 +inf BYSCORE LIMIT 1 2", []any{"e", "f"}},
+		{"ZRANGE zset:a +inf (5 BYSCORE REV WITHSCORES", []any{"a", "inf", "f", "7"}},
+		{"ZRANGE zset:a 5 1 BYSCORE", []any{}},
+		{"ZRANGE zset:a 0 -1 LIMIT 0 1", resp.Error("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")},
+		{"ZRANGE zset:a x 1 BYSCORE", resp.Error("ERR min or max is not a float")},
+		{"ZRANGEBYSCORE zset:a -inf 5 LIMIT 1 -1", []any{"c", "d", "e"}},
+		{"ZRANGEBYSCORE zset:a 5 5 WITHSCORES", []any{"d", "5", "e", "5"}},
+		{"ZCOUNT zset:a 1 5", 4},
+		{"ZCOUNT zset:a (1 (7", 2},
+		{"ZCOUNT zset:a 8 9", 0},
+		{"ZADD zset:lex 0 a 0 b 0 c 0 d", 4},
+		{"ZRANGE zset:lex [b + BYLEX", []any{"b", "c", "d"}},
+		{"ZRANGE zset:lex (d (a BYLEX REV", []any{"c", "b"}},
+		{"ZRANGE zset:lex - + BYLEX LIMIT 1 1", []any{"b"}},
+		{"ZRANGE zset:lex b + BYLEX", resp.Error("ERR min or max not valid string range item")},
+		{"ZPOPMIN zset:lex", []any{"a", "0"}},
+		{"ZPOPMAX zset:lex 2", []any{"d", "0", "c", "0"}},
+		{"ZPOPMIN zset:lex 5", []any{"b", "0"}},
+		{"EXISTS zset:lex", 0},
+		{"ZPOPMIN zset:lex", []any{}},
+		{"ZADD zset:u1 1 a 2 b", 2},
+		{"ZADD zset:u2 3 b 4 c", 2},
+		{"SADD zset:set c d", 2},
+		{"ZUNIONSTORE zset:dest 2 zset:u1 zset:u2", 3},
+		{"ZRANGE zset:dest 0 -1 WITHSCORES", []any{"a", "1", "c", "4", "b", "5"}},
+		{"ZUNIONSTORE zset:dest 3 zset:u1 zset:u2 zset:set WEIGHTS 2 1 10 AGGREGATE MAX", 4},
+		{"ZRANGE zset:dest 0 -1 WITHSCORES", []any{"a", "2", "b", "4", "c", "10", "d", "10"}},
+		{"ZINTERSTORE zset:dest 2 zset:u1 zset:u2 AGGREGATE MIN", 1},
+		{"ZRANGE zset:dest 0 -1 WITHSCORES", []any{"b", "2"}},
+		{"ZINTERSTORE zset:dest 2 zset:u1 zset:missing", 0},
+		{"EXISTS zset:dest", 0},
+		{"ZUNIONSTORE zset:dest 0 zset:u1", resp.Error("ERR at least 1 input key is needed for 'zunionstore' command")},
+		{"ZUNIONSTORE zset:dest 3 zset:u1", resp.Error("ERR syntax error")},
+		{"ZUNIONSTORE zset:dest 1 zset:u1 WEIGHTS x", resp.Error("ERR weight value is not a float")},
+		{"RPUSH zset:list x", 1},
+		{"ZADD zset:list 1 x", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"ZRANGE zset:list 0 -1", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"ZUNIONSTORE zset:dest 2 zset:u1 zset:list", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"SMEMBERS zset:u1", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_Geo(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"GEOADD Sicily 13.361389 38.115556 Palermo 15.087269 37.502669 Catania", 2},
+		{"GEOADD Sicily NX 13.361389 38.115556 Palermo", 0},
+		{"GEOADD Sicily 13.361389 38.115556 Palermo 15", resp.Error("ERR syntax error")},
+		{"GEOADD Sicily 200 100 Nowhere", resp.Error("ERR invalid longitude,latitude pair 200.000000,100.000000")},
+		{"GEOADD Sicily x 38 Nowhere", resp.Error("ERR value is not a valid float")},
+		{"ZSCORE Sicily Palermo", "3479099956230698"},
+		{"GEODIST Sicily Palermo Catania", "166274.1516"},
+		{"GEODIST Sicily Palermo Catania km", "166.2742"},
+		{"GEODIST Sicily Palermo Catania MI", "103.3182"},
+		{"GEODIST Sicily Palermo Catania yd", resp.Error("ERR unsupported unit provided. please use M, KM, FT, MI")},
+		{"GEODIST Sicily Foo Bar", nil},
+		{"GEOPOS Sicily Palermo Catania NonExisting", []any{
+			[]any{"13.36138933897018433", "38.11555639549629859"},
+			[]any{"15.08726745843887329", "37.50266842333162032"},
+			nil,
+		}},
+		{"GEOHASH Sicily Palermo Catania NonExisting", []any{"sqc8b49rny0", "sqdtr74hyu0", nil}},
+		{"GEOADD Sicily 12.758489 38.788135 edge1 17.241510 38.788135 edge2", 2},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 200 km ASC", []any{"Catania", "Palermo"}},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 200 km DESC", []any{"Palermo", "Catania"}},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC WITHCOORD WITHDIST", []any{
+			[]any{"Catania", "56.4413", []any{"15.08726745843887329", "37.50266842333162032"}},
+			[]any{"Palermo", "190.4424", []any{"13.36138933897018433", "38.11555639549629859"}},
+			[]any{"edge2", "279.7403", []any{"17.24151045083999634", "38.78813451624225195"}},
+			[]any{"edge1", "279.7405", []any{"12.7584877610206604", "38.78813451624225195"}},
+		}},
+		{"GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS 200 km COUNT 1 WITHHASH", []any{[]any{"Palermo", 3479099956230698}}},
+		{"GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS 1000 km COUNT 2 ANY", []any{"Palermo", "edge1"}},
+		{"GEOSEARCH Sicily FROMMEMBER Nowhere BYRADIUS 200 km", resp.Error("ERR could not decode requested zset member")},
+		{"GEOSEARCH Sicily BYRADIUS 200 km ASC WITHDIST", resp.Error("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 ASC WITHDIST", resp.Error("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 FROMLONLAT 15 37", resp.Error("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 200 km ANY", resp.Error("ERR the ANY argument requires COUNT argument")},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS -1 km", resp.Error("ERR radius cannot be negative")},
+		{"GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 200 km COUNT 0", resp.Error("ERR COUNT must be > 0")},
+		{"GEOSEARCH Sicily FROMMEMBER Palermo FROMLONLAT 15 37 BYRADIUS 200 km", resp.Error("ERR syntax error")},
+		{"GEOSEARCH geo:none FROMMEMBER Palermo BYRADIUS 200 km", []any{}},
+		{"GEOSEARCHSTORE geo:dst Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC COUNT 3", 3},
+		{"GEOSEARCH geo:dst FROMLONLAT 15 37 BYBOX 400 400 km ASC WITHHASH", []any{
+			[]any{"Catania", 3479447370796909},
+			[]any{"Palermo", 3479099956230698},
+			[]any{"edge2", 3481342659049484},
+		}},
+		{"GEOSEARCHSTORE geo:dist Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC COUNT 3 STOREDIST", 3},
+		{"ZRANGE geo:dist 0 -1 WITHSCORES", []any{"Catania", "56.4412578701582", "Palermo", "190.4424298477578", "edge2", "279.7403417843143"}},
+		{"GEOSEARCHSTORE geo:dist Sicily FROMLONLAT 15 37 BYRADIUS 1 m", 0},
+		{"EXISTS geo:dist", 0},
+		{"GEOSEARCHSTORE geo:dist Sicily FROMLONLAT 15 37 BYRADIUS 1 m WITHDIST", resp.Error("ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options")},
+		{"SET geo:string x", "OK"},
+		{"GEOADD geo:string 15 37 m", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"GEOPOS geo:string m", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_Streams(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"XADD stream:a 1-1 f v", "1-1"},
+		{"XADD stream:a 1-* f v2", "1-2"},
+		{"XADD stream:a 2-0 f v3 g w", "2-0"},
+		{"XADD stream:a 2-0 f v", resp.Error("ERR The ID specified in XADD is equal or smaller than the target stream top item")},
+		{"XADD stream:a 1-* f v", resp.Error("ERR The ID specified in XADD is equal or smaller than the target stream top item")},
+		{"XADD stream:b 0-0 f v", resp.Error("ERR The ID specified in XADD must be greater than 0-0")},
+		{"XADD stream:a x-1 f v", resp.Error("ERR Invalid stream ID specified as stream command argument")},
+		{"XADD stream:a 3-0 f", resp.Error("ERR wrong number of arguments for 'xadd' command")},
+		{"XADD stream:b NOMKSTREAM * f v", nil},
+		{"EXISTS stream:b", 0},
+		{"XLEN stream:a", 3},
+		{"XRANGE stream:a - +", []any{
+			[]any{"1-1", []any{"f", "v"}},
+			[]any{"1-2", []any{"f", "v2"}},
+			[]any{"2-0", []any{"f", "v3", "g", "w"}},
+		}},
+		{"XRANGE stream:a 1 1 COUNT 1", []any{[]any{"1-1", []any{"f", "v"}}}},
+		{"XRANGE stream:a (1-1 2", []any{[]any{"1-2", []any{"f", "v2"}}, []any{"2-0", []any{"f", "v3", "g", "w"}}}},
+		{"XREVRANGE stream:a + (1-2", []any{[]any{"2-0", []any{"f", "v3", "g", "w"}}}},
+		{"XREVRANGE stream:a + - COUNT 2", []any{
+			[]any{"2-0", []any{"f", "v3", "g", "w"}},
+			[]any{"1-2", []any{"f", "v2"}},
+		}},
+		{"XRANGE stream:missing - +", []any{}},
+		{"XREAD STREAMS stream:a 1-2", []any{[]any{"stream:a", []any{[]any{"2-0", []any{"f", "v3", "g", "w"}}}}}},
+		{"XREAD COUNT 1 STREAMS stream:a stream:missing 0 0", []any{[]any{"stream:a", []any{[]any{"1-1", []any{"f", "v"}}}}}},
+		{"XREAD STREAMS stream:a $", nil},
+		{"XREAD STREAMS stream:a stream:b 0", resp.Error("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")},
+		{"XDEL stream:a 1-2 5-0", 1},
+		{"XADD stream:a MAXLEN 2 3-0 f v4", "3-0"},
+		{"XRANGE stream:a - +", []any{
+			[]any{"2-0", []any{"f", "v3", "g", "w"}},
+			[]any{"3-0", []any{"f", "v4"}},
+		}},
+		{"XTRIM stream:a MINID 3", 1},
+		{"XTRIM stream:a MAXLEN 0 LIMIT 1", resp.Error("ERR syntax error, LIMIT cannot be used without the special ~ option")},
+		{"XTRIM stream:a MAXLEN ~ 0 LIMIT 5", 1},
+		{"XLEN stream:a", 0},
+		{"XADD stream:a 3-0 f v", resp.Error("ERR The ID specified in XADD is equal or smaller than the target stream top item")},
+		{"SET stream:string x", "OK"},
+		{"XADD stream:string * f v", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"XREAD STREAMS stream:string 0", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"LLEN stream:a", resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_StreamGroups(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"XGROUP CREATE group:s g $", resp.Error("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")},
+		{"XGROUP CREATE group:s g $ MKSTREAM", "OK"},
+		{"XGROUP CREATE group:s g 0", resp.Error("BUSYGROUP Consumer Group name already exists")},
+		{"XADD group:s 1-0 a 1", "1-0"},
+		{"XADD group:s 2-0 b 2", "2-0"},
+		{"XADD group:s 3-0 c 3", "3-0"},
+		{"XREADGROUP GROUP nope alice STREAMS group:s >", resp.Error("NOGROUP No such key 'group:s' or consumer group 'nope' in XREADGROUP with GROUP option")},
+		{"XREADGROUP GROUP g alice COUNT 2 STREAMS group:s >", []any{[]any{"group:s", []any{
+			[]any{"1-0", []any{"a", "1"}},
+			[]any{"2-0", []any{"b", "2"}},
+		}}}},
+		{"XREADGROUP GROUP g bob STREAMS group:s >", []any{[]any{"group:s", []any{[]any{"3-0", []any{"c", "3"}}}}}},
+		{"XREADGROUP GROUP g bob STREAMS group:s >", nil},
+		{"XREADGROUP GROUP g alice STREAMS group:s 1-0", []any{[]any{"group:s", []any{[]any{"2-0", []any{"b", "2"}}}}}},
+		{"XPENDING group:s g", []any{3, "1-0", "3-0", []any{[]any{"alice", "2"}, []any{"bob", "1"}}}},
+		{"XPENDING group:s g - + 10 alice", []any{
+			[]any{"1-0", "alice", 0, 1},
+			[]any{"2-0", "alice", 0, 2},
+		}},
+		{"XPENDING group:s nope", resp.Error("NOGROUP No such key 'group:s' or consumer group 'nope'")},
+		{"XACK group:s g 1-0 9-0", 1},
+		{"XCLAIM group:s g bob 3600000 2-0", []any{}},
+		{"XCLAIM group:s g bob 0 2-0 JUSTID", []any{"2-0"}},
+		{"XPENDING group:s g - + 10", []any{
+			[]any{"2-0", "bob", 0, 2},
+			[]any{"3-0", "bob", 0, 1},
+		}},
+		{"XDEL group:s 3-0", 1},
+		{"XAUTOCLAIM group:s g alice 0 0 COUNT 1", []any{"3-0", []any{[]any{"2-0", []any{"b", "2"}}}, []any{}}},
+		{"XAUTOCLAIM group:s g alice 0 3-0", []any{"0-0", []any{}, []any{"3-0"}}},
+		{"XPENDING group:s g", []any{1, "2-0", "2-0", []any{[]any{"alice", "1"}}}},
+		{"XREADGROUP GROUP g alice STREAMS group:s 0", []any{[]any{"group:s", []any{[]any{"2-0", []any{"b", "2"}}}}}},
+		{"XGROUP SETID group:s g 0", "OK"},
+		{"XREADGROUP GROUP g carol NOACK STREAMS group:s >", []any{[]any{"group:s", []any{
+			[]any{"1-0", []any{"a", "1"}},
+			[]any{"2-0", []any{"b", "2"}},
+		}}}},
+		{"XPENDING group:s g", []any{1, "2-0", "2-0", []any{[]any{"alice", "1"}}}},
+		{"XGROUP CREATECONSUMER group:s g dave", 1},
+		{"XGROUP DELCONSUMER group:s g dave", 0},
+		{"XGROUP DESTROY group:s g", 1},
+		{"XGROUP DESTROY group:s g", 0},
+		{"XGROUP SETID group:s g 0", resp.Error("NOGROUP No such consumer group 'g' for key name 'group:s'")},
+		{"XGROUP NOPE", resp.Error("ERR unknown subcommand or wrong number of arguments for 'NOPE'. Try XGROUP HELP.")},
+	}
+
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_BlockingStreams(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"XADD bstream:a 1-0 f v", "1-0"},
+		{"XREAD BLOCK 0 STREAMS bstream:a 0", []any{[]any{"bstream:a", []any{[]any{"1-0", []any{"f", "v"}}}}}},
+		{"XREAD BLOCK 10 STREAMS bstream:a $", nil},
+		{"XREAD BLOCK -1 STREAMS bstream:a $", resp.Error("ERR timeout is negative")},
+		{"XGROUP CREATE bstream:a g $", "OK"},
+		{"XREADGROUP GROUP g alice BLOCK 10 STREAMS bstream:a >", nil},
+	}
+	for _, tt := range tests {
+		got, err := send(tt.cmd)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	// every reader blocked on the stream is served by the same entry
+	var replies []chan any
+	for _, cmd := range []string{
+		"XREAD BLOCK 0 STREAMS bstream:none bstream:a 0 $",
+		"XREADGROUP GROUP g alice BLOCK 0 STREAMS bstream:a >",
+		"XREADGROUP GROUP g bob BLOCK 0 COUNT 1 STREAMS bstream:a >",
+	} {
+		waitBlocked := blockedClients(t) + 1
+		reply := make(chan any, 1)
+		replies = append(replies, reply)
+		go func(cmd string, replies chan any) {
+			c := client.New(client.Options{Addr: "localhost" + testPort})
+			defer c.Close()
+			reply, err := c.Do(context.Background(), strings.Split(cmd, " ")...)
+			if err != nil {
+				reply = err
+			}
+			replies <- reply
+		}(cmd, reply)
+		for blockedClients(t) < waitBlocked {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	send("XADD bstream:a 2-0 f v2")
+	entries := []any{[]any{"bstream:a", []any{[]any{"2-0", []any{"f", "v2"}}}}}
+	if got := <-replies[0]; !reflect.DeepEqual(got, entries) {
+		t.Errorf("got %q, want %q", got, entries)
+	}
+	if got := <-replies[1]; !reflect.DeepEqual(got, entries) {
+		t.Errorf("got %q, want %q", got, entries)
+	}
+	// the group already delivered the entry to alice
+	send("XADD bstream:a 3-0 f v3")
+	want := []any{[]any{"bstream:a", []any{[]any{"3-0", []any{"f", "v3"}}}}}
+	if got := <-replies[2]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	want = []any{2, "2-0", "3-0", []any{[]any{"alice", "1"}, []any{"bob", "1"}}}
+	if got, _ := send("XPENDING bstream:a g"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestServer_SaveAndLoad(t *testing.T) {
 	tests := []struct {
 		cmd  string
@@ -200,6 +1527,12 @@ func TestServer_SaveAndLoad(t *testing.T) {
 		// set db and save it to file
 		{"SET name JOHN", "OK"},
 		{"SET name2 JANE", "OK"},
+		{"SET saved:key a:b", "OK"},
+		{"RPUSH savedlist x y", 2},
+		{"HSET savedhash f v", 1},
+		{"SADD savedset m", 1},
+		{"ZADD savedzset 1.5 m", 1},
+		{"XADD savedstream 1-1 f v", "1-1"},
 		{"SAVE", "OK"},
 		// delete local db and verify that it is deleted
 		{"DEL name", 1},
@@ -210,6 +1543,12 @@ func TestServer_SaveAndLoad(t *testing.T) {
 		{"LOAD", "OK"},
 		{"EXISTS name", 1},
 		{"EXISTS name2", 1},
+		{"GET saved:key", "a:b"},
+		{"RPUSH savedlist z", 3},
+		{"HGET savedhash f", "v"},
+		{"SISMEMBER savedset m", 1},
+		{"ZSCORE savedzset m", "1.5"},
+		{"XLEN savedstream", 1},
 	}
 
 	for _, tt := range tests {
@@ -224,35 +1563,363 @@ func TestServer_SaveAndLoad(t *testing.T) {
 	}
 }
 
-// executed before every test
-func init() {
-	s := server.NewServer("8888")
-	go s.Run()
+func TestServer_Bgsave(t *testing.T) {
+	before, err := send("LASTSAVE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send("SET bgsave:key 1")
+	if got, err := send("BGSAVE"); got != "Background saving started" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	// wait for the save to complete
+	var info string
+	for i := 0; i < 100; i++ {
+		reply, err := send("INFO persistence")
+		if err != nil {
+			t.Fatal(err)
+		}
+		info = reply.(string)
+		if strings.Contains(info, "rdb_bgsave_in_progress:0") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, want := range []string{"# Persistence\r\n", "rdb_bgsave_in_progress:0\r\n", "rdb_last_bgsave_status:ok\r\n"} {
+		if !strings.Contains(info, want) {
+			t.Errorf("got %q, want %q", info, want)
+		}
+	}
+	if strings.Contains(info, "# Server") {
+		t.Errorf("got %q, want only the persistence section", info)
+	}
+	if after, _ := send("LASTSAVE"); after.(int) < before.(int) {
+		t.Errorf("got LASTSAVE %d before %d", after, before)
+	}
 }
 
-func send(cmd string) (any, error) {
-	respCmd, err := resp.Encode(cmd)
+func TestServer_CommandErrors(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"SET onlykey", "ERR wrong number of arguments for 'set' command"},
+		{"GET a b", "ERR wrong number of arguments for 'get' command"},
+		{"NOPE a", "ERR unknown command 'NOPE'"},
+		{"SHUTDOWN SAVE NOSAVE", "ERR syntax error"},
+		{"SHUTDOWN LATER", "ERR syntax error"},
+		{"BGSAVE NOW", "ERR syntax error"},
+	}
+
+	for _, tt := range tests {
+		_, err := send(tt.cmd)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("for command %q, got %v, want %q", tt.cmd, err, tt.want)
+		}
+	}
+}
+
+func TestServer_PersistentConnection(t *testing.T) {
+	conn := dial(t)
+	defer conn.Close()
+
+	// the connection stays open between commands
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"SET persistent 1", "+OK\r\n"},
+		{"GET persistent", "$1\r\n1\r\n"},
+		{"EXISTS persistent", ":1\r\n"},
+	}
+	for _, tt := range tests {
+		respCmd, _ := resp.Encode(tt.cmd)
+		if _, err := conn.Write([]byte(respCmd)); err != nil {
+			t.Fatalf("for command %q: %s", tt.cmd, err)
+		}
+		got := readN(t, conn, len(tt.want))
+		if got != tt.want {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestServer_Pipelining(t *testing.T) {
+	conn := dial(t)
+	defer conn.Close()
+
+	cmds := []string{"SET pipelined 10", "GET pipelined", "EXISTS pipelined nope", "DEL pipelined", "GET pipelined"}
+	want := "+OK\r\n$2\r\n10\r\n:1\r\n:1\r\n$-1\r\n"
+
+	// all the commands are sent in a single segment
+	var pipeline []byte
+	for _, cmd := range cmds {
+		respCmd, _ := resp.Encode(cmd)
+		pipeline = append(pipeline, respCmd...)
+	}
+	if _, err := conn.Write(pipeline); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readN(t, conn, len(want)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestServer_Hello(t *testing.T) {
+	conn := dial(t)
+	defer conn.Close()
+
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"GET hello", "$-1\r\n"},
+		{"HELLO 4", "-NOPROTO unsupported protocol version\r\n"},
+		{"HELLO 3 SETNAME conn", "%7\r\n$6\r\nserver\r\n"},
+		{"GET hello", "_\r\n"},
+		{"HELLO 2", "*14\r\n$6\r\nserver\r\n"},
+	}
+
+	// the start of each reply is checked, then the rest of it is skipped
+	rd := bufio.NewReader(conn)
+	r := resp.NewReader(rd)
+	for _, tt := range tests {
+		respCmd, _ := resp.Encode(tt.cmd)
+		if _, err := conn.Write([]byte(respCmd)); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		got, err := rd.Peek(len(tt.want))
+		if err != nil {
+			t.Fatalf("for command %q: %s", tt.cmd, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("for command %q, got %q, want %q", tt.cmd, got, tt.want)
+		}
+		if _, err = r.Read(); err != nil {
+			t.Fatalf("for command %q: %s", tt.cmd, err)
+		}
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	config := server.DefaultConfig()
+	config.Port = 8891
+	config.Dir = t.TempDir()
+	s := server.NewServerWithConfig(config)
+	done := make(chan error)
+	go func() { done <- s.Run(context.Background()) }()
+	waitForServer("localhost:8891")
+
+	idle, err := net.Dial("tcp", "localhost:8891")
 	if err != nil {
-		return "", err
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	// a blocked client is disconnected as well
+	idle.Write([]byte("*3\r\n$5\r\nBLPOP\r\n$4\r\nwait\r\n$1\r\n0\r\n"))
+	conn, err := net.Dial("tcp", "localhost:8891")
+	if err != nil {
+		t.Fatal(err)
 	}
-	conn, err := net.Dial("tcp", "localhost"+testPort)
 	defer conn.Close()
+
+	// the reply of the command sent before SHUTDOWN is delivered
+	conn.Write([]byte("*3\r\n$3\r\nSET\r\n$4\r\nlast\r\n$4\r\nsave\r\n*2\r\n$8\r\nSHUTDOWN\r\n$4\r\nSAVE\r\n"))
+	if got, want := readN(t, conn, 5), "+OK\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// then both connections are closed
+	for _, c := range []net.Conn{conn, idle} {
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		if n, err := c.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("got %d bytes, %v, want EOF", n, err)
+		}
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+	if _, err := os.Stat(filepath.Join(config.Dir, config.DBFilename)); err != nil {
+		t.Errorf("no final snapshot: %s", err)
+	}
+}
+
+func TestServer_RunContext(t *testing.T) {
+	config := server.DefaultConfig()
+	config.Port = 8892
+	config.Dir = t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.NewServerWithConfig(config).Run(ctx) }()
+	waitForServer("localhost:8892")
+
+	conn, err := net.Dial("tcp", "localhost:8892")
 	if err != nil {
-		return "", err
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %d bytes, %v, want EOF", n, err)
+	}
+	if _, err := net.Dial("tcp", "localhost:8892"); err == nil {
+		t.Error("the listener is still open")
+	}
+	// with a save policy the dataset is saved as on SHUTDOWN
+	if _, err := os.Stat(filepath.Join(config.Dir, config.DBFilename)); err != nil {
+		t.Errorf("no final snapshot: %s", err)
 	}
-	_, err = conn.Write([]byte(respCmd))
+}
+
+func TestServer_RunAddressInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:8894")
 	if err != nil {
-		return "", err
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	config := server.DefaultConfig()
+	config.Port = 8894
+	config.Dir = t.TempDir()
+	config.AppendOnly = true
+	// the incomplete command at the end would be truncated by the load
+	aof := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n*3\r\n$3\r\nSET\r\n"
+	path := filepath.Join(config.Dir, config.AppendFilename)
+	if err := os.WriteFile(path, []byte(aof), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.NewServerWithConfig(config).Run(context.Background()); err == nil {
+		t.Fatal("Run didn't fail with the address in use")
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != aof {
+		t.Errorf("got %q, %v, want the AOF unchanged", got, err)
 	}
-	buf := make([]byte, 0124)
-	_, err = conn.Read(buf)
+}
+
+// executed before every test
+func init() {
+	config := server.DefaultConfig()
+	config.Port = 8888
+	// the snapshots are written out of the source tree
+	dir, err := os.MkdirTemp("", "redis_server")
 	if err != nil {
-		return "", err
+		panic(err)
 	}
+	config.Dir = dir
 
-	response, err := resp.Decode(buf)
+	s := server.NewServerWithConfig(config)
+	go s.Run(context.Background())
+	waitForServer("localhost" + testPort)
+}
+
+// waitForServer waits for the server to accept connections
+func waitForServer(addr string) {
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func dial(t *testing.T) net.Conn {
+	conn, err := net.Dial("tcp", "localhost"+testPort)
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func readN(t *testing.T, conn net.Conn, n int) string {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read %q: %s", buf, err)
+	}
+	return string(buf)
+}
+
+func send(cmd string) (any, error) {
+	return testClient.Do(context.Background(), strings.Split(cmd, " ")...)
+}
+
+func TestServer_AppendOnly(t *testing.T) {
+	config := server.DefaultConfig()
+	config.Port = 8893
+	config.Dir = t.TempDir()
+	config.Save = nil
+	config.AppendOnly = true
+	config.AppendFsync = "always"
+	addr := "localhost:8893"
+	c := client.New(client.Options{Addr: addr})
+	do := func(cmd string) (any, error) {
+		return c.Do(context.Background(), strings.Split(cmd, " ")...)
+	}
+
+	s := server.NewServerWithConfig(config)
+	go s.Run(context.Background())
+	waitForServer(addr)
+	for _, cmd := range []string{"SET a 1", "INCR a", "RPUSH list x y", "SET gone 1", "DEL gone", "SET ttl v EX 100"} {
+		if _, err := do(cmd); err != nil {
+			t.Fatalf("%s: %s", cmd, err)
+		}
+	}
+	if got, err := do("BGREWRITEAOF"); got != "Background append only file rewriting started" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	// the commands executed during the rewrite are appended to the new file
+	do("LPUSH list w")
+	for i := 0; i < 100; i++ {
+		info, _ := do("INFO persistence")
+		if strings.Contains(info.(string), "aof_rewrite_in_progress:0") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	do("INCR a")
+	s.Close()
+	c.Close()
+
+	// the dataset is loaded from the AOF on restart
+	s = server.NewServerWithConfig(config)
+	go s.Run(context.Background())
+	defer s.Close()
+	waitForServer(addr)
+	c = client.New(client.Options{Addr: addr})
+	defer c.Close()
+	tests := []struct {
+		cmd  string
+		want any
+	}{
+		{"GET a", "3"},
+		{"GET ttl", "v"},
+		{"EXISTS gone", 0},
+		{"RPUSH list z", 4},
+	}
+	for _, tt := range tests {
+		if got, err := do(tt.cmd); err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, %v, want %v", tt.cmd, got, err, tt.want)
+		}
 	}
-	return response, nil
 }
@@ -0,0 +1,457 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"math/rand"
+	"strconv"
+)
+
+const (
+	SADD        = "SADD"
+	SREM        = "SREM"
+	SISMEMBER   = "SISMEMBER"
+	SMISMEMBER  = "SMISMEMBER"
+	SMEMBERS    = "SMEMBERS"
+	SCARD       = "SCARD"
+	SPOP        = "SPOP"
+	SRANDMEMBER = "SRANDMEMBER"
+	SINTER      = "SINTER"
+	SINTERSTORE = "SINTERSTORE"
+	SUNION      = "SUNION"
+	SUNIONSTORE = "SUNIONSTORE"
+	SDIFF       = "SDIFF"
+	SDIFFSTORE  = "SDIFFSTORE"
+	SSCAN       = "SSCAN"
+)
+
+// set is an unordered set of members, the commands modify it in place, see touchKey
+type set struct {
+	dict map[string]struct{}
+	// scan orders the members for SSCAN, nil until its first call
+	scan *scanIndex
+}
+
+func newSet() *set {
+	return &set{dict: make(map[string]struct{})}
+}
+
+// len returns the number of members, 0 for a nil set
+func (st *set) len() int {
+	if st == nil {
+		return 0
+	}
+	return len(st.dict)
+}
+
+// has reports whether member is in the set, false for a nil set
+func (st *set) has(member string) bool {
+	if st == nil {
+		return false
+	}
+	_, exists := st.dict[member]
+	return exists
+}
+
+// add adds member and reports whether it wasn't in the set
+func (st *set) add(member string) bool {
+	if _, exists := st.dict[member]; exists {
+		return false
+	}
+	st.dict[member] = struct{}{}
+	st.scan.add(member)
+	return true
+}
+
+// remove removes member and reports whether it was in the set
+func (st *set) remove(member string) bool {
+	if _, exists := st.dict[member]; !exists {
+		return false
+	}
+	delete(st.dict, member)
+	st.scan.remove(member)
+	return true
+}
+
+func (st *set) clone() *set {
+	clone := &set{dict: make(map[string]struct{}, len(st.dict))}
+	for member := range st.dict {
+		clone.dict[member] = struct{}{}
+	}
+	return clone
+}
+
+// members returns the members, none for a nil set
+func (st *set) members() []string {
+	members := make([]string, 0, st.len())
+	if st != nil {
+		for member := range st.dict {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// lookupSet returns the set at key, nil if the key doesn't exist.
+// ok is false if the key holds another type.
+func (s *Server) lookupSet(key string) (st *set, ok bool) {
+	val, exists := s.lookupKey(key)
+	if !exists {
+		return nil, true
+	}
+	st, ok = val.value.(*set)
+	return st, ok
+}
+
+// setForWrite returns the set at key to be modified, created if the key
+// doesn't exist. ok is false if the key holds another type.
+func (s *Server) setForWrite(key string) (st *set, ok bool) {
+	st, ok = s.lookupSet(key)
+	if !ok {
+		return nil, false
+	}
+	if st == nil {
+		st = newSet()
+		s.setKey(key, RedisValue{value: st})
+	} else {
+		s.touchKey(key)
+	}
+	return st, true
+}
+
+// SADD key member [member ...] adds the members,
+// returns the number of members that weren't in the set
+func (s *Server) handleSAdd(c *client, args []string) resp.Value {
+	st, ok := s.setForWrite(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	added := 0
+	for _, member := range args[2:] {
+		if st.add(member) {
+			added++
+		}
+	}
+	return resp.NewInteger(int64(added))
+}
+
+// SREM key member [member ...] removes the members, the key is deleted with
+// the last one. Returns the number of members removed.
+func (s *Server) handleSRem(c *client, args []string) resp.Value {
+	key := args[1]
+	st, ok := s.lookupSet(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	removed := 0
+	for _, member := range args[2:] {
+		if !st.has(member) {
+			continue
+		}
+		if removed == 0 {
+			s.touchKey(key)
+		}
+		st.remove(member)
+		removed++
+	}
+	if removed > 0 && st.len() == 0 {
+		s.deleteKey(key)
+	}
+	return resp.NewInteger(int64(removed))
+}
+
+// SISMEMBER key member returns 1 if member is in the set
+func (s *Server) handleSIsMember(c *client, args []string) resp.Value {
+	st, ok := s.lookupSet(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if st.has(args[2]) {
+		return resp.NewInteger(1)
+	}
+	return resp.NewInteger(0)
+}
+
+// SMISMEMBER key member [member ...] returns 1 or 0 for each member
+// depending on whether it is in the set
+func (s *Server) handleSMIsMember(c *client, args []string) resp.Value {
+	st, ok := s.lookupSet(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	values := make([]resp.Value, 0, len(args)-2)
+	for _, member := range args[2:] {
+		n := int64(0)
+		if st.has(member) {
+			n = 1
+		}
+		values = append(values, resp.NewInteger(n))
+	}
+	return resp.NewArray(values...)
+}
+
+// SMEMBERS key returns the members, as a set under RESP3
+func (s *Server) handleSMembers(c *client, args []string) resp.Value {
+	st, ok := s.lookupSet(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	return newSetReply(st)
+}
+
+// SCARD key returns the number of members
+func (s *Server) handleSCard(c *client, args []string) resp.Value {
+	st, ok := s.lookupSet(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	return resp.NewInteger(int64(st.len()))
+}
+
+// SPOP key [count] removes and returns random members, a bulk string without
+// count, an array with count. Nil when the key doesn't exist.
+func (s *Server) handleSPop(c *client, args []string) resp.Value {
+	if len(args) > 3 {
+		return resp.NewError("ERR syntax error")
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return resp.NewError("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	key := args[1]
+	st, ok := s.lookupSet(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	if st == nil {
+		if len(args) == 3 {
+			return resp.NewArray()
+		}
+		return resp.NewNull()
+	}
+
+	popped := randomMembers(st, count)
+	if len(popped) > 0 {
+		s.touchKey(key)
+	}
+	for _, member := range popped {
+		st.remove(member)
+	}
+	if st.len() == 0 {
+		s.deleteKey(key)
+	}
+	// the members are chosen at random, the replay must remove the same ones
+	c.propagate = append([]string{SREM, key}, popped...)
+
+	if len(args) == 2 {
+		return resp.NewBulkString(popped[0])
+	}
+	return resp.NewBulkStringArray(popped)
+}
+
+// SRANDMEMBER key [count] returns random members without removing them, a
+// bulk string without count, an array with count. A negative count may
+// return the same member several times.
+func (s *Server) handleSRandMember(c *client, args []string) resp.Value {
+	if len(args) > 3 {
+		return resp.NewError("ERR syntax error")
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return resp.NewError("ERR " + notIntegerErr.Error())
+		}
+		count = n
+	}
+
+	st, ok := s.lookupSet(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if st == nil {
+		if len(args) == 3 {
+			return resp.NewArray()
+		}
+		return resp.NewNull()
+	}
+
+	if len(args) == 2 {
+		return resp.NewBulkString(randomMembers(st, 1)[0])
+	}
+	if count >= 0 {
+		return resp.NewBulkStringArray(randomMembers(st, count))
+	}
+	members := st.members()
+	values := make([]string, 0, -count)
+	for ; count < 0; count++ {
+		values = append(values, members[rand.Intn(len(members))])
+	}
+	return resp.NewBulkStringArray(values)
+}
+
+// randomMembers returns count distinct members chosen at random,
+// all of them in a random order if the set is smaller
+func randomMembers(st *set, count int) []string {
+	members := st.members()
+	if count > len(members) {
+		count = len(members)
+	}
+	// partial Fisher-Yates shuffle
+	for i := 0; i < count; i++ {
+		j := i + rand.Intn(len(members)-i)
+		members[i], members[j] = members[j], members[i]
+	}
+	return members[:count]
+}
+
+// the operations of the set algebra commands
+const (
+	setInter = iota
+	setUnion
+	setDiff
+)
+
+// combineSets returns the intersection, the union or the difference of the
+// sets at keys, a missing key is an empty set. ok is false if one of the keys
+// holds another type.
+func (s *Server) combineSets(keys []string, op int) (result *set, ok bool) {
+	sets := make([]*set, 0, len(keys))
+	for _, key := range keys {
+		st, ok := s.lookupSet(key)
+		if !ok {
+			return nil, false
+		}
+		if st == nil {
+			st = newSet()
+		}
+		sets = append(sets, st)
+	}
+
+	result = newSet()
+	switch op {
+	case setInter:
+		for member := range sets[0].dict {
+			in := true
+			for _, other := range sets[1:] {
+				if !other.has(member) {
+					in = false
+					break
+				}
+			}
+			if in {
+				result.add(member)
+			}
+		}
+	case setUnion:
+		for _, st := range sets {
+			for member := range st.dict {
+				result.add(member)
+			}
+		}
+	case setDiff:
+		for member := range sets[0].dict {
+			result.add(member)
+		}
+		for _, other := range sets[1:] {
+			for member := range other.dict {
+				result.remove(member)
+			}
+		}
+	}
+	return result, true
+}
+
+// SINTER key [key ...] returns the members of all the sets
+func (s *Server) handleSInter(c *client, args []string) resp.Value {
+	return s.setAlgebra(args[1:], setInter)
+}
+
+// SUNION key [key ...] returns the members of any of the sets
+func (s *Server) handleSUnion(c *client, args []string) resp.Value {
+	return s.setAlgebra(args[1:], setUnion)
+}
+
+// SDIFF key [key ...] returns the members of the first set
+// that aren't in the other ones
+func (s *Server) handleSDiff(c *client, args []string) resp.Value {
+	return s.setAlgebra(args[1:], setDiff)
+}
+
+func (s *Server) setAlgebra(keys []string, op int) resp.Value {
+	result, ok := s.combineSets(keys, op)
+	if !ok {
+		return wrongTypeReply
+	}
+	return newSetReply(result)
+}
+
+// SINTERSTORE destination key [key ...] is SINTER, it stores the result at
+// destination
+func (s *Server) handleSInterStore(c *client, args []string) resp.Value {
+	return s.setAlgebraStore(args[1], args[2:], setInter)
+}
+
+// SUNIONSTORE destination key [key ...] is SUNION, it stores the result at
+// destination
+func (s *Server) handleSUnionStore(c *client, args []string) resp.Value {
+	return s.setAlgebraStore(args[1], args[2:], setUnion)
+}
+
+// SDIFFSTORE destination key [key ...] is SDIFF, it stores the result at
+// destination
+func (s *Server) handleSDiffStore(c *client, args []string) resp.Value {
+	return s.setAlgebraStore(args[1], args[2:], setDiff)
+}
+
+// setAlgebraStore replaces destination, whatever its type, with the result of
+// the operation, an empty result deletes it. Returns the number of members.
+func (s *Server) setAlgebraStore(destination string, keys []string, op int) resp.Value {
+	result, ok := s.combineSets(keys, op)
+	if !ok {
+		return wrongTypeReply
+	}
+	if result.len() == 0 {
+		s.deleteKey(destination)
+	} else {
+		s.setKey(destination, RedisValue{value: result})
+	}
+	return resp.NewInteger(int64(result.len()))
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count] iterates the members,
+// see scanIndex
+func (s *Server) handleSScan(c *client, args []string) resp.Value {
+	opts, err := parseScanOptions(args[2:])
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	st, ok := s.lookupSet(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if st == nil {
+		return resp.NewArray(resp.NewBulkString("0"), resp.NewArray())
+	}
+	if st.scan == nil {
+		st.scan = newScanIndex(st.members())
+	}
+	batch, cursor := st.scan.scan(opts)
+	return resp.NewArray(
+		resp.NewBulkString(strconv.FormatUint(cursor, 10)),
+		resp.NewBulkStringArray(batch),
+	)
+}
+
+// newSetReply returns the members of st, as a set under RESP3
+func newSetReply(st *set) resp.Value {
+	values := make([]resp.Value, 0, st.len())
+	for _, member := range st.members() {
+		values = append(values, resp.NewBulkString(member))
+	}
+	return resp.NewSet(values...)
+}
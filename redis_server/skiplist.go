@@ -0,0 +1,266 @@
+package server
+
+import "math/rand"
+
+// A sorted set is a dict from the members to their scores and a skiplist of
+// the members ordered by score, then lexicographically, as in Redis: the
+// dict gives the score of a member in O(1), the skiplist finds a score, a
+// member or a rank in O(log n). Each link of the skiplist records the number
+// of nodes it skips, its span, which gives the rank of the nodes it reaches.
+
+const (
+	skiplistMaxLevel = 32
+	// probability that a node of level i also has level i+1
+	skiplistP = 0.25
+)
+
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int
+}
+
+type skiplist struct {
+	header *skiplistNode // sentinel before the first node
+	tail   *skiplistNode
+	length int
+	level  int // the highest level of the nodes
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skiplistNode{level: make([]skiplistLevel, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// before reports whether node comes before the element (score, member)
+func (n *skiplistNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// after reports whether node comes after the element (score, member)
+func (n *skiplistNode) after(score float64, member string) bool {
+	return n.score > score || (n.score == score && n.member > member)
+}
+
+// insert adds the element (score, member), member must not be in the skiplist
+func (zsl *skiplist) insert(score float64, member string) {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+	x = &skiplistNode{member: member, score: score, level: make([]skiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		// rank[0] is the rank of the node before x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	// the higher links now skip x too
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+}
+
+// delete removes the element (score, member) and reports whether it existed
+func (zsl *skiplist) delete(score float64, member string) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	return true
+}
+
+// rank returns the 0-based rank of the element (score, member), which must
+// be in the skiplist
+func (zsl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !x.level[i].forward.after(score, member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+	}
+	return rank - 1
+}
+
+// byRank returns the node of 0-based rank, nil if it's out of range
+func (zsl *skiplist) byRank(rank int) *skiplistNode {
+	if rank < 0 || rank >= zsl.length {
+		return nil
+	}
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank+1 {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// zrange is a range of elements, by score or lexicographical
+type zrange interface {
+	// aboveMin reports whether the node is after the start of the range
+	aboveMin(n *skiplistNode) bool
+	// belowMax reports whether the node is before the end of the range
+	belowMax(n *skiplistNode) bool
+}
+
+// firstInRange returns the first node in the range, nil if there is none
+func (zsl *skiplist) firstInRange(r zrange) *skiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.aboveMin(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if x == nil || !r.belowMax(x) {
+		return nil
+	}
+	return x
+}
+
+// lastInRange returns the last node in the range, nil if there is none
+func (zsl *skiplist) lastInRange(r zrange) *skiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.belowMax(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header || !r.aboveMin(x) {
+		return nil
+	}
+	return x
+}
+
+// zset is a sorted set, the commands modify it in place, see touchKey
+type zset struct {
+	dict map[string]float64
+	zsl  *skiplist
+}
+
+func newZset() *zset {
+	return &zset{dict: make(map[string]float64), zsl: newSkiplist()}
+}
+
+func (z *zset) len() int {
+	return len(z.dict)
+}
+
+// add sets the score of member, it is added if it isn't in the set
+func (z *zset) add(member string, score float64) {
+	if old, ok := z.dict[member]; ok {
+		if old == score {
+			return
+		}
+		z.zsl.delete(old, member)
+	}
+	z.dict[member] = score
+	z.zsl.insert(score, member)
+}
+
+// remove removes member and reports whether it was in the set
+func (z *zset) remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	delete(z.dict, member)
+	z.zsl.delete(score, member)
+	return true
+}
+
+// rank returns the 0-based rank of member, ok is false if it isn't in the set
+func (z *zset) rank(member string) (rank int, ok bool) {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0, false
+	}
+	return z.zsl.rank(score, member), true
+}
+
+func (z *zset) clone() *zset {
+	clone := newZset()
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		clone.add(x.member, x.score)
+	}
+	return clone
+}
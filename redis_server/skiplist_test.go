@@ -0,0 +1,81 @@
+package server
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestSkiplist(t *testing.T) {
+	z := newZset()
+	scores := make(map[string]float64)
+	// few distinct scores so that the members break the ties
+	for i := 0; i < 2000; i++ {
+		member := strconv.Itoa(rand.Intn(300))
+		if rand.Intn(3) == 0 {
+			z.remove(member)
+			delete(scores, member)
+		} else {
+			score := float64(rand.Intn(20))
+			z.add(member, score)
+			scores[member] = score
+		}
+	}
+
+	type element struct {
+		member string
+		score  float64
+	}
+	var want []element
+	for member, score := range scores {
+		want = append(want, element{member, score})
+	}
+	sort.Slice(want, func(i, j int) bool {
+		return want[i].score < want[j].score ||
+			(want[i].score == want[j].score && want[i].member < want[j].member)
+	})
+
+	if z.len() != len(want) || z.zsl.length != len(want) {
+		t.Fatalf("got %d members and %d nodes, want %d", z.len(), z.zsl.length, len(want))
+	}
+	var prev *skiplistNode
+	for i, e := range want {
+		x := z.zsl.byRank(i)
+		if x == nil || x.member != e.member || x.score != e.score {
+			t.Fatalf("at rank %d, got %+v, want %+v", i, x, e)
+		}
+		if rank, _ := z.rank(e.member); rank != i {
+			t.Errorf("got rank %d for %s, want %d", rank, e.member, i)
+		}
+		if x.backward != prev {
+			t.Errorf("wrong backward link at rank %d", i)
+		}
+		prev = x
+	}
+	if z.zsl.tail != prev {
+		t.Errorf("wrong tail")
+	}
+
+	r := scoreRange{min: 5, max: 10, minex: true}
+	first, last := z.zsl.firstInRange(r), z.zsl.lastInRange(r)
+	for i, e := range want {
+		if e.score > 5 {
+			if first != z.zsl.byRank(i) {
+				t.Errorf("got first %+v, want %+v", first, e)
+			}
+			break
+		}
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		if want[i].score <= 10 {
+			if last != z.zsl.byRank(i) {
+				t.Errorf("got last %+v, want %+v", last, want[i])
+			}
+			break
+		}
+	}
+	if x := z.zsl.firstInRange(scoreRange{min: 30, max: 40}); x != nil {
+		t.Errorf("got %+v in an empty range", x)
+	}
+}
@@ -0,0 +1,650 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	XADD      = "XADD"
+	XLEN      = "XLEN"
+	XRANGE    = "XRANGE"
+	XREVRANGE = "XREVRANGE"
+	XTRIM     = "XTRIM"
+	XDEL      = "XDEL"
+	XREAD     = "XREAD"
+)
+
+// A stream is a log of entries identified by increasing IDs made of a
+// timestamp in milliseconds and a sequence number. The entries are kept in a
+// slice sorted by ID: they are appended at the end and mostly trimmed at the
+// start, the deletions in the middle are O(n). The consumer groups record
+// the entries delivered to their consumers until they acknowledge them, see
+// stream_group.go.
+
+var invalidStreamIDErr = errors.New("Invalid stream ID specified as stream command argument")
+
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+// next returns the smallest ID greater than id, ok is false if id is the greatest
+func (id streamID) next() (next streamID, ok bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	default:
+		return id, false
+	}
+}
+
+// parseStreamID parses an ID ms-seq, seq is missingSeq if the ID is only ms
+func parseStreamID(arg string, missingSeq uint64) (streamID, error) {
+	msArg, seqArg, hasSeq := strings.Cut(arg, "-")
+	ms, err := strconv.ParseUint(msArg, 10, 64)
+	if err != nil {
+		return streamID{}, invalidStreamIDErr
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqArg, 10, 64)
+	if err != nil {
+		return streamID{}, invalidStreamIDErr
+	}
+	return streamID{ms, seq}, nil
+}
+
+// parseRangeID parses a bound of XRANGE: - and + are the smallest and the
+// greatest IDs, a missing sequence number is missingSeq, and the bound is
+// excluded with the prefix (
+func parseRangeID(arg string, missingSeq uint64) (id streamID, exclusive bool, err error) {
+	switch arg {
+	case "-":
+		return streamID{}, false, nil
+	case "+":
+		return maxStreamID, false, nil
+	}
+	if strings.HasPrefix(arg, "(") {
+		exclusive = true
+		arg = arg[1:]
+	}
+	id, err = parseStreamID(arg, missingSeq)
+	return id, exclusive, err
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string // the fields and their values, one after another
+}
+
+// stream is a stream of entries, the commands modify it in place, see touchKey
+type stream struct {
+	entries []streamEntry
+	lastID  streamID // the ID of the last entry added, even if it was deleted
+	groups  map[string]*consumerGroup
+}
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*consumerGroup)}
+}
+
+func (st *stream) len() int {
+	return len(st.entries)
+}
+
+// search returns the index of the first entry whose ID is id or greater
+func (st *stream) search(id streamID) int {
+	return sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].id.less(id)
+	})
+}
+
+// lookup returns the entry of ID id, nil if there is none
+func (st *stream) lookup(id streamID) *streamEntry {
+	i := st.search(id)
+	if i == len(st.entries) || st.entries[i].id != id {
+		return nil
+	}
+	return &st.entries[i]
+}
+
+// after returns at most count entries whose ID is greater than id,
+// all of them if count is 0
+func (st *stream) after(id streamID, count int) []streamEntry {
+	next, ok := id.next()
+	if !ok {
+		return nil
+	}
+	entries := st.entries[st.search(next):]
+	if count > 0 && len(entries) > count {
+		entries = entries[:count]
+	}
+	return entries
+}
+
+// remove deletes the entry of ID id and reports whether it existed
+func (st *stream) remove(id streamID) bool {
+	i := st.search(id)
+	if i == len(st.entries) || st.entries[i].id != id {
+		return false
+	}
+	st.entries = append(st.entries[:i], st.entries[i+1:]...)
+	return true
+}
+
+// trimOptions are the trimming arguments of XADD and XTRIM
+type trimOptions struct {
+	maxLen int64    // with MAXLEN, -1 otherwise
+	minID  streamID // with MINID
+	limit  int64    // maximum number of entries removed, 0 for no limit
+}
+
+// parseTrimOptions parses MAXLEN|MINID [=|~] threshold [LIMIT count] at
+// args[i], and returns the index of the argument that follows.
+// The trimming is always exact, ~ only allows LIMIT.
+func parseTrimOptions(args []string, i int) (trimOptions, int, error) {
+	opts := trimOptions{maxLen: -1}
+	strategy := strings.ToUpper(args[i])
+	i++
+	approx := false
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return opts, i, syntaxErr
+	}
+	if strategy == "MAXLEN" {
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return opts, i, notIntegerErr
+		}
+		if n < 0 {
+			return opts, i, errors.New("The MAXLEN argument must be >= 0.")
+		}
+		opts.maxLen = n
+	} else {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			return opts, i, err
+		}
+		opts.minID = id
+	}
+	i++
+	if i+1 < len(args) && strings.EqualFold(args[i], "LIMIT") {
+		if !approx {
+			return opts, i, errors.New("syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || n < 0 {
+			return opts, i, errors.New("The LIMIT argument must be >= 0.")
+		}
+		opts.limit = n
+		i += 2
+	}
+	return opts, i, nil
+}
+
+// trim removes the first entries as opts tells, returns the number removed
+func (st *stream) trim(opts trimOptions) int {
+	n := 0
+	for n < len(st.entries) {
+		if opts.limit > 0 && int64(n) == opts.limit {
+			break
+		}
+		if opts.maxLen >= 0 && int64(len(st.entries)-n) <= opts.maxLen {
+			break
+		}
+		if opts.maxLen < 0 && !st.entries[n].id.less(opts.minID) {
+			break
+		}
+		n++
+	}
+	st.entries = append(st.entries[:0:0], st.entries[n:]...)
+	return n
+}
+
+// clone copies the entries and the groups, the fields of the entries are
+// never modified
+func (st *stream) clone() *stream {
+	clone := &stream{
+		entries: append([]streamEntry(nil), st.entries...),
+		lastID:  st.lastID,
+		groups:  make(map[string]*consumerGroup, len(st.groups)),
+	}
+	for name, g := range st.groups {
+		clone.groups[name] = g.clone()
+	}
+	return clone
+}
+
+// lookupStream returns the stream at key, nil if the key doesn't exist.
+// ok is false if the key holds another type.
+func (s *Server) lookupStream(key string) (st *stream, ok bool) {
+	val, exists := s.lookupKey(key)
+	if !exists {
+		return nil, true
+	}
+	st, ok = val.value.(*stream)
+	return st, ok
+}
+
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id
+// field value [field value ...] appends an entry and returns its ID. The ID
+// is generated from the current time with *, or from the timestamp ms with
+// ms-*. NOMKSTREAM doesn't create the stream, nil is returned instead.
+func (s *Server) handleXAdd(c *client, args []string) resp.Value {
+	noMkStream := false
+	trim := trimOptions{maxLen: -1}
+	trimming := false
+	i := 2
+	for ; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); option {
+		case "NOMKSTREAM":
+			noMkStream = true
+			continue
+		case "MAXLEN", "MINID":
+			var err error
+			trim, i, err = parseTrimOptions(args, i)
+			if err != nil {
+				return resp.NewError("ERR " + err.Error())
+			}
+			trimming = true
+			i--
+			continue
+		}
+		break
+	}
+	if i >= len(args) || (len(args)-i-1)%2 != 0 || len(args)-i-1 == 0 {
+		return resp.NewError("ERR wrong number of arguments for 'xadd' command")
+	}
+
+	key := args[1]
+	st, ok := s.lookupStream(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	var lastID streamID
+	if st != nil {
+		lastID = st.lastID
+	}
+	id, err := nextStreamID(args[i], lastID)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	if st == nil {
+		if noMkStream {
+			return resp.NewNull()
+		}
+		st = newStream()
+		s.setKey(key, RedisValue{value: st})
+	} else {
+		s.touchKey(key)
+	}
+
+	st.entries = append(st.entries, streamEntry{id: id, fields: append([]string(nil), args[i+1:]...)})
+	st.lastID = id
+	if trimming {
+		st.trim(trim)
+	}
+	s.signalReady(key)
+
+	// the generated ID is replicated
+	c.propagate = append([]string(nil), args...)
+	c.propagate[i] = id.String()
+	return resp.NewBulkString(id.String())
+}
+
+// nextStreamID returns the ID of a new entry given by arg, after lastID
+func nextStreamID(arg string, lastID streamID) (streamID, error) {
+	exhausted := errors.New("The stream has exhausted the last possible ID, unable to add more items")
+	smaller := errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	if arg == "*" {
+		now := uint64(time.Now().UnixMilli())
+		if now > lastID.ms {
+			return streamID{now, 0}, nil
+		}
+		id, ok := lastID.next()
+		if !ok {
+			return streamID{}, exhausted
+		}
+		return id, nil
+	}
+
+	if msArg, found := strings.CutSuffix(arg, "-*"); found {
+		ms, err := strconv.ParseUint(msArg, 10, 64)
+		if err != nil {
+			return streamID{}, invalidStreamIDErr
+		}
+		switch {
+		case ms > lastID.ms:
+			return streamID{ms, 0}, nil
+		case ms < lastID.ms || lastID.seq == math.MaxUint64:
+			return streamID{}, smaller
+		}
+		return streamID{ms, lastID.seq + 1}, nil
+	}
+
+	id, err := parseStreamID(arg, 0)
+	if err != nil {
+		return streamID{}, err
+	}
+	if id == (streamID{}) {
+		return streamID{}, errors.New("The ID specified in XADD must be greater than 0-0")
+	}
+	if !lastID.less(id) {
+		return streamID{}, smaller
+	}
+	return id, nil
+}
+
+// XLEN key returns the number of entries
+func (s *Server) handleXLen(c *client, args []string) resp.Value {
+	st, ok := s.lookupStream(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if st == nil {
+		return resp.NewInteger(0)
+	}
+	return resp.NewInteger(int64(st.len()))
+}
+
+// XRANGE key start end [COUNT count] returns the entries between start and end
+func (s *Server) handleXRange(c *client, args []string) resp.Value {
+	return s.xrange(args, false)
+}
+
+// XREVRANGE key end start [COUNT count] returns the entries between end and
+// start, in the reverse order
+func (s *Server) handleXRevRange(c *client, args []string) resp.Value {
+	return s.xrange(args, true)
+}
+
+func (s *Server) xrange(args []string, rev bool) resp.Value {
+	startArg, endArg := args[2], args[3]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, startex, err := parseRangeID(startArg, 0)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	end, endex, err := parseRangeID(endArg, math.MaxUint64)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	count := -1
+	if len(args) == 6 && strings.EqualFold(args[4], "COUNT") {
+		if count, err = strconv.Atoi(args[5]); err != nil {
+			return resp.NewError("ERR " + notIntegerErr.Error())
+		}
+		if count < 0 {
+			count = 0
+		}
+	} else if len(args) != 4 {
+		return resp.NewError("ERR syntax error")
+	}
+
+	st, ok := s.lookupStream(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if st == nil || count == 0 {
+		return resp.NewArray()
+	}
+	if startex {
+		if start, ok = start.next(); !ok {
+			return resp.NewArray()
+		}
+	}
+	if endex {
+		if end == (streamID{}) {
+			return resp.NewArray()
+		}
+		if end.seq > 0 {
+			end.seq--
+		} else {
+			end = streamID{end.ms - 1, math.MaxUint64}
+		}
+	}
+
+	entries := st.entries[st.search(start):]
+	entries = entries[:sort.Search(len(entries), func(i int) bool {
+		return end.less(entries[i].id)
+	})]
+	values := make([]resp.Value, 0, len(entries))
+	for i := range entries {
+		if count >= 0 && len(values) == count {
+			break
+		}
+		entry := entries[i]
+		if rev {
+			entry = entries[len(entries)-1-i]
+		}
+		values = append(values, entryReply(entry))
+	}
+	return resp.NewArray(values...)
+}
+
+// entryReply returns an entry as its ID and its fields
+func entryReply(entry streamEntry) resp.Value {
+	return resp.NewArray(resp.NewBulkString(entry.id.String()), resp.NewBulkStringArray(entry.fields))
+}
+
+// XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count] removes the first
+// entries until there are at most MAXLEN of them, or until the first one has
+// the ID MINID or a greater one. Returns the number of entries removed.
+func (s *Server) handleXTrim(c *client, args []string) resp.Value {
+	strategy := strings.ToUpper(args[2])
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return resp.NewError("ERR syntax error")
+	}
+	opts, i, err := parseTrimOptions(args, 2)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	if i != len(args) {
+		return resp.NewError("ERR syntax error")
+	}
+
+	key := args[1]
+	st, ok := s.lookupStream(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	if st == nil {
+		return resp.NewInteger(0)
+	}
+	s.touchKey(key)
+	return resp.NewInteger(int64(st.trim(opts)))
+}
+
+// XDEL key id [id ...] removes the entries, returns the number removed.
+// The stream is kept once empty.
+func (s *Server) handleXDel(c *client, args []string) resp.Value {
+	ids := make([]streamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return resp.NewError("ERR " + err.Error())
+		}
+		ids = append(ids, id)
+	}
+
+	key := args[1]
+	st, ok := s.lookupStream(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	removed := 0
+	for _, id := range ids {
+		if st == nil || st.lookup(id) == nil {
+			continue
+		}
+		if removed == 0 {
+			s.touchKey(key)
+		}
+		st.remove(id)
+		removed++
+	}
+	return resp.NewInteger(int64(removed))
+}
+
+// streamReadOptions are the arguments of XREAD and XREADGROUP
+type streamReadOptions struct {
+	count    int
+	block    bool
+	timeout  time.Duration
+	noAck    bool
+	keys     []string
+	ids      []string
+	group    string
+	consumer string
+}
+
+// parseStreamReadOptions parses [COUNT count] [BLOCK milliseconds] [NOACK]
+// STREAMS key [key ...] id [id ...] from args[i]
+func parseStreamReadOptions(args []string, i int, group bool) (opts streamReadOptions, err error) {
+	cmdName := strings.ToLower(args[0])
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 == len(args) {
+				return opts, syntaxErr
+			}
+			i++
+			if opts.count, err = strconv.Atoi(args[i]); err != nil {
+				return opts, notIntegerErr
+			}
+			if opts.count < 0 {
+				opts.count = 0
+			}
+		case "BLOCK":
+			if i+1 == len(args) {
+				return opts, syntaxErr
+			}
+			i++
+			ms, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return opts, errors.New("timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return opts, errors.New("timeout is negative")
+			}
+			opts.block = true
+			opts.timeout = time.Duration(ms) * time.Millisecond
+		case "NOACK":
+			if !group {
+				return opts, syntaxErr
+			}
+			opts.noAck = true
+		case "STREAMS":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				return opts, errors.New("Unbalanced '" + cmdName + "' list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			opts.keys = streams[:len(streams)/2]
+			opts.ids = streams[len(streams)/2:]
+			return opts, nil
+		default:
+			return opts, syntaxErr
+		}
+	}
+	return opts, syntaxErr
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// returns the entries of each stream after the ID, $ being the last ID of the
+// stream. With BLOCK, it waits at most milliseconds for an entry to be added
+// if there is none, forever with 0. Returns nil if there is no entry.
+func (s *Server) handleXRead(c *client, args []string) resp.Value {
+	opts, err := parseStreamReadOptions(args, 1, false)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+
+	ids := make(map[string]streamID, len(opts.keys))
+	for i, key := range opts.keys {
+		st, ok := s.lookupStream(key)
+		if !ok {
+			return wrongTypeReply
+		}
+		switch {
+		case opts.ids[i] == "$":
+			if st != nil {
+				ids[key] = st.lastID
+			}
+		case opts.ids[i] == ">":
+			return resp.NewError("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+		default:
+			id, err := parseStreamID(opts.ids[i], 0)
+			if err != nil {
+				return resp.NewError("ERR " + err.Error())
+			}
+			ids[key] = id
+		}
+	}
+
+	var results []resp.Value
+	for _, key := range opts.keys {
+		st, _ := s.lookupStream(key)
+		if st == nil {
+			continue
+		}
+		if entries := st.after(ids[key], opts.count); len(entries) > 0 {
+			results = append(results, resp.NewBulkString(key), entriesReply(entries))
+		}
+	}
+	if len(results) > 0 {
+		return streamsReply(c, results)
+	}
+	if !opts.block {
+		return resp.NewNullArray()
+	}
+
+	return s.block(c, &blockedClient{
+		keys:    opts.keys,
+		timeout: opts.timeout,
+		read:    &streamRead{ids: ids, count: opts.count},
+	})
+}
+
+func entriesReply(entries []streamEntry) resp.Value {
+	values := make([]resp.Value, 0, len(entries))
+	for _, entry := range entries {
+		values = append(values, entryReply(entry))
+	}
+	return resp.NewArray(values...)
+}
+
+// streamsReply returns the keys and their entries, one after another, as a
+// map under RESP3 and as pairs under RESP2
+func streamsReply(c *client, results []resp.Value) resp.Value {
+	if c.writer.Proto == resp.RESP3 {
+		return resp.NewMap(results...)
+	}
+	pairs := make([]resp.Value, 0, len(results)/2)
+	for i := 0; i < len(results); i += 2 {
+		pairs = append(pairs, resp.NewArray(results[i], results[i+1]))
+	}
+	return resp.NewArray(pairs...)
+}
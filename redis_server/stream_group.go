@@ -0,0 +1,771 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	XGROUP     = "XGROUP"
+	XREADGROUP = "XREADGROUP"
+	XACK       = "XACK"
+	XPENDING   = "XPENDING"
+	XCLAIM     = "XCLAIM"
+	XAUTOCLAIM = "XAUTOCLAIM"
+)
+
+// A consumer group delivers each entry of a stream to one of its consumers.
+// The entries delivered are pending until the consumer acknowledges them
+// with XACK, they are recorded in the pending entries list (PEL) of the group
+// and of the consumer. The pending entries of a consumer that failed can be
+// claimed by another one.
+
+type consumerGroup struct {
+	lastID    streamID // the ID of the last entry delivered
+	pending   map[streamID]*pendingEntry
+	consumers map[string]*consumer
+}
+
+type pendingEntry struct {
+	consumer     *consumer
+	deliveryTime time.Time // last delivery
+	deliveries   uint64
+}
+
+type consumer struct {
+	name     string
+	seenTime time.Time // last attempt to read or claim
+	pending  map[streamID]*pendingEntry
+}
+
+func newConsumerGroup(lastID streamID) *consumerGroup {
+	return &consumerGroup{
+		lastID:    lastID,
+		pending:   make(map[streamID]*pendingEntry),
+		consumers: make(map[string]*consumer),
+	}
+}
+
+func newConsumer(name string, seenTime time.Time) *consumer {
+	return &consumer{name: name, seenTime: seenTime, pending: make(map[streamID]*pendingEntry)}
+}
+
+func (g *consumerGroup) clone() *consumerGroup {
+	clone := newConsumerGroup(g.lastID)
+	for name, c := range g.consumers {
+		clone.consumers[name] = newConsumer(name, c.seenTime)
+	}
+	for id, pe := range g.pending {
+		copied := *pe
+		copied.consumer = clone.consumers[pe.consumer.name]
+		clone.pending[id] = &copied
+		copied.consumer.pending[id] = &copied
+	}
+	return clone
+}
+
+// lookupConsumer returns the consumer name, created if it doesn't exist
+func (g *consumerGroup) lookupConsumer(name string, now time.Time) *consumer {
+	c, ok := g.consumers[name]
+	if !ok {
+		c = newConsumer(name, now)
+		g.consumers[name] = c
+	}
+	c.seenTime = now
+	return c
+}
+
+// assign makes pe a pending entry of c
+func (g *consumerGroup) assign(id streamID, pe *pendingEntry, c *consumer) {
+	if pe.consumer != nil {
+		delete(pe.consumer.pending, id)
+	}
+	pe.consumer = c
+	c.pending[id] = pe
+}
+
+// ack removes the pending entry id and reports whether it existed
+func (g *consumerGroup) ack(id streamID) bool {
+	pe, ok := g.pending[id]
+	if !ok {
+		return false
+	}
+	delete(pe.consumer.pending, id)
+	delete(g.pending, id)
+	return true
+}
+
+// sortedIDs returns the IDs of the pending entries in order
+func sortedIDs(pending map[streamID]*pendingEntry) []streamID {
+	ids := make([]streamID, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].less(ids[j])
+	})
+	return ids
+}
+
+// lookupGroup returns the stream at key and its group name,
+// nil if either doesn't exist. ok is false if the key holds another type.
+func (s *Server) lookupGroup(key, name string) (st *stream, g *consumerGroup, ok bool) {
+	st, ok = s.lookupStream(key)
+	if st != nil {
+		g = st.groups[name]
+	}
+	return st, g, ok
+}
+
+func noGroupReply(key, group string) resp.Value {
+	return resp.NewError("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+// XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read] creates
+// a group that delivers the entries after the ID, MKSTREAM creates an empty
+// stream if the key doesn't exist.
+// XGROUP SETID key group id|$ [ENTRIESREAD entries-read] sets the last ID
+// delivered by the group.
+// XGROUP DESTROY key group removes the group.
+// XGROUP CREATECONSUMER key group consumer creates a consumer.
+// XGROUP DELCONSUMER key group consumer removes a consumer and its pending
+// entries, returns their number.
+func (s *Server) handleXGroup(c *client, args []string) resp.Value {
+	sub := strings.ToUpper(args[1])
+	switch {
+	case (sub == "CREATE" || sub == "SETID") && len(args) >= 5:
+	case sub == "DESTROY" && len(args) == 4:
+	case (sub == "CREATECONSUMER" || sub == "DELCONSUMER") && len(args) == 5:
+	default:
+		return resp.NewError("ERR unknown subcommand or wrong number of arguments for '" + args[1] + "'. Try XGROUP HELP.")
+	}
+
+	key, name := args[2], args[3]
+	mkStream := false
+	if sub == "CREATE" || sub == "SETID" {
+		for i := 5; i < len(args); i++ {
+			switch {
+			case sub == "CREATE" && strings.EqualFold(args[i], "MKSTREAM"):
+				mkStream = true
+			case strings.EqualFold(args[i], "ENTRIESREAD") && i+1 < len(args):
+				// the lag of the groups isn't tracked
+				if _, err := strconv.ParseInt(args[i+1], 10, 64); err != nil {
+					return resp.NewError("ERR " + notIntegerErr.Error())
+				}
+				i++
+			default:
+				return resp.NewError("ERR syntax error")
+			}
+		}
+	}
+
+	st, g, ok := s.lookupGroup(key, name)
+	if !ok {
+		return wrongTypeReply
+	}
+	if st == nil && !mkStream {
+		return resp.NewError("ERR The XGROUP subcommand requires the key to exist. " +
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+	if g == nil && sub != "CREATE" && sub != "DESTROY" {
+		return resp.NewError("NOGROUP No such consumer group '" + name + "' for key name '" + key + "'")
+	}
+
+	switch sub {
+	case "CREATE", "SETID":
+		var id streamID
+		if args[4] == "$" {
+			if st != nil {
+				id = st.lastID
+			}
+		} else {
+			var err error
+			if id, err = parseStreamID(args[4], 0); err != nil {
+				return resp.NewError("ERR " + err.Error())
+			}
+		}
+		if sub == "SETID" {
+			s.touchKey(key)
+			g.lastID = id
+			return resp.NewSimpleString("OK")
+		}
+		if g != nil {
+			return resp.NewError("BUSYGROUP Consumer Group name already exists")
+		}
+		if st == nil {
+			st = newStream()
+			s.setKey(key, RedisValue{value: st})
+		} else {
+			s.touchKey(key)
+		}
+		st.groups[name] = newConsumerGroup(id)
+		return resp.NewSimpleString("OK")
+	case "DESTROY":
+		if g == nil {
+			return resp.NewInteger(0)
+		}
+		s.touchKey(key)
+		delete(st.groups, name)
+		return resp.NewInteger(1)
+	case "CREATECONSUMER":
+		if _, exists := g.consumers[args[4]]; exists {
+			return resp.NewInteger(0)
+		}
+		s.touchKey(key)
+		g.lookupConsumer(args[4], time.Now())
+		return resp.NewInteger(1)
+	default: // DELCONSUMER
+		cons, exists := g.consumers[args[4]]
+		if !exists {
+			return resp.NewInteger(0)
+		}
+		s.touchKey(key)
+		pending := len(cons.pending)
+		for id := range cons.pending {
+			g.ack(id)
+		}
+		delete(g.consumers, cons.name)
+		return resp.NewInteger(int64(pending))
+	}
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK]
+// STREAMS key [key ...] id [id ...] is XREAD for a consumer of a group: the ID
+// > delivers the entries that the group never delivered, they are pending
+// until acknowledged unless NOACK is given, and only > blocks. Another ID
+// returns the pending entries of the consumer after it, nil for the entries
+// that were deleted meanwhile.
+func (s *Server) handleXReadGroup(c *client, args []string) resp.Value {
+	if len(args) < 4 || !strings.EqualFold(args[1], "GROUP") {
+		return resp.NewError("ERR syntax error")
+	}
+	opts, err := parseStreamReadOptions(args, 4, true)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	opts.group, opts.consumer = args[2], args[3]
+
+	ids := make([]streamID, len(opts.keys))
+	for i, key := range opts.keys {
+		_, g, ok := s.lookupGroup(key, opts.group)
+		if !ok {
+			return wrongTypeReply
+		}
+		if g == nil {
+			return resp.NewError("NOGROUP No such key '" + key + "' or consumer group '" + opts.group + "' in XREADGROUP with GROUP option")
+		}
+		switch opts.ids[i] {
+		case ">":
+		case "$":
+			return resp.NewError("ERR The $ ID is meaningless in the context of XREADGROUP: " +
+				"you want to read the history of this consumer by specifying a proper ID, " +
+				"or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		default:
+			if ids[i], err = parseStreamID(opts.ids[i], 0); err != nil {
+				return resp.NewError("ERR " + err.Error())
+			}
+		}
+	}
+	// the replicas read the same entries without blocking
+	c.propagate = readGroupCommand(opts, opts.keys, opts.ids)
+
+	var results []resp.Value
+	for i, key := range opts.keys {
+		reply := s.readGroup(key, opts, opts.ids[i] == ">", ids[i])
+		if reply.Type != "" {
+			results = append(results, resp.NewBulkString(key), reply)
+		}
+	}
+	if len(results) > 0 {
+		return streamsReply(c, results)
+	}
+	if !opts.block {
+		return resp.NewNullArray()
+	}
+
+	return s.block(c, &blockedClient{
+		keys:    opts.keys,
+		timeout: opts.timeout,
+		read:    &streamRead{count: opts.count, group: opts.group, consumer: opts.consumer, noAck: opts.noAck},
+	})
+}
+
+// readGroup reads the entries of the group of the stream at key for the
+// consumer, the new entries or the pending ones after id. Returns the reply
+// of the entries, the zero Value if there are no new entries.
+func (s *Server) readGroup(key string, opts streamReadOptions, newEntries bool, id streamID) resp.Value {
+	st, g, _ := s.lookupGroup(key, opts.group)
+	now := time.Now()
+	s.touchKey(key)
+	cons := g.lookupConsumer(opts.consumer, now)
+
+	if newEntries {
+		entries := st.after(g.lastID, opts.count)
+		if len(entries) == 0 {
+			return resp.Value{}
+		}
+		for _, entry := range entries {
+			g.lastID = entry.id
+			if opts.noAck {
+				continue
+			}
+			pe, ok := g.pending[entry.id]
+			if !ok {
+				pe = &pendingEntry{}
+				g.pending[entry.id] = pe
+			}
+			g.assign(entry.id, pe, cons)
+			pe.deliveryTime, pe.deliveries = now, 1
+		}
+		return entriesReply(entries)
+	}
+
+	var values []resp.Value
+	for _, pendingID := range sortedIDs(cons.pending) {
+		if !id.less(pendingID) {
+			continue
+		}
+		if opts.count > 0 && len(values) == opts.count {
+			break
+		}
+		pe := cons.pending[pendingID]
+		pe.deliveryTime = now
+		pe.deliveries++
+		if entry := st.lookup(pendingID); entry != nil {
+			values = append(values, entryReply(*entry))
+		} else {
+			values = append(values, resp.NewArray(resp.NewBulkString(pendingID.String()), resp.NewNullArray()))
+		}
+	}
+	return resp.NewArray(values...)
+}
+
+// readGroupCommand returns the XREADGROUP command that reads the same
+// entries without blocking
+func readGroupCommand(opts streamReadOptions, keys, ids []string) []string {
+	command := []string{XREADGROUP, "GROUP", opts.group, opts.consumer}
+	if opts.count > 0 {
+		command = append(command, "COUNT", strconv.Itoa(opts.count))
+	}
+	if opts.noAck {
+		command = append(command, "NOACK")
+	}
+	command = append(command, "STREAMS")
+	command = append(command, keys...)
+	return append(command, ids...)
+}
+
+// XACK key group id [id ...] acknowledges the pending entries,
+// returns the number of entries that were pending
+func (s *Server) handleXAck(c *client, args []string) resp.Value {
+	ids := make([]streamID, 0, len(args)-3)
+	for _, arg := range args[3:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return resp.NewError("ERR " + err.Error())
+		}
+		ids = append(ids, id)
+	}
+	key := args[1]
+	_, g, ok := s.lookupGroup(key, args[2])
+	if !ok {
+		return wrongTypeReply
+	}
+	if g == nil {
+		return resp.NewInteger(0)
+	}
+	acked := 0
+	for _, id := range ids {
+		if _, pending := g.pending[id]; !pending {
+			continue
+		}
+		if acked == 0 {
+			s.touchKey(key)
+		}
+		g.ack(id)
+		acked++
+	}
+	return resp.NewInteger(int64(acked))
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+// returns the number of pending entries of the group, their smallest and
+// greatest IDs and the number of pending entries of each consumer. With a
+// range, it returns the ID, the consumer, the idle time in milliseconds and
+// the number of deliveries of the pending entries in the range, idle for at
+// least min-idle-time milliseconds and of the consumer if given.
+func (s *Server) handleXPending(c *client, args []string) resp.Value {
+	key, group := args[1], args[2]
+	extended := len(args) > 3
+	var minIdle int64
+	var start, end streamID
+	count := 0
+	consumerName := ""
+	if extended {
+		i := 3
+		if strings.EqualFold(args[i], "IDLE") && len(args) > i+1 {
+			var err error
+			if minIdle, err = strconv.ParseInt(args[i+1], 10, 64); err != nil {
+				return resp.NewError("ERR " + notIntegerErr.Error())
+			}
+			i += 2
+		}
+		if len(args)-i != 3 && len(args)-i != 4 {
+			return resp.NewError("ERR syntax error")
+		}
+		var startex, endex bool
+		var err error
+		if start, startex, err = parseRangeID(args[i], 0); err != nil {
+			return resp.NewError("ERR " + err.Error())
+		}
+		if end, endex, err = parseRangeID(args[i+1], maxStreamID.seq); err != nil {
+			return resp.NewError("ERR " + err.Error())
+		}
+		if count, err = strconv.Atoi(args[i+2]); err != nil {
+			return resp.NewError("ERR " + notIntegerErr.Error())
+		}
+		if len(args)-i == 4 {
+			consumerName = args[i+3]
+		}
+		if startex {
+			start, _ = start.next()
+		}
+		if endex && end == (streamID{}) {
+			count = 0
+		} else if endex && end.seq > 0 {
+			end.seq--
+		} else if endex {
+			end = streamID{end.ms - 1, maxStreamID.seq}
+		}
+	}
+
+	_, g, ok := s.lookupGroup(key, group)
+	if !ok {
+		return wrongTypeReply
+	}
+	if g == nil {
+		return noGroupReply(key, group)
+	}
+
+	if !extended {
+		if len(g.pending) == 0 {
+			return resp.NewArray(resp.NewInteger(0), resp.NewNull(), resp.NewNull(), resp.NewNullArray())
+		}
+		ids := sortedIDs(g.pending)
+		counts := make(map[string]int)
+		for _, pe := range g.pending {
+			counts[pe.consumer.name]++
+		}
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		consumers := make([]resp.Value, 0, len(names))
+		for _, name := range names {
+			consumers = append(consumers, resp.NewBulkStringArray([]string{name, strconv.Itoa(counts[name])}))
+		}
+		return resp.NewArray(
+			resp.NewInteger(int64(len(ids))),
+			resp.NewBulkString(ids[0].String()),
+			resp.NewBulkString(ids[len(ids)-1].String()),
+			resp.NewArray(consumers...),
+		)
+	}
+
+	pending := g.pending
+	if consumerName != "" {
+		cons, exists := g.consumers[consumerName]
+		if !exists {
+			return resp.NewArray()
+		}
+		pending = cons.pending
+	}
+	now := time.Now()
+	var values []resp.Value
+	for _, id := range sortedIDs(pending) {
+		if len(values) >= count {
+			break
+		}
+		if id.less(start) || end.less(id) {
+			continue
+		}
+		pe := pending[id]
+		idle := now.Sub(pe.deliveryTime).Milliseconds()
+		if idle < minIdle {
+			continue
+		}
+		values = append(values, resp.NewArray(
+			resp.NewBulkString(id.String()),
+			resp.NewBulkString(pe.consumer.name),
+			resp.NewInteger(idle),
+			resp.NewInteger(int64(pe.deliveries)),
+		))
+	}
+	return resp.NewArray(values...)
+}
+
+// claimOptions are the options of XCLAIM and XAUTOCLAIM
+type claimOptions struct {
+	minIdle      time.Duration
+	deliveryTime time.Time // with IDLE or TIME, now otherwise
+	retryCount   int64     // with RETRYCOUNT, -1 otherwise
+	force        bool
+	justID       bool
+}
+
+// claim gives the pending entry id of the group to the consumer if it is idle
+// for at least opts.minIdle, and reports whether it did. deleted is true if
+// the entry was deleted from the stream, it is removed from the group.
+func (g *consumerGroup) claim(st *stream, id streamID, cons *consumer, opts claimOptions, now time.Time) (claimed, deleted bool) {
+	pe, ok := g.pending[id]
+	if st.lookup(id) == nil {
+		if ok {
+			g.ack(id)
+		}
+		return false, ok
+	}
+	if !ok {
+		if !opts.force {
+			return false, false
+		}
+		pe = &pendingEntry{deliveryTime: now}
+		g.pending[id] = pe
+	} else if opts.minIdle > 0 && now.Sub(pe.deliveryTime) < opts.minIdle {
+		return false, false
+	}
+
+	g.assign(id, pe, cons)
+	pe.deliveryTime = opts.deliveryTime
+	switch {
+	case opts.retryCount >= 0:
+		pe.deliveries = uint64(opts.retryCount)
+	case !opts.justID:
+		pe.deliveries++
+	}
+	return true, false
+}
+
+// parseMinIdle parses the min-idle-time argument of the claim commands
+func parseMinIdle(arg, cmdName string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid min-idle-time argument for " + cmdName)
+	}
+	if ms < 0 {
+		ms = 0
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms]
+// [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID]
+// [LASTID lastid] gives the pending entries idle for at least min-idle-time
+// milliseconds to the consumer. Their delivery time is set to now, to
+// IDLE milliseconds ago or to TIME, and their number of deliveries is
+// incremented unless JUSTID is given, or set to RETRYCOUNT. FORCE claims the
+// entries that are not pending. Returns the entries claimed, their IDs with
+// JUSTID.
+func (s *Server) handleXClaim(c *client, args []string) resp.Value {
+	key, group, consumerName := args[1], args[2], args[3]
+	now := time.Now()
+	minIdle, err := parseMinIdle(args[4], XCLAIM)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	opts := claimOptions{minIdle: minIdle, deliveryTime: now, retryCount: -1}
+
+	var ids []streamID
+	i := 5
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return resp.NewError("ERR " + invalidStreamIDErr.Error())
+	}
+	var lastID streamID
+	hasLastID := false
+	options := args[i:]
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch {
+		case option == "FORCE":
+			opts.force = true
+		case option == "JUSTID":
+			opts.justID = true
+		case option == "IDLE" && i+1 < len(args):
+			i++
+			ms, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return resp.NewError("ERR Invalid IDLE option argument for XCLAIM")
+			}
+			opts.deliveryTime = now.Add(-time.Duration(ms) * time.Millisecond)
+		case option == "TIME" && i+1 < len(args):
+			i++
+			ms, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return resp.NewError("ERR Invalid TIME option argument for XCLAIM")
+			}
+			opts.deliveryTime = time.UnixMilli(ms)
+		case option == "RETRYCOUNT" && i+1 < len(args):
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || n < 0 {
+				return resp.NewError("ERR Invalid RETRYCOUNT option argument for XCLAIM")
+			}
+			opts.retryCount = n
+		case option == "LASTID" && i+1 < len(args):
+			i++
+			if lastID, err = parseStreamID(args[i], 0); err != nil {
+				return resp.NewError("ERR " + err.Error())
+			}
+			hasLastID = true
+		default:
+			return resp.NewError("ERR Unrecognized XCLAIM option '" + args[i] + "'")
+		}
+	}
+	if opts.deliveryTime.After(now) {
+		opts.deliveryTime = now
+	}
+
+	st, g, ok := s.lookupGroup(key, group)
+	if !ok {
+		return wrongTypeReply
+	}
+	if g == nil {
+		return noGroupReply(key, group)
+	}
+	if hasLastID && g.lastID.less(lastID) {
+		s.touchKey(key)
+		g.lastID = lastID
+	}
+
+	var values []resp.Value
+	var handled []string
+	var cons *consumer
+	for _, id := range ids {
+		if _, pending := g.pending[id]; !pending && !opts.force {
+			continue
+		}
+		s.touchKey(key)
+		if cons == nil {
+			cons = g.lookupConsumer(consumerName, now)
+		}
+		claimed, deleted := g.claim(st, id, cons, opts, now)
+		if claimed || deleted {
+			handled = append(handled, id.String())
+		}
+		if claimed {
+			values = append(values, claimedReply(st, id, opts.justID))
+		}
+	}
+	if len(handled) > 0 {
+		// the idle time differs when the command is replayed
+		c.propagate = append([]string{XCLAIM, key, group, consumerName, "0"}, handled...)
+		c.propagate = append(c.propagate, options...)
+	}
+	return resp.NewArray(values...)
+}
+
+func claimedReply(st *stream, id streamID, justID bool) resp.Value {
+	if justID {
+		return resp.NewBulkString(id.String())
+	}
+	return entryReply(*st.lookup(id))
+}
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID] is
+// XCLAIM for the pending entries from start idle for at least min-idle-time,
+// at most count of them, 100 by default. Returns the ID to start the next
+// call with, 0-0 once all the pending entries were examined, the entries
+// claimed and the IDs of the entries removed from the pending entries because
+// they were deleted from the stream.
+func (s *Server) handleXAutoClaim(c *client, args []string) resp.Value {
+	key, group, consumerName := args[1], args[2], args[3]
+	now := time.Now()
+	minIdle, err := parseMinIdle(args[4], XAUTOCLAIM)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	start, startex, err := parseRangeID(args[5], 0)
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	if startex {
+		start, _ = start.next()
+	}
+	opts := claimOptions{minIdle: minIdle, deliveryTime: now, retryCount: -1}
+	count := 100
+	for i := 6; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "JUSTID"):
+			opts.justID = true
+		case strings.EqualFold(args[i], "COUNT") && i+1 < len(args):
+			i++
+			if count, err = strconv.Atoi(args[i]); err != nil || count < 1 {
+				return resp.NewError("ERR COUNT must be > 0")
+			}
+		default:
+			return resp.NewError("ERR syntax error")
+		}
+	}
+
+	st, g, ok := s.lookupGroup(key, group)
+	if !ok {
+		return wrongTypeReply
+	}
+	if g == nil {
+		return noGroupReply(key, group)
+	}
+
+	ids := sortedIDs(g.pending)
+	i := sort.Search(len(ids), func(i int) bool {
+		return !ids[i].less(start)
+	})
+	var values []resp.Value
+	var handled, deletedIDs []string
+	var cons *consumer
+	// the number of entries examined is bounded
+	for attempts := 10 * count; i < len(ids) && attempts > 0 && len(handled) < count; i, attempts = i+1, attempts-1 {
+		id := ids[i]
+		if pe := g.pending[id]; opts.minIdle > 0 && now.Sub(pe.deliveryTime) < opts.minIdle && st.lookup(id) != nil {
+			continue
+		}
+		s.touchKey(key)
+		if cons == nil {
+			cons = g.lookupConsumer(consumerName, now)
+		}
+		claimed, deleted := g.claim(st, id, cons, opts, now)
+		if claimed {
+			values = append(values, claimedReply(st, id, opts.justID))
+		}
+		if deleted {
+			deletedIDs = append(deletedIDs, id.String())
+		}
+		handled = append(handled, id.String())
+	}
+	cursor := streamID{}
+	if i < len(ids) {
+		cursor = ids[i]
+	}
+
+	if len(handled) > 0 {
+		c.propagate = append([]string{XCLAIM, key, group, consumerName, "0"}, handled...)
+		if opts.justID {
+			c.propagate = append(c.propagate, "JUSTID")
+		}
+	}
+	return resp.NewArray(
+		resp.NewBulkString(cursor.String()),
+		resp.NewArray(values...),
+		resp.NewBulkStringArray(deletedIDs),
+	)
+}
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MGET        = "MGET"
+	MSET        = "MSET"
+	MSETNX      = "MSETNX"
+	SETNX       = "SETNX"
+	SETEX       = "SETEX"
+	PSETEX      = "PSETEX"
+	GETSET      = "GETSET"
+	GETDEL      = "GETDEL"
+	GETEX       = "GETEX"
+	APPEND      = "APPEND"
+	STRLEN      = "STRLEN"
+	GETRANGE    = "GETRANGE"
+	SETRANGE    = "SETRANGE"
+	INCRBY      = "INCRBY"
+	DECRBY      = "DECRBY"
+	INCRBYFLOAT = "INCRBYFLOAT"
+)
+
+// maximum length of a string built by APPEND or SETRANGE: 512MB
+const maxStringLen = 512 * 1024 * 1024
+
+// lookupString returns the string at key, exists is false if the key doesn't
+// exist and ok is false if it holds another type
+func (s *Server) lookupString(key string) (str string, exists, ok bool) {
+	val, exists := s.lookupKey(key)
+	if !exists {
+		return "", false, true
+	}
+	str, ok = val.value.(string)
+	return str, true, ok
+}
+
+// MGET key [key ...] returns the values of the keys, nil for the keys that
+// don't exist or don't hold a string
+func (s *Server) handleMGet(c *client, args []string) resp.Value {
+	values := make([]resp.Value, 0, len(args)-1)
+	for _, key := range args[1:] {
+		if str, exists, ok := s.lookupString(key); exists && ok {
+			values = append(values, resp.NewBulkString(str))
+		} else {
+			values = append(values, resp.NewNull())
+		}
+	}
+	return resp.NewArray(values...)
+}
+
+// MSET key value [key value ...] sets the keys, replacing their values
+func (s *Server) handleMSet(c *client, args []string) resp.Value {
+	if len(args)%2 == 0 {
+		return resp.NewError("ERR wrong number of arguments for 'mset' command")
+	}
+	for i := 1; i < len(args); i += 2 {
+		s.setKey(args[i], RedisValue{value: args[i+1]})
+	}
+	return resp.OK
+}
+
+// MSETNX key value [key value ...] sets the keys, only if none of them exists.
+// Returns 1 if they were set.
+func (s *Server) handleMSetNX(c *client, args []string) resp.Value {
+	if len(args)%2 == 0 {
+		return resp.NewError("ERR wrong number of arguments for 'msetnx' command")
+	}
+	for i := 1; i < len(args); i += 2 {
+		if _, exists := s.lookupKey(args[i]); exists {
+			return resp.NewInteger(0)
+		}
+	}
+	for i := 1; i < len(args); i += 2 {
+		s.setKey(args[i], RedisValue{value: args[i+1]})
+	}
+	return resp.NewInteger(1)
+}
+
+// SETNX key value sets key if it doesn't exist, returns 1 if it was set
+func (s *Server) handleSetNX(c *client, args []string) resp.Value {
+	if _, exists := s.lookupKey(args[1]); exists {
+		return resp.NewInteger(0)
+	}
+	s.setKey(args[1], RedisValue{value: args[2]})
+	return resp.NewInteger(1)
+}
+
+// SETEX key seconds value sets key with a time to live in seconds
+func (s *Server) handleSetEx(c *client, args []string) resp.Value {
+	return s.setEx(c, args, EX)
+}
+
+// PSETEX key milliseconds value sets key with a time to live in milliseconds
+func (s *Server) handlePSetEx(c *client, args []string) resp.Value {
+	return s.setEx(c, args, PX)
+}
+
+func (s *Server) setEx(c *client, args []string, option string) resp.Value {
+	key, value := args[1], args[3]
+	cmdName := strings.ToLower(args[0])
+	if n, err := strconv.ParseInt(args[2], 10, 64); err == nil && n <= 0 {
+		return resp.NewError("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	deadline, err := expireDeadline(option, args[2], cmdName, time.Now())
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	s.setKey(key, RedisValue{value: value, exp: deadline})
+	// the AOF is replayed later: the expiration must be absolute
+	c.propagate = []string{SET, key, value, PXAT, strconv.FormatInt(deadline.UnixMilli(), 10)}
+	return resp.OK
+}
+
+// GETSET key value sets key and returns its old value, nil if it didn't exist
+func (s *Server) handleGetSet(c *client, args []string) resp.Value {
+	old, exists, ok := s.lookupString(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	s.setKey(args[1], RedisValue{value: args[2]})
+	if !exists {
+		return resp.NewNull()
+	}
+	return resp.NewBulkString(old)
+}
+
+// GETDEL key deletes key and returns its value, nil if it didn't exist
+func (s *Server) handleGetDel(c *client, args []string) resp.Value {
+	str, exists, ok := s.lookupString(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if !exists {
+		return resp.NewNull()
+	}
+	s.deleteKey(args[1])
+	return resp.NewBulkString(str)
+}
+
+// GETEX key [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|PERSIST]
+// returns the value of key and sets or removes its expiration
+func (s *Server) handleGetEx(c *client, args []string) resp.Value {
+	key := args[1]
+	now := time.Now()
+	var persist bool
+	var deadline time.Time
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "PERSIST" && deadline.IsZero():
+			persist = true
+		case (opt == EX || opt == PX || opt == EXAT || opt == PXAT) && !persist && deadline.IsZero() && i+1 < len(args):
+			if n, err := strconv.ParseInt(args[i+1], 10, 64); err == nil && n <= 0 {
+				return resp.NewError("ERR invalid expire time in 'getex' command")
+			}
+			var err error
+			if deadline, err = expireDeadline(opt, args[i+1], "getex", now); err != nil {
+				return resp.NewError("ERR " + err.Error())
+			}
+			i++
+		default:
+			return resp.NewError("ERR syntax error")
+		}
+	}
+
+	val, exists := s.lookupKey(key)
+	if !exists {
+		return resp.NewNull()
+	}
+	str, ok := val.value.(string)
+	if !ok {
+		return wrongTypeReply
+	}
+	switch {
+	case !deadline.IsZero() && !deadline.After(now):
+		s.deleteKey(key)
+		c.propagate = []string{DEL, key}
+	case !deadline.IsZero():
+		val.exp = deadline
+		s.setKey(key, val)
+		c.propagate = []string{PEXPIREAT, key, strconv.FormatInt(deadline.UnixMilli(), 10)}
+	case persist && !val.exp.IsZero():
+		val.exp = time.Time{}
+		s.setKey(key, val)
+		c.propagate = []string{PERSIST, key}
+	}
+	return resp.NewBulkString(str)
+}
+
+// APPEND key value appends value to the string at key, a key that doesn't
+// exist is set to value. Returns the new length.
+func (s *Server) handleAppend(c *client, args []string) resp.Value {
+	val, exists := s.lookupKey(args[1])
+	str, ok := val.value.(string)
+	if exists && !ok {
+		return wrongTypeReply
+	}
+	if len(str)+len(args[2]) > maxStringLen {
+		return resp.NewError("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	val.value = str + args[2]
+	// the expiration is kept
+	s.setKey(args[1], val)
+	return resp.NewInteger(int64(len(str) + len(args[2])))
+}
+
+// STRLEN key returns the length of the string at key, 0 if it doesn't exist
+func (s *Server) handleStrLen(c *client, args []string) resp.Value {
+	str, _, ok := s.lookupString(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	return resp.NewInteger(int64(len(str)))
+}
+
+// GETRANGE key start end returns the substring between the offsets start and
+// end included, negative offsets count from the end of the string
+func (s *Server) handleGetRange(c *client, args []string) resp.Value {
+	start, err1 := strconv.ParseInt(args[2], 10, 64)
+	end, err2 := strconv.ParseInt(args[3], 10, 64)
+	if err1 != nil || err2 != nil {
+		return resp.NewError("ERR " + notIntegerErr.Error())
+	}
+	str, _, ok := s.lookupString(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if start < 0 && end < 0 && start > end {
+		return resp.NewBulkString("")
+	}
+	n := int64(len(str))
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if n == 0 || start > end {
+		return resp.NewBulkString("")
+	}
+	return resp.NewBulkString(str[start : end+1])
+}
+
+// SETRANGE key offset value overwrites the string at key from offset, padding
+// it with zero bytes if it is shorter. Returns the new length.
+func (s *Server) handleSetRange(c *client, args []string) resp.Value {
+	offset, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return resp.NewError("ERR " + notIntegerErr.Error())
+	}
+	if offset < 0 {
+		return resp.NewError("ERR offset is out of range")
+	}
+	value := args[3]
+	val, exists := s.lookupKey(args[1])
+	str, ok := val.value.(string)
+	if exists && !ok {
+		return wrongTypeReply
+	}
+	if value == "" {
+		// nothing to write, a key that doesn't exist isn't created
+		return resp.NewInteger(int64(len(str)))
+	}
+	if offset+int64(len(value)) > maxStringLen {
+		return resp.NewError("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+
+	end := int(offset) + len(value)
+	b := []byte(str)
+	if end > len(b) {
+		b = append(b, make([]byte, end-len(b))...)
+	}
+	copy(b[offset:], value)
+	val.value = string(b)
+	s.setKey(args[1], val)
+	return resp.NewInteger(int64(len(b)))
+}
+
+// INCR key increments the integer value of key by one, a key that doesn't
+// exist is set to 0 first. Returns the new value.
+func (s *Server) handleIncr(c *client, args []string) resp.Value {
+	return s.incrBy(args[1], 1)
+}
+
+// DECR key decrements the integer value of key by one
+func (s *Server) handleDecr(c *client, args []string) resp.Value {
+	return s.incrBy(args[1], -1)
+}
+
+// INCRBY key increment increments the integer value of key by increment
+func (s *Server) handleIncrBy(c *client, args []string) resp.Value {
+	increment, err := parseInteger(args[2])
+	if err != nil {
+		return resp.NewError("ERR " + notIntegerErr.Error())
+	}
+	return s.incrBy(args[1], increment)
+}
+
+// DECRBY key decrement decrements the integer value of key by decrement
+func (s *Server) handleDecrBy(c *client, args []string) resp.Value {
+	decrement, err := parseInteger(args[2])
+	if err != nil {
+		return resp.NewError("ERR " + notIntegerErr.Error())
+	}
+	if decrement == math.MinInt64 {
+		return resp.NewError("ERR decrement would overflow")
+	}
+	return s.incrBy(args[1], -decrement)
+}
+
+func (s *Server) incrBy(key string, increment int64) resp.Value {
+	val, exists := s.lookupKey(key)
+	var n int64
+	if exists {
+		str, ok := val.value.(string)
+		if !ok {
+			return wrongTypeReply
+		}
+		var err error
+		if n, err = parseInteger(str); err != nil {
+			return resp.NewError("ERR " + notIntegerErr.Error())
+		}
+	}
+	if (increment > 0 && n > math.MaxInt64-increment) || (increment < 0 && n < math.MinInt64-increment) {
+		return resp.NewError("ERR increment or decrement would overflow")
+	}
+	n += increment
+
+	val.value = strconv.FormatInt(n, 10)
+	// the expiration is kept
+	s.setKey(key, val)
+	return resp.NewInteger(n)
+}
+
+// INCRBYFLOAT key increment adds increment to the float value of key, a key
+// that doesn't exist is set to 0 first. Returns the new value.
+func (s *Server) handleIncrByFloat(c *client, args []string) resp.Value {
+	key := args[1]
+	increment, err := parseFloat(args[2])
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	val, exists := s.lookupKey(key)
+	var f float64
+	if exists {
+		str, ok := val.value.(string)
+		if !ok {
+			return wrongTypeReply
+		}
+		if f, err = parseFloat(str); err != nil {
+			return resp.NewError("ERR " + err.Error())
+		}
+	}
+	f += increment
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return resp.NewError("ERR increment would produce NaN or Infinity")
+	}
+
+	value := formatFloat(f)
+	val.value = value
+	s.setKey(key, val)
+	// the result may differ if the increment is replayed
+	c.propagate = []string{SET, key, value, "KEEPTTL"}
+	return resp.NewBulkString(value)
+}
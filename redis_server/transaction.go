@@ -0,0 +1,193 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"time"
+)
+
+const (
+	MULTI   = "MULTI"
+	EXEC    = "EXEC"
+	DISCARD = "DISCARD"
+	WATCH   = "WATCH"
+	UNWATCH = "UNWATCH"
+)
+
+// After MULTI, the commands of a client are queued instead of executed, and
+// EXEC executes them one after another while holding the server lock, so no
+// other command runs in between. A command that can't be queued, e.g. with a
+// wrong number of arguments, discards the transaction when EXEC is called.
+//
+// WATCH implements optimistic locking: each watched key has a version,
+// incremented whenever the key is modified, and EXEC aborts the transaction
+// when the version of a key changed since the client watched it.
+
+// multiState holds the commands queued since MULTI
+type multiState struct {
+	commands []queuedCommand
+	// aborted is set when a command failed to be queued
+	aborted bool
+}
+
+type queuedCommand struct {
+	cmd  command
+	args []string
+}
+
+// watchedKey is a key watched by at least one client
+type watchedKey struct {
+	version uint64
+	clients int
+}
+
+// watch is a key watched by a client
+type watch struct {
+	db      *database
+	key     string
+	version uint64
+	// expires is set when the key existed with an expiration, the
+	// transaction is aborted if it expired meanwhile
+	expires bool
+}
+
+// touchWatched increments the version of key if it is watched
+func (s *Server) touchWatched(db *database, key string) {
+	if w, ok := db.watched[key]; ok {
+		w.version++
+	}
+}
+
+// touchAllWatched increments the version of the watched keys of db that
+// exist in one of the keyspaces
+func (s *Server) touchAllWatched(db *database, keyspaces ...*keyspace) {
+	for key, w := range db.watched {
+		for _, ks := range keyspaces {
+			if _, ok := ks.dict[key]; ok {
+				w.version++
+				break
+			}
+		}
+	}
+}
+
+// flagTransaction discards the transaction of c, if any, when EXEC is called
+func (c *client) flagTransaction() {
+	if c.multi != nil {
+		c.multi.aborted = true
+	}
+}
+
+// MULTI starts a transaction, the next commands are queued until EXEC
+func (s *Server) handleMulti(c *client, args []string) resp.Value {
+	if c.multi != nil {
+		return resp.NewError("ERR MULTI calls can not be nested")
+	}
+	c.multi = &multiState{}
+	return resp.OK
+}
+
+// EXEC executes the queued commands and returns their replies, or a null
+// array if one of the watched keys was modified
+func (s *Server) handleExec(c *client, args []string) resp.Value {
+	m := c.multi
+	if m == nil {
+		return resp.NewError("ERR EXEC without MULTI")
+	}
+	c.multi = nil
+	changed := s.watchedChanged(c)
+	s.unwatchAll(c)
+	if m.aborted {
+		return resp.NewError("EXECABORT Transaction discarded because of previous errors.")
+	}
+	if changed {
+		return resp.NewNullArray()
+	}
+
+	// the commands are logged to the AOF between MULTI and EXEC, unless
+	// none of them modified the dataset
+	s.propagateMulti = true
+	c.inExec = true
+	replies := make([]resp.Value, len(m.commands))
+	for i, queued := range m.commands {
+		replies[i] = s.call(c, queued.cmd, queued.args)
+	}
+	c.inExec = false
+	if !s.propagateMulti {
+		s.propagate([]string{EXEC})
+	}
+	s.propagateMulti = false
+	return resp.NewArray(replies...)
+}
+
+// DISCARD discards the queued commands and unwatches the keys
+func (s *Server) handleDiscard(c *client, args []string) resp.Value {
+	if c.multi == nil {
+		return resp.NewError("ERR DISCARD without MULTI")
+	}
+	c.multi = nil
+	s.unwatchAll(c)
+	return resp.OK
+}
+
+// WATCH key [key ...] watches the keys of the current database, the next
+// EXEC is aborted if one of them is modified meanwhile
+func (s *Server) handleWatch(c *client, args []string) resp.Value {
+	if c.multi != nil {
+		return resp.NewError("ERR WATCH inside MULTI is not allowed")
+	}
+	now := time.Now()
+next:
+	for _, key := range args[1:] {
+		for _, w := range c.watched {
+			if w.db == s.db && w.key == key {
+				continue next
+			}
+		}
+		wk, ok := s.db.watched[key]
+		if !ok {
+			wk = &watchedKey{}
+			s.db.watched[key] = wk
+		}
+		wk.clients++
+		val, exists := s.db.dict[key]
+		c.watched = append(c.watched, watch{
+			db:      s.db,
+			key:     key,
+			version: wk.version,
+			expires: exists && !val.exp.IsZero() && !val.expired(now),
+		})
+	}
+	return resp.OK
+}
+
+// UNWATCH unwatches all the keys
+func (s *Server) handleUnwatch(c *client, args []string) resp.Value {
+	s.unwatchAll(c)
+	return resp.OK
+}
+
+// watchedChanged reports whether one of the keys watched by c was modified
+// or expired since it was watched
+func (s *Server) watchedChanged(c *client) bool {
+	now := time.Now()
+	for _, w := range c.watched {
+		if w.db.watched[w.key].version != w.version {
+			return true
+		}
+		if val, ok := w.db.dict[w.key]; w.expires && ok && val.expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// unwatchAll unwatches the keys watched by c
+func (s *Server) unwatchAll(c *client) {
+	for _, w := range c.watched {
+		wk := w.db.watched[w.key]
+		if wk.clients--; wk.clients == 0 {
+			delete(w.db.watched, w.key)
+		}
+	}
+	c.watched = nil
+}
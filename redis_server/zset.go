@@ -0,0 +1,805 @@
+package server
+
+import (
+	"ccwc/redis_server/resp"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	ZADD          = "ZADD"
+	ZREM          = "ZREM"
+	ZSCORE        = "ZSCORE"
+	ZINCRBY       = "ZINCRBY"
+	ZCARD         = "ZCARD"
+	ZRANK         = "ZRANK"
+	ZREVRANK      = "ZREVRANK"
+	ZRANGE        = "ZRANGE"
+	ZRANGEBYSCORE = "ZRANGEBYSCORE"
+	ZCOUNT        = "ZCOUNT"
+	ZPOPMIN       = "ZPOPMIN"
+	ZPOPMAX       = "ZPOPMAX"
+	ZUNIONSTORE   = "ZUNIONSTORE"
+	ZINTERSTORE   = "ZINTERSTORE"
+)
+
+var (
+	notScoreRangeErr = errors.New("min or max is not a float")
+	notLexRangeErr   = errors.New("min or max not valid string range item")
+	scoreNaNErr      = errors.New("resulting score is not a number (NaN)")
+)
+
+// lookupZset returns the sorted set at key, nil if the key doesn't exist.
+// ok is false if the key holds another type.
+func (s *Server) lookupZset(key string) (z *zset, ok bool) {
+	val, exists := s.lookupKey(key)
+	if !exists {
+		return nil, true
+	}
+	z, ok = val.value.(*zset)
+	return z, ok
+}
+
+// zsetForWrite returns the sorted set at key to be modified, created if the
+// key doesn't exist. ok is false if the key holds another type.
+func (s *Server) zsetForWrite(key string) (z *zset, ok bool) {
+	z, ok = s.lookupZset(key)
+	if !ok {
+		return nil, false
+	}
+	if z == nil {
+		z = newZset()
+		s.setKey(key, RedisValue{value: z})
+	} else {
+		s.touchKey(key)
+	}
+	return z, true
+}
+
+// scoreRange is a range of scores, the bounds are excluded if minex or maxex
+type scoreRange struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r scoreRange) aboveMin(n *skiplistNode) bool {
+	if r.minex {
+		return n.score > r.min
+	}
+	return n.score >= r.min
+}
+
+func (r scoreRange) belowMax(n *skiplistNode) bool {
+	if r.maxex {
+		return n.score < r.max
+	}
+	return n.score <= r.max
+}
+
+// parseScoreRange parses the bounds of a range of scores,
+// a bound prefixed with ( is excluded
+func parseScoreRange(min, max string) (r scoreRange, err error) {
+	parse := func(arg string) (float64, bool, error) {
+		exclusive := strings.HasPrefix(arg, "(")
+		f, err := strconv.ParseFloat(strings.TrimPrefix(arg, "("), 64)
+		if err != nil || math.IsNaN(f) {
+			return 0, false, notScoreRangeErr
+		}
+		return f, exclusive, nil
+	}
+	if r.min, r.minex, err = parse(min); err != nil {
+		return scoreRange{}, err
+	}
+	if r.max, r.maxex, err = parse(max); err != nil {
+		return scoreRange{}, err
+	}
+	return r, nil
+}
+
+// lexBound is a bound of a lexicographical range: - and + are the lowest and
+// the highest strings, [value is included and (value excluded
+type lexBound struct {
+	value     string
+	exclusive bool
+	inf       int // -1 for -, 1 for +
+}
+
+func parseLexBound(arg string) (lexBound, error) {
+	switch {
+	case arg == "-":
+		return lexBound{inf: -1}, nil
+	case arg == "+":
+		return lexBound{inf: 1}, nil
+	case strings.HasPrefix(arg, "["):
+		return lexBound{value: arg[1:]}, nil
+	case strings.HasPrefix(arg, "("):
+		return lexBound{value: arg[1:], exclusive: true}, nil
+	default:
+		return lexBound{}, notLexRangeErr
+	}
+}
+
+// lexRange is a lexicographical range of members, it assumes that all the
+// members have the same score
+type lexRange struct {
+	min, max lexBound
+}
+
+func (r lexRange) aboveMin(n *skiplistNode) bool {
+	switch {
+	case r.min.inf != 0:
+		return r.min.inf < 0
+	case r.min.exclusive:
+		return n.member > r.min.value
+	default:
+		return n.member >= r.min.value
+	}
+}
+
+func (r lexRange) belowMax(n *skiplistNode) bool {
+	switch {
+	case r.max.inf != 0:
+		return r.max.inf > 0
+	case r.max.exclusive:
+		return n.member < r.max.value
+	default:
+		return n.member <= r.max.value
+	}
+}
+
+func parseLexRange(min, max string) (r lexRange, err error) {
+	if r.min, err = parseLexBound(min); err != nil {
+		return lexRange{}, err
+	}
+	if r.max, err = parseLexBound(max); err != nil {
+		return lexRange{}, err
+	}
+	return r, nil
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...] sets
+// the scores of the members: NX only adds new members, XX only updates
+// existing ones, GT and LT only update a score to a greater or a lower one.
+// Returns the number of members added, or changed with CH. With INCR, ZADD is
+// ZINCRBY and returns the new score, nil if the options prevented it.
+func (s *Server) handleZAdd(c *client, args []string) resp.Value {
+	var nx, xx, gt, lt, ch, incr bool
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break options
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return resp.NewError("ERR syntax error")
+	}
+	if nx && xx {
+		return resp.NewError("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return resp.NewError("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) > 2 {
+		return resp.NewError("ERR INCR option supports a single increment-element pair")
+	}
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		score, err := parseFloat(pairs[2*j])
+		if err != nil {
+			return resp.NewError("ERR " + err.Error())
+		}
+		scores[j] = score
+	}
+
+	key := args[1]
+	z, ok := s.lookupZset(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	written := false
+	added, changed := 0, 0
+	for j, score := range scores {
+		member := pairs[2*j+1]
+		var old float64
+		exists := false
+		if z != nil {
+			old, exists = z.dict[member]
+		}
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if incr {
+			score += old
+			if math.IsNaN(score) {
+				return resp.NewError("ERR " + scoreNaNErr.Error())
+			}
+		}
+		if exists && ((gt && score <= old) || (lt && score >= old)) {
+			continue
+		}
+
+		if !written {
+			z, _ = s.zsetForWrite(key)
+			written = true
+		}
+		if !exists {
+			added++
+		} else if score != old {
+			changed++
+		}
+		z.add(member, score)
+		if incr {
+			return resp.NewDouble(score)
+		}
+	}
+
+	if incr {
+		return resp.NewNull()
+	}
+	if ch {
+		return resp.NewInteger(int64(added + changed))
+	}
+	return resp.NewInteger(int64(added))
+}
+
+// ZINCRBY key increment member adds increment to the score of member,
+// a member that isn't in the set is added with score 0 first.
+// Returns the new score.
+func (s *Server) handleZIncrBy(c *client, args []string) resp.Value {
+	increment, err := parseFloat(args[2])
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	key, member := args[1], args[3]
+	z, ok := s.lookupZset(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	var score float64
+	if z != nil {
+		score = z.dict[member]
+	}
+	score += increment
+	if math.IsNaN(score) {
+		return resp.NewError("ERR " + scoreNaNErr.Error())
+	}
+	z, _ = s.zsetForWrite(key)
+	z.add(member, score)
+	return resp.NewDouble(score)
+}
+
+// ZREM key member [member ...] removes the members, the key is deleted with
+// the last one. Returns the number of members removed.
+func (s *Server) handleZRem(c *client, args []string) resp.Value {
+	key := args[1]
+	z, ok := s.lookupZset(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	if z == nil {
+		return resp.NewInteger(0)
+	}
+	removed := 0
+	for _, member := range args[2:] {
+		if _, exists := z.dict[member]; !exists {
+			continue
+		}
+		if removed == 0 {
+			s.touchKey(key)
+		}
+		z.remove(member)
+		removed++
+	}
+	if removed > 0 && z.len() == 0 {
+		s.deleteKey(key)
+	}
+	return resp.NewInteger(int64(removed))
+}
+
+// ZSCORE key member returns the score of member, nil if it isn't in the set
+func (s *Server) handleZScore(c *client, args []string) resp.Value {
+	z, ok := s.lookupZset(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if z == nil {
+		return resp.NewNull()
+	}
+	score, exists := z.dict[args[2]]
+	if !exists {
+		return resp.NewNull()
+	}
+	return resp.NewDouble(score)
+}
+
+// ZCARD key returns the number of members
+func (s *Server) handleZCard(c *client, args []string) resp.Value {
+	z, ok := s.lookupZset(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if z == nil {
+		return resp.NewInteger(0)
+	}
+	return resp.NewInteger(int64(z.len()))
+}
+
+// ZRANK key member [WITHSCORE] returns the rank of member by ascending
+// score, and its score with WITHSCORE. Nil if it isn't in the set.
+func (s *Server) handleZRank(c *client, args []string) resp.Value {
+	return s.zrank(args, false)
+}
+
+// ZREVRANK key member [WITHSCORE] returns the rank of member by descending score
+func (s *Server) handleZRevRank(c *client, args []string) resp.Value {
+	return s.zrank(args, true)
+}
+
+func (s *Server) zrank(args []string, rev bool) resp.Value {
+	withScore := false
+	if len(args) == 4 {
+		if !strings.EqualFold(args[3], "WITHSCORE") {
+			return resp.NewError("ERR syntax error")
+		}
+		withScore = true
+	} else if len(args) > 4 {
+		return resp.NewError("ERR syntax error")
+	}
+
+	z, ok := s.lookupZset(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	var rank int
+	exists := false
+	if z != nil {
+		rank, exists = z.rank(args[2])
+	}
+	if !exists {
+		if withScore {
+			return resp.NewNullArray()
+		}
+		return resp.NewNull()
+	}
+	if rev {
+		rank = z.len() - 1 - rank
+	}
+	if withScore {
+		return resp.NewArray(resp.NewInteger(int64(rank)), resp.NewDouble(z.dict[args[2]]))
+	}
+	return resp.NewInteger(int64(rank))
+}
+
+// ZCOUNT key min max returns the number of members with a score in the range
+func (s *Server) handleZCount(c *client, args []string) resp.Value {
+	r, err := parseScoreRange(args[2], args[3])
+	if err != nil {
+		return resp.NewError("ERR " + err.Error())
+	}
+	z, ok := s.lookupZset(args[1])
+	if !ok {
+		return wrongTypeReply
+	}
+	if z == nil {
+		return resp.NewInteger(0)
+	}
+	first := z.zsl.firstInRange(r)
+	if first == nil {
+		return resp.NewInteger(0)
+	}
+	last := z.zsl.lastInRange(r)
+	count := z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+	return resp.NewInteger(int64(count))
+}
+
+// the kinds of ranges of ZRANGE
+const (
+	byRank = iota
+	byScore
+	byLex
+)
+
+// zrangeSpec is the range of elements of a ZRANGE command
+type zrangeSpec struct {
+	by         int
+	start      string
+	stop       string
+	rev        bool
+	limit      bool
+	offset     int
+	count      int // a negative count returns all the elements from offset
+	withScores bool
+}
+
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+// returns the members by rank, by score or lexicographically, in the reverse
+// order with REV, in which case start is the upper bound of the range.
+// LIMIT selects count members from offset in the range.
+func (s *Server) handleZRange(c *client, args []string) resp.Value {
+	spec := zrangeSpec{start: args[2], stop: args[3], count: -1}
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "BYSCORE":
+			spec.by = byScore
+		case "BYLEX":
+			spec.by = byLex
+		case "REV":
+			spec.rev = true
+		case "WITHSCORES":
+			spec.withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return resp.NewError("ERR syntax error")
+			}
+			if err := spec.parseLimit(args[i+1], args[i+2]); err != nil {
+				return resp.NewError("ERR " + err.Error())
+			}
+			i += 2
+		default:
+			return resp.NewError("ERR syntax error")
+		}
+	}
+	if spec.limit && spec.by == byRank {
+		return resp.NewError("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.by == byLex {
+		return resp.NewError("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return s.zrange(c, args[1], spec)
+}
+
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count] is
+// ZRANGE key min max BYSCORE
+func (s *Server) handleZRangeByScore(c *client, args []string) resp.Value {
+	spec := zrangeSpec{by: byScore, start: args[2], stop: args[3], count: -1}
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "WITHSCORES":
+			spec.withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return resp.NewError("ERR syntax error")
+			}
+			if err := spec.parseLimit(args[i+1], args[i+2]); err != nil {
+				return resp.NewError("ERR " + err.Error())
+			}
+			i += 2
+		default:
+			return resp.NewError("ERR syntax error")
+		}
+	}
+	return s.zrange(c, args[1], spec)
+}
+
+func (spec *zrangeSpec) parseLimit(offset, count string) error {
+	var err error
+	if spec.offset, err = strconv.Atoi(offset); err != nil {
+		return notIntegerErr
+	}
+	if spec.count, err = strconv.Atoi(count); err != nil {
+		return notIntegerErr
+	}
+	spec.limit = true
+	return nil
+}
+
+func (s *Server) zrange(c *client, key string, spec zrangeSpec) resp.Value {
+	var r zrange
+	switch spec.by {
+	case byScore:
+		min, max := spec.start, spec.stop
+		if spec.rev {
+			min, max = max, min
+		}
+		sr, err := parseScoreRange(min, max)
+		if err != nil {
+			return resp.NewError("ERR " + err.Error())
+		}
+		r = sr
+	case byLex:
+		min, max := spec.start, spec.stop
+		if spec.rev {
+			min, max = max, min
+		}
+		lr, err := parseLexRange(min, max)
+		if err != nil {
+			return resp.NewError("ERR " + err.Error())
+		}
+		r = lr
+	}
+	start, errStart := strconv.Atoi(spec.start)
+	stop, errStop := strconv.Atoi(spec.stop)
+	if spec.by == byRank && (errStart != nil || errStop != nil) {
+		return resp.NewError("ERR " + notIntegerErr.Error())
+	}
+
+	z, ok := s.lookupZset(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	if z == nil {
+		return resp.NewArray()
+	}
+
+	next := func(x *skiplistNode) *skiplistNode {
+		if spec.rev {
+			return x.backward
+		}
+		return x.level[0].forward
+	}
+	var nodes []*skiplistNode
+	if spec.by == byRank {
+		n := z.len()
+		if start < 0 {
+			start += n
+		}
+		if stop < 0 {
+			stop += n
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= n {
+			stop = n - 1
+		}
+		if start > stop || start >= n {
+			return resp.NewArray()
+		}
+		rank := start
+		if spec.rev {
+			rank = n - 1 - start
+		}
+		x := z.zsl.byRank(rank)
+		for i := start; i <= stop; i++ {
+			nodes = append(nodes, x)
+			x = next(x)
+		}
+		return zsetReply(c, nodes, spec.withScores)
+	}
+
+	if spec.offset < 0 {
+		return resp.NewArray()
+	}
+	var x *skiplistNode
+	inRange := r.belowMax
+	if spec.rev {
+		x = z.zsl.lastInRange(r)
+		inRange = r.aboveMin
+	} else {
+		x = z.zsl.firstInRange(r)
+	}
+	for offset := spec.offset; x != nil && offset > 0; offset-- {
+		x = next(x)
+	}
+	for count := spec.count; x != nil && count != 0 && inRange(x); count-- {
+		nodes = append(nodes, x)
+		x = next(x)
+	}
+	return zsetReply(c, nodes, spec.withScores)
+}
+
+// zsetReply returns the members of the nodes, and their scores withScores,
+// as pairs of a member and its score under RESP3
+func zsetReply(c *client, nodes []*skiplistNode, withScores bool) resp.Value {
+	values := make([]resp.Value, 0, len(nodes))
+	for _, x := range nodes {
+		member := resp.NewBulkString(x.member)
+		switch {
+		case !withScores:
+			values = append(values, member)
+		case c.writer.Proto == resp.RESP3:
+			values = append(values, resp.NewArray(member, resp.NewDouble(x.score)))
+		default:
+			values = append(values, member, resp.NewDouble(x.score))
+		}
+	}
+	return resp.NewArray(values...)
+}
+
+// ZPOPMIN key [count] removes and returns the members with the lowest scores
+// and their scores
+func (s *Server) handleZPopMin(c *client, args []string) resp.Value {
+	return s.zpop(c, args, false)
+}
+
+// ZPOPMAX key [count] removes and returns the members with the highest
+// scores and their scores
+func (s *Server) handleZPopMax(c *client, args []string) resp.Value {
+	return s.zpop(c, args, true)
+}
+
+func (s *Server) zpop(c *client, args []string, max bool) resp.Value {
+	if len(args) > 3 {
+		return resp.NewError("ERR syntax error")
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return resp.NewError("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	key := args[1]
+	z, ok := s.lookupZset(key)
+	if !ok {
+		return wrongTypeReply
+	}
+	if z == nil || count == 0 {
+		return resp.NewArray()
+	}
+
+	s.touchKey(key)
+	var nodes []*skiplistNode
+	for ; count > 0 && z.len() > 0; count-- {
+		x := z.zsl.header.level[0].forward
+		if max {
+			x = z.zsl.tail
+		}
+		z.remove(x.member)
+		nodes = append(nodes, x)
+	}
+	if z.len() == 0 {
+		s.deleteKey(key)
+	}
+	if len(args) == 2 {
+		// a single pair isn't nested under RESP3
+		return resp.NewArray(resp.NewBulkString(nodes[0].member), resp.NewDouble(nodes[0].score))
+	}
+	return zsetReply(c, nodes, true)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]]
+// [AGGREGATE SUM|MIN|MAX] stores at destination the members of any of the
+// sorted sets, with the aggregate of their scores multiplied by the weights.
+// Returns the number of members.
+func (s *Server) handleZUnionStore(c *client, args []string) resp.Value {
+	return s.zsetAlgebraStore(args, setUnion)
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]]
+// [AGGREGATE SUM|MIN|MAX] stores at destination the members of all the
+// sorted sets
+func (s *Server) handleZInterStore(c *client, args []string) resp.Value {
+	return s.zsetAlgebraStore(args, setInter)
+}
+
+// zsetAlgebraStore replaces destination, whatever its type, with the result
+// of the operation, an empty result deletes it. The keys may hold sets, their
+// members have the score 1.
+func (s *Server) zsetAlgebraStore(args []string, op int) resp.Value {
+	destination := args[1]
+	numKeys, err := strconv.Atoi(args[2])
+	if err != nil {
+		return resp.NewError("ERR " + notIntegerErr.Error())
+	}
+	if numKeys < 1 {
+		return resp.NewError("ERR at least 1 input key is needed for '" + strings.ToLower(args[0]) + "' command")
+	}
+	if numKeys > len(args)-3 {
+		return resp.NewError("ERR syntax error")
+	}
+	keys := args[3 : 3+numKeys]
+
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := "SUM"
+	for i := 3 + numKeys; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "WEIGHTS") && i+numKeys < len(args):
+			for j := range weights {
+				w, err := parseFloat(args[i+1+j])
+				if err != nil {
+					return resp.NewError("ERR weight value is not a float")
+				}
+				weights[j] = w
+			}
+			i += numKeys
+		case strings.EqualFold(args[i], "AGGREGATE") && i+1 < len(args):
+			aggregate = strings.ToUpper(args[i+1])
+			if aggregate != "SUM" && aggregate != "MIN" && aggregate != "MAX" {
+				return resp.NewError("ERR syntax error")
+			}
+			i++
+		default:
+			return resp.NewError("ERR syntax error")
+		}
+	}
+
+	// the scores of the members of each key
+	sources := make([]map[string]float64, 0, numKeys)
+	for _, key := range keys {
+		val, exists := s.lookupKey(key)
+		scores := map[string]float64{}
+		if exists {
+			switch v := val.value.(type) {
+			case *zset:
+				scores = v.dict
+			case *set:
+				for member := range v.dict {
+					scores[member] = 1
+				}
+			default:
+				return wrongTypeReply
+			}
+		}
+		sources = append(sources, scores)
+	}
+
+	combine := func(a, b float64) float64 {
+		switch aggregate {
+		case "MIN":
+			return math.Min(a, b)
+		case "MAX":
+			return math.Max(a, b)
+		default:
+			// inf + -inf
+			if sum := a + b; !math.IsNaN(sum) {
+				return sum
+			}
+			return 0
+		}
+	}
+	weighted := func(score, weight float64) float64 {
+		// 0 * inf
+		if product := score * weight; !math.IsNaN(product) {
+			return product
+		}
+		return 0
+	}
+	result := make(map[string]float64)
+	for member, score := range sources[0] {
+		result[member] = weighted(score, weights[0])
+	}
+	for i, scores := range sources[1:] {
+		weight := weights[i+1]
+		if op == setUnion {
+			for member, score := range scores {
+				if acc, exists := result[member]; exists {
+					result[member] = combine(acc, weighted(score, weight))
+				} else {
+					result[member] = weighted(score, weight)
+				}
+			}
+			continue
+		}
+		for member, acc := range result {
+			if score, exists := scores[member]; exists {
+				result[member] = combine(acc, weighted(score, weight))
+			} else {
+				delete(result, member)
+			}
+		}
+	}
+
+	if len(result) == 0 {
+		s.deleteKey(destination)
+		return resp.NewInteger(0)
+	}
+	z := newZset()
+	for member, score := range result {
+		z.add(member, score)
+	}
+	s.setKey(destination, RedisValue{value: z})
+	return resp.NewInteger(int64(z.len()))
+}